@@ -3,11 +3,7 @@ package auth
 import (
 	"bytes"
 	"context"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -37,78 +33,35 @@ var (
 	ErrFailedToGetRole = errors.New("failed to get role from context")
 )
 
-// fetchPubKey fetches the public key set from the auth service
-func fetchPubKey(url string) (*rsa.PublicKey, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusAccepted {
-		return nil, errors.New("Failed to fetch public key set: " + resp.Status)
-	}
+// Cache of the auth service public keys, set up by InitKeyManager
+var keyManager *KeyManager
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// InitKeyManager starts caching and refreshing the JWT public keys
+func InitKeyManager() {
+	if DEBUG_MODE == "true" {
+		return
 	}
 
-	var jsonData map[string]any
-	jwtPublicKeySet := ""
-	if err := json.Unmarshal(body, &jsonData); err == nil {
-		if pubkey, ok := jsonData["pubkey"].(string); ok && pubkey != "" {
-			jwtPublicKeySet = pubkey
+	refreshInterval := defaultRefreshInterval
+	if PUBKEY_REFRESH_INTERVAL != "" {
+		if d, err := time.ParseDuration(PUBKEY_REFRESH_INTERVAL); err == nil {
+			refreshInterval = d
+		} else {
+			fmt.Printf("Invalid PUBKEY_REFRESH_INTERVAL %q, using %s\n", PUBKEY_REFRESH_INTERVAL, refreshInterval)
 		}
 	}
-	if jwtPublicKeySet == "" {
-		return nil, errors.New("Empty public key")
-	}
 
-	var PublicKey *rsa.PublicKey
-	publicKeyPEM, err := base64.StdEncoding.DecodeString(jwtPublicKeySet)
-	if err != nil {
-		return nil, err
-	}
-	block, _ := pem.Decode(publicKeyPEM)
-	if block == nil {
-		return nil, rsa.ErrDecryption
-	}
-	publicKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, err
-	}
-	PublicKey, ok := publicKeyInterface.(*rsa.PublicKey)
-	if !ok || PublicKey == nil {
-		return nil, errors.New("Failed to parse public key")
-	}
-
-	return PublicKey, nil
+	keyManager = NewKeyManager(AUTH_URL+PUBKEY_ENDPOINT, refreshInterval)
+	keyManager.Start()
 }
 
 // get and returns the PublicKey for JWT validation
 func keyFunc(token *jwt.Token) (any, error) {
-	var jwtPublicKeySet *rsa.PublicKey
-	var err error
-
-	if DEBUG_MODE == "true" {
-		jwtPublicKeySet = nil
-	} else {
-		jwtPublicKeySet, err = fetchPubKey(AUTH_URL + PUBKEY_ENDPOINT)
-		if err != nil {
-			fmt.Print("Failed to fetch public key set: " + err.Error())
-		}
-	}
-
-	if jwtPublicKeySet == nil {
-		return nil, errors.New("public key set is not available")
-	}
-
-	if token.Method.Alg() != jwt.SigningMethodRS512.Alg() {
-		return nil, errors.New("unexpected signing method")
+	if DEBUG_MODE == "true" || keyManager == nil {
+		return nil, ErrNoPublicKey
 	}
 
-	return jwtPublicKeySet, nil
+	return keyManager.Keyfunc(token)
 }
 
 func AuthenticationFunc(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
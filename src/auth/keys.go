@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// How often the public keys are refreshed in the background
+var PUBKEY_REFRESH_INTERVAL = os.Getenv("PUBKEY_REFRESH_INTERVAL")
+
+const (
+	defaultRefreshInterval = 5 * time.Minute
+	// Retry sooner than the normal interval while the auth service is failing
+	failedRefreshInterval = 15 * time.Second
+	// Minimum delay between two on-demand refreshes triggered by unknown kids
+	minRefreshInterval = 10 * time.Second
+	// Keys that disappear from the auth service are kept this long so that
+	// tokens signed before a rotation stay valid until they expire
+	retiredKeyRetention = 24 * time.Hour
+)
+
+var (
+	ErrNoPublicKey = errors.New("public key set is not available")
+	ErrUnknownKid  = errors.New("unknown key id")
+)
+
+// Metrics exported on /debug/vars
+var keyMetrics = expvar.NewMap("auth_pubkey")
+
+type cachedKey struct {
+	key      *rsa.PublicKey
+	lastSeen time.Time
+}
+
+// KeyManager caches the auth service public keys and refreshes them in the
+// background. When a refresh fails the last good keys keep being served.
+type KeyManager struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]cachedKey
+	currentKid  string
+	lastAttempt time.Time
+	lastSuccess time.Time
+
+	refreshMu sync.Mutex
+	// Coalesces the refreshes triggered by unknown kids
+	onDemand singleflight.Group
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewKeyManager(url string, refreshInterval time.Duration) *KeyManager {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+	return &KeyManager{
+		url:             url,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		refreshInterval: refreshInterval,
+		keys:            map[string]cachedKey{},
+		stop:            make(chan struct{}),
+	}
+}
+
+// Start fetches the keys once and keeps refreshing them until Stop is called.
+// A failing first fetch is not fatal: the auth service may come up later.
+func (km *KeyManager) Start() {
+	if err := km.Refresh(); err != nil {
+		fmt.Printf("Failed to fetch public key set: %v\n", err)
+	}
+	go km.run()
+}
+
+func (km *KeyManager) Stop() {
+	km.stopOnce.Do(func() { close(km.stop) })
+}
+
+func (km *KeyManager) run() {
+	timer := time.NewTimer(km.nextRefresh())
+	defer timer.Stop()
+	for {
+		select {
+		case <-km.stop:
+			return
+		case <-timer.C:
+			if err := km.Refresh(); err != nil {
+				fmt.Printf("Failed to refresh public key set: %v\n", err)
+			}
+			timer.Reset(km.nextRefresh())
+		}
+	}
+}
+
+func (km *KeyManager) nextRefresh() time.Duration {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	if km.lastSuccess.Before(km.lastAttempt) || km.lastSuccess.IsZero() {
+		return failedRefreshInterval
+	}
+	return km.refreshInterval
+}
+
+// Refresh fetches the current key set from the auth service. On failure the
+// cached keys are left untouched.
+func (km *KeyManager) Refresh() error {
+	km.refreshMu.Lock()
+	defer km.refreshMu.Unlock()
+
+	now := time.Now()
+	km.mu.Lock()
+	km.lastAttempt = now
+	km.mu.Unlock()
+
+	fetched, currentKid, err := fetchPubKeys(km.client, km.url)
+	if err != nil {
+		keyMetrics.Add("refresh_failures", 1)
+		keyMetrics.Set("last_failure_unix", unixVar(now))
+		return err
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	for kid, key := range fetched {
+		km.keys[kid] = cachedKey{key: key, lastSeen: now}
+	}
+	for kid, cached := range km.keys {
+		if now.Sub(cached.lastSeen) > retiredKeyRetention {
+			delete(km.keys, kid)
+		}
+	}
+	km.currentKid = currentKid
+	km.lastSuccess = now
+
+	keyMetrics.Add("refresh_success", 1)
+	keyMetrics.Set("last_success_unix", unixVar(now))
+	keyMetrics.Set("cached_keys", intVar(len(km.keys)))
+	return nil
+}
+
+// Key returns the public key for the given kid. An empty kid selects the key
+// the auth service currently signs with. An unknown kid triggers a refresh,
+// since the auth service may just have rotated its keys.
+func (km *KeyManager) Key(kid string) (*rsa.PublicKey, error) {
+	if key, ok := km.lookup(kid); ok {
+		return key, nil
+	}
+
+	// Concurrent requests with unknown kids wait for the same fetch, which
+	// runs at most once per minimum interval
+	km.onDemand.Do("refresh", func() (any, error) {
+		if _, ok := km.lookup(kid); ok || !km.canRefresh() {
+			return nil, nil
+		}
+		if err := km.Refresh(); err != nil {
+			fmt.Printf("Failed to refresh public key set: %v\n", err)
+		}
+		return nil, nil
+	})
+	if key, ok := km.lookup(kid); ok {
+		return key, nil
+	}
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	if len(km.keys) == 0 {
+		return nil, ErrNoPublicKey
+	}
+	keyMetrics.Add("unknown_kid", 1)
+	return nil, ErrUnknownKid
+}
+
+// canRefresh tells whether the last attempt is old enough for an on-demand
+// refresh
+func (km *KeyManager) canRefresh() bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return time.Since(km.lastAttempt) >= minRefreshInterval
+}
+
+func (km *KeyManager) lookup(kid string) (*rsa.PublicKey, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	if kid == "" {
+		kid = km.currentKid
+	}
+	cached, ok := km.keys[kid]
+	return cached.key, ok
+}
+
+// Keyfunc is a jwt.Keyfunc selecting the verification key by the `kid` header
+func (km *KeyManager) Keyfunc(token *jwt.Token) (any, error) {
+	if token.Method.Alg() != jwt.SigningMethodRS512.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	kid, _ := token.Header["kid"].(string)
+	return km.Key(kid)
+}
+
+type pubKeyEntry struct {
+	Kid    string `json:"kid"`
+	Pubkey string `json:"pubkey"`
+}
+
+type pubKeySet struct {
+	pubKeyEntry
+	Keys []pubKeyEntry `json:"keys"`
+}
+
+// fetchPubKeys fetches the public key set from the auth service.
+// It accepts both the single key format {"pubkey": ...} and a key set
+// {"keys": [{"kid": ..., "pubkey": ...}]} used during rotation, where the
+// first key is the one currently used for signing.
+func fetchPubKeys(client *http.Client, url string) (map[string]*rsa.PublicKey, string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, "", errors.New("Failed to fetch public key set: " + resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var set pubKeySet
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, "", fmt.Errorf("invalid public key set: %w", err)
+	}
+	entries := set.Keys
+	if set.Pubkey != "" {
+		entries = append([]pubKeyEntry{set.pubKeyEntry}, entries...)
+	}
+	if len(entries) == 0 {
+		return nil, "", errors.New("Empty public key")
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, entry := range entries {
+		key, err := parsePubKey(entry.Pubkey)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid public key %q: %w", entry.Kid, err)
+		}
+		keys[entry.Kid] = key
+	}
+
+	return keys, entries[0].Kid, nil
+}
+
+// parsePubKey decodes a base64 encoded PEM public key
+func parsePubKey(encoded string) (*rsa.PublicKey, error) {
+	if encoded == "" {
+		return nil, errors.New("Empty public key")
+	}
+	publicKeyPEM, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return nil, rsa.ErrDecryption
+	}
+	publicKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := publicKeyInterface.(*rsa.PublicKey)
+	if !ok || publicKey == nil {
+		return nil, errors.New("Failed to parse public key")
+	}
+	return publicKey, nil
+}
+
+func unixVar(t time.Time) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(t.Unix())
+	return v
+}
+
+func intVar(n int) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(n))
+	return v
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyServer is a local stand-in for the auth service pubkey endpoint
+type keyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	entries []pubKeyEntry
+	failing bool
+	hits    atomic.Int32
+}
+
+func newKeyServer(t *testing.T) *keyServer {
+	ks := &keyServer{}
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks.hits.Add(1)
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		if ks.failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{"keys": ks.entries})
+	}))
+	t.Cleanup(ks.Close)
+	return ks
+}
+
+func (ks *keyServer) publish(entries ...pubKeyEntry) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.entries = entries
+}
+
+func (ks *keyServer) setFailing(failing bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.failing = failing
+}
+
+func newRSAKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	return key, encoded
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
+		"username": "driver",
+		"role":     "driver",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func metric(name string) int64 {
+	if v, ok := keyMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestKeyManagerCachesKeys(t *testing.T) {
+	ks := newKeyServer(t)
+	key, pub := newRSAKey(t)
+	ks.publish(pubKeyEntry{Kid: "k1", Pubkey: pub})
+
+	km := NewKeyManager(ks.URL, time.Hour)
+	if err := km.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := jwt.Parse(signToken(t, key, "k1"), km.Keyfunc); err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+	}
+	if hits := ks.hits.Load(); hits != 1 {
+		t.Fatalf("expected 1 fetch, got %d", hits)
+	}
+}
+
+func TestKeyManagerSelectsKeyByKid(t *testing.T) {
+	ks := newKeyServer(t)
+	oldKey, oldPub := newRSAKey(t)
+	newKey, newPub := newRSAKey(t)
+	ks.publish(pubKeyEntry{Kid: "new", Pubkey: newPub}, pubKeyEntry{Kid: "old", Pubkey: oldPub})
+
+	km := NewKeyManager(ks.URL, time.Hour)
+	if err := km.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if _, err := jwt.Parse(signToken(t, oldKey, "old"), km.Keyfunc); err != nil {
+		t.Fatalf("old key: %v", err)
+	}
+	if _, err := jwt.Parse(signToken(t, newKey, "new"), km.Keyfunc); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	// Without a kid the current signing key is used
+	if _, err := jwt.Parse(signToken(t, newKey, ""), km.Keyfunc); err != nil {
+		t.Fatalf("no kid: %v", err)
+	}
+	if _, err := jwt.Parse(signToken(t, oldKey, "new"), km.Keyfunc); err == nil {
+		t.Fatal("token signed with the wrong key was accepted")
+	}
+}
+
+func TestKeyManagerRefreshesOnUnknownKid(t *testing.T) {
+	ks := newKeyServer(t)
+	_, pub1 := newRSAKey(t)
+	key2, pub2 := newRSAKey(t)
+	ks.publish(pubKeyEntry{Kid: "k1", Pubkey: pub1})
+
+	km := NewKeyManager(ks.URL, time.Hour)
+	if err := km.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	// The auth service rotates to a new key
+	ks.publish(pubKeyEntry{Kid: "k2", Pubkey: pub2}, pubKeyEntry{Kid: "k1", Pubkey: pub1})
+	km.mu.Lock()
+	km.lastAttempt = time.Now().Add(-minRefreshInterval)
+	km.mu.Unlock()
+
+	if _, err := jwt.Parse(signToken(t, key2, "k2"), km.Keyfunc); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+
+	// Unknown kids do not hammer the auth service
+	hits := ks.hits.Load()
+	_, err := km.Key("k3")
+	if !errors.Is(err, ErrUnknownKid) {
+		t.Fatalf("expected ErrUnknownKid, got %v", err)
+	}
+	if ks.hits.Load() != hits {
+		t.Fatal("unknown kid triggered a refresh within the minimum interval")
+	}
+}
+
+func TestKeyManagerCoalescesConcurrentRefreshes(t *testing.T) {
+	ks := newKeyServer(t)
+	_, pub1 := newRSAKey(t)
+	_, pub2 := newRSAKey(t)
+	ks.publish(pubKeyEntry{Kid: "k1", Pubkey: pub1})
+
+	km := NewKeyManager(ks.URL, time.Hour)
+	if err := km.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	ks.publish(pubKeyEntry{Kid: "k2", Pubkey: pub2}, pubKeyEntry{Kid: "k1", Pubkey: pub1})
+	km.mu.Lock()
+	km.lastAttempt = time.Now().Add(-minRefreshInterval)
+	km.mu.Unlock()
+
+	// Many requests with the new kid at once fetch the key set once
+	hits := ks.hits.Load()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := km.Key("k2"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if fetches := ks.hits.Load() - hits; fetches != 1 {
+		t.Fatalf("expected 1 fetch, got %d", fetches)
+	}
+}
+
+func TestKeyManagerKeepsLastGoodKey(t *testing.T) {
+	ks := newKeyServer(t)
+	key, pub := newRSAKey(t)
+	ks.publish(pubKeyEntry{Kid: "k1", Pubkey: pub})
+
+	km := NewKeyManager(ks.URL, time.Hour)
+	if err := km.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	ks.setFailing(true)
+	failures := metric("refresh_failures")
+	if err := km.Refresh(); err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	if metric("refresh_failures") != failures+1 {
+		t.Fatal("refresh failure was not counted")
+	}
+	if km.nextRefresh() != failedRefreshInterval {
+		t.Fatal("failed refresh should be retried sooner")
+	}
+
+	if _, err := jwt.Parse(signToken(t, key, "k1"), km.Keyfunc); err != nil {
+		t.Fatalf("last good key not served: %v", err)
+	}
+}
+
+func TestKeyManagerWithoutKeys(t *testing.T) {
+	ks := newKeyServer(t)
+	ks.setFailing(true)
+
+	km := NewKeyManager(ks.URL, time.Hour)
+	if err := km.Refresh(); err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	if _, err := km.Key(""); !errors.Is(err, ErrNoPublicKey) {
+		t.Fatalf("expected ErrNoPublicKey, got %v", err)
+	}
+}
+
+func TestFetchPubKeysSingleKeyFormat(t *testing.T) {
+	_, pub := newRSAKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"pubkey": pub})
+	}))
+	defer server.Close()
+
+	keys, current, err := fetchPubKeys(server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(keys) != 1 || current != "" || keys[""] == nil {
+		t.Fatalf("unexpected key set %v (current %q)", keys, current)
+	}
+}
@@ -5,12 +5,21 @@ package main
 import (
 	"OPP/backend/api"
 	"OPP/backend/auth"
+	"OPP/backend/blobstore"
+	"OPP/backend/dao"
 	"OPP/backend/db"
 	"OPP/backend/handlers"
+	"OPP/backend/idempotency"
+	"OPP/backend/jobs"
+	"OPP/backend/payments"
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/gin-gonic/gin"
@@ -24,11 +33,53 @@ type opp_handlers struct {
 	handlers.FineHandlers
 	handlers.ZoneHandlers
 	handlers.TotemHandlers
+	handlers.SessionHandlers
+	handlers.TariffHandlers
+	handlers.PricingHandlers
+	handlers.PaymentHandlers
+	handlers.RefundHandlers
+	handlers.EnforcementHandlers
+	handlers.AppealHandlers
+	handlers.EvidenceHandlers
+	handlers.NoticeHandlers
+	handlers.TicketKeyHandlers
+	handlers.PermitHandlers
+	handlers.SubscriptionHandlers
+	handlers.DiscountHandlers
+	handlers.WalletHandlers
+	handlers.OperatorHandlers
+	handlers.InvoiceHandlers
+	handlers.OccupancyHandlers
+	handlers.DynamicPricingHandlers
 }
 
 var DEBUG_MODE = os.Getenv("DEBUG_MODE")
 
+// Address of the internal listener serving the metrics, which must not be
+// reachable from the internet
+var METRICS_ADDR = os.Getenv("METRICS_ADDR")
+
+const defaultMetricsAddr = "127.0.0.1:9090"
+
+// serveMetrics serves /debug/vars on its own listener, apart from the API
+func serveMetrics() {
+	addr := METRICS_ADDR
+	if addr == "" {
+		addr = defaultMetricsAddr
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics listener on %s stopped: %v", addr, err)
+		}
+	}()
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
 
 	if err := db.Init(); err != nil {
 		log.Panicf("Failed to initialize database: %v", err)
@@ -39,14 +90,71 @@ func main() {
 		defer db.GetDB().Close()
 	}
 
+	auth.InitKeyManager()
+
+	// Without a provider the backend runs with payments disabled
+	paymentsEnabled := true
+	if err := payments.InitProvider(); errors.Is(err, payments.ErrNoProvider) {
+		log.Printf("PAYMENT_PROVIDER not set, payments are disabled")
+		paymentsEnabled = false
+	} else if err != nil {
+		log.Panicf("Failed to initialize payment provider: %v", err)
+	}
+
+	if err := blobstore.InitStore(); err != nil {
+		log.Panicf("Failed to initialize blob store: %v", err)
+	}
+
+	// Creates the first ticket signing key, and fails early when the
+	// stored keys cannot be decrypted
+	if err := dao.NewTicketKeyDao().RotateTicketKeys(context.Background()); err != nil {
+		log.Panicf("Failed to initialize ticket signing keys: %v", err)
+	}
+
 	opp_handlers := &opp_handlers{
-		CarHandlers:    *handlers.NewCarHandler(),
-		TicketHandlers: *handlers.NewTicketHandler(),
-		FineHandlers:   *handlers.NewFineHandler(),
-		ZoneHandlers:   *handlers.NewZoneHandler(),
-		TotemHandlers:  *handlers.NewTotemHandler(),
+		CarHandlers:            *handlers.NewCarHandler(),
+		TicketHandlers:         *handlers.NewTicketHandler(),
+		FineHandlers:           *handlers.NewFineHandler(),
+		ZoneHandlers:           *handlers.NewZoneHandler(),
+		TotemHandlers:          *handlers.NewTotemHandler(),
+		SessionHandlers:        *handlers.NewSessionHandler(),
+		TariffHandlers:         *handlers.NewTariffHandler(),
+		PricingHandlers:        *handlers.NewPricingHandler(),
+		PaymentHandlers:        *handlers.NewPaymentHandler(),
+		RefundHandlers:         *handlers.NewRefundHandler(),
+		EnforcementHandlers:    *handlers.NewEnforcementHandler(),
+		AppealHandlers:         *handlers.NewAppealHandler(),
+		EvidenceHandlers:       *handlers.NewEvidenceHandler(),
+		NoticeHandlers:         *handlers.NewNoticeHandler(),
+		TicketKeyHandlers:      *handlers.NewTicketKeyHandler(),
+		PermitHandlers:         *handlers.NewPermitHandler(),
+		SubscriptionHandlers:   *handlers.NewSubscriptionHandler(),
+		DiscountHandlers:       *handlers.NewDiscountHandler(),
+		WalletHandlers:         *handlers.NewWalletHandler(),
+		OperatorHandlers:       *handlers.NewOperatorHandler(),
+		InvoiceHandlers:        *handlers.NewInvoiceHandler(),
+		OccupancyHandlers:      *handlers.NewOccupancyHandler(),
+		DynamicPricingHandlers: *handlers.NewDynamicPricingHandler(),
 	}
 
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	jobs.Every(jobsCtx, "session-autostop", time.Minute, dao.NewSessionDao().AutoStopExpiredSessions)
+	jobs.Every(jobsCtx, "idempotency-cleanup", time.Hour, dao.NewIdempotencyDao().DeleteExpiredKeys)
+	jobs.Every(jobsCtx, "fine-stages", time.Hour, dao.NewFineDao().AdvanceFineStages)
+	jobs.Every(jobsCtx, "ticket-keys", time.Hour, dao.NewTicketKeyDao().RotateTicketKeys)
+	// Renewals charged without a provider would all fail and end in dunning
+	if paymentsEnabled {
+		jobs.Every(jobsCtx, "subscription-billing", time.Hour, dao.NewSubscriptionDao().BillSubscriptions)
+		jobs.Every(jobsCtx, "pending-refunds", dao.PendingRefundsInterval, dao.NewRefundDao().SettlePendingRefunds)
+	}
+	jobs.Every(jobsCtx, "invoicing", time.Hour, dao.NewInvoiceDao().IssueInvoices)
+	jobs.Every(jobsCtx, "occupancy-snapshots", dao.OccupancySnapshotInterval, dao.NewOccupancyDao().SnapshotOccupancy)
+	jobs.Every(jobsCtx, "dynamic-pricing", dao.DynamicPricingInterval, dao.NewDynamicPricingDao().AdjustPriceLevels)
+
+	serveMetrics()
+
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
@@ -81,6 +189,10 @@ func main() {
 		log.Panicf("Failed to create validator: %v", err)
 	}
 	r.Use(validator)
+
+	// Retried mutating requests with the same Idempotency-Key are replayed,
+	// after the validator so that keys are scoped by the authenticated user
+	r.Use(idempotency.Middleware(dao.NewIdempotencyDao(), idempotency.TTL()))
 	r.SetTrustedProxies(nil)
 
 	options := api.GinServerOptions{
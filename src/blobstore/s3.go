@@ -0,0 +1,214 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 compatible store settings: endpoint URL (such as https://s3.eu-west-1.amazonaws.com
+// or a MinIO server), bucket, region and credentials
+var (
+	BLOB_S3_ENDPOINT   = os.Getenv("BLOB_S3_ENDPOINT")
+	BLOB_S3_BUCKET     = os.Getenv("BLOB_S3_BUCKET")
+	BLOB_S3_REGION     = os.Getenv("BLOB_S3_REGION")
+	BLOB_S3_ACCESS_KEY = os.Getenv("BLOB_S3_ACCESS_KEY")
+	BLOB_S3_SECRET_KEY = os.Getenv("BLOB_S3_SECRET_KEY")
+)
+
+const defaultS3Region = "us-east-1"
+
+var ErrS3Config = errors.New("incomplete S3 blob store configuration")
+
+// S3Store keeps blobs in a bucket of an S3 compatible service. Objects are
+// addressed path style, which every compatible service supports, and
+// requests are signed with AWS Signature Version 4.
+type S3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// NewS3Store creates a store for bucket at endpoint
+func NewS3Store(endpoint string, bucket string, region string, accessKey string, secretKey string) (*S3Store, error) {
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, ErrS3Config
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid endpoint %q", ErrS3Config, endpoint)
+	}
+	if region == "" {
+		region = defaultS3Region
+	}
+	return &S3Store{
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// NewS3StoreFromEnv creates the store described by the BLOB_S3_* variables
+func NewS3StoreFromEnv() (*S3Store, error) {
+	return NewS3Store(BLOB_S3_ENDPOINT, BLOB_S3_BUCKET, BLOB_S3_REGION, BLOB_S3_ACCESS_KEY, BLOB_S3_SECRET_KEY)
+}
+
+func (s *S3Store) Name() string {
+	return "s3"
+}
+
+// objectPath is the escaped path of an object, keys are already limited to
+// characters that need no escaping
+func (s *S3Store) objectPath(key string) string {
+	return strings.TrimSuffix(s.endpoint.Path, "/") + "/" + url.PathEscape(s.bucket) + "/" + key
+}
+
+func (s *S3Store) do(ctx context.Context, method string, key string, body []byte, contentType string) (*http.Response, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	u := *s.endpoint
+	u.Path = s.objectPath(key)
+	u.RawPath = u.Path
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds the Signature Version 4 authorization of a request
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest(req, hex.EncodeToString(payloadHash[:]), amzDate)))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, s.signature(date, stringToSign)))
+}
+
+// The headers covered by the signature of a request
+const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+
+// canonicalRequest is the form of a request that Signature Version 4 signs
+func canonicalRequest(req *http.Request, payloadHash string, amzDate string) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+// canonicalQuery sorts the query parameters by name, then by value, and
+// escapes them as Signature Version 4 does, spaces as %20
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// signature signs stringToSign with the key derived from the secret key for
+// the date, the region and the s3 service
+func (s *S3Store) signature(date string, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// statusError describes an unexpected response of the service
+func statusError(action string, resp *http.Response) error {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("failed to %s blob: %s: %s", action, resp.Status, strings.TrimSpace(string(detail)))
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	resp, err := s.do(ctx, http.MethodPut, key, data, contentType)
+	if err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError("store", resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, statusError("read", resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+	return data, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return statusError("delete", resp)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package blobstore keeps the files uploaded to the backend, such as the
+// photos attached to fines, outside of the database.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Blob store used by the backend, "local" by default
+var BLOB_STORE = os.Getenv("BLOB_STORE")
+
+var (
+	ErrUnknownStore = errors.New("unknown blob store")
+	ErrNotFound     = errors.New("blob not found")
+	ErrInvalidKey   = errors.New("invalid blob key")
+)
+
+// Store keeps blobs by key. Keys are slash separated paths of letters,
+// digits, '-', '_' and '.', such as "evidence/3f2a...".
+type Store interface {
+	// Name identifies the store in logs
+	Name() string
+	// Put stores data under key, replacing any previous blob
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the blob stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the blob stored under key, if any
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey tells whether key is a relative path that cannot escape the
+// root of a store
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	store     Store
+	storeOnce sync.Once
+	storeErr  error
+)
+
+// NewStore creates the store called name from its environment
+func NewStore(name string) (Store, error) {
+	switch name {
+	case "", "local":
+		return NewLocalStore(localDir())
+	case "s3":
+		return NewS3StoreFromEnv()
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStore, name)
+	}
+}
+
+// InitStore creates the store configured by BLOB_STORE
+func InitStore() error {
+	storeOnce.Do(func() {
+		store, storeErr = NewStore(BLOB_STORE)
+	})
+	return storeErr
+}
+
+// GetStore returns the configured store, InitStore must have been called
+func GetStore() Store {
+	return store
+}
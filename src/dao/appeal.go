@@ -0,0 +1,300 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Appeal states, the last three are decisions
+const (
+	AppealStatusPending   = "pending"
+	AppealStatusUpheld    = "upheld"
+	AppealStatusReduced   = "reduced"
+	AppealStatusCancelled = "cancelled"
+)
+
+var (
+	ErrAppealNotFound        = errors.New("appeal not found")
+	ErrAppealAlreadyDecided  = errors.New("appeal already decided")
+	ErrAppealAlreadyPending  = errors.New("fine already has a pending appeal")
+	ErrAppealInvalid         = errors.New("invalid appeal")
+	ErrAppealDecisionInvalid = errors.New("invalid appeal decision")
+	ErrFineNotOwned          = errors.New("fine not owned by user")
+)
+
+type AppealDao struct {
+	db db.DB
+}
+
+func NewAppealDao() *AppealDao {
+	return &AppealDao{
+		db: *db.GetDB(),
+	}
+}
+
+const appealColumns = "id, fine_id, status, text, evidence, opened_by, original_amount, decided_amount, currency, decided_by, decision_reason, decided_at, creation_time"
+
+func scanAppeal(row pgx.Row) (*api.FineAppeal, error) {
+	var appeal api.FineAppeal
+	var decidedAmount *int64
+	if err := row.Scan(&appeal.Id, &appeal.FineId, &appeal.Status, &appeal.Text, &appeal.Evidence, &appeal.OpenedBy, &appeal.OriginalAmount.Amount, &decidedAmount, &appeal.OriginalAmount.Currency, &appeal.DecidedBy, &appeal.DecisionReason, &appeal.DecidedAt, &appeal.CreationTime); err != nil {
+		return nil, err
+	}
+	if decidedAmount != nil {
+		appeal.DecidedAmount = &api.Money{Amount: *decidedAmount, Currency: appeal.OriginalAmount.Currency}
+	}
+	appeal.Events = []api.FineAppealEvent{}
+	return &appeal, nil
+}
+
+// recordEvent records a state transition of an appeal
+func (d *AppealDao) recordEvent(c context.Context, appealId int64, from *string, to string, actor string, note *string) error {
+	query := "INSERT INTO fine_appeal_events (appeal_id, from_status, to_status, actor, note) VALUES ($1, $2, $3, $4, $5)"
+	if _, err := d.db.Exec(c, query, appealId, from, to, actor, note); err != nil {
+		return fmt.Errorf("failed to record appeal event: %w", err)
+	}
+	return nil
+}
+
+// withEvents loads the history of each appeal
+func (d *AppealDao) withEvents(c context.Context, appeals []api.FineAppeal) ([]api.FineAppeal, error) {
+	if len(appeals) == 0 {
+		return appeals, nil
+	}
+	ids := make([]int64, len(appeals))
+	index := map[int64]int{}
+	for i, appeal := range appeals {
+		ids[i] = appeal.Id
+		index[appeal.Id] = i
+	}
+
+	query := "SELECT id, appeal_id, from_status, to_status, actor, note, creation_time FROM fine_appeal_events WHERE appeal_id = ANY($1) ORDER BY id"
+	rows, err := d.db.Query(c, query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query appeal events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event api.FineAppealEvent
+		var appealId int64
+		if err := rows.Scan(&event.Id, &appealId, &event.FromStatus, &event.ToStatus, &event.Actor, &event.Note, &event.CreationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan appeal event: %w", err)
+		}
+		i := index[appealId]
+		appeals[i].Events = append(appeals[i].Events, event)
+	}
+	return appeals, nil
+}
+
+func (d *AppealDao) queryAppeals(c context.Context, query string, args ...any) ([]api.FineAppeal, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query appeals: %w", err)
+	}
+	defer rows.Close()
+
+	appeals := []api.FineAppeal{}
+	for rows.Next() {
+		appeal, err := scanAppeal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appeal: %w", err)
+		}
+		appeals = append(appeals, *appeal)
+	}
+	rows.Close()
+
+	return d.withEvents(c, appeals)
+}
+
+func (d *AppealDao) GetAppealById(c context.Context, id int64) (*api.FineAppeal, error) {
+	appeals, err := d.queryAppeals(c, "SELECT "+appealColumns+" FROM fine_appeals WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(appeals) == 0 {
+		return nil, ErrAppealNotFound
+	}
+	return &appeals[0], nil
+}
+
+// GetFineAppeals returns the appeals of a fine with their history
+func (d *AppealDao) GetFineAppeals(c context.Context, fineId int64) ([]api.FineAppeal, error) {
+	return d.queryAppeals(c, "SELECT "+appealColumns+" FROM fine_appeals WHERE fine_id = $1 ORDER BY id", fineId)
+}
+
+// GetZoneAppeals returns the appeals of the fines of a zone, newest first
+func (d *AppealDao) GetZoneAppeals(c context.Context, zoneId int64, pendingOnly bool) ([]api.FineAppeal, error) {
+	query := "SELECT " + appealColumns + " FROM fine_appeals WHERE fine_id IN (SELECT id FROM fines WHERE zone_id = $1)"
+	if pendingOnly {
+		query += " AND status = 'pending'"
+	}
+	query += " ORDER BY id DESC"
+	return d.queryAppeals(c, query, zoneId)
+}
+
+// IsFineOwner tells whether username owns the car of a fine
+func (d *AppealDao) IsFineOwner(c context.Context, fineId int64, username string) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM fines AS f JOIN cars ON cars.plate = f.plate WHERE f.id = $1 AND cars.user_id = $2)"
+	var owned bool
+	if err := d.db.QueryRow(c, query, fineId, username).Scan(&owned); err != nil {
+		return false, fmt.Errorf("failed to check fine ownership: %w", err)
+	}
+	return owned, nil
+}
+
+// CreateFineAppeal opens an appeal on an unpaid fine of the driver and
+// freezes the fine until the appeal is decided
+func (d *AppealDao) CreateFineAppeal(c context.Context, username string, fineId int64, request api.FineAppealRequest) (*api.FineAppeal, error) {
+	if strings.TrimSpace(request.Text) == "" {
+		return nil, fmt.Errorf("%w: text is required", ErrAppealInvalid)
+	}
+	evidence := []string{}
+	if request.Evidence != nil {
+		evidence = *request.Evidence
+	}
+
+	var appealId int64
+	err := d.db.WithTx(c, func(c context.Context) error {
+		lockQuery := "SELECT paid, status FROM fines WHERE id = $1 FOR UPDATE"
+		var paid bool
+		var status string
+		if err := d.db.QueryRow(c, lockQuery, fineId).Scan(&paid, &status); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrFineNotFound
+			}
+			return fmt.Errorf("failed to lock fine: %w", err)
+		}
+
+		owned, err := d.IsFineOwner(c, fineId, username)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return ErrFineNotOwned
+		}
+
+		if paid {
+			return ErrFineAlreadyPaid
+		}
+		switch status {
+		case FineStatusAppealed:
+			return ErrAppealAlreadyPending
+		case FineStatusCancelled:
+			return ErrFineCancelled
+		}
+
+		// The appeal freezes the amount of the current stage
+		if err := NewFineDao().advanceFine(c, fineId); err != nil {
+			return err
+		}
+
+		insertQuery := "INSERT INTO fine_appeals (fine_id, text, evidence, opened_by, original_amount, currency) SELECT id, $2, $3, $4, amount, currency FROM fines WHERE id = $1 RETURNING id"
+		if err := d.db.QueryRow(c, insertQuery, fineId, request.Text, evidence, username).Scan(&appealId); err != nil {
+			return fmt.Errorf("failed to add appeal: %w", err)
+		}
+		if _, err := d.db.Exec(c, "UPDATE fines SET status = $2 WHERE id = $1", fineId, FineStatusAppealed); err != nil {
+			return fmt.Errorf("failed to freeze fine: %w", err)
+		}
+		return d.recordEvent(c, appealId, nil, AppealStatusPending, username, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetAppealById(c, appealId)
+}
+
+// DecideFineAppeal closes a pending appeal. Upholding it reopens the fine
+// as it was, reducing it reopens it with a lower amount and cancelling it
+// cancels the fine.
+func (d *AppealDao) DecideFineAppeal(c context.Context, username string, appealId int64, decision api.FineAppealDecision) (*api.FineAppeal, error) {
+	if strings.TrimSpace(decision.Reason) == "" {
+		return nil, fmt.Errorf("%w: reason is required", ErrAppealDecisionInvalid)
+	}
+
+	err := d.db.WithTx(c, func(c context.Context) error {
+		// Lock the appeal, a concurrent decision waits and then sees it decided
+		query := "SELECT " + appealColumns + " FROM fine_appeals WHERE id = $1 FOR UPDATE"
+		appeal, err := scanAppeal(d.db.QueryRow(c, query, appealId))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrAppealNotFound
+			}
+			return fmt.Errorf("failed to lock appeal: %w", err)
+		}
+		if appeal.Status != AppealStatusPending {
+			return ErrAppealAlreadyDecided
+		}
+
+		fineStatus := FineStatusOpen
+		var decidedAmount *int64
+		switch decision.Outcome {
+		case AppealStatusUpheld:
+		case AppealStatusReduced:
+			if decision.Amount == nil || *decision.Amount <= 0 || *decision.Amount >= appeal.OriginalAmount.Amount {
+				return fmt.Errorf("%w: a reduced amount must be positive and lower than %d", ErrAppealDecisionInvalid, appeal.OriginalAmount.Amount)
+			}
+			decidedAmount = decision.Amount
+		case AppealStatusCancelled:
+			fineStatus = FineStatusCancelled
+		default:
+			return fmt.Errorf("%w: unknown outcome %q", ErrAppealDecisionInvalid, decision.Outcome)
+		}
+
+		updateQuery := "UPDATE fine_appeals SET status = $2, decided_amount = $3, decided_by = $4, decision_reason = $5, decided_at = NOW() WHERE id = $1"
+		if _, err := d.db.Exec(c, updateQuery, appealId, decision.Outcome, decidedAmount, username, decision.Reason); err != nil {
+			return fmt.Errorf("failed to decide appeal: %w", err)
+		}
+
+		// A reduced amount is final, stages no longer apply to it. The
+		// stages of a reopened fine resume where the appeal paused them.
+		fineQuery := `
+			UPDATE fines SET
+				status = $2,
+				amount = COALESCE($3, amount),
+				amount_final = amount_final OR $3 IS NOT NULL,
+				stage_paused = stage_paused + (SELECT NOW() - creation_time FROM fine_appeals WHERE id = $4)
+			WHERE id = $1
+		`
+		if _, err := d.db.Exec(c, fineQuery, appeal.FineId, fineStatus, decidedAmount, appealId); err != nil {
+			return fmt.Errorf("failed to update fine: %w", err)
+		}
+
+		if err := d.recordEvent(c, appealId, &appeal.Status, decision.Outcome, username, &decision.Reason); err != nil {
+			return err
+		}
+
+		// Payments confirmed before the appeal may now cover a reduced fine
+		if fineStatus == FineStatusOpen {
+			if _, err := NewPaymentDao().settle(c, PaymentTargetFine, appeal.FineId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetAppealById(c, appealId)
+}
+
+// GetAppealZone returns the zone of the fine an appeal is about
+func (d *AppealDao) GetAppealZone(c context.Context, appealId int64) (int64, error) {
+	query := "SELECT f.zone_id FROM fine_appeals AS a JOIN fines AS f ON f.id = a.fine_id WHERE a.id = $1"
+	var zoneId int64
+	if err := d.db.QueryRow(c, query, appealId).Scan(&zoneId); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrAppealNotFound
+		}
+		return 0, fmt.Errorf("failed to get appeal zone: %w", err)
+	}
+	return zoneId, nil
+}
@@ -7,13 +7,26 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/jackc/pgx/v5"
 )
 
 var (
 	ErrCarAlreadyExists = errors.New("car already exists")
 	ErrCarNotFound      = errors.New("car not found")
+	ErrCarNotOwned      = errors.New("car not owned by user")
 )
 
+// A car is currently parked when it has a paid ticket covering the current
+// time or an active parking session
+const carCurrentlyParkedCondition = `(EXISTS (
+		SELECT 1 FROM tickets t
+		WHERE t.plate = c.plate AND t.paid = TRUE AND t.start_date <= NOW() AND t.paid_until >= NOW()
+	) OR EXISTS (
+		SELECT 1 FROM parking_sessions s
+		WHERE s.plate = c.plate AND s.status = 'active'
+	))`
+
 type CarDao struct {
 	db db.DB
 }
@@ -25,9 +38,9 @@ func NewCarDao() *CarDao {
 }
 
 func (d *CarDao) GetCars(c context.Context, limit *int, offset *int, currentlyParked *bool) []api.Car {
-	query := "SELECT c.plate, c.brand, c.model FROM cars c"
+	query := "SELECT c.plate, c.brand, c.model, c.electric, c.disabled_badge FROM cars c"
 	if currentlyParked != nil && *currentlyParked {
-		query += " INNER JOIN tickets t ON c.plate = t.plate WHERE t.status = 'active'"
+		query += " WHERE " + carCurrentlyParkedCondition
 	}
 	query += " LIMIT $1 OFFSET $2"
 
@@ -49,7 +62,7 @@ func (d *CarDao) GetCars(c context.Context, limit *int, offset *int, currentlyPa
 
 	for rows.Next() {
 		var car api.Car
-		if err := rows.Scan(&car.Plate, &car.Brand, &car.Model); err != nil {
+		if err := rows.Scan(&car.Plate, &car.Brand, &car.Model, &car.Electric, &car.DisabledBadge); err != nil {
 			fmt.Printf("row scan error: %v\n", err.Error())
 			continue
 		}
@@ -60,12 +73,10 @@ func (d *CarDao) GetCars(c context.Context, limit *int, offset *int, currentlyPa
 }
 
 func (d *CarDao) GetUserCars(c context.Context, username string, currentlyParked *bool) []api.Car {
-	query := "SELECT c.plate, c.brand, c.model FROM cars c WHERE c.user_id = $1"
+	query := "SELECT c.plate, c.brand, c.model, c.electric, c.disabled_badge FROM cars c WHERE c.user_id = $1"
 	params := []any{username}
 	if currentlyParked != nil && *currentlyParked {
-		query = "SELECT c.plate, c.brand, c.model FROM cars c " +
-			"INNER JOIN tickets t ON c.plate = t.plate " +
-			"WHERE c.user_id = ? AND t.status = 'active'"
+		query += " AND " + carCurrentlyParkedCondition
 	}
 
 	cars := []api.Car{}
@@ -78,7 +89,7 @@ func (d *CarDao) GetUserCars(c context.Context, username string, currentlyParked
 
 	for rows.Next() {
 		var car api.Car
-		if err := rows.Scan(&car.Plate, &car.Brand, &car.Model); err != nil {
+		if err := rows.Scan(&car.Plate, &car.Brand, &car.Model, &car.Electric, &car.DisabledBadge); err != nil {
 			fmt.Printf("row scan error: %v\n", err.Error())
 			continue
 		}
@@ -88,6 +99,9 @@ func (d *CarDao) GetUserCars(c context.Context, username string, currentlyParked
 	return cars
 }
 
+// AddUserCar adds a car of a user. Its electric and disabled_badge
+// attributes grant discounts, only superusers set them with
+// SetCarAttributes.
 func (d *CarDao) AddUserCar(c context.Context, username string, car api.Car) error {
 	query := "INSERT INTO cars (plate, brand, model, user_id) VALUES ($1, $2, $3, $4)"
 	_, err := d.db.Exec(c, query, car.Plate, car.Brand, car.Model, username)
@@ -113,6 +127,7 @@ func (d *CarDao) UpdateUserCar(c context.Context, username string, car api.Car)
 		return ErrCarNotFound
 	}
 
+	// The attributes granting discounts are left to SetCarAttributes
 	query := "UPDATE cars SET brand = $1, model = $2 WHERE plate = $3 AND user_id = $4"
 	_, err = d.db.Exec(c, query, car.Brand, car.Model, car.Plate, username)
 	if err != nil {
@@ -122,6 +137,21 @@ func (d *CarDao) UpdateUserCar(c context.Context, username string, car api.Car)
 	return nil
 }
 
+// SetCarAttributes sets the verified attributes of a car, attributes left
+// out are kept
+func (d *CarDao) SetCarAttributes(c context.Context, plate string, attributes api.CarAttributes) (*api.Car, error) {
+	query := "UPDATE cars SET electric = COALESCE($2, electric), disabled_badge = COALESCE($3, disabled_badge) WHERE plate = $1 RETURNING plate, brand, model, electric, disabled_badge"
+	var car api.Car
+	err := d.db.QueryRow(c, query, plate, attributes.Electric, attributes.DisabledBadge).Scan(&car.Plate, &car.Brand, &car.Model, &car.Electric, &car.DisabledBadge)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCarNotFound
+		}
+		return nil, fmt.Errorf("failed to set car attributes: %w", err)
+	}
+	return &car, nil
+}
+
 func (d *CarDao) DeleteUserCar(c context.Context, username string, plate string) error {
 	query := "DELETE FROM cars WHERE user_id = $1 AND plate = $2"
 	result, err := d.db.Exec(c, query, username, plate)
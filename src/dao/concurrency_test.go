@@ -0,0 +1,247 @@
+package dao
+
+import (
+	"OPP/backend/db"
+	"OPP/backend/payments"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Number of concurrent payment attempts
+const parallelPayments = 16
+
+// connectTestDB connects to the database described by the usual
+// environment, skipping the test when there is none. A configured database
+// that cannot be initialized, migrations included, fails the test.
+func connectTestDB(t *testing.T) {
+	t.Helper()
+	if db.OPP_BACKEND_DB_HOST == "" {
+		t.Skip("OPP_BACKEND_DB_HOST not set, skipping database test")
+	}
+	if err := db.Init(); err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+}
+
+// zoneFixture creates a zone and a car of the user race, removed when the
+// test ends, and returns a suffix unique to the test
+func zoneFixture(t *testing.T) (zoneId int64, plate string, suffix string) {
+	t.Helper()
+	c := context.Background()
+	d := db.GetDB()
+
+	suffix = fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63())
+	plate = "RACE" + suffix[len(suffix)-6:]
+	// A tiny square somewhere in the South Pacific, away from real zones
+	lon, lat := -150+rand.Float64()*10, -40+rand.Float64()*10
+	geometry := fmt.Sprintf(`{"type":"MultiPolygon","coordinates":[[[[%f,%f],[%f,%f],[%f,%f],[%f,%f],[%f,%f]]]]}`,
+		lon, lat, lon+0.0001, lat, lon+0.0001, lat+0.0001, lon, lat+0.0001, lon, lat)
+
+	if err := d.QueryRow(c, "INSERT INTO zones (name, geometry) VALUES ($1, ST_GeomFromGeoJSON($2)) RETURNING id", "race-"+suffix, geometry).Scan(&zoneId); err != nil {
+		t.Fatalf("failed to create zone: %v", err)
+	}
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM zones WHERE id = $1", zoneId)
+		d.Exec(c, "DELETE FROM cars WHERE plate = $1", plate)
+	})
+
+	if _, err := d.Exec(c, "INSERT INTO cars (plate, user_id) VALUES ($1, 'race')", plate); err != nil {
+		t.Fatalf("failed to create car: %v", err)
+	}
+	return zoneId, plate, suffix
+}
+
+// payFixture creates a zone, a car and a ticket and a fine of 500 minor units
+// on it, both covered by a confirmed payment. Everything is removed when the
+// test ends.
+func payFixture(t *testing.T) (ticketId int64, fineId int64) {
+	t.Helper()
+	c := context.Background()
+	d := db.GetDB()
+
+	zoneId, plate, suffix := zoneFixture(t)
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM payments WHERE (target_type = 'ticket' AND target_id = $1) OR (target_type = 'fine' AND target_id = $2)", ticketId, fineId)
+	})
+
+	now := time.Now()
+	if err := d.QueryRow(c, "INSERT INTO tickets (zone_id, plate, start_date, end_date, price, currency) VALUES ($1, $2, $3, $4, 500, 'EUR') RETURNING id", zoneId, plate, now, now.Add(time.Hour)).Scan(&ticketId); err != nil {
+		t.Fatalf("failed to create ticket: %v", err)
+	}
+	if err := d.QueryRow(c, "INSERT INTO fines (zone_id, plate, date, amount, base_amount, currency) VALUES ($1, $2, $3, 500, 500, 'EUR') RETURNING id", zoneId, plate, now).Scan(&fineId); err != nil {
+		t.Fatalf("failed to create fine: %v", err)
+	}
+
+	paymentQuery := "INSERT INTO payments (target_type, target_id, username, amount, currency, provider, provider_ref, status) VALUES ($1, $2, 'race', 500, 'EUR', 'test', $3, 'confirmed')"
+	if _, err := d.Exec(c, paymentQuery, PaymentTargetTicket, ticketId, "ticket-"+suffix); err != nil {
+		t.Fatalf("failed to create ticket payment: %v", err)
+	}
+	if _, err := d.Exec(c, paymentQuery, PaymentTargetFine, fineId, "fine-"+suffix); err != nil {
+		t.Fatalf("failed to create fine payment: %v", err)
+	}
+
+	return ticketId, fineId
+}
+
+// race runs pay parallelPayments times at once and checks that exactly one
+// call succeeds while all the others report alreadyPaid
+func race(t *testing.T, alreadyPaid error, pay func(c context.Context) error) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	results := make(chan error, parallelPayments)
+	for i := 0; i < parallelPayments; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			results <- pay(context.Background())
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	wins := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			wins++
+		case errors.Is(err, alreadyPaid):
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if wins != 1 {
+		t.Fatalf("%d of %d parallel payments won, want exactly 1", wins, parallelPayments)
+	}
+}
+
+func TestParallelTicketPayments(t *testing.T) {
+	connectTestDB(t)
+	ticketId, _ := payFixture(t)
+
+	tickets := NewTicketDao()
+	race(t, ErrTicketAlreadyPaid, func(c context.Context) error {
+		_, err := tickets.PayTicket(c, ticketId)
+		return err
+	})
+}
+
+func TestParallelFinePayments(t *testing.T) {
+	connectTestDB(t)
+	_, fineId := payFixture(t)
+
+	fines := NewFineDao()
+	race(t, ErrFineAlreadyPaid, func(c context.Context) error {
+		return fines.PayFine(c, fineId)
+	})
+}
+
+// walletFixture creates parallelPayments unpaid tickets of 500 minor units
+// and a wallet of the user race holding 500, so that the wallet can pay a
+// single ticket. Everything is removed when the test ends.
+func walletFixture(t *testing.T) (username string, ticketIds []int64) {
+	t.Helper()
+	c := context.Background()
+	d := db.GetDB()
+
+	zoneId, plate, suffix := zoneFixture(t)
+	username = "race-" + suffix
+
+	now := time.Now()
+	for i := 0; i < parallelPayments; i++ {
+		var ticketId int64
+		if err := d.QueryRow(c, "INSERT INTO tickets (zone_id, plate, start_date, end_date, price, currency) VALUES ($1, $2, $3, $4, 500, 'EUR') RETURNING id", zoneId, plate, now, now.Add(time.Hour)).Scan(&ticketId); err != nil {
+			t.Fatalf("failed to create ticket: %v", err)
+		}
+		ticketIds = append(ticketIds, ticketId)
+	}
+
+	var walletId int64
+	if err := d.QueryRow(c, "INSERT INTO wallets (username, currency, balance) VALUES ($1, 'EUR', 500) RETURNING id", username).Scan(&walletId); err != nil {
+		t.Fatalf("failed to create wallet: %v", err)
+	}
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM payments WHERE username = $1", username)
+		d.Exec(c, "DELETE FROM ledger_entries WHERE transaction_id IN (SELECT transaction_id FROM ledger_entries WHERE wallet_id = $1)", walletId)
+		d.Exec(c, "DELETE FROM ledger_transactions WHERE target_type = 'ticket' AND target_id = ANY($1)", ticketIds)
+		d.Exec(c, "DELETE FROM wallets WHERE id = $1", walletId)
+	})
+
+	return username, ticketIds
+}
+
+func TestParallelWalletPayments(t *testing.T) {
+	connectTestDB(t)
+	username, ticketIds := walletFixture(t)
+
+	tickets := NewTicketDao()
+	var next atomic.Int64
+	race(t, ErrWalletInsufficientFunds, func(c context.Context) error {
+		ticketId := ticketIds[next.Add(1)-1]
+		_, err := tickets.PayTicketWithWallet(c, username, ticketId)
+		return err
+	})
+
+	var balance int64
+	if err := db.GetDB().QueryRow(context.Background(), "SELECT balance FROM wallets WHERE username = $1", username).Scan(&balance); err != nil {
+		t.Fatalf("failed to get wallet balance: %v", err)
+	}
+	if balance != 0 {
+		t.Fatalf("wallet balance is %d, want 0", balance)
+	}
+}
+
+func TestParallelPaymentIntents(t *testing.T) {
+	connectTestDB(t)
+	c := context.Background()
+	d := db.GetDB()
+
+	zoneId, plate, _ := zoneFixture(t)
+	var ticketId int64
+	now := time.Now()
+	if err := d.QueryRow(c, "INSERT INTO tickets (zone_id, plate, start_date, end_date, price, currency) VALUES ($1, $2, $3, $4, 500, 'EUR') RETURNING id", zoneId, plate, now, now.Add(time.Hour)).Scan(&ticketId); err != nil {
+		t.Fatalf("failed to create ticket: %v", err)
+	}
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM payments WHERE target_type = 'ticket' AND target_id = $1", ticketId)
+	})
+
+	// Every attempt gets the same pending payment
+	paymentDao := &PaymentDao{db: *d, provider: payments.NewMockProvider("")}
+	var wg sync.WaitGroup
+	ids := make(chan int64, parallelPayments)
+	for i := 0; i < parallelPayments; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payment, err := paymentDao.CreatePayment(c, "race", PaymentTargetTicket, ticketId)
+			if err != nil {
+				t.Errorf("create payment: %v", err)
+				return
+			}
+			if payment.ClientSecret == nil || *payment.ClientSecret == "" {
+				t.Errorf("payment %d has no client secret", payment.Id)
+			}
+			ids <- payment.Id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	distinct := map[int64]bool{}
+	for id := range ids {
+		distinct[id] = true
+	}
+	if len(distinct) != 1 {
+		t.Fatalf("%d payments opened, want 1", len(distinct))
+	}
+}
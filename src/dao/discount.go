@@ -0,0 +1,433 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/pricing"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Car attributes a discount may require
+const (
+	DiscountRequiresElectric      = "electric"
+	DiscountRequiresDisabledBadge = "disabled_badge"
+)
+
+var (
+	ErrDiscountNotFound    = errors.New("discount not found")
+	ErrDiscountInvalid     = errors.New("invalid discount")
+	ErrDiscountCodeTaken   = errors.New("discount code already exists")
+	ErrDiscountCodeInvalid = errors.New("discount code not applicable")
+)
+
+type DiscountDao struct {
+	db db.DB
+}
+
+func NewDiscountDao() *DiscountDao {
+	return &DiscountDao{
+		db: *db.GetDB(),
+	}
+}
+
+const discountColumns = `d.id, d.name, d.code, d.kind, d.value, d.currency, d.zone_ids::BIGINT[], d.requires, d.valid_from, d.valid_until,
+	d.max_uses, d.max_uses_per_user, d.active, (SELECT COUNT(*) FROM discount_redemptions WHERE discount_id = d.id), d.creation_time`
+
+func scanDiscount(row pgx.Row) (*api.Discount, error) {
+	var discount api.Discount
+	if err := row.Scan(&discount.Id, &discount.Name, &discount.Code, &discount.Kind, &discount.Value, &discount.Currency, &discount.ZoneIds, &discount.Requires, &discount.ValidFrom, &discount.ValidUntil, &discount.MaxUses, &discount.MaxUsesPerUser, &discount.Active, &discount.Uses, &discount.CreationTime); err != nil {
+		return nil, err
+	}
+	return &discount, nil
+}
+
+func (d *DiscountDao) GetDiscounts(c context.Context) ([]api.Discount, error) {
+	rows, err := d.db.Query(c, "SELECT "+discountColumns+" FROM discounts AS d ORDER BY d.id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query discounts: %w", err)
+	}
+	defer rows.Close()
+
+	discounts := []api.Discount{}
+	for rows.Next() {
+		discount, err := scanDiscount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan discount: %w", err)
+		}
+		discounts = append(discounts, *discount)
+	}
+	return discounts, nil
+}
+
+func (d *DiscountDao) GetDiscountById(c context.Context, id int64) (*api.Discount, error) {
+	discount, err := scanDiscount(d.db.QueryRow(c, "SELECT "+discountColumns+" FROM discounts AS d WHERE d.id = $1", id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDiscountNotFound
+		}
+		return nil, fmt.Errorf("failed to get discount: %w", err)
+	}
+	return discount, nil
+}
+
+// discountRecord is a checked discount request with its defaults
+type discountRecord struct {
+	code     *string
+	currency *string
+	active   bool
+}
+
+// normalizeDiscountCode makes codes case insensitive
+func normalizeDiscountCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// discountValues checks a discount request. Only amount discounts have a
+// currency, they apply in the zones priced in it.
+func (d *DiscountDao) discountValues(c context.Context, request api.DiscountRequest) (*discountRecord, error) {
+	record := &discountRecord{active: true}
+	if request.Active != nil {
+		record.active = *request.Active
+	}
+
+	if strings.TrimSpace(request.Name) == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrDiscountInvalid)
+	}
+	if err := (pricing.Rule{Kind: request.Kind, Value: request.Value}).Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscountInvalid, err)
+	}
+	if request.Code != nil {
+		code := normalizeDiscountCode(*request.Code)
+		if code == "" {
+			return nil, fmt.Errorf("%w: code must not be blank", ErrDiscountInvalid)
+		}
+		record.code = &code
+	}
+	if request.Kind == pricing.DiscountAmount {
+		if request.Currency == nil || *request.Currency == "" {
+			return nil, fmt.Errorf("%w: currency is required for amount discounts", ErrDiscountInvalid)
+		}
+		record.currency = request.Currency
+	}
+	if request.Requires != nil && *request.Requires != DiscountRequiresElectric && *request.Requires != DiscountRequiresDisabledBadge {
+		return nil, fmt.Errorf("%w: requires is electric or disabled_badge", ErrDiscountInvalid)
+	}
+	if request.ValidFrom != nil && request.ValidUntil != nil && !request.ValidUntil.After(*request.ValidFrom) {
+		return nil, fmt.Errorf("%w: valid_until must be after valid_from", ErrDiscountInvalid)
+	}
+	if request.MaxUses != nil && *request.MaxUses <= 0 {
+		return nil, fmt.Errorf("%w: max_uses must be positive", ErrDiscountInvalid)
+	}
+	if request.MaxUsesPerUser != nil && *request.MaxUsesPerUser <= 0 {
+		return nil, fmt.Errorf("%w: max_uses_per_user must be positive", ErrDiscountInvalid)
+	}
+
+	if request.ZoneIds != nil {
+		zones := map[int64]bool{}
+		for _, zoneId := range *request.ZoneIds {
+			if zones[zoneId] {
+				return nil, fmt.Errorf("%w: zone_ids must be distinct", ErrDiscountInvalid)
+			}
+			zones[zoneId] = true
+		}
+		if len(zones) == 0 {
+			return nil, fmt.Errorf("%w: zone_ids must not be empty, omit it for all zones", ErrDiscountInvalid)
+		}
+		query := "SELECT COUNT(*) FROM zones WHERE id = ANY($1)"
+		var found int
+		if err := d.db.QueryRow(c, query, *request.ZoneIds).Scan(&found); err != nil {
+			return nil, fmt.Errorf("failed to check discount zones: %w", err)
+		}
+		if found != len(zones) {
+			return nil, ErrZoneNotFound
+		}
+	}
+
+	return record, nil
+}
+
+// CreateDiscount adds a discount
+func (d *DiscountDao) CreateDiscount(c context.Context, request api.DiscountRequest) (*api.Discount, error) {
+	record, err := d.discountValues(c, request)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO discounts AS d (name, code, kind, value, currency, zone_ids, requires, valid_from, valid_until, max_uses, max_uses_per_user, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING ` + discountColumns
+	discount, err := scanDiscount(d.db.QueryRow(c, query, request.Name, record.code, request.Kind, request.Value, record.currency, request.ZoneIds, request.Requires, request.ValidFrom, request.ValidUntil, request.MaxUses, request.MaxUsesPerUser, record.active))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return nil, ErrDiscountCodeTaken
+		}
+		return nil, fmt.Errorf("failed to add discount: %w", err)
+	}
+	return discount, nil
+}
+
+// UpdateDiscount replaces a discount. Tickets keep the discounts applied
+// when they were bought.
+func (d *DiscountDao) UpdateDiscount(c context.Context, id int64, request api.DiscountRequest) (*api.Discount, error) {
+	record, err := d.discountValues(c, request)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE discounts AS d SET name = $2, code = $3, kind = $4, value = $5, currency = $6, zone_ids = $7, requires = $8, valid_from = $9, valid_until = $10,
+		max_uses = $11, max_uses_per_user = $12, active = $13 WHERE d.id = $1 RETURNING ` + discountColumns
+	discount, err := scanDiscount(d.db.QueryRow(c, query, id, request.Name, record.code, request.Kind, request.Value, record.currency, request.ZoneIds, request.Requires, request.ValidFrom, request.ValidUntil, request.MaxUses, request.MaxUsesPerUser, record.active))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDiscountNotFound
+		}
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return nil, ErrDiscountCodeTaken
+		}
+		return nil, fmt.Errorf("failed to update discount: %w", err)
+	}
+	return discount, nil
+}
+
+const discountRedemptionColumns = "discount_id, ticket_id, name, code, kind, value, amount, currency, username, creation_time"
+
+func (d *DiscountDao) queryRedemptions(c context.Context, query string, args ...any) ([]api.TicketDiscount, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query discount redemptions: %w", err)
+	}
+	defer rows.Close()
+
+	redemptions := []api.TicketDiscount{}
+	for rows.Next() {
+		var redemption api.TicketDiscount
+		if err := rows.Scan(&redemption.DiscountId, &redemption.TicketId, &redemption.Name, &redemption.Code, &redemption.Kind, &redemption.Value, &redemption.Amount.Amount, &redemption.Amount.Currency, &redemption.Username, &redemption.CreationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan discount redemption: %w", err)
+		}
+		redemptions = append(redemptions, redemption)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query discount redemptions: %w", err)
+	}
+	return redemptions, nil
+}
+
+// GetDiscountRedemptions lists the tickets a discount was applied to,
+// newest first
+func (d *DiscountDao) GetDiscountRedemptions(c context.Context, id int64) ([]api.TicketDiscount, error) {
+	return d.queryRedemptions(c, "SELECT "+discountRedemptionColumns+" FROM discount_redemptions WHERE discount_id = $1 ORDER BY id DESC", id)
+}
+
+// GetTicketDiscounts lists the discounts applied to a ticket
+func (d *DiscountDao) GetTicketDiscounts(c context.Context, ticketId int64) ([]api.TicketDiscount, error) {
+	return d.queryRedemptions(c, "SELECT "+discountRedemptionColumns+" FROM discount_redemptions WHERE ticket_id = $1 ORDER BY id", ticketId)
+}
+
+// discountCar is what discounts know of the car of a stay
+type discountCar struct {
+	owner         string
+	electric      bool
+	disabledBadge bool
+}
+
+// getDiscountCar loads the car discounts are checked against
+func (d *DiscountDao) getDiscountCar(c context.Context, plate string) (*discountCar, error) {
+	query := "SELECT user_id, electric, disabled_badge FROM cars WHERE plate = $1"
+	var car discountCar
+	if err := d.db.QueryRow(c, query, plate).Scan(&car.owner, &car.electric, &car.disabledBadge); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCarNotFound
+		}
+		return nil, fmt.Errorf("failed to check car: %w", err)
+	}
+	return &car, nil
+}
+
+// discountRule is a discount that applies to a stay
+type discountRule struct {
+	pricing.Rule
+	code *string
+}
+
+// eligibleDiscounts returns the discounts that apply to a stay in a zone:
+// every eligible discount without a code, and the one with `code` when
+// given. Without a car, discounts requiring a car attribute do not apply and
+// per user caps are not checked. An unknown or inapplicable code fails with
+// ErrDiscountCodeInvalid. With lock, to be used within a transaction, the
+// discounts with usage limits are locked first so that concurrent tickets
+// cannot exceed them.
+func (d *DiscountDao) eligibleDiscounts(c context.Context, zone *api.ZoneResponse, start time.Time, car *discountCar, code *string, lock bool) ([]discountRule, error) {
+	var normalized *string
+	if code != nil {
+		value := normalizeDiscountCode(*code)
+		normalized = &value
+	}
+	var owner *string
+	electric, disabledBadge := false, false
+	if car != nil {
+		owner = &car.owner
+		electric, disabledBadge = car.electric, car.disabledBadge
+	}
+
+	if lock {
+		lockQuery := "SELECT id FROM discounts WHERE active = TRUE AND (code IS NULL OR code = $1) AND (max_uses IS NOT NULL OR max_uses_per_user IS NOT NULL) ORDER BY id FOR UPDATE"
+		if _, err := d.db.Exec(c, lockQuery, normalized); err != nil {
+			return nil, fmt.Errorf("failed to lock discounts: %w", err)
+		}
+	}
+
+	query := `SELECT d.id, d.name, d.kind, d.value, d.code FROM discounts AS d
+		WHERE d.active = TRUE
+		AND (d.zone_ids IS NULL OR $1 = ANY(d.zone_ids))
+		AND (d.valid_from IS NULL OR d.valid_from <= $2)
+		AND (d.valid_until IS NULL OR d.valid_until > $2)
+		AND (d.kind <> 'amount' OR d.currency = $3)
+		AND (d.code IS NULL OR d.code = $4)
+		AND (d.requires IS NULL OR (d.requires = 'electric' AND $5) OR (d.requires = 'disabled_badge' AND $6))
+		AND (d.max_uses IS NULL OR (SELECT COUNT(*) FROM discount_redemptions WHERE discount_id = d.id) < d.max_uses)
+		AND (d.max_uses_per_user IS NULL OR $7::TEXT IS NULL
+			OR (SELECT COUNT(*) FROM discount_redemptions WHERE discount_id = d.id AND username = $7) < d.max_uses_per_user)
+		ORDER BY d.id`
+	rows, err := d.db.Query(c, query, zone.Id, start, zone.Currency, normalized, electric, disabledBadge, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query discounts: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []discountRule{}
+	codeFound := false
+	for rows.Next() {
+		var rule discountRule
+		if err := rows.Scan(&rule.Id, &rule.Name, &rule.Kind, &rule.Value, &rule.code); err != nil {
+			return nil, fmt.Errorf("failed to scan discount: %w", err)
+		}
+		codeFound = codeFound || rule.code != nil
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query discounts: %w", err)
+	}
+	rows.Close()
+
+	if normalized != nil && !codeFound {
+		var exists int
+		err := d.db.QueryRow(c, "SELECT 1 FROM discounts WHERE code = $1 AND active = TRUE", *normalized).Scan(&exists)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: unknown code", ErrDiscountCodeInvalid)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check discount code: %w", err)
+		}
+		return nil, fmt.Errorf("%w: the code does not apply to this stay or has been used up", ErrDiscountCodeInvalid)
+	}
+
+	return rules, nil
+}
+
+// ticketDiscountRules returns the discounts applied to a ticket as they
+// were when it was bought, to reprice it
+func (d *DiscountDao) ticketDiscountRules(c context.Context, ticketId int64) ([]pricing.Rule, error) {
+	query := "SELECT discount_id, name, kind, value FROM discount_redemptions WHERE ticket_id = $1 ORDER BY discount_id"
+	rows, err := d.db.Query(c, query, ticketId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ticket discounts: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []pricing.Rule{}
+	for rows.Next() {
+		var rule pricing.Rule
+		if err := rows.Scan(&rule.Id, &rule.Name, &rule.Kind, &rule.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan ticket discount: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	// A truncated rule set would reprice the ticket without some discounts
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query ticket discounts: %w", err)
+	}
+	return rules, nil
+}
+
+// applyDiscountRules applies discount rules to the price of a stay, free
+// minutes are worth the price of the stay without them
+func applyDiscountRules(c context.Context, zone *api.ZoneResponse, start time.Time, minutes int, breakdown *pricing.Breakdown, rules []pricing.Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	return breakdown.ApplyRules(rules, func(free int) (int64, error) {
+		if free >= minutes {
+			return 0, nil
+		}
+		rest, err := priceZoneStay(c, zone, start.Add(time.Duration(free)*time.Minute), minutes-free)
+		if err != nil {
+			return 0, err
+		}
+		return rest.Total, nil
+	})
+}
+
+// priceDiscountedStay prices a stay with the discounts that apply to it,
+// see eligibleDiscounts
+func (d *DiscountDao) priceDiscountedStay(c context.Context, zone *api.ZoneResponse, start time.Time, minutes int, car *discountCar, code *string, lock bool) (*pricing.Breakdown, []discountRule, error) {
+	breakdown, err := priceZoneStay(c, zone, start, minutes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	discounts, err := d.eligibleDiscounts(c, zone, start, car, code, lock)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules := make([]pricing.Rule, len(discounts))
+	for i, discount := range discounts {
+		rules[i] = discount.Rule
+	}
+	if err := applyDiscountRules(c, zone, start, minutes, breakdown, rules); err != nil {
+		return nil, nil, err
+	}
+	return breakdown, discounts, nil
+}
+
+// redeemDiscounts records the discounts applied to a new ticket, with a
+// copy of their rule
+func (d *DiscountDao) redeemDiscounts(c context.Context, ticketId int64, owner string, breakdown *pricing.Breakdown, discounts []discountRule) error {
+	byId := map[int64]discountRule{}
+	for _, discount := range discounts {
+		byId[discount.Id] = discount
+	}
+
+	query := "INSERT INTO discount_redemptions (discount_id, ticket_id, username, code, name, kind, value, amount, currency) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	for _, applied := range breakdown.Discounts {
+		discount, ok := byId[applied.RuleId]
+		if !ok {
+			continue
+		}
+		if _, err := d.db.Exec(c, query, discount.Id, ticketId, owner, discount.code, discount.Name, discount.Kind, discount.Value, applied.Amount, breakdown.Currency); err != nil {
+			return fmt.Errorf("failed to record discount: %w", err)
+		}
+	}
+	return nil
+}
+
+// repriceRedemptions updates what the discounts of a repriced ticket take
+// off its price, a discount that no longer takes anything off is kept at 0
+func (d *DiscountDao) repriceRedemptions(c context.Context, ticketId int64, breakdown *pricing.Breakdown, rules []pricing.Rule) error {
+	amounts := map[int64]int64{}
+	for _, applied := range breakdown.Discounts {
+		amounts[applied.RuleId] = applied.Amount
+	}
+
+	query := "UPDATE discount_redemptions SET amount = $3 WHERE ticket_id = $1 AND discount_id = $2"
+	for _, rule := range rules {
+		if _, err := d.db.Exec(c, query, ticketId, rule.Id, amounts[rule.Id]); err != nil {
+			return fmt.Errorf("failed to update ticket discount: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/pricing"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrDynamicPricingNotFound = errors.New("dynamic pricing policy not found")
+	ErrDynamicPricingInvalid  = pricing.ErrInvalidPolicy
+)
+
+// How often the price levels of the zones are reviewed
+const DynamicPricingInterval = 5 * time.Minute
+
+type DynamicPricingDao struct {
+	db db.DB
+}
+
+func NewDynamicPricingDao() *DynamicPricingDao {
+	return &DynamicPricingDao{
+		db: *db.GetDB(),
+	}
+}
+
+const dynamicPricingColumns = "enabled, high_occupancy, raise_step, low_occupancy, lower_step, min_level, max_level, interval_minutes, updated_at"
+
+func scanDynamicPricingPolicy(row pgx.Row) (*api.DynamicPricingPolicy, error) {
+	var policy api.DynamicPricingPolicy
+	if err := row.Scan(&policy.Enabled, &policy.HighOccupancy, &policy.RaiseStep, &policy.LowOccupancy, &policy.LowerStep, &policy.MinLevel, &policy.MaxLevel, &policy.IntervalMinutes, &policy.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func policyFromApi(policy api.DynamicPricingPolicy) pricing.Policy {
+	return pricing.Policy{
+		HighOccupancy: policy.HighOccupancy,
+		RaiseStep:     policy.RaiseStep,
+		LowOccupancy:  policy.LowOccupancy,
+		LowerStep:     policy.LowerStep,
+		MinLevel:      policy.MinLevel,
+		MaxLevel:      policy.MaxLevel,
+	}
+}
+
+func (d *DynamicPricingDao) GetZoneDynamicPricing(c context.Context, zoneId int64) (*api.DynamicPricingPolicy, error) {
+	query := "SELECT " + dynamicPricingColumns + " FROM dynamic_pricing_policies WHERE zone_id = $1"
+	policy, err := scanDynamicPricingPolicy(d.db.QueryRow(c, query, zoneId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDynamicPricingNotFound
+		}
+		return nil, fmt.Errorf("failed to get dynamic pricing policy: %w", err)
+	}
+	return policy, nil
+}
+
+// UpdateZoneDynamicPricing sets the dynamic pricing policy of a zone. An
+// enabled policy needs the capacity of the zone to know how full it is.
+// Disabling the policy puts the zone back at its base prices.
+func (d *DynamicPricingDao) UpdateZoneDynamicPricing(c context.Context, zoneId int64, policy api.DynamicPricingPolicy) (*api.DynamicPricingPolicy, error) {
+	if err := policyFromApi(policy).Validate(); err != nil {
+		return nil, err
+	}
+	if policy.IntervalMinutes <= 0 {
+		return nil, fmt.Errorf("%w: interval_minutes must be positive", ErrDynamicPricingInvalid)
+	}
+
+	err := d.db.WithTx(c, func(c context.Context) error {
+		query := "SELECT capacity IS NOT NULL FROM zones WHERE id = $1 FOR UPDATE"
+		var hasCapacity bool
+		if err := d.db.QueryRow(c, query, zoneId).Scan(&hasCapacity); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrZoneNotFound
+			}
+			return fmt.Errorf("failed to get zone capacity: %w", err)
+		}
+		if policy.Enabled && !hasCapacity {
+			return fmt.Errorf("%w: the zone has no capacity", ErrDynamicPricingInvalid)
+		}
+
+		query = `
+			INSERT INTO dynamic_pricing_policies (zone_id, enabled, high_occupancy, raise_step, low_occupancy, lower_step, min_level, max_level, interval_minutes, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+			ON CONFLICT (zone_id) DO UPDATE
+			SET enabled = $2, high_occupancy = $3, raise_step = $4, low_occupancy = $5, lower_step = $6,
+				min_level = $7, max_level = $8, interval_minutes = $9, updated_at = NOW()
+		`
+		if _, err := d.db.Exec(c, query, zoneId, policy.Enabled, policy.HighOccupancy, policy.RaiseStep, policy.LowOccupancy, policy.LowerStep, policy.MinLevel, policy.MaxLevel, policy.IntervalMinutes); err != nil {
+			return fmt.Errorf("failed to set dynamic pricing policy: %w", err)
+		}
+
+		if !policy.Enabled {
+			return d.resetPriceLevel(c, zoneId, "dynamic pricing disabled")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.GetZoneDynamicPricing(c, zoneId)
+}
+
+// DeleteZoneDynamicPricing removes the policy of a zone and puts it back at
+// its base prices
+func (d *DynamicPricingDao) DeleteZoneDynamicPricing(c context.Context, zoneId int64) error {
+	return d.db.WithTx(c, func(c context.Context) error {
+		query := "DELETE FROM dynamic_pricing_policies WHERE zone_id = $1"
+		result, err := d.db.Exec(c, query, zoneId)
+		if err != nil {
+			return fmt.Errorf("failed to delete dynamic pricing policy: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrDynamicPricingNotFound
+		}
+		return d.resetPriceLevel(c, zoneId, "dynamic pricing removed")
+	})
+}
+
+// setPriceLevel makes `level` the price level of a zone from now on and
+// logs the change as a new version. occupancy is what the change was based
+// on, if anything.
+func (d *DynamicPricingDao) setPriceLevel(c context.Context, zoneId int64, previous int, level int, occupancy *api.ZoneOccupancy, reason string) error {
+	var occupied, capacity *int
+	if occupancy != nil {
+		occupied, capacity = &occupancy.Occupied, occupancy.Capacity
+	}
+	query := "INSERT INTO zone_price_levels (zone_id, level, previous_level, occupied, capacity, reason) VALUES ($1, $2, $3, $4, $5, $6)"
+	if _, err := d.db.Exec(c, query, zoneId, level, previous, occupied, capacity, reason); err != nil {
+		return fmt.Errorf("failed to log price level: %w", err)
+	}
+	query = "UPDATE zones SET price_level = $2 WHERE id = $1"
+	if _, err := d.db.Exec(c, query, zoneId, level); err != nil {
+		return fmt.Errorf("failed to set price level: %w", err)
+	}
+	return nil
+}
+
+// resetPriceLevel puts a zone back at its base prices
+func (d *DynamicPricingDao) resetPriceLevel(c context.Context, zoneId int64, reason string) error {
+	query := "SELECT price_level FROM zones WHERE id = $1 FOR UPDATE"
+	var level int
+	if err := d.db.QueryRow(c, query, zoneId).Scan(&level); err != nil {
+		return fmt.Errorf("failed to get price level: %w", err)
+	}
+	if level == pricing.BaseLevel {
+		return nil
+	}
+	return d.setPriceLevel(c, zoneId, level, pricing.BaseLevel, nil, reason)
+}
+
+// GetZonePriceLevels lists the price level changes of a zone, newest first
+func (d *DynamicPricingDao) GetZonePriceLevels(c context.Context, zoneId int64, limit *int, offset *int) ([]api.ZonePriceLevel, error) {
+	query := "SELECT id, zone_id, level, previous_level, occupied, capacity, reason, effective_from FROM zone_price_levels WHERE zone_id = $1 ORDER BY effective_from DESC, id DESC LIMIT $2 OFFSET $3"
+	params := []any{zoneId, 20, 0}
+	if limit != nil {
+		params[1] = *limit
+	}
+	if offset != nil {
+		params[2] = *offset
+	}
+	rows, err := d.db.Query(c, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price levels: %w", err)
+	}
+	defer rows.Close()
+
+	levels := []api.ZonePriceLevel{}
+	for rows.Next() {
+		var level api.ZonePriceLevel
+		if err := rows.Scan(&level.Id, &level.ZoneId, &level.Level, &level.PreviousLevel, &level.Occupied, &level.Capacity, &level.Reason, &level.EffectiveFrom); err != nil {
+			return nil, fmt.Errorf("failed to scan price level: %w", err)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// adjustPriceLevel moves the price level of a zone with its occupancy, once
+// its policy interval has passed since the last change
+func (d *DynamicPricingDao) adjustPriceLevel(c context.Context, zoneId int64) error {
+	return d.db.WithTx(c, func(c context.Context) error {
+		// The zone stays locked so concurrent runs change it once
+		query := `
+			SELECT
+				z.price_level,
+				p.high_occupancy,
+				p.raise_step,
+				p.low_occupancy,
+				p.lower_step,
+				p.min_level,
+				p.max_level,
+				p.interval_minutes,
+				(SELECT MAX(l.effective_from) FROM zone_price_levels AS l WHERE l.zone_id = z.id)
+			FROM zones AS z
+			JOIN dynamic_pricing_policies AS p ON p.zone_id = z.id
+			WHERE z.id = $1 AND p.enabled AND z.capacity IS NOT NULL
+			FOR UPDATE OF z
+		`
+		var level, intervalMinutes int
+		var policy pricing.Policy
+		var lastChange *time.Time
+		if err := d.db.QueryRow(c, query, zoneId).Scan(&level, &policy.HighOccupancy, &policy.RaiseStep, &policy.LowOccupancy, &policy.LowerStep, &policy.MinLevel, &policy.MaxLevel, &intervalMinutes, &lastChange); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Disabled or without capacity since it was listed
+				return nil
+			}
+			return fmt.Errorf("failed to get dynamic pricing policy: %w", err)
+		}
+		if lastChange != nil && time.Since(*lastChange) < time.Duration(intervalMinutes)*time.Minute {
+			return nil
+		}
+
+		occupancies, err := NewOccupancyDao().zoneOccupancies(c, []int64{zoneId}, time.Now(), "")
+		if err != nil {
+			return err
+		}
+		if len(occupancies) == 0 || occupancies[0].Capacity == nil {
+			return nil
+		}
+		occupancy := occupancies[0]
+		next, reason := policy.Next(level, occupancy.Occupied, *occupancy.Capacity)
+		if next == level {
+			return nil
+		}
+		return d.setPriceLevel(c, zoneId, level, next, &occupancy, reason)
+	})
+}
+
+// AdjustPriceLevels reviews the price level of every zone with an enabled
+// dynamic pricing policy
+func (d *DynamicPricingDao) AdjustPriceLevels(c context.Context) error {
+	rows, err := d.db.Query(c, "SELECT zone_id FROM dynamic_pricing_policies WHERE enabled ORDER BY zone_id")
+	if err != nil {
+		return fmt.Errorf("failed to query dynamic pricing policies: %w", err)
+	}
+	zoneIds, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return fmt.Errorf("failed to scan dynamic pricing policies: %w", err)
+	}
+
+	var errs []error
+	for _, zoneId := range zoneIds {
+		if err := d.adjustPriceLevel(c, zoneId); err != nil {
+			errs = append(errs, fmt.Errorf("zone %d: %w", zoneId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,247 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Enforcement verdicts
+const (
+	VerdictValid        = "valid"
+	VerdictExpired      = "expired"
+	VerdictWrongZone    = "wrong_zone"
+	VerdictNotCovered   = "not_covered"
+	VerdictUnknownPlate = "unknown_plate"
+)
+
+var ErrNoZoneAtLocation = errors.New("no zone at this location")
+
+type EnforcementDao struct {
+	db db.DB
+}
+
+func NewEnforcementDao() *EnforcementDao {
+	return &EnforcementDao{
+		db: *db.GetDB(),
+	}
+}
+
+// ZoneAt resolves the zone containing a point
+func (d *EnforcementDao) ZoneAt(c context.Context, latitude float64, longitude float64) (*api.ZoneResponse, error) {
+	zones, err := NewZoneDao().FindAllZonesContainingPoint(c, longitude, latitude)
+	if err != nil {
+		return nil, err
+	}
+	// Zones cannot overlap, a point is in one zone at most
+	if len(zones) == 0 {
+		return nil, ErrNoZoneAtLocation
+	}
+	return &zones[0], nil
+}
+
+// coverage is a period during which a ticket, a session, a permit or a
+// subscription covers a plate
+type coverage struct {
+	zoneId       int64
+	start        time.Time
+	end          time.Time
+	ticket       *api.TicketResponse
+	session      *api.SessionResponse
+	permit       *api.Permit
+	subscription *api.Subscription
+}
+
+func (cv coverage) covers(now time.Time) bool {
+	return !cv.start.After(now) && now.Before(cv.end)
+}
+
+// plateCoverage lists what covers a plate: paid tickets up to their paid
+// time, parking sessions and valid permits. Active sessions cover until they are stopped, at the
+// latest until their maximum end date.
+func plateCoverage(tickets []api.TicketResponse, sessions []api.SessionResponse, permits []api.Permit) []coverage {
+	var result []coverage
+	for i := range tickets {
+		if !tickets[i].Paid || tickets[i].PaidUntil == nil {
+			continue
+		}
+		result = append(result, coverage{zoneId: tickets[i].ZoneId, start: tickets[i].StartDate, end: *tickets[i].PaidUntil, ticket: &tickets[i]})
+	}
+	for i := range sessions {
+		end := sessions[i].MaxEndDate
+		if sessions[i].Status != SessionStatusActive && sessions[i].EndDate != nil {
+			end = *sessions[i].EndDate
+		}
+		result = append(result, coverage{zoneId: sessions[i].ZoneId, start: sessions[i].StartDate, end: end, session: &sessions[i]})
+	}
+	for i := range permits {
+		if permits[i].ValidFrom == nil || permits[i].ValidUntil == nil {
+			continue
+		}
+		result = append(result, coverage{zoneId: permits[i].ZoneId, start: *permits[i].ValidFrom, end: *permits[i].ValidUntil, permit: &permits[i]})
+	}
+	return result
+}
+
+// subscriptionCoverage lists the time windows of today during which
+// subscriptions cover a plate, in each zone of their plan
+func subscriptionCoverage(subscriptions []api.Subscription, plans map[int64]*api.SubscriptionPlan, now time.Time) []coverage {
+	var result []coverage
+	for i := range subscriptions {
+		until, ok := subscriptionCoveredUntil(subscriptions[i])
+		plan := plans[subscriptions[i].PlanId]
+		if !ok || plan == nil {
+			continue
+		}
+		start, end, ok := subscriptionWindow(plan, now)
+		if !ok {
+			continue
+		}
+		if until.Before(end) {
+			end = until
+		}
+		if !end.After(start) {
+			continue
+		}
+		for _, zoneId := range plan.ZoneIds {
+			result = append(result, coverage{zoneId: zoneId, start: start, end: end, subscription: &subscriptions[i]})
+		}
+	}
+	return result
+}
+
+// addEvidence appends the ticket, session, permit or subscription behind a
+// coverage to the check
+func addEvidence(check *api.EnforcementCheck, cv coverage) {
+	if cv.ticket != nil {
+		check.Tickets = append(check.Tickets, *cv.ticket)
+	}
+	if cv.session != nil {
+		check.Sessions = append(check.Sessions, *cv.session)
+	}
+	if cv.permit != nil {
+		check.Permits = append(check.Permits, *cv.permit)
+	}
+	// A subscription covers several zones, it is listed once
+	if cv.subscription != nil {
+		for _, subscription := range check.Subscriptions {
+			if subscription.Id == cv.subscription.Id {
+				return
+			}
+		}
+		check.Subscriptions = append(check.Subscriptions, *cv.subscription)
+	}
+}
+
+// decide fills the verdict of a check from the coverage of the plate. A
+// plate covered in the zone is valid; otherwise, covered in another zone
+// it is in the wrong zone; otherwise, it is expired if it was covered in
+// the zone before, and not covered at all if it never was.
+func decide(check *api.EnforcementCheck, coverages []coverage, now time.Time) {
+	var elsewhere []coverage
+	var last *coverage
+	for i, cv := range coverages {
+		switch {
+		case cv.covers(now) && cv.zoneId == check.ZoneId:
+			check.Verdict = VerdictValid
+			addEvidence(check, cv)
+		case cv.covers(now):
+			elsewhere = append(elsewhere, cv)
+		case cv.zoneId == check.ZoneId && !cv.end.After(now):
+			if last == nil || cv.end.After(last.end) {
+				last = &coverages[i]
+			}
+		}
+	}
+	if check.Verdict == VerdictValid {
+		return
+	}
+
+	if len(elsewhere) > 0 {
+		check.Verdict = VerdictWrongZone
+		check.CoveringZoneId = &elsewhere[0].zoneId
+		for _, cv := range elsewhere {
+			addEvidence(check, cv)
+		}
+		return
+	}
+
+	if last != nil {
+		minutes := int(now.Sub(last.end).Minutes())
+		check.Verdict = VerdictExpired
+		check.ExpiredMinutes = &minutes
+		addEvidence(check, *last)
+		return
+	}
+
+	check.Verdict = VerdictNotCovered
+}
+
+// CheckPlate tells whether a plate may park in a zone right now, with the
+// tickets, sessions, permits and subscriptions the verdict is based on
+func (d *EnforcementDao) CheckPlate(c context.Context, plate string, latitude float64, longitude float64, zone *api.ZoneResponse) (*api.EnforcementCheck, error) {
+	now := time.Now()
+	check := &api.EnforcementCheck{
+		Plate:         plate,
+		Latitude:      latitude,
+		Longitude:     longitude,
+		ZoneId:        zone.Id,
+		ZoneName:      zone.Name,
+		CheckedAt:     now,
+		Tickets:       []api.TicketResponse{},
+		Sessions:      []api.SessionResponse{},
+		Permits:       []api.Permit{},
+		Subscriptions: []api.Subscription{},
+	}
+
+	query := "SELECT 1 FROM cars WHERE plate = $1"
+	var exists int
+	if err := d.db.QueryRow(c, query, plate).Scan(&exists); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			check.Verdict = VerdictUnknownPlate
+			return check, nil
+		}
+		return nil, fmt.Errorf("failed to check car: %w", err)
+	}
+
+	tickets, err := NewTicketDao().GetCarTickets(c, plate)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := NewSessionDao().GetCarSessions(c, plate)
+	if err != nil {
+		return nil, err
+	}
+
+	permits, err := NewPermitDao().GetPlatePermits(c, plate)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionDao := NewSubscriptionDao()
+	subscriptions, err := subscriptionDao.GetPlateSubscriptions(c, plate)
+	if err != nil {
+		return nil, err
+	}
+	plans := map[int64]*api.SubscriptionPlan{}
+	for _, subscription := range subscriptions {
+		if plans[subscription.PlanId] != nil {
+			continue
+		}
+		plan, err := subscriptionDao.GetSubscriptionPlanById(c, subscription.PlanId)
+		if err != nil {
+			return nil, err
+		}
+		plans[plan.Id] = plan
+	}
+
+	coverages := plateCoverage(tickets, sessions, permits)
+	coverages = append(coverages, subscriptionCoverage(subscriptions, plans, now)...)
+	decide(check, coverages, now)
+	return check, nil
+}
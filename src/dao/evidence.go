@@ -0,0 +1,155 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/blobstore"
+	"OPP/backend/db"
+	"OPP/backend/evidence"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Kinds of fine evidence
+const (
+	EvidenceKindPhoto = "photo"
+	EvidenceKindNote  = "note"
+)
+
+var (
+	ErrEvidenceNotFound = errors.New("evidence not found")
+	ErrEvidenceEmpty    = errors.New("evidence needs a photo or a note")
+	ErrEvidenceTampered = errors.New("evidence file does not match its hash")
+)
+
+type EvidenceDao struct {
+	db    db.DB
+	store blobstore.Store
+}
+
+func NewEvidenceDao() *EvidenceDao {
+	return &EvidenceDao{
+		db:    *db.GetDB(),
+		store: blobstore.GetStore(),
+	}
+}
+
+const evidenceColumns = "id, fine_id, kind, content_type, size, sha256, original_sha256, gps_stripped, note, uploaded_by, creation_time"
+
+func scanEvidence(row pgx.Row, extra ...any) (*api.FineEvidence, error) {
+	var item api.FineEvidence
+	dest := []any{&item.Id, &item.FineId, &item.Kind, &item.ContentType, &item.Size, &item.Sha256, &item.OriginalSha256, &item.GpsStripped, &item.Note, &item.UploadedBy, &item.CreationTime}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// evidenceKey is the blob key of a photo, photos are stored by content
+func evidenceKey(sha256 string) string {
+	return "evidence/" + sha256
+}
+
+// AddFineEvidence attaches photos and notes to a fine. Every upload is
+// checked before anything is stored, and GPS data is removed from photos
+// unless the policy keeps it.
+func (d *EvidenceDao) AddFineEvidence(c context.Context, fineId int64, uploadedBy string, uploads []api.EvidenceUpload) ([]api.FineEvidence, error) {
+	photos := make([]*evidence.Photo, len(uploads))
+	for i, upload := range uploads {
+		hasNote := upload.Note != nil && strings.TrimSpace(*upload.Note) != ""
+		if upload.Data == nil && !hasNote {
+			return nil, ErrEvidenceEmpty
+		}
+		if upload.Data != nil {
+			photo, err := evidence.Prepare(*upload.Data, evidence.KeepGPS())
+			if err != nil {
+				return nil, err
+			}
+			photos[i] = photo
+		}
+	}
+
+	added := []api.FineEvidence{}
+	err := d.db.WithTx(c, func(c context.Context) error {
+		var exists int
+		if err := d.db.QueryRow(c, "SELECT 1 FROM fines WHERE id = $1", fineId).Scan(&exists); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrFineNotFound
+			}
+			return fmt.Errorf("failed to check fine: %w", err)
+		}
+
+		for i, upload := range uploads {
+			query := "INSERT INTO fine_evidence (fine_id, kind, note, uploaded_by) VALUES ($1, $2, $3, $4) RETURNING " + evidenceColumns
+			args := []any{fineId, EvidenceKindNote, upload.Note, uploadedBy}
+			if photo := photos[i]; photo != nil {
+				// A blob without a row is harmless, a row without a blob is not
+				key := evidenceKey(photo.SHA256)
+				if err := d.store.Put(c, key, photo.Data, photo.ContentType); err != nil {
+					return err
+				}
+				query = `
+					INSERT INTO fine_evidence (fine_id, kind, note, uploaded_by, blob_key, content_type, size, sha256, original_sha256, gps_stripped)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+					RETURNING ` + evidenceColumns
+				args = []any{fineId, EvidenceKindPhoto, upload.Note, uploadedBy, key, photo.ContentType, len(photo.Data), photo.SHA256, photo.OriginalSHA256, photo.GPSStripped}
+			}
+			item, err := scanEvidence(d.db.QueryRow(c, query, args...))
+			if err != nil {
+				return fmt.Errorf("failed to add evidence: %w", err)
+			}
+			added = append(added, *item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// GetFineEvidence lists the evidence of a fine in upload order
+func (d *EvidenceDao) GetFineEvidence(c context.Context, fineId int64) ([]api.FineEvidence, error) {
+	rows, err := d.db.Query(c, "SELECT "+evidenceColumns+" FROM fine_evidence WHERE fine_id = $1 ORDER BY id", fineId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query evidence: %w", err)
+	}
+	defer rows.Close()
+
+	items := []api.FineEvidence{}
+	for rows.Next() {
+		item, err := scanEvidence(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan evidence: %w", err)
+		}
+		items = append(items, *item)
+	}
+	return items, nil
+}
+
+// GetFineEvidenceFile returns a photo of a fine with its file. The file is
+// checked against the hash recorded at upload, a file changed in the store
+// is reported with ErrEvidenceTampered.
+func (d *EvidenceDao) GetFineEvidenceFile(c context.Context, fineId int64, evidenceId int64) (*api.FineEvidence, []byte, error) {
+	query := "SELECT " + evidenceColumns + ", blob_key FROM fine_evidence WHERE id = $1 AND fine_id = $2 AND kind = $3"
+	var key string
+	item, err := scanEvidence(d.db.QueryRow(c, query, evidenceId, fineId, EvidenceKindPhoto), &key)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrEvidenceNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get evidence: %w", err)
+	}
+
+	data, err := d.store.Get(c, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if item.Sha256 == nil || evidence.Hash(data) != *item.Sha256 {
+		return item, nil, fmt.Errorf("%w: evidence %d", ErrEvidenceTampered, evidenceId)
+	}
+	return item, data, nil
+}
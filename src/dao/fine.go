@@ -6,14 +6,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 )
 
 var (
-	ErrFineNotFound    = errors.New("fine not found")
-	ErrFineAlreadyPaid = errors.New("fine already paid")
+	ErrFineNotFound        = errors.New("fine not found")
+	ErrFineAlreadyPaid     = errors.New("fine already paid")
+	ErrFineOutsideZone     = errors.New("position is not in the zone")
+	ErrFineNotJustified    = errors.New("plate is covered, the fine must be overridden")
+	ErrFineOverrideReason  = errors.New("an override needs a reason")
+	ErrFineOffenseRequired = errors.New("an overridden fine needs an offense")
+	ErrFineScheduleMissing = errors.New("no fine scheduled for the offense in this zone")
+	ErrFineScheduleInvalid = errors.New("invalid fine schedule")
+	ErrFineAppealPending   = errors.New("fine has a pending appeal")
+	ErrFineCancelled       = errors.New("fine was cancelled")
+)
+
+// Fine states
+const (
+	FineStatusOpen      = "open"
+	FineStatusAppealed  = "appealed"
+	FineStatusCancelled = "cancelled"
 )
 
 type FineDao struct {
@@ -26,8 +42,37 @@ func NewFineDao() *FineDao {
 	}
 }
 
+// Fines are read from fineSource, their amount is the amount due in their
+// current stage
+const fineColumns = "fines.id, fines.plate, " + fineDueAmount + ", fines.currency, fines.date, fines.paid, fines.zone_id, fines.offense, fines.verdict, fines.issued_by, fines.latitude, fines.longitude, fines.override, fines.override_reason, fines.status, fines.base_amount, " + fineCurrentStage
+
+func scanFine(row pgx.Row) (*api.FineResponse, error) {
+	var fine api.FineResponse
+	var override bool
+	var baseAmount int64
+	if err := row.Scan(&fine.Id, &fine.Plate, &fine.Amount.Amount, &fine.Amount.Currency, &fine.Date, &fine.Paid, &fine.ZoneId, &fine.Offense, &fine.Verdict, &fine.IssuedBy, &fine.Latitude, &fine.Longitude, &override, &fine.OverrideReason, &fine.Status, &baseAmount, &fine.Stage); err != nil {
+		return nil, err
+	}
+	if override {
+		fine.Override = &override
+	}
+	fine.BaseAmount = &api.Money{Amount: baseAmount, Currency: fine.Amount.Currency}
+	return &fine, nil
+}
+
+// finePayable tells why a fine in status cannot be paid, if it cannot
+func finePayable(status string) error {
+	switch status {
+	case FineStatusAppealed:
+		return ErrFineAppealPending
+	case FineStatusCancelled:
+		return ErrFineCancelled
+	}
+	return nil
+}
+
 func (d *FineDao) GetFines(c context.Context, limit *int, offset *int) []api.FineResponse {
-	query := "SELECT id, plate, amount, date, paid, zone_id FROM fines LIMIT $1 OFFSET $2"
+	query := "SELECT " + fineColumns + " FROM " + fineSource + " LIMIT $1 OFFSET $2"
 	params := []any{20, 0}
 	if limit != nil {
 		params[0] = *limit
@@ -45,19 +90,19 @@ func (d *FineDao) GetFines(c context.Context, limit *int, offset *int) []api.Fin
 	defer rows.Close()
 
 	for rows.Next() {
-		var fine api.FineResponse
-		if err := rows.Scan(&fine.Id, &fine.Plate, &fine.Amount, &fine.Date, &fine.Paid, &fine.ZoneId); err != nil {
+		fine, err := scanFine(rows)
+		if err != nil {
 			fmt.Printf("row scan error: %v\n", err.Error())
 			continue
 		}
-		fines = append(fines, fine)
+		fines = append(fines, *fine)
 	}
 
 	return fines
 }
 
 func (d *FineDao) GetCarFines(c context.Context, plate string) []api.FineResponse {
-	query := "SELECT id, plate, amount, date, paid, zone_id FROM fines WHERE plate = $1"
+	query := "SELECT " + fineColumns + " FROM " + fineSource + " WHERE plate = $1"
 	rows, err := d.db.Query(c, query, plate)
 	if err != nil {
 		fmt.Printf("db error: %v\n", err.Error())
@@ -67,57 +112,176 @@ func (d *FineDao) GetCarFines(c context.Context, plate string) []api.FineRespons
 
 	fines := []api.FineResponse{}
 	for rows.Next() {
-		var fine api.FineResponse
-		if err := rows.Scan(&fine.Id, &fine.Plate, &fine.Amount, &fine.Date, &fine.Paid, &fine.ZoneId); err != nil {
+		fine, err := scanFine(rows)
+		if err != nil {
 			fmt.Printf("row scan error: %v\n", err.Error())
 			continue
 		}
-		fines = append(fines, fine)
+		fines = append(fines, *fine)
 	}
 
 	return fines
 }
 
-func (d *FineDao) CreateZoneFine(c context.Context, zoneId int64, fine api.FineRequest) (*api.FineResponse, error) {
-	carQuery := "SELECT * FROM cars WHERE plate = $1"
-	carRows, err := d.db.Query(c, carQuery, fine.Plate)
+// CreateZoneFine issues a fine after checking the plate again at the
+// position of the controller. The plate must not be covered unless the
+// controller overrides the check with a reason, and the amount is the one
+// of the zone fine schedule for the offense, which defaults to the verdict.
+// When the plate is covered the check is returned with ErrFineNotJustified.
+// Evidence sent with the request is attached to the fine.
+func (d *FineDao) CreateZoneFine(c context.Context, zoneId int64, issuedBy string, fine api.FineRequest) (*api.FineResponse, *api.EnforcementCheck, error) {
+	enforcement := NewEnforcementDao()
+	zone, err := enforcement.ZoneAt(c, fine.Latitude, fine.Longitude)
+	if err != nil {
+		if errors.Is(err, ErrNoZoneAtLocation) {
+			return nil, nil, ErrFineOutsideZone
+		}
+		return nil, nil, err
+	}
+	if zone.Id != zoneId {
+		return nil, nil, ErrFineOutsideZone
+	}
+
+	check, err := enforcement.CheckPlate(c, fine.Plate, fine.Latitude, fine.Longitude, zone)
+	if err != nil {
+		return nil, nil, err
+	}
+	if check.Verdict == VerdictUnknownPlate {
+		return nil, check, ErrCarNotFound
+	}
+
+	override := fine.Override != nil && *fine.Override
+	if override && (fine.OverrideReason == nil || strings.TrimSpace(*fine.OverrideReason) == "") {
+		return nil, check, ErrFineOverrideReason
+	}
+	if check.Verdict == VerdictValid && !override {
+		return nil, check, ErrFineNotJustified
+	}
+
+	offense := check.Verdict
+	if fine.Offense != nil {
+		offense = *fine.Offense
+	} else if check.Verdict == VerdictValid {
+		return nil, check, ErrFineOffenseRequired
+	}
+
+	// The amount comes from the schedule, in the currency of the zone
+	query := `
+		INSERT INTO fines (plate, amount, base_amount, currency, date, paid, zone_id, offense, verdict, issued_by, latitude, longitude, override, override_reason)
+		SELECT $1, s.amount, s.amount, z.currency, $2, FALSE, z.id, s.offense, $4, $5, $6, $7, $8, $9
+		FROM zones AS z
+		JOIN zone_fine_schedules AS s ON s.zone_id = z.id AND s.offense = $10
+		WHERE z.id = $3
+		RETURNING id`
+	var reason *string
+	if override {
+		reason = fine.OverrideReason
+	}
+	var fineId int64
+	err = d.db.WithTx(c, func(c context.Context) error {
+		err := d.db.QueryRow(c, query, fine.Plate, time.Now(), zoneId, check.Verdict, issuedBy, fine.Latitude, fine.Longitude, override, reason, offense).Scan(&fineId)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("%w: %q", ErrFineScheduleMissing, offense)
+			}
+			return fmt.Errorf("failed to add fine: %w", err)
+		}
+
+		// No fine is issued when its evidence is refused
+		if fine.Evidence != nil && len(*fine.Evidence) > 0 {
+			if _, err := NewEvidenceDao().AddFineEvidence(c, fineId, issuedBy, *fine.Evidence); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, check, err
+	}
+
+	// Read back with the amount due in the first stage
+	created, err := d.GetFineById(c, fineId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check car: %w", err)
+		return nil, check, err
 	}
-	defer carRows.Close()
+	return created, check, nil
+}
 
-	if !carRows.Next() {
-		return nil, ErrCarNotFound
+// GetZoneFineSchedule returns the fine amount of each offense in a zone
+func (d *FineDao) GetZoneFineSchedule(c context.Context, zoneId int64) (*api.FineSchedule, error) {
+	zone, err := NewZoneDao().GetZoneById(c, zoneId)
+	if err != nil {
+		return nil, err
 	}
 
-	res, err := NewZoneDao().ZoneExists(c, zoneId)
+	query := "SELECT offense, amount FROM zone_fine_schedules WHERE zone_id = $1 ORDER BY offense"
+	rows, err := d.db.Query(c, query, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fine schedule: %w", err)
+	}
+	defer rows.Close()
+
+	schedule := &api.FineSchedule{
+		ZoneId:   zoneId,
+		Currency: zone.Currency,
+		Offenses: []api.FineScheduleEntry{},
+	}
+	for rows.Next() {
+		var entry api.FineScheduleEntry
+		if err := rows.Scan(&entry.Offense, &entry.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan fine schedule: %w", err)
+		}
+		schedule.Offenses = append(schedule.Offenses, entry)
+	}
+
+	return schedule, nil
+}
+
+// UpdateZoneFineSchedule replaces the fine schedule of a zone
+func (d *FineDao) UpdateZoneFineSchedule(c context.Context, zoneId int64, request api.FineScheduleRequest) (*api.FineSchedule, error) {
+	seen := map[string]bool{}
+	for _, entry := range request.Offenses {
+		if strings.TrimSpace(entry.Offense) == "" {
+			return nil, fmt.Errorf("%w: empty offense", ErrFineScheduleInvalid)
+		}
+		if entry.Amount <= 0 {
+			return nil, fmt.Errorf("%w: amount of %q must be positive", ErrFineScheduleInvalid, entry.Offense)
+		}
+		if seen[entry.Offense] {
+			return nil, fmt.Errorf("%w: duplicate offense %q", ErrFineScheduleInvalid, entry.Offense)
+		}
+		seen[entry.Offense] = true
+	}
+
+	exists, err := NewZoneDao().ZoneExists(c, zoneId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check zone existence: %w", err)
 	}
-	if !res {
-		return nil, fmt.Errorf("zone with ID %d does not exist", zoneId)
+	if !exists {
+		return nil, ErrZoneNotFound
 	}
 
-	query := "INSERT INTO fines (plate, amount, date, paid, zone_id) VALUES ($1, $2, $3, FALSE, $4) RETURNING id"
-	currentDate := time.Now()
-	var lastId int64
-	err = d.db.QueryRow(c, query, fine.Plate, fine.Amount, currentDate, zoneId).Scan(&lastId)
+	err = d.db.WithTx(c, func(c context.Context) error {
+		if _, err := d.db.Exec(c, "DELETE FROM zone_fine_schedules WHERE zone_id = $1", zoneId); err != nil {
+			return fmt.Errorf("failed to clear fine schedule: %w", err)
+		}
+		for _, entry := range request.Offenses {
+			query := "INSERT INTO zone_fine_schedules (zone_id, offense, amount) VALUES ($1, $2, $3)"
+			if _, err := d.db.Exec(c, query, zoneId, entry.Offense, entry.Amount); err != nil {
+				return fmt.Errorf("failed to add fine schedule entry: %w", err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to add fine: %w", err)
+		return nil, err
 	}
 
-	return &api.FineResponse{
-		Id:     lastId,
-		Plate:  fine.Plate,
-		Amount: fine.Amount,
-		Date:   currentDate,
-		Paid:   false,
-		ZoneId: zoneId,
-	}, nil
+	return d.GetZoneFineSchedule(c, zoneId)
 }
 
 func (d *FineDao) GetUserFines(c context.Context, username string) ([]api.FineResponse, error) {
-	query := "SELECT f.id, f.plate, f.amount, f.date, f.paid, f.zone_id FROM fines f JOIN cars c ON f.plate = c.plate WHERE c.user_id = $1"
+	query := "SELECT " + fineColumns + " FROM " + fineSource + " WHERE plate IN (SELECT plate FROM cars WHERE user_id = $1)"
 	rows, err := d.db.Query(c, query, username)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user fines: %w", err)
@@ -126,29 +290,33 @@ func (d *FineDao) GetUserFines(c context.Context, username string) ([]api.FineRe
 
 	fines := []api.FineResponse{}
 	for rows.Next() {
-		var fine api.FineResponse
-		if err := rows.Scan(&fine.Id, &fine.Plate, &fine.Amount, &fine.Date, &fine.Paid, &fine.ZoneId); err != nil {
+		fine, err := scanFine(rows)
+		if err != nil {
 			return nil, fmt.Errorf("row scan error: %w", err)
 		}
-		fines = append(fines, fine)
+		fines = append(fines, *fine)
 	}
 
 	return fines, nil
 }
 
 func (d *FineDao) GetFineById(c context.Context, id int64) (*api.FineResponse, error) {
-	query := "SELECT id, plate, amount, date, paid, zone_id FROM fines WHERE id = $1"
-	row := d.db.QueryRow(c, query, id)
-
-	var fine api.FineResponse
-	if err := row.Scan(&fine.Id, &fine.Plate, &fine.Amount, &fine.Date, &fine.Paid, &fine.ZoneId); err != nil {
+	query := "SELECT " + fineColumns + " FROM " + fineSource + " WHERE id = $1"
+	fine, err := scanFine(d.db.QueryRow(c, query, id))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrFineNotFound
 		}
 		return nil, fmt.Errorf("failed to get fine by id: %w", err)
 	}
 
-	return &fine, nil
+	events, err := d.getFineStageEvents(c, id)
+	if err != nil {
+		return nil, err
+	}
+	fine.StageEvents = &events
+
+	return fine, nil
 }
 
 func (d *FineDao) DeleteFines(c context.Context) error {
@@ -176,36 +344,94 @@ func (d *FineDao) DeleteFineById(c context.Context, id int64) error {
 }
 
 func (d *FineDao) PayFine(c context.Context, id int64) error {
-	checkQuery := "SELECT paid FROM fines WHERE id = $1"
-	var isPaid bool
-	err := d.db.QueryRow(c, checkQuery, id).Scan(&isPaid)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrFineNotFound
+	return d.db.WithTx(c, func(c context.Context) error {
+		// Lock the fine, a concurrent payment waits and then sees it paid
+		checkQuery := "SELECT paid, status FROM fines WHERE id = $1 FOR UPDATE"
+		var isPaid bool
+		var status string
+		err := d.db.QueryRow(c, checkQuery, id).Scan(&isPaid, &status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrFineNotFound
+			}
+			return fmt.Errorf("failed to check fine status: %w", err)
 		}
-		return fmt.Errorf("failed to check fine status: %w", err)
-	}
 
-	if isPaid {
-		return ErrFineAlreadyPaid
-	}
+		if isPaid {
+			return ErrFineAlreadyPaid
+		}
+		if err := finePayable(status); err != nil {
+			return err
+		}
+
+		// Charge the amount of the current stage
+		if err := d.advanceFine(c, id); err != nil {
+			return err
+		}
+
+		// The fine is only paid once its confirmed payments cover its amount
+		settled, err := NewPaymentDao().settle(c, PaymentTargetFine, id)
+		if err != nil {
+			return err
+		}
+		if !settled {
+			return ErrPaymentRequired
+		}
 
-	updateQuery := "UPDATE fines SET paid = TRUE WHERE id = $1"
-	result, err := d.db.Exec(c, updateQuery, id)
+		return nil
+	})
+}
+
+// PayFineWithWallet pays what is left due on a fine, at its current stage,
+// from the wallet of the user, and then marks it paid
+func (d *FineDao) PayFineWithWallet(c context.Context, username string, id int64) error {
+	var paymentId int64
+	err := d.db.WithTx(c, func(c context.Context) error {
+		// Lock the fine, a concurrent payment waits and then sees it paid
+		checkQuery := "SELECT paid, status FROM fines WHERE id = $1 FOR UPDATE"
+		var isPaid bool
+		var status string
+		if err := d.db.QueryRow(c, checkQuery, id).Scan(&isPaid, &status); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrFineNotFound
+			}
+			return fmt.Errorf("failed to check fine status: %w", err)
+		}
+		if isPaid {
+			return ErrFineAlreadyPaid
+		}
+		if err := finePayable(status); err != nil {
+			return err
+		}
+
+		if err := d.advanceFine(c, id); err != nil {
+			return err
+		}
+		// Confirmed payments may already cover the fine
+		due, err := NewPaymentDao().amountDue(c, PaymentTargetFine, id)
+		if err != nil && !errors.Is(err, ErrNothingToPay) {
+			return err
+		}
+		if err == nil {
+			paymentId, err = NewWalletDao().payFromWallet(c, username, PaymentTargetFine, id, due)
+			if err != nil {
+				return err
+			}
+		}
+
+		return d.PayFine(c, id)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to pay fine: %w", err)
+		return err
 	}
-
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return ErrFineNotFound
+	if paymentId != 0 {
+		NewInvoiceDao().billPayment(c, paymentId)
 	}
-
 	return nil
 }
 
 func (d *FineDao) GetZoneFines(ctx context.Context, zoneId int64, limit int, offset int) []api.FineResponse {
-	query := "SELECT id, plate, amount, date, paid, zone_id FROM fines WHERE zone_id = $1 LIMIT $2 OFFSET $3"
+	query := "SELECT " + fineColumns + " FROM " + fineSource + " WHERE zone_id = $1 LIMIT $2 OFFSET $3"
 	rows, err := d.db.Query(ctx, query, zoneId, limit, offset)
 	if err != nil {
 		fmt.Printf("db error: %v\n", err.Error())
@@ -215,12 +441,12 @@ func (d *FineDao) GetZoneFines(ctx context.Context, zoneId int64, limit int, off
 
 	fines := []api.FineResponse{}
 	for rows.Next() {
-		var fine api.FineResponse
-		if err := rows.Scan(&fine.Id, &fine.Plate, &fine.Amount, &fine.Date, &fine.Paid, &fine.ZoneId); err != nil {
+		fine, err := scanFine(rows)
+		if err != nil {
 			fmt.Printf("row scan error: %v\n", err.Error())
 			continue
 		}
-		fines = append(fines, fine)
+		fines = append(fines, *fine)
 	}
 
 	return fines
@@ -0,0 +1,166 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrFineStagesInvalid = errors.New("invalid fine stages")
+
+// fineStageBasis is when the stages of a fine start: when it was issued,
+// later by the time it spent under appeal
+const fineStageBasis = "(fines.date + fines.stage_paused)"
+
+// fineSource joins each fine with the stage of its zone it is in now: the
+// last stage started since fineStageBasis
+const fineSource = `fines
+	LEFT JOIN LATERAL (
+		SELECT s.name, s.adjustment_percent
+		FROM zone_fine_stages AS s
+		WHERE s.zone_id = fines.zone_id AND ` + fineStageBasis + ` + make_interval(days => s.starts_after_days) <= NOW()
+		ORDER BY s.starts_after_days DESC
+		LIMIT 1
+	) AS stage ON TRUE`
+
+// fineFrozen holds for fines whose amount no longer follows the stages:
+// paid, appealed or cancelled fines and fines with an amount set by appeal
+const fineFrozen = "(fines.paid OR fines.status <> 'open' OR fines.amount_final)"
+
+// fineDueAmount is the amount due on a fine of fineSource
+const fineDueAmount = "CASE WHEN " + fineFrozen + " OR stage.name IS NULL THEN fines.amount ELSE ROUND(fines.base_amount * (100 + stage.adjustment_percent) / 100.0)::BIGINT END"
+
+// fineCurrentStage is the stage of a fine of fineSource
+const fineCurrentStage = "CASE WHEN " + fineFrozen + " OR stage.name IS NULL THEN fines.stage ELSE stage.name END"
+
+// advanceFines moves fines to their current stage, all of them or only
+// fineId, and records each move
+func (d *FineDao) advanceFines(c context.Context, fineId *int64) error {
+	query := `
+		WITH due AS (
+			SELECT fines.id, fines.stage AS from_stage, stage.name AS to_stage, fines.amount AS from_amount, ` + fineDueAmount + ` AS to_amount
+			FROM ` + fineSource + `
+			WHERE NOT ` + fineFrozen + `
+				AND stage.name IS NOT NULL
+				AND (stage.name IS DISTINCT FROM fines.stage OR fines.amount <> ` + fineDueAmount + `)
+				AND ($1::BIGINT IS NULL OR fines.id = $1)
+			FOR UPDATE OF fines
+		), moved AS (
+			UPDATE fines SET amount = due.to_amount, stage = due.to_stage, stage_since = NOW()
+			FROM due
+			WHERE fines.id = due.id
+		)
+		INSERT INTO fine_stage_events (fine_id, from_stage, to_stage, from_amount, to_amount)
+		SELECT id, from_stage, to_stage, from_amount, to_amount FROM due
+	`
+	if _, err := d.db.Exec(c, query, fineId); err != nil {
+		return fmt.Errorf("failed to advance fine stages: %w", err)
+	}
+	return nil
+}
+
+// advanceFine moves a fine to its current stage before it is charged
+func (d *FineDao) advanceFine(c context.Context, fineId int64) error {
+	return d.advanceFines(c, &fineId)
+}
+
+// AdvanceFineStages moves every open fine to its current stage, it is run
+// by a background job
+func (d *FineDao) AdvanceFineStages(c context.Context) error {
+	return d.advanceFines(c, nil)
+}
+
+func (d *FineDao) getFineStageEvents(c context.Context, fineId int64) ([]api.FineStageEvent, error) {
+	query := "SELECT from_stage, to_stage, from_amount, to_amount, creation_time FROM fine_stage_events WHERE fine_id = $1 ORDER BY id"
+	rows, err := d.db.Query(c, query, fineId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fine stage events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []api.FineStageEvent{}
+	for rows.Next() {
+		var event api.FineStageEvent
+		if err := rows.Scan(&event.FromStage, &event.ToStage, &event.FromAmount, &event.ToAmount, &event.CreationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan fine stage event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// GetZoneFineStages returns the fine stages of a zone in order
+func (d *FineDao) GetZoneFineStages(c context.Context, zoneId int64) (*api.FineStages, error) {
+	exists, err := NewZoneDao().ZoneExists(c, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check zone existence: %w", err)
+	}
+	if !exists {
+		return nil, ErrZoneNotFound
+	}
+
+	query := "SELECT name, starts_after_days, adjustment_percent FROM zone_fine_stages WHERE zone_id = $1 ORDER BY starts_after_days"
+	rows, err := d.db.Query(c, query, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fine stages: %w", err)
+	}
+	defer rows.Close()
+
+	stages := &api.FineStages{ZoneId: zoneId, Stages: []api.FineStage{}}
+	for rows.Next() {
+		var stage api.FineStage
+		if err := rows.Scan(&stage.Name, &stage.StartsAfterDays, &stage.AdjustmentPercent); err != nil {
+			return nil, fmt.Errorf("failed to scan fine stage: %w", err)
+		}
+		stages.Stages = append(stages.Stages, stage)
+	}
+	return stages, nil
+}
+
+// UpdateZoneFineStages replaces the fine stages of a zone. Open fines move
+// to their new stage with the next run of the stage job, or when charged.
+func (d *FineDao) UpdateZoneFineStages(c context.Context, zoneId int64, request api.FineStagesRequest) (*api.FineStages, error) {
+	names := map[string]bool{}
+	days := map[int]bool{}
+	for _, stage := range request.Stages {
+		if strings.TrimSpace(stage.Name) == "" {
+			return nil, fmt.Errorf("%w: empty stage name", ErrFineStagesInvalid)
+		}
+		if stage.StartsAfterDays < 0 || stage.AdjustmentPercent < -100 {
+			return nil, fmt.Errorf("%w: stage %q starts before the fine or takes off more than the fine", ErrFineStagesInvalid, stage.Name)
+		}
+		if names[stage.Name] || days[stage.StartsAfterDays] {
+			return nil, fmt.Errorf("%w: duplicate stage %q", ErrFineStagesInvalid, stage.Name)
+		}
+		names[stage.Name] = true
+		days[stage.StartsAfterDays] = true
+	}
+
+	exists, err := NewZoneDao().ZoneExists(c, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check zone existence: %w", err)
+	}
+	if !exists {
+		return nil, ErrZoneNotFound
+	}
+
+	err = d.db.WithTx(c, func(c context.Context) error {
+		if _, err := d.db.Exec(c, "DELETE FROM zone_fine_stages WHERE zone_id = $1", zoneId); err != nil {
+			return fmt.Errorf("failed to clear fine stages: %w", err)
+		}
+		for _, stage := range request.Stages {
+			query := "INSERT INTO zone_fine_stages (zone_id, name, starts_after_days, adjustment_percent) VALUES ($1, $2, $3, $4)"
+			if _, err := d.db.Exec(c, query, zoneId, stage.Name, stage.StartsAfterDays, stage.AdjustmentPercent); err != nil {
+				return fmt.Errorf("failed to add fine stage: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetZoneFineStages(c, zoneId)
+}
@@ -0,0 +1,98 @@
+package dao
+
+import (
+	"OPP/backend/db"
+	"OPP/backend/idempotency"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// IdempotencyDao is the Postgres store of idempotency keys
+type IdempotencyDao struct {
+	db db.DB
+}
+
+func NewIdempotencyDao() *IdempotencyDao {
+	return &IdempotencyDao{
+		db: *db.GetDB(),
+	}
+}
+
+// Reserve claims a key, taking over expired ones. When the key is held the
+// stored record is returned.
+func (d *IdempotencyDao) Reserve(c context.Context, scope string, key string, requestHash string, ttl time.Duration) (*idempotency.Record, error) {
+	query := `
+		INSERT INTO idempotency_keys (username, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username, key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			status_code = NULL,
+			content_type = NULL,
+			body = NULL,
+			creation_time = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+		RETURNING 1
+	`
+	var reserved int
+	err := d.db.QueryRow(c, query, scope, key, requestHash, time.Now().Add(ttl)).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	selectQuery := "SELECT request_hash, status_code, content_type, body FROM idempotency_keys WHERE username = $1 AND key = $2"
+	var record idempotency.Record
+	var status *int
+	var contentType *string
+	var body []byte
+	if err := d.db.QueryRow(c, selectQuery, scope, key).Scan(&record.RequestHash, &status, &contentType, &body); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Released in the meantime
+			return d.Reserve(c, scope, key, requestHash, ttl)
+		}
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	if status != nil {
+		record.Response = &idempotency.Response{Status: *status, Body: body}
+		if contentType != nil {
+			record.Response.ContentType = *contentType
+		}
+	}
+	return &record, nil
+}
+
+func (d *IdempotencyDao) Save(c context.Context, scope string, key string, response idempotency.Response) error {
+	query := "UPDATE idempotency_keys SET status_code = $3, content_type = $4, body = $5 WHERE username = $1 AND key = $2"
+	result, err := d.db.Exec(c, query, scope, key, response.Status, response.ContentType, response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return idempotency.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (d *IdempotencyDao) Release(c context.Context, scope string, key string) error {
+	query := "DELETE FROM idempotency_keys WHERE username = $1 AND key = $2 AND status_code IS NULL"
+	if _, err := d.db.Exec(c, query, scope, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredKeys removes the keys past their retention window
+func (d *IdempotencyDao) DeleteExpiredKeys(c context.Context) error {
+	query := "DELETE FROM idempotency_keys WHERE expires_at <= NOW()"
+	if _, err := d.db.Exec(c, query); err != nil {
+		return fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	return nil
+}
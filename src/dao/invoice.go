@@ -0,0 +1,610 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/invoice"
+	"OPP/backend/logger"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Kinds of invoices
+const (
+	InvoiceKindPayment    = "payment"
+	InvoiceKindMonthly    = "monthly"
+	InvoiceKindCreditNote = "credit_note"
+)
+
+var (
+	ErrInvoiceNotFound        = errors.New("invoice not found")
+	ErrBillingProfileNotFound = errors.New("billing profile not found")
+	ErrBillingProfileInvalid  = errors.New("invalid billing profile")
+)
+
+type InvoiceDao struct {
+	db db.DB
+}
+
+func NewInvoiceDao() *InvoiceDao {
+	return &InvoiceDao{
+		db: *db.GetDB(),
+	}
+}
+
+func (d *InvoiceDao) GetUserBillingProfile(c context.Context, username string) (*api.BillingProfile, error) {
+	query := "SELECT company_name, vat_number, address, country, consolidated FROM billing_profiles WHERE username = $1"
+	var profile api.BillingProfile
+	if err := d.db.QueryRow(c, query, username).Scan(&profile.CompanyName, &profile.VatNumber, &profile.Address, &profile.Country, &profile.Consolidated); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBillingProfileNotFound
+		}
+		return nil, fmt.Errorf("failed to get billing profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// UpdateUserBillingProfile sets the billing profile of a user. Invoices
+// already issued keep the details they were issued with.
+func (d *InvoiceDao) UpdateUserBillingProfile(c context.Context, username string, request api.BillingProfile) (*api.BillingProfile, error) {
+	if strings.TrimSpace(request.CompanyName) == "" {
+		return nil, fmt.Errorf("%w: company_name is required", ErrBillingProfileInvalid)
+	}
+	if strings.TrimSpace(request.Address) == "" {
+		return nil, fmt.Errorf("%w: address is required", ErrBillingProfileInvalid)
+	}
+	if !countryPattern.MatchString(request.Country) {
+		return nil, fmt.Errorf("%w: country must be an ISO 3166 alpha-2 code", ErrBillingProfileInvalid)
+	}
+	if request.VatNumber != nil && strings.TrimSpace(*request.VatNumber) == "" {
+		request.VatNumber = nil
+	}
+	consolidated := request.Consolidated != nil && *request.Consolidated
+
+	query := `
+		INSERT INTO billing_profiles (username, company_name, vat_number, address, country, consolidated) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (username) DO UPDATE SET company_name = EXCLUDED.company_name, vat_number = EXCLUDED.vat_number, address = EXCLUDED.address,
+			country = EXCLUDED.country, consolidated = EXCLUDED.consolidated, updated_at = NOW()
+	`
+	if _, err := d.db.Exec(c, query, username, request.CompanyName, request.VatNumber, request.Address, request.Country, consolidated); err != nil {
+		return nil, fmt.Errorf("failed to set billing profile: %w", err)
+	}
+	return d.GetUserBillingProfile(c, username)
+}
+
+const invoiceColumns = `i.id, i.number, i.kind, i.operator_id, i.credited_invoice_id, i.period_start, i.period_end,
+	i.issuer_name, i.issuer_address, i.issuer_country, i.issuer_vat_number, i.buyer_name, i.buyer_address, i.buyer_country, i.buyer_vat_number,
+	i.currency, i.net, i.vat, i.total, i.issue_time`
+
+func scanInvoice(row pgx.Row) (*api.Invoice, error) {
+	var inv api.Invoice
+	var buyerName, buyerAddress, buyerCountry, buyerVatNumber *string
+	if err := row.Scan(&inv.Id, &inv.Number, &inv.Kind, &inv.OperatorId, &inv.CreditedInvoiceId, &inv.PeriodStart, &inv.PeriodEnd,
+		&inv.Issuer.Name, &inv.Issuer.Address, &inv.Issuer.Country, &inv.Issuer.VatNumber, &buyerName, &buyerAddress, &buyerCountry, &buyerVatNumber,
+		&inv.Currency, &inv.Net, &inv.Vat, &inv.Total, &inv.IssueTime); err != nil {
+		return nil, err
+	}
+	// Invoices of users without a billing profile have no buyer
+	if buyerName != nil {
+		inv.Buyer = &api.InvoiceParty{Name: *buyerName, VatNumber: buyerVatNumber}
+		if buyerAddress != nil {
+			inv.Buyer.Address = *buyerAddress
+		}
+		if buyerCountry != nil {
+			inv.Buyer.Country = *buyerCountry
+		}
+	}
+	inv.Lines = []api.InvoiceLine{}
+	return &inv, nil
+}
+
+// queryInvoices returns the invoices of a query on invoiceColumns, with
+// their lines
+func (d *InvoiceDao) queryInvoices(c context.Context, query string, args ...any) ([]api.Invoice, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invoices: %w", err)
+	}
+	defer rows.Close()
+
+	invoices := []api.Invoice{}
+	index := map[int64]int{}
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invoice: %w", err)
+		}
+		index[inv.Id] = len(invoices)
+		invoices = append(invoices, *inv)
+	}
+	rows.Close()
+	if len(invoices) == 0 {
+		return invoices, nil
+	}
+
+	ids := make([]int64, 0, len(invoices))
+	for id := range index {
+		ids = append(ids, id)
+	}
+	linesQuery := "SELECT invoice_id, description, payment_id, refund_id, vat_rate, net, vat, total FROM invoice_lines WHERE invoice_id = ANY($1) ORDER BY id"
+	lines, err := d.db.Query(c, linesQuery, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invoice lines: %w", err)
+	}
+	defer lines.Close()
+	for lines.Next() {
+		var invoiceId int64
+		var line api.InvoiceLine
+		if err := lines.Scan(&invoiceId, &line.Description, &line.PaymentId, &line.RefundId, &line.VatRate, &line.Net, &line.Vat, &line.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan invoice line: %w", err)
+		}
+		inv := &invoices[index[invoiceId]]
+		inv.Lines = append(inv.Lines, line)
+	}
+	return invoices, nil
+}
+
+func (d *InvoiceDao) GetInvoiceById(c context.Context, id int64) (*api.Invoice, error) {
+	invoices, err := d.queryInvoices(c, "SELECT "+invoiceColumns+" FROM invoices AS i WHERE i.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return nil, ErrInvoiceNotFound
+	}
+	return &invoices[0], nil
+}
+
+// IsInvoiceOwner tells whether an invoice was issued to username
+func (d *InvoiceDao) IsInvoiceOwner(c context.Context, id int64, username string) (bool, error) {
+	query := "SELECT username = $2 FROM invoices WHERE id = $1"
+	var owned bool
+	if err := d.db.QueryRow(c, query, id, username).Scan(&owned); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrInvoiceNotFound
+		}
+		return false, fmt.Errorf("failed to check invoice ownership: %w", err)
+	}
+	return owned, nil
+}
+
+// GetUserInvoices lists the invoices and credit notes of a user, newest
+// first
+func (d *InvoiceDao) GetUserInvoices(c context.Context, username string, limit *int, offset *int) ([]api.Invoice, error) {
+	query := "SELECT " + invoiceColumns + " FROM invoices AS i WHERE i.username = $1 ORDER BY i.id DESC LIMIT $2 OFFSET $3"
+	params := []any{username, 20, 0}
+	if limit != nil {
+		params[1] = *limit
+	}
+	if offset != nil {
+		params[2] = *offset
+	}
+	return d.queryInvoices(c, query, params...)
+}
+
+// GetOperatorInvoices lists the invoices and credit notes an operator
+// issued between from (included) and to (excluded), in number order
+func (d *InvoiceDao) GetOperatorInvoices(c context.Context, operatorId int64, from time.Time, to time.Time, limit *int, offset *int) ([]api.Invoice, error) {
+	query := "SELECT " + invoiceColumns + " FROM invoices AS i WHERE i.operator_id = $1 AND i.issue_time >= $2 AND i.issue_time < $3 ORDER BY i.sequence LIMIT $4 OFFSET $5"
+	params := []any{operatorId, from, to, 20, 0}
+	if limit != nil {
+		params[3] = *limit
+	}
+	if offset != nil {
+		params[4] = *offset
+	}
+	return d.queryInvoices(c, query, params...)
+}
+
+// document returns what the PDF and XML documents of an invoice show
+func (d *InvoiceDao) document(c context.Context, inv *api.Invoice) (invoice.Invoice, error) {
+	party := func(p api.InvoiceParty) invoice.Party {
+		party := invoice.Party{Name: p.Name, Address: p.Address, Country: p.Country}
+		if p.VatNumber != nil {
+			party.VatNumber = *p.VatNumber
+		}
+		return party
+	}
+	doc := invoice.Invoice{
+		Number:      inv.Number,
+		CreditNote:  inv.Kind == InvoiceKindCreditNote,
+		IssueTime:   inv.IssueTime,
+		PeriodStart: inv.PeriodStart,
+		PeriodEnd:   inv.PeriodEnd,
+		Issuer:      party(inv.Issuer),
+		Currency:    inv.Currency,
+		Net:         inv.Net,
+		Vat:         inv.Vat,
+		Total:       inv.Total,
+	}
+	if inv.Buyer != nil {
+		buyer := party(*inv.Buyer)
+		doc.Buyer = &buyer
+	}
+	for _, line := range inv.Lines {
+		doc.Lines = append(doc.Lines, invoice.Line{Description: line.Description, VatRate: line.VatRate, Net: line.Net, Vat: line.Vat, Total: line.Total})
+	}
+	if inv.CreditedInvoiceId != nil {
+		query := "SELECT number FROM invoices WHERE id = $1"
+		if err := d.db.QueryRow(c, query, *inv.CreditedInvoiceId).Scan(&doc.Credits); err != nil {
+			return invoice.Invoice{}, fmt.Errorf("failed to get credited invoice: %w", err)
+		}
+	}
+	return doc, nil
+}
+
+// RenderInvoicePdf renders the printable invoice
+func (d *InvoiceDao) RenderInvoicePdf(c context.Context, inv *api.Invoice) ([]byte, error) {
+	doc, err := d.document(c, inv)
+	if err != nil {
+		return nil, err
+	}
+	return invoice.Render(doc)
+}
+
+// RenderInvoiceXml renders the invoice as a UBL document
+func (d *InvoiceDao) RenderInvoiceXml(c context.Context, inv *api.Invoice) ([]byte, error) {
+	doc, err := d.document(c, inv)
+	if err != nil {
+		return nil, err
+	}
+	return invoice.MarshalUBL(doc)
+}
+
+// invoiceablePaymentsQuery selects the confirmed payments not invoiced yet
+// that an operator invoices: those of the tickets, sessions and permits of
+// its zones, and of the subscriptions whose plan starts with one of its
+// zones. Fines are penalties rather than sales, and wallet top-ups are
+// invoiced when the wallet is spent.
+const invoiceablePaymentsQuery = `
+	SELECT p.id, p.username, p.amount, p.currency, p.target_type, p.target_id, z.operator_id, z.name, sp.name,
+		COALESCE(t.start_date, s.start_date, pm.valid_from, per.period_start), COALESCE(t.end_date, s.end_date, pm.valid_until, per.period_end),
+		p.updated_at, COALESCE(b.consolidated, FALSE)
+	FROM payments AS p
+	LEFT JOIN tickets AS t ON p.target_type = 'ticket' AND t.id = p.target_id
+	LEFT JOIN parking_sessions AS s ON p.target_type = 'session' AND s.id = p.target_id
+	LEFT JOIN permits AS pm ON p.target_type = 'permit' AND pm.id = p.target_id
+	LEFT JOIN subscription_periods AS per ON p.target_type = 'subscription_period' AND per.id = p.target_id
+	LEFT JOIN subscriptions AS sub ON sub.id = per.subscription_id
+	LEFT JOIN subscription_plans AS sp ON sp.id = sub.plan_id
+	JOIN zones AS z ON z.id = COALESCE(t.zone_id, s.zone_id, pm.zone_id, sp.zone_ids[1])
+	LEFT JOIN billing_profiles AS b ON b.username = p.username
+	WHERE p.status = 'confirmed' AND p.target_type IN ('ticket', 'session', 'permit', 'subscription_period')
+		AND z.operator_id IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM invoice_lines AS l WHERE l.payment_id = p.id AND l.refund_id IS NULL)
+`
+
+type invoiceablePayment struct {
+	id           int64
+	username     string
+	amount       int64
+	currency     string
+	targetType   string
+	targetId     int64
+	operatorId   int64
+	zoneName     string
+	planName     *string
+	start        *time.Time
+	end          *time.Time
+	confirmedAt  time.Time
+	consolidated bool
+}
+
+func (d *InvoiceDao) queryInvoiceablePayments(c context.Context, query string, args ...any) ([]invoiceablePayment, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invoiceable payments: %w", err)
+	}
+	defer rows.Close()
+
+	var result []invoiceablePayment
+	for rows.Next() {
+		var p invoiceablePayment
+		if err := rows.Scan(&p.id, &p.username, &p.amount, &p.currency, &p.targetType, &p.targetId, &p.operatorId, &p.zoneName, &p.planName, &p.start, &p.end, &p.confirmedAt, &p.consolidated); err != nil {
+			return nil, fmt.Errorf("failed to scan invoiceable payment: %w", err)
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// description returns the invoice line of a payment
+func (p invoiceablePayment) description() string {
+	span := func(layout string, end func(time.Time) time.Time) string {
+		if p.start == nil || p.end == nil {
+			return ""
+		}
+		return fmt.Sprintf(", %s - %s", p.start.UTC().Format(layout), end(p.end.UTC()).Format(layout))
+	}
+	same := func(t time.Time) time.Time { return t }
+	// Days ending at midnight are shown as ending the day before
+	dayBefore := func(t time.Time) time.Time { return t.Add(-time.Second) }
+
+	switch p.targetType {
+	case PaymentTargetTicket:
+		return fmt.Sprintf("Parking ticket %d, %s%s", p.targetId, p.zoneName, span("02 Jan 2006 15:04 MST", same))
+	case PaymentTargetSession:
+		return fmt.Sprintf("Parking session %d, %s%s", p.targetId, p.zoneName, span("02 Jan 2006 15:04 MST", same))
+	case PaymentTargetPermit:
+		return fmt.Sprintf("Parking permit %d, %s%s", p.targetId, p.zoneName, span("02 Jan 2006", dayBefore))
+	case PaymentTargetSubscriptionPeriod:
+		plan := p.zoneName
+		if p.planName != nil {
+			plan = *p.planName
+		}
+		return fmt.Sprintf("Subscription %s%s", plan, span("02 Jan 2006", dayBefore))
+	}
+	return fmt.Sprintf("Payment %d", p.id)
+}
+
+type invoiceDraft struct {
+	operatorId        int64
+	kind              string
+	username          string
+	currency          string
+	creditedInvoiceId *int64
+	periodStart       *time.Time
+	periodEnd         *time.Time
+	lines             []draftLine
+}
+
+type draftLine struct {
+	paymentId   int64
+	refundId    *int64
+	description string
+	// total includes VAT, at vatRate or else at the rate of the operator
+	total   int64
+	vatRate *int
+}
+
+// issue numbers and records an invoice. Its lines already invoiced are
+// left out, and nothing is issued when none is left.
+func (d *InvoiceDao) issue(c context.Context, draft invoiceDraft) error {
+	return d.db.WithTx(c, func(c context.Context) error {
+		// Locking the operator serializes its invoices, so their numbers
+		// have no gaps and no payment is invoiced twice
+		operatorQuery := "SELECT name, address, country, vat_number, vat_rate, invoice_prefix, next_invoice_number FROM operators WHERE id = $1 FOR UPDATE"
+		var doc invoice.Invoice
+		var rate int
+		var prefix string
+		var sequence int64
+		if err := d.db.QueryRow(c, operatorQuery, draft.operatorId).Scan(&doc.Issuer.Name, &doc.Issuer.Address, &doc.Issuer.Country, &doc.Issuer.VatNumber, &rate, &prefix, &sequence); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrOperatorNotFound
+			}
+			return fmt.Errorf("failed to lock operator: %w", err)
+		}
+
+		paymentIds, refundIds := []int64{}, []int64{}
+		for _, line := range draft.lines {
+			if line.refundId != nil {
+				refundIds = append(refundIds, *line.refundId)
+			} else {
+				paymentIds = append(paymentIds, line.paymentId)
+			}
+		}
+		invoicedQuery := "SELECT payment_id, refund_id FROM invoice_lines WHERE (refund_id IS NULL AND payment_id = ANY($1)) OR refund_id = ANY($2)"
+		rows, err := d.db.Query(c, invoicedQuery, paymentIds, refundIds)
+		if err != nil {
+			return fmt.Errorf("failed to query invoiced lines: %w", err)
+		}
+		invoicedPayments, invoicedRefunds := map[int64]bool{}, map[int64]bool{}
+		for rows.Next() {
+			var paymentId int64
+			var refundId *int64
+			if err := rows.Scan(&paymentId, &refundId); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan invoiced line: %w", err)
+			}
+			if refundId != nil {
+				invoicedRefunds[*refundId] = true
+			} else {
+				invoicedPayments[paymentId] = true
+			}
+		}
+		rows.Close()
+
+		var lines []draftLine
+		for _, line := range draft.lines {
+			if (line.refundId != nil && invoicedRefunds[*line.refundId]) || (line.refundId == nil && invoicedPayments[line.paymentId]) {
+				continue
+			}
+			lineRate := rate
+			if line.vatRate != nil {
+				lineRate = *line.vatRate
+			}
+			doc.AddLine(invoice.NewLine(line.description, line.total, lineRate))
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+
+		// Credit notes bill the buyer of the invoice they credit
+		var buyerName, buyerAddress, buyerCountry, buyerVatNumber *string
+		buyerQuery := "SELECT company_name, address, country, vat_number FROM billing_profiles WHERE username = $1"
+		buyerArgs := []any{draft.username}
+		if draft.creditedInvoiceId != nil {
+			buyerQuery = "SELECT buyer_name, buyer_address, buyer_country, buyer_vat_number FROM invoices WHERE id = $1"
+			buyerArgs = []any{*draft.creditedInvoiceId}
+		}
+		err = d.db.QueryRow(c, buyerQuery, buyerArgs...).Scan(&buyerName, &buyerAddress, &buyerCountry, &buyerVatNumber)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to get invoice buyer: %w", err)
+		}
+
+		if _, err := d.db.Exec(c, "UPDATE operators SET next_invoice_number = next_invoice_number + 1 WHERE id = $1", draft.operatorId); err != nil {
+			return fmt.Errorf("failed to number invoice: %w", err)
+		}
+
+		invoiceQuery := `
+			INSERT INTO invoices (operator_id, sequence, number, kind, username, credited_invoice_id, period_start, period_end,
+				issuer_name, issuer_address, issuer_country, issuer_vat_number, buyer_name, buyer_address, buyer_country, buyer_vat_number,
+				currency, net, vat, total)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+			RETURNING id
+		`
+		var invoiceId int64
+		if err := d.db.QueryRow(c, invoiceQuery, draft.operatorId, sequence, fmt.Sprintf("%s%06d", prefix, sequence), draft.kind, draft.username, draft.creditedInvoiceId, draft.periodStart, draft.periodEnd,
+			doc.Issuer.Name, doc.Issuer.Address, doc.Issuer.Country, doc.Issuer.VatNumber, buyerName, buyerAddress, buyerCountry, buyerVatNumber,
+			draft.currency, doc.Net, doc.Vat, doc.Total).Scan(&invoiceId); err != nil {
+			return fmt.Errorf("failed to add invoice: %w", err)
+		}
+
+		lineQuery := "INSERT INTO invoice_lines (invoice_id, payment_id, refund_id, description, vat_rate, net, vat, total) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+		for i, line := range lines {
+			computed := doc.Lines[i]
+			if _, err := d.db.Exec(c, lineQuery, invoiceId, line.paymentId, line.refundId, computed.Description, computed.VatRate, computed.Net, computed.Vat, computed.Total); err != nil {
+				return fmt.Errorf("failed to add invoice line: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// invoicePayment issues the invoice of a confirmed payment, unless the
+// payment is not invoiceable or its user gets monthly invoices
+func (d *InvoiceDao) invoicePayment(c context.Context, paymentId int64) error {
+	payments, err := d.queryInvoiceablePayments(c, invoiceablePaymentsQuery+" AND p.id = $1", paymentId)
+	if err != nil {
+		return err
+	}
+	if len(payments) == 0 || payments[0].consolidated {
+		return nil
+	}
+	p := payments[0]
+	return d.issue(c, invoiceDraft{
+		operatorId: p.operatorId,
+		kind:       InvoiceKindPayment,
+		username:   p.username,
+		currency:   p.currency,
+		lines:      []draftLine{{paymentId: p.id, description: p.description(), total: p.amount}},
+	})
+}
+
+// invoiceRefund issues the credit note of a confirmed refund of an invoiced
+// payment, at the VAT rate of the invoiced line. Refunds of payments not
+// invoiced yet are credited once the payment is.
+func (d *InvoiceDao) invoiceRefund(c context.Context, refundId int64) error {
+	query := `
+		SELECT r.payment_id, r.amount, i.id, i.operator_id, i.username, i.currency, l.vat_rate, l.description
+		FROM refunds AS r
+		JOIN invoice_lines AS l ON l.payment_id = r.payment_id AND l.refund_id IS NULL
+		JOIN invoices AS i ON i.id = l.invoice_id
+		WHERE r.id = $1 AND r.status = 'confirmed'
+	`
+	var draft invoiceDraft
+	var line draftLine
+	var rate int
+	var creditedId int64
+	err := d.db.QueryRow(c, query, refundId).Scan(&line.paymentId, &line.total, &creditedId, &draft.operatorId, &draft.username, &draft.currency, &rate, &line.description)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed to get refunded invoice line: %w", err)
+	}
+	draft.kind = InvoiceKindCreditNote
+	draft.creditedInvoiceId = &creditedId
+	line.refundId = &refundId
+	line.total = -line.total
+	line.vatRate = &rate
+	line.description = "Refund of " + line.description
+	draft.lines = []draftLine{line}
+	return d.issue(c, draft)
+}
+
+// billPayment issues the invoice of a confirmed payment once its
+// confirmation is committed, the invoicing job retries those that fail
+func (d *InvoiceDao) billPayment(c context.Context, paymentId int64) {
+	if err := d.invoicePayment(c, paymentId); err != nil {
+		logger.Error.Printf("failed to invoice payment %d: %v", paymentId, err)
+	}
+}
+
+// creditRefund issues the credit note of a refund, the invoicing job
+// retries those that fail
+func (d *InvoiceDao) creditRefund(c context.Context, refundId int64) {
+	if err := d.invoiceRefund(c, refundId); err != nil {
+		logger.Error.Printf("failed to credit refund %d: %v", refundId, err)
+	}
+}
+
+// IssueInvoices issues the invoices payment confirmations did not: those
+// that failed, those of payments made before their zone had an operator, the monthly invoices
+// of consolidated users once the month is over, and the credit notes of
+// refunds
+func (d *InvoiceDao) IssueInvoices(c context.Context) error {
+	query := invoiceablePaymentsQuery + " AND (NOT COALESCE(b.consolidated, FALSE) OR p.updated_at < date_trunc('month', NOW(), 'UTC')) ORDER BY p.id"
+	payments, err := d.queryInvoiceablePayments(c, query)
+	if err != nil {
+		return err
+	}
+
+	// Consolidated payments are grouped by calendar month, in UTC
+	type month struct {
+		username   string
+		operatorId int64
+		currency   string
+		start      time.Time
+	}
+	var months []month
+	monthly := map[month][]draftLine{}
+	var errs []error
+	for _, p := range payments {
+		line := draftLine{paymentId: p.id, description: p.description(), total: p.amount}
+		if !p.consolidated {
+			err := d.issue(c, invoiceDraft{operatorId: p.operatorId, kind: InvoiceKindPayment, username: p.username, currency: p.currency, lines: []draftLine{line}})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to invoice payment %d: %w", p.id, err))
+			}
+			continue
+		}
+		confirmed := p.confirmedAt.UTC()
+		key := month{p.username, p.operatorId, p.currency, time.Date(confirmed.Year(), confirmed.Month(), 1, 0, 0, 0, 0, time.UTC)}
+		if _, ok := monthly[key]; !ok {
+			months = append(months, key)
+		}
+		monthly[key] = append(monthly[key], line)
+	}
+	for _, m := range months {
+		end := m.start.AddDate(0, 1, 0)
+		err := d.issue(c, invoiceDraft{operatorId: m.operatorId, kind: InvoiceKindMonthly, username: m.username, currency: m.currency, periodStart: &m.start, periodEnd: &end, lines: monthly[m]})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to issue monthly invoice of %s: %w", m.username, err))
+		}
+	}
+
+	refundsQuery := `
+		SELECT r.id FROM refunds AS r
+		WHERE r.status = 'confirmed'
+			AND EXISTS (SELECT 1 FROM invoice_lines AS l WHERE l.payment_id = r.payment_id AND l.refund_id IS NULL)
+			AND NOT EXISTS (SELECT 1 FROM invoice_lines AS l WHERE l.refund_id = r.id)
+		ORDER BY r.id
+	`
+	rows, err := d.db.Query(c, refundsQuery)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("failed to query refunds to credit: %w", err))...)
+	}
+	var refundIds []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return errors.Join(append(errs, fmt.Errorf("failed to scan refund to credit: %w", err))...)
+		}
+		refundIds = append(refundIds, id)
+	}
+	rows.Close()
+	for _, id := range refundIds {
+		if err := d.invoiceRefund(c, id); err != nil {
+			errs = append(errs, fmt.Errorf("failed to credit refund %d: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
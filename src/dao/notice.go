@@ -0,0 +1,186 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/money"
+	"OPP/backend/notice"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type NoticeDao struct {
+	db db.DB
+}
+
+func NewNoticeDao() *NoticeDao {
+	return &NoticeDao{
+		db: *db.GetDB(),
+	}
+}
+
+// getTemplate returns the notice template of a zone with the defaults
+// filled in
+func (d *NoticeDao) getTemplate(c context.Context, zoneId int64) (notice.Template, error) {
+	query := "SELECT COALESCE(title, ''), COALESCE(issuer, ''), COALESCE(body, ''), COALESCE(footer, ''), COALESCE(color, ''), COALESCE(payment_url, ''), COALESCE(timezone, '') FROM zone_notice_templates WHERE zone_id = $1"
+	var t notice.Template
+	err := d.db.QueryRow(c, query, zoneId).Scan(&t.Title, &t.Issuer, &t.Body, &t.Footer, &t.Color, &t.PaymentURL, &t.Timezone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return t, fmt.Errorf("failed to get notice template: %w", err)
+	}
+	return t.Merge(notice.DefaultTemplate()), nil
+}
+
+func noticeTemplateResponse(zoneId int64, t notice.Template) *api.NoticeTemplate {
+	return &api.NoticeTemplate{
+		ZoneId:     zoneId,
+		Title:      t.Title,
+		Issuer:     t.Issuer,
+		Body:       t.Body,
+		Footer:     t.Footer,
+		Color:      t.Color,
+		PaymentUrl: t.PaymentURL,
+		Timezone:   t.Timezone,
+	}
+}
+
+// GetZoneNoticeTemplate returns the notice template used for the fines of
+// a zone
+func (d *NoticeDao) GetZoneNoticeTemplate(c context.Context, zoneId int64) (*api.NoticeTemplate, error) {
+	exists, err := NewZoneDao().ZoneExists(c, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check zone existence: %w", err)
+	}
+	if !exists {
+		return nil, ErrZoneNotFound
+	}
+
+	t, err := d.getTemplate(c, zoneId)
+	if err != nil {
+		return nil, err
+	}
+	return noticeTemplateResponse(zoneId, t), nil
+}
+
+// UpdateZoneNoticeTemplate replaces the notice template of a zone, omitted
+// fields go back to the defaults. The template is checked by rendering a
+// sample notice.
+func (d *NoticeDao) UpdateZoneNoticeTemplate(c context.Context, zoneId int64, request api.NoticeTemplateRequest) (*api.NoticeTemplate, error) {
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	t := notice.Template{
+		Title:      value(request.Title),
+		Issuer:     value(request.Issuer),
+		Body:       value(request.Body),
+		Footer:     value(request.Footer),
+		Color:      value(request.Color),
+		PaymentURL: value(request.PaymentUrl),
+		Timezone:   value(request.Timezone),
+	}
+	if err := t.Merge(notice.DefaultTemplate()).Validate(); err != nil {
+		return nil, err
+	}
+
+	exists, err := NewZoneDao().ZoneExists(c, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check zone existence: %w", err)
+	}
+	if !exists {
+		return nil, ErrZoneNotFound
+	}
+
+	query := `
+		INSERT INTO zone_notice_templates (zone_id, title, issuer, body, footer, color, payment_url, timezone, updated_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NOW())
+		ON CONFLICT (zone_id) DO UPDATE SET
+			title = EXCLUDED.title, issuer = EXCLUDED.issuer, body = EXCLUDED.body, footer = EXCLUDED.footer,
+			color = EXCLUDED.color, payment_url = EXCLUDED.payment_url, timezone = EXCLUDED.timezone, updated_at = NOW()
+	`
+	if _, err := d.db.Exec(c, query, zoneId, t.Title, t.Issuer, t.Body, t.Footer, t.Color, t.PaymentURL, t.Timezone); err != nil {
+		return nil, fmt.Errorf("failed to update notice template: %w", err)
+	}
+
+	return d.GetZoneNoticeTemplate(c, zoneId)
+}
+
+// noticeStages lists the stages a fine goes through from `basis`, with the
+// amount due in each. The list is empty when the amount of the fine no longer changes:
+// paid, appealed or cancelled fines and amounts set on appeal.
+func noticeStages(fine *api.FineResponse, final bool, stages []api.FineStage, basis time.Time, now time.Time) []notice.Stage {
+	if final || fine.Paid || fine.Status != FineStatusOpen || fine.BaseAmount == nil || len(stages) == 0 {
+		return nil
+	}
+	// The base amount is due until the first stage
+	if stages[0].StartsAfterDays > 0 {
+		stages = append([]api.FineStage{{Name: "Issued"}}, stages...)
+	}
+
+	var result []notice.Stage
+	for i, stage := range stages {
+		from := basis.AddDate(0, 0, stage.StartsAfterDays)
+		amount := money.DefaultRounding.Round(float64(fine.BaseAmount.Amount) * float64(100+stage.AdjustmentPercent) / 100)
+		current := !from.After(now) && (i+1 == len(stages) || basis.AddDate(0, 0, stages[i+1].StartsAfterDays).After(now))
+		result = append(result, notice.Stage{
+			Name:    stage.Name,
+			From:    from,
+			Percent: stage.AdjustmentPercent,
+			Amount:  money.New(amount, fine.BaseAmount.Currency),
+			Current: current,
+		})
+	}
+	return result
+}
+
+// RenderFineNotice renders the printable notice of a fine with the
+// template of its zone
+func (d *NoticeDao) RenderFineNotice(c context.Context, fine *api.FineResponse) ([]byte, error) {
+	zone, err := NewZoneDao().GetZoneById(c, fine.ZoneId)
+	if err != nil {
+		return nil, err
+	}
+	t, err := d.getTemplate(c, fine.ZoneId)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := NewFineDao().GetZoneFineStages(c, fine.ZoneId)
+	if err != nil {
+		return nil, err
+	}
+	var final bool
+	var basis time.Time
+	if err := d.db.QueryRow(c, "SELECT amount_final, "+fineStageBasis+" FROM fines WHERE id = $1", fine.Id).Scan(&final, &basis); err != nil {
+		return nil, fmt.Errorf("failed to get fine: %w", err)
+	}
+
+	data := notice.Fine{
+		Id:       fine.Id,
+		Plate:    fine.Plate,
+		ZoneName: zone.Name,
+		Status:   fine.Status,
+		Paid:     fine.Paid,
+		Date:     fine.Date,
+		Amount:   money.New(fine.Amount.Amount, fine.Amount.Currency),
+		Stages:   noticeStages(fine, final, stages.Stages, basis, time.Now()),
+	}
+	data.BaseAmount = data.Amount
+	if fine.BaseAmount != nil {
+		data.BaseAmount = money.New(fine.BaseAmount.Amount, fine.BaseAmount.Currency)
+	}
+	if fine.Offense != nil {
+		data.Offense = *fine.Offense
+	}
+
+	pdf, err := notice.Render(data, t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render notice: %w", err)
+	}
+	return pdf, nil
+}
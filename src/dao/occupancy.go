@@ -0,0 +1,310 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ZONE_SENSOR_MAX_AGE_MINUTES = os.Getenv("ZONE_SENSOR_MAX_AGE_MINUTES")
+
+var (
+	ErrZoneFull         = errors.New("zone is full")
+	ErrOccupancyInvalid = errors.New("invalid occupancy")
+)
+
+const (
+	OccupancySourceSensor  = "sensor"
+	OccupancySourceTickets = "tickets"
+)
+
+const (
+	defaultZoneSensorMaxAgeMinutes = 15
+	// How long an unpaid ticket holds its spot after it is bought
+	unpaidTicketHold = 15 * time.Minute
+	// How often the occupancy of the zones is kept
+	OccupancySnapshotInterval = 15 * time.Minute
+	// The longest history returned at once
+	maxOccupancyHistory = 92 * 24 * time.Hour
+)
+
+type OccupancyDao struct {
+	db db.DB
+}
+
+func NewOccupancyDao() *OccupancyDao {
+	return &OccupancyDao{
+		db: *db.GetDB(),
+	}
+}
+
+func zoneSensorMaxAge() time.Duration {
+	if ZONE_SENSOR_MAX_AGE_MINUTES != "" {
+		if m, err := strconv.Atoi(ZONE_SENSOR_MAX_AGE_MINUTES); err == nil && m > 0 {
+			return time.Duration(m) * time.Minute
+		}
+	}
+	return defaultZoneSensorMaxAgeMinutes * time.Minute
+}
+
+// newZoneOccupancy fills in what follows from the capacity and the spots
+// taken. Zones without a capacity are never full.
+func newZoneOccupancy(zoneId int64, capacity *int, occupied int, source string, measuredAt time.Time) api.ZoneOccupancy {
+	occupancy := api.ZoneOccupancy{ZoneId: zoneId, Capacity: capacity, Occupied: occupied, Source: source, MeasuredAt: measuredAt}
+	if capacity != nil {
+		free := max(*capacity-occupied, 0)
+		occupancy.Free = &free
+		occupancy.Full = free == 0
+	}
+	return occupancy
+}
+
+// zoneOccupancies returns the occupancy of zones at a time, ordered by
+// zone; missing zones are left out. The cars of paid tickets, of unpaid
+// tickets bought recently and of active sessions take a spot each, except
+// the car with plate `except` when set. A fresh sensor reading takes over
+// when `at` is close to now.
+func (d *OccupancyDao) zoneOccupancies(c context.Context, zoneIds []int64, at time.Time, except string) ([]api.ZoneOccupancy, error) {
+	now := time.Now()
+	query := `
+		SELECT
+			z.id,
+			z.capacity,
+			z.sensor_occupied,
+			z.sensor_updated_at,
+			(SELECT COUNT(DISTINCT cars.plate) FROM (
+				SELECT t.plate FROM tickets AS t
+				WHERE t.zone_id = z.id AND t.start_date <= $2 AND t.end_date > $2
+					AND (t.paid OR t.creation_time > $3)
+				UNION
+				SELECT s.plate FROM parking_sessions AS s
+				WHERE s.zone_id = z.id AND s.status = $4 AND s.start_date <= $2 AND s.max_end_date > $2
+			) AS cars WHERE cars.plate <> $5)
+		FROM zones AS z
+		WHERE z.id = ANY($1)
+		ORDER BY z.id
+	`
+	rows, err := d.db.Query(c, query, zoneIds, at, now.Add(-unpaidTicketHold), SessionStatusActive, except)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zone occupancy: %w", err)
+	}
+	defer rows.Close()
+
+	maxAge := zoneSensorMaxAge()
+	occupancies := []api.ZoneOccupancy{}
+	for rows.Next() {
+		var zoneId int64
+		var capacity, sensorOccupied *int
+		var sensorUpdatedAt *time.Time
+		var counted int
+		if err := rows.Scan(&zoneId, &capacity, &sensorOccupied, &sensorUpdatedAt, &counted); err != nil {
+			return nil, fmt.Errorf("failed to scan zone occupancy: %w", err)
+		}
+
+		occupancy := newZoneOccupancy(zoneId, capacity, counted, OccupancySourceTickets, at)
+		if sensorOccupied != nil && sensorUpdatedAt != nil && now.Sub(*sensorUpdatedAt) <= maxAge && at.Sub(now).Abs() <= maxAge {
+			occupancy = newZoneOccupancy(zoneId, capacity, *sensorOccupied, OccupancySourceSensor, *sensorUpdatedAt)
+		}
+		occupancies = append(occupancies, occupancy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read zone occupancy: %w", err)
+	}
+	return occupancies, nil
+}
+
+// GetZoneOccupancy returns the live occupancy of a zone
+func (d *OccupancyDao) GetZoneOccupancy(c context.Context, zoneId int64) (*api.ZoneOccupancy, error) {
+	occupancies, err := d.zoneOccupancies(c, []int64{zoneId}, time.Now(), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(occupancies) == 0 {
+		return nil, ErrZoneNotFound
+	}
+	return &occupancies[0], nil
+}
+
+// FillZoneOccupancy sets the live occupancy of zones
+func (d *OccupancyDao) FillZoneOccupancy(c context.Context, zones []api.ZoneResponse) error {
+	if len(zones) == 0 {
+		return nil
+	}
+	zoneIds := make([]int64, len(zones))
+	for i, zone := range zones {
+		zoneIds[i] = zone.Id
+	}
+	occupancies, err := d.zoneOccupancies(c, zoneIds, time.Now(), "")
+	if err != nil {
+		return err
+	}
+	byZone := map[int64]api.ZoneOccupancy{}
+	for _, occupancy := range occupancies {
+		byZone[occupancy.ZoneId] = occupancy
+	}
+	for i := range zones {
+		if occupancy, ok := byZone[zones[i].Id]; ok {
+			zones[i].Occupancy = &occupancy
+		}
+	}
+	return nil
+}
+
+// UpdateZoneSensor records the spots the sensors of a zone count as taken
+func (d *OccupancyDao) UpdateZoneSensor(c context.Context, zoneId int64, reading api.ZoneSensorReading) (*api.ZoneOccupancy, error) {
+	if reading.Occupied < 0 {
+		return nil, fmt.Errorf("%w: occupied must not be negative", ErrOccupancyInvalid)
+	}
+
+	query := "UPDATE zones SET sensor_occupied = $2, sensor_updated_at = NOW() WHERE id = $1"
+	result, err := d.db.Exec(c, query, zoneId, reading.Occupied)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update zone sensor: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, ErrZoneNotFound
+	}
+	return d.GetZoneOccupancy(c, zoneId)
+}
+
+// peakOccupancy returns the most cars that tickets and active sessions put
+// in a zone at once during [start, end), except the car with plate
+// `except`. Occupancy only rises when a stay starts, so the peak is at
+// `start` or at the start of one of the stays.
+func (d *OccupancyDao) peakOccupancy(c context.Context, zoneId int64, start time.Time, end time.Time, except string) (int, error) {
+	query := `
+		WITH cars AS (
+			SELECT t.plate, t.start_date, t.end_date FROM tickets AS t
+			WHERE t.zone_id = $1 AND t.start_date < $3 AND t.end_date > $2
+				AND (t.paid OR t.creation_time > $4) AND t.plate <> $5
+			UNION ALL
+			SELECT s.plate, s.start_date, s.max_end_date FROM parking_sessions AS s
+			WHERE s.zone_id = $1 AND s.status = $6 AND s.start_date < $3 AND s.max_end_date > $2
+				AND s.plate <> $5
+		), points AS (
+			SELECT $2::TIMESTAMP AS at
+			UNION
+			SELECT start_date FROM cars WHERE start_date > $2
+		)
+		SELECT COALESCE(MAX((
+			SELECT COUNT(DISTINCT cars.plate) FROM cars
+			WHERE cars.start_date <= points.at AND cars.end_date > points.at
+		)), 0)
+		FROM points
+	`
+	var peak int
+	if err := d.db.QueryRow(c, query, zoneId, start, end, time.Now().Add(-unpaidTicketHold), except, SessionStatusActive).Scan(&peak); err != nil {
+		return 0, fmt.Errorf("failed to get zone peak occupancy: %w", err)
+	}
+	return peak, nil
+}
+
+// checkZoneCapacity refuses a car in a zone that refuses cars when full
+// if the zone is full at any time during [start, end). The car is not
+// refused for the time it already takes a spot in the zone. It must run in
+// the transaction that adds the car: the zone stays locked until then, so
+// concurrent purchases cannot both take the last spot.
+func (d *OccupancyDao) checkZoneCapacity(c context.Context, zoneId int64, plate string, start time.Time, end time.Time) error {
+	query := "SELECT refuse_when_full AND capacity IS NOT NULL FROM zones WHERE id = $1"
+	var refuse bool
+	if err := d.db.QueryRow(c, query, zoneId).Scan(&refuse); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrZoneNotFound
+		}
+		return fmt.Errorf("failed to get zone capacity: %w", err)
+	}
+	if !refuse {
+		return nil
+	}
+
+	query = "SELECT 1 FROM zones WHERE id = $1 FOR NO KEY UPDATE"
+	var locked int
+	if err := d.db.QueryRow(c, query, zoneId).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to lock zone: %w", err)
+	}
+	// A fresh sensor reading tells how full the zone is at the start
+	occupancies, err := d.zoneOccupancies(c, []int64{zoneId}, start, plate)
+	if err != nil {
+		return err
+	}
+	if len(occupancies) == 0 {
+		return ErrZoneNotFound
+	}
+	peak, err := d.peakOccupancy(c, zoneId, start, end, plate)
+	if err != nil {
+		return err
+	}
+	occupancy := occupancies[0]
+	if newZoneOccupancy(zoneId, occupancy.Capacity, max(occupancy.Occupied, peak), occupancy.Source, start).Full {
+		return ErrZoneFull
+	}
+	return nil
+}
+
+// SnapshotOccupancy keeps the live occupancy of every zone. Snapshots are
+// kept once per interval, a second run in the same one is a no-op.
+func (d *OccupancyDao) SnapshotOccupancy(c context.Context) error {
+	rows, err := d.db.Query(c, "SELECT id FROM zones ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("failed to query zones: %w", err)
+	}
+	zoneIds, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return fmt.Errorf("failed to scan zones: %w", err)
+	}
+	if len(zoneIds) == 0 {
+		return nil
+	}
+
+	occupancies, err := d.zoneOccupancies(c, zoneIds, time.Now(), "")
+	if err != nil {
+		return err
+	}
+	slot := time.Now().Truncate(OccupancySnapshotInterval)
+	var errs []error
+	for _, occupancy := range occupancies {
+		query := "INSERT INTO zone_occupancy_snapshots (zone_id, time, occupied, capacity, source) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING"
+		if _, err := d.db.Exec(c, query, occupancy.ZoneId, slot, occupancy.Occupied, occupancy.Capacity, occupancy.Source); err != nil {
+			errs = append(errs, fmt.Errorf("failed to snapshot zone %d occupancy: %w", occupancy.ZoneId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetZoneOccupancyHistory returns the snapshots of a zone from `from` to
+// `to`, oldest first
+func (d *OccupancyDao) GetZoneOccupancyHistory(c context.Context, zoneId int64, from time.Time, to time.Time) ([]api.ZoneOccupancy, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("%w: to must be after from", ErrOccupancyInvalid)
+	}
+	if to.Sub(from) > maxOccupancyHistory {
+		return nil, fmt.Errorf("%w: history spans at most %d days", ErrOccupancyInvalid, int(maxOccupancyHistory.Hours()/24))
+	}
+
+	query := "SELECT occupied, capacity, source, time FROM zone_occupancy_snapshots WHERE zone_id = $1 AND time >= $2 AND time < $3 ORDER BY time"
+	rows, err := d.db.Query(c, query, zoneId, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zone occupancy history: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := []api.ZoneOccupancy{}
+	for rows.Next() {
+		var occupied int
+		var capacity *int
+		var source string
+		var measuredAt time.Time
+		if err := rows.Scan(&occupied, &capacity, &source, &measuredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan zone occupancy: %w", err)
+		}
+		snapshots = append(snapshots, newZoneOccupancy(zoneId, capacity, occupied, source, measuredAt))
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,173 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrOperatorNotFound  = errors.New("operator not found")
+	ErrOperatorInvalid   = errors.New("invalid operator")
+	ErrOperatorZoneTaken = errors.New("zone belongs to another operator")
+)
+
+// countryPattern matches ISO 3166 alpha-2 country codes
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+type OperatorDao struct {
+	db db.DB
+}
+
+func NewOperatorDao() *OperatorDao {
+	return &OperatorDao{
+		db: *db.GetDB(),
+	}
+}
+
+const operatorColumns = `o.id, o.name, o.address, o.country, o.vat_number, o.vat_rate, o.invoice_prefix,
+	ARRAY(SELECT z.id::BIGINT FROM zones AS z WHERE z.operator_id = o.id ORDER BY z.id), o.creation_time`
+
+func scanOperator(row pgx.Row) (*api.Operator, error) {
+	var operator api.Operator
+	if err := row.Scan(&operator.Id, &operator.Name, &operator.Address, &operator.Country, &operator.VatNumber, &operator.VatRate, &operator.InvoicePrefix, &operator.ZoneIds, &operator.CreationTime); err != nil {
+		return nil, err
+	}
+	return &operator, nil
+}
+
+func (d *OperatorDao) GetOperators(c context.Context) ([]api.Operator, error) {
+	query := "SELECT " + operatorColumns + " FROM operators AS o ORDER BY o.id"
+	rows, err := d.db.Query(c, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operators: %w", err)
+	}
+	defer rows.Close()
+
+	operators := []api.Operator{}
+	for rows.Next() {
+		operator, err := scanOperator(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan operator: %w", err)
+		}
+		operators = append(operators, *operator)
+	}
+	return operators, nil
+}
+
+func (d *OperatorDao) GetOperatorById(c context.Context, id int64) (*api.Operator, error) {
+	query := "SELECT " + operatorColumns + " FROM operators AS o WHERE o.id = $1"
+	operator, err := scanOperator(d.db.QueryRow(c, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOperatorNotFound
+		}
+		return nil, fmt.Errorf("failed to get operator: %w", err)
+	}
+	return operator, nil
+}
+
+func validateOperator(request api.OperatorRequest) error {
+	for _, field := range [][2]string{{"name", request.Name}, {"address", request.Address}, {"vat_number", request.VatNumber}} {
+		if strings.TrimSpace(field[1]) == "" {
+			return fmt.Errorf("%w: %s is required", ErrOperatorInvalid, field[0])
+		}
+	}
+	if !countryPattern.MatchString(request.Country) {
+		return fmt.Errorf("%w: country must be an ISO 3166 alpha-2 code", ErrOperatorInvalid)
+	}
+	if request.VatRate < 0 || request.VatRate > 10000 {
+		return fmt.Errorf("%w: vat_rate must be between 0 and 10000 basis points", ErrOperatorInvalid)
+	}
+	zones := map[int64]bool{}
+	for _, zoneId := range request.ZoneIds {
+		if zones[zoneId] {
+			return fmt.Errorf("%w: zone_ids must be distinct", ErrOperatorInvalid)
+		}
+		zones[zoneId] = true
+	}
+	return nil
+}
+
+// assignZones makes zoneIds the zones of an operator. Zones of another
+// operator are not taken from it.
+func (d *OperatorDao) assignZones(c context.Context, operatorId int64, zoneIds []int64) error {
+	if zoneIds == nil {
+		zoneIds = []int64{}
+	}
+
+	query := "UPDATE zones SET operator_id = NULL WHERE operator_id = $1 AND NOT id = ANY($2)"
+	if _, err := d.db.Exec(c, query, operatorId, zoneIds); err != nil {
+		return fmt.Errorf("failed to release operator zones: %w", err)
+	}
+
+	query = "UPDATE zones SET operator_id = $1 WHERE id = ANY($2) AND (operator_id IS NULL OR operator_id = $1)"
+	result, err := d.db.Exec(c, query, operatorId, zoneIds)
+	if err != nil {
+		return fmt.Errorf("failed to assign operator zones: %w", err)
+	}
+	if int(result.RowsAffected()) == len(zoneIds) {
+		return nil
+	}
+
+	// Tell missing zones from zones of other operators
+	query = "SELECT COUNT(*) FROM zones WHERE id = ANY($1)"
+	var found int
+	if err := d.db.QueryRow(c, query, zoneIds).Scan(&found); err != nil {
+		return fmt.Errorf("failed to check operator zones: %w", err)
+	}
+	if found != len(zoneIds) {
+		return ErrZoneNotFound
+	}
+	return ErrOperatorZoneTaken
+}
+
+// CreateOperator adds an operator with its zones
+func (d *OperatorDao) CreateOperator(c context.Context, request api.OperatorRequest) (*api.Operator, error) {
+	if err := validateOperator(request); err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err := d.db.WithTx(c, func(c context.Context) error {
+		query := "INSERT INTO operators (name, address, country, vat_number, vat_rate, invoice_prefix) VALUES ($1, $2, $3, $4, $5, COALESCE($6, '')) RETURNING id"
+		if err := d.db.QueryRow(c, query, request.Name, request.Address, request.Country, request.VatNumber, request.VatRate, request.InvoicePrefix).Scan(&id); err != nil {
+			return fmt.Errorf("failed to add operator: %w", err)
+		}
+		return d.assignZones(c, id, request.ZoneIds)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.GetOperatorById(c, id)
+}
+
+// UpdateOperator replaces the details and zones of an operator. Invoices
+// already issued keep the details they were issued with.
+func (d *OperatorDao) UpdateOperator(c context.Context, id int64, request api.OperatorRequest) (*api.Operator, error) {
+	if err := validateOperator(request); err != nil {
+		return nil, err
+	}
+
+	err := d.db.WithTx(c, func(c context.Context) error {
+		query := "UPDATE operators SET name = $2, address = $3, country = $4, vat_number = $5, vat_rate = $6, invoice_prefix = COALESCE($7, invoice_prefix) WHERE id = $1"
+		result, err := d.db.Exec(c, query, id, request.Name, request.Address, request.Country, request.VatNumber, request.VatRate, request.InvoicePrefix)
+		if err != nil {
+			return fmt.Errorf("failed to update operator: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrOperatorNotFound
+		}
+		return d.assignZones(c, id, request.ZoneIds)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.GetOperatorById(c, id)
+}
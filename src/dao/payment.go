@@ -0,0 +1,499 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/logger"
+	"OPP/backend/money"
+	"OPP/backend/payments"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Things that can be paid
+const (
+	PaymentTargetTicket  = "ticket"
+	PaymentTargetFine    = "fine"
+	PaymentTargetSession = "session"
+	PaymentTargetPermit  = "permit"
+	// Billing periods of subscriptions
+	PaymentTargetSubscriptionPeriod = "subscription_period"
+	PaymentTargetWalletTopUp        = "wallet_topup"
+)
+
+var (
+	ErrPaymentNotFound   = errors.New("payment not found")
+	ErrPaymentNotOwned   = errors.New("payment not owned by user")
+	ErrPaymentNotPending = errors.New("payment is not pending")
+	ErrPaymentRequired   = errors.New("no confirmed payment covers the amount due")
+	ErrNothingToPay      = errors.New("nothing to pay")
+)
+
+type PaymentDao struct {
+	db       db.DB
+	provider payments.Provider
+}
+
+func NewPaymentDao() *PaymentDao {
+	return &PaymentDao{
+		db:       *db.GetDB(),
+		provider: payments.GetProvider(),
+	}
+}
+
+// Reserved payments have no provider reference yet, they read as ""
+const paymentColumns = "id, target_type, target_id, amount, currency, provider, COALESCE(provider_ref, ''), status, creation_time, updated_at"
+
+func scanPayment(row pgx.Row) (*api.Payment, error) {
+	var payment api.Payment
+	if err := row.Scan(&payment.Id, &payment.TargetType, &payment.TargetId, &payment.Amount.Amount, &payment.Amount.Currency, &payment.Provider, &payment.ProviderRef, &payment.Status, &payment.CreationTime, &payment.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// confirmedPaymentsQuery sums the confirmed payments of a target, $1 being
+// the target type and $2 its id
+const confirmedPaymentsQuery = "SELECT COALESCE(SUM(amount), 0) FROM payments WHERE target_type = $1 AND target_id = $2 AND status = 'confirmed'"
+
+// amountDue returns what is left to pay on a target: its price minus its
+// confirmed payments
+func (d *PaymentDao) amountDue(c context.Context, targetType string, targetId int64) (money.Money, error) {
+	var query string
+	var notFound error
+	switch targetType {
+	case PaymentTargetTicket:
+		// Fully refunded tickets have ended
+		query = "SELECT price, currency, refund_status FROM tickets WHERE id = $2"
+		notFound = ErrTicketNotFound
+	case PaymentTargetFine:
+		// Appealed and cancelled fines are frozen
+		query = "SELECT amount, currency, status FROM fines WHERE id = $2"
+		notFound = ErrFineNotFound
+	case PaymentTargetSession:
+		// Active sessions have no price yet, settled ones are already paid
+		query = "SELECT COALESCE(price, 0), currency, status FROM parking_sessions WHERE id = $2"
+		notFound = ErrSessionNotFound
+	case PaymentTargetPermit:
+		// Permits are paid once approved
+		query = "SELECT price, currency, status FROM permits WHERE id = $2"
+		notFound = ErrPermitNotFound
+	case PaymentTargetSubscriptionPeriod:
+		query = "SELECT price, currency, status FROM subscription_periods WHERE id = $2"
+		notFound = ErrSubscriptionNotFound
+	case PaymentTargetWalletTopUp:
+		query = "SELECT amount, currency, status FROM wallet_topups WHERE id = $2"
+		notFound = ErrWalletTopUpNotFound
+	default:
+		return money.Money{}, fmt.Errorf("unknown payment target %q", targetType)
+	}
+	query = "SELECT t.*, (" + confirmedPaymentsQuery + ") FROM (" + query + ") AS t"
+
+	var price, paid int64
+	var currency string
+	var status string
+	if err := d.db.QueryRow(c, query, targetType, targetId).Scan(&price, &currency, &status, &paid); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return money.Money{}, notFound
+		}
+		return money.Money{}, fmt.Errorf("failed to get amount due: %w", err)
+	}
+	switch targetType {
+	case PaymentTargetTicket:
+		if status == TicketRefundStatusRefunded {
+			return money.Money{}, ErrNothingToPay
+		}
+	case PaymentTargetSession:
+		if status != SessionStatusStopped {
+			return money.Money{}, ErrSessionNotStopped
+		}
+	case PaymentTargetFine:
+		if err := finePayable(status); err != nil {
+			return money.Money{}, err
+		}
+	case PaymentTargetPermit:
+		if status != PermitStatusApproved {
+			return money.Money{}, ErrPermitNotApproved
+		}
+	case PaymentTargetSubscriptionPeriod:
+		if status != PeriodStatusOpen {
+			return money.Money{}, ErrNothingToPay
+		}
+	case PaymentTargetWalletTopUp:
+		if status != WalletTopUpStatusPending {
+			return money.Money{}, ErrNothingToPay
+		}
+	}
+	if price-paid <= 0 {
+		return money.Money{}, ErrNothingToPay
+	}
+
+	return money.New(price-paid, currency), nil
+}
+
+// settle marks a target paid when its confirmed payments cover its price.
+// It returns false when they do not or the target was already paid.
+func (d *PaymentDao) settle(c context.Context, targetType string, targetId int64) (bool, error) {
+	var query string
+	switch targetType {
+	case PaymentTargetTicket:
+		// Paying an extension of a paid ticket extends its paid time
+		query = "UPDATE tickets SET paid = TRUE, paid_until = end_date WHERE id = $2 AND NOT (" + ticketSettledCondition + ") AND price <= (" + confirmedPaymentsQuery + ")"
+	case PaymentTargetFine:
+		query = "UPDATE fines SET paid = TRUE WHERE id = $2 AND paid = FALSE AND status = 'open' AND amount <= (" + confirmedPaymentsQuery + ")"
+	case PaymentTargetSession:
+		query = "UPDATE parking_sessions SET status = 'settled' WHERE id = $2 AND status = 'stopped' AND price <= (" + confirmedPaymentsQuery + ")"
+	case PaymentTargetPermit:
+		query = "UPDATE permits SET paid = TRUE WHERE id = $2 AND paid = FALSE AND status = 'approved' AND price <= (" + confirmedPaymentsQuery + ")"
+	case PaymentTargetSubscriptionPeriod:
+		// The paid period becomes the current period of the subscription
+		query = `
+			WITH period AS (
+				UPDATE subscription_periods SET status = 'paid', paid_at = NOW(), next_attempt_at = NULL
+				WHERE id = $2 AND status = 'open' AND price <= (` + confirmedPaymentsQuery + `)
+				RETURNING subscription_id, period_start, period_end
+			)
+			UPDATE subscriptions AS s
+			SET status = 'active', current_period_start = period.period_start, current_period_end = period.period_end, grace_until = NULL
+			FROM period
+			WHERE s.id = period.subscription_id
+		`
+	case PaymentTargetWalletTopUp:
+		// Crediting the wallet also writes the ledger
+		return NewWalletDao().creditTopUp(c, targetId)
+	default:
+		return false, fmt.Errorf("unknown payment target %q", targetType)
+	}
+
+	result, err := d.db.Exec(c, query, targetType, targetId)
+	if err != nil {
+		return false, fmt.Errorf("failed to settle %s: %w", targetType, err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// checkProvider fails with payments.ErrNoProvider when payments are
+// disabled
+func (d *PaymentDao) checkProvider() error {
+	if d.provider == nil {
+		return payments.ErrNoProvider
+	}
+	return nil
+}
+
+// CreatePayment opens a payment with the provider for what is left to pay
+// on a target. A pending payment of the user for that amount is returned
+// again instead, so repeated or concurrent calls open a single intent.
+func (d *PaymentDao) CreatePayment(c context.Context, username string, targetType string, targetId int64) (*api.Payment, error) {
+	if err := d.checkProvider(); err != nil {
+		return nil, err
+	}
+
+	// Fines are charged the amount of their current stage
+	if targetType == PaymentTargetFine {
+		if err := NewFineDao().advanceFine(c, targetId); err != nil {
+			return nil, err
+		}
+	}
+
+	// The payment is reserved under the lock of its target, and opened with
+	// the provider once the lock is released
+	var payment *api.Payment
+	var clientSecret *string
+	var due money.Money
+	err := d.db.WithTx(c, func(c context.Context) error {
+		// Payments of the same target are reserved one at a time
+		if _, err := d.db.Exec(c, "SELECT pg_advisory_xact_lock(hashtext($1), $2)", targetType, targetId); err != nil {
+			return fmt.Errorf("failed to lock payment target: %w", err)
+		}
+
+		var err error
+		due, err = d.amountDue(c, targetType, targetId)
+		if err != nil {
+			return err
+		}
+
+		query := "SELECT " + paymentColumns + ", client_secret FROM payments WHERE target_type = $1 AND target_id = $2 AND username = $3 AND status = 'pending' AND amount = $4 AND currency = $5 ORDER BY id DESC LIMIT 1"
+		payment, clientSecret, err = scanPendingPayment(d.db.QueryRow(c, query, targetType, targetId, username, due.Amount, due.Currency))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to get pending payment: %w", err)
+		}
+
+		query = "INSERT INTO payments (target_type, target_id, username, amount, currency, provider, status) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING " + paymentColumns
+		payment, err = scanPayment(d.db.QueryRow(c, query, targetType, targetId, username, due.Amount, due.Currency, d.provider.Name(), payments.StatusPending))
+		if err != nil {
+			return fmt.Errorf("failed to reserve payment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Opened already, or reserved by a call that did not reach the provider
+	if payment.ProviderRef != "" {
+		payment.ClientSecret = clientSecret
+		return payment, nil
+	}
+	return d.openPayment(c, payment, due)
+}
+
+// scanPendingPayment scans a payment followed by its client secret
+func scanPendingPayment(row pgx.Row) (*api.Payment, *string, error) {
+	var payment api.Payment
+	var clientSecret *string
+	if err := row.Scan(&payment.Id, &payment.TargetType, &payment.TargetId, &payment.Amount.Amount, &payment.Amount.Currency, &payment.Provider, &payment.ProviderRef, &payment.Status, &payment.CreationTime, &payment.UpdatedAt, &clientSecret); err != nil {
+		return nil, nil, err
+	}
+	return &payment, clientSecret, nil
+}
+
+// openPayment creates the provider intent of a reserved payment. When a
+// concurrent call opened it first, the intent created here is cancelled and
+// the one stored is returned.
+func (d *PaymentDao) openPayment(c context.Context, payment *api.Payment, due money.Money) (*api.Payment, error) {
+	intent, err := d.provider.CreateIntent(c, due, fmt.Sprintf("OPP %s %d", payment.TargetType, payment.TargetId))
+	if err != nil {
+		// Later calls reserve a new payment rather than retry this one
+		if _, failErr := d.db.Exec(c, "UPDATE payments SET status = 'failed', updated_at = NOW() WHERE id = $1 AND provider_ref IS NULL", payment.Id); failErr != nil {
+			logger.Error.Printf("failed to release reserved payment %d: %v", payment.Id, failErr)
+		}
+		return nil, fmt.Errorf("failed to create payment intent: %w", err)
+	}
+
+	query := "UPDATE payments SET provider_ref = $2, client_secret = $3, status = $4, updated_at = NOW() WHERE id = $1 AND provider_ref IS NULL RETURNING " + paymentColumns
+	opened, err := scanPayment(d.db.QueryRow(c, query, payment.Id, intent.Reference, intent.ClientSecret, intent.Status))
+	if err == nil {
+		opened.ClientSecret = &intent.ClientSecret
+		return opened, nil
+	}
+
+	if cancelErr := d.provider.Cancel(c, intent.Reference); cancelErr != nil {
+		logger.Error.Printf("failed to cancel payment intent %s: %v", intent.Reference, cancelErr)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to store payment intent: %w", err)
+	}
+
+	query = "SELECT " + paymentColumns + ", client_secret FROM payments WHERE id = $1"
+	opened, clientSecret, err := scanPendingPayment(d.db.QueryRow(c, query, payment.Id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	opened.ClientSecret = clientSecret
+	return opened, nil
+}
+
+// chargeOffSession charges what is left to pay on a target without the
+// customer, with the payment method they saved with the provider, for
+// recurring billing. A pending charge of the target is checked again rather
+// than charged twice.
+func (d *PaymentDao) chargeOffSession(c context.Context, username string, targetType string, targetId int64) (*api.Payment, error) {
+	if err := d.checkProvider(); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + paymentColumns + " FROM payments WHERE target_type = $1 AND target_id = $2 AND status = 'pending' AND provider_ref IS NOT NULL ORDER BY id DESC LIMIT 1"
+	payment, err := scanPayment(d.db.QueryRow(c, query, targetType, targetId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		payment, err = d.CreatePayment(c, username, targetType, targetId)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get pending payment: %w", err)
+	}
+
+	status, err := d.provider.Confirm(c, payment.ProviderRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to charge payment: %w", err)
+	}
+	return d.updateStatus(c, payment, status)
+}
+
+func (d *PaymentDao) GetPaymentById(c context.Context, id int64) (*api.Payment, error) {
+	query := "SELECT " + paymentColumns + " FROM payments WHERE id = $1"
+	payment, err := scanPayment(d.db.QueryRow(c, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	return payment, nil
+}
+
+// IsPaymentOwner tells whether username created the payment
+func (d *PaymentDao) IsPaymentOwner(c context.Context, id int64, username string) (bool, error) {
+	query := "SELECT username = $2 FROM payments WHERE id = $1"
+	var owned bool
+	if err := d.db.QueryRow(c, query, id, username).Scan(&owned); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrPaymentNotFound
+		}
+		return false, fmt.Errorf("failed to check payment ownership: %w", err)
+	}
+	return owned, nil
+}
+
+// updateStatus moves a pending payment to status, and settles its target
+// when it is confirmed. The payment is invoiced once that is committed, so
+// invoicing neither holds up nor undoes confirmations. Payments that are no
+// longer pending are left untouched, so replayed confirmations and webhooks
+// are harmless.
+func (d *PaymentDao) updateStatus(c context.Context, payment *api.Payment, status string) (*api.Payment, error) {
+	if status == payments.StatusPending || status == payment.Status {
+		return payment, nil
+	}
+
+	// The payment and its target change together
+	var updated *api.Payment
+	err := d.db.WithTx(c, func(c context.Context) error {
+		query := "UPDATE payments SET status = $2, updated_at = NOW() WHERE id = $1 AND status = 'pending' RETURNING " + paymentColumns
+		var err error
+		updated, err = scanPayment(d.db.QueryRow(c, query, payment.Id, status))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				updated = nil
+				return nil
+			}
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+
+		if updated.Status == payments.StatusConfirmed {
+			if _, err := d.settle(c, updated.TargetType, updated.TargetId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return d.GetPaymentById(c, payment.Id)
+	}
+	if updated.Status == payments.StatusConfirmed {
+		NewInvoiceDao().billPayment(c, updated.Id)
+	}
+	return updated, nil
+}
+
+// ConfirmPayment asks the provider for the outcome of a pending payment
+func (d *PaymentDao) ConfirmPayment(c context.Context, username string, id int64) (*api.Payment, error) {
+	if err := d.checkProvider(); err != nil {
+		return nil, err
+	}
+
+	payment, err := d.GetPaymentById(c, id)
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := d.IsPaymentOwner(c, id, username)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrPaymentNotOwned
+	}
+
+	// Reserved payments are not opened with the provider yet
+	if payment.Status != payments.StatusPending || payment.ProviderRef == "" {
+		return nil, ErrPaymentNotPending
+	}
+
+	status, err := d.provider.Confirm(c, payment.ProviderRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm payment: %w", err)
+	}
+
+	return d.updateStatus(c, payment, status)
+}
+
+// HandleWebhook applies a status notification of the provider
+func (d *PaymentDao) HandleWebhook(c context.Context, payload []byte, header http.Header) error {
+	if err := d.checkProvider(); err != nil {
+		return err
+	}
+
+	event, err := d.provider.VerifyWebhook(payload, header)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT " + paymentColumns + " FROM payments WHERE provider = $1 AND provider_ref = $2"
+	payment, err := scanPayment(d.db.QueryRow(c, query, d.provider.Name(), event.Reference))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrPaymentNotFound
+		}
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	_, err = d.updateStatus(c, payment, event.Status)
+	return err
+}
+
+// GetZoneRevenue sums the payments confirmed and the refunds issued in a
+// zone between from (included) and to (excluded), per currency
+func (d *PaymentDao) GetZoneRevenue(c context.Context, zoneId int64, from time.Time, to time.Time) (*api.ZoneRevenue, error) {
+	query := `
+		WITH zone_payments AS (
+			SELECT p.amount, p.currency
+			FROM payments AS p
+			LEFT JOIN tickets AS t ON p.target_type = 'ticket' AND t.id = p.target_id
+			LEFT JOIN fines AS f ON p.target_type = 'fine' AND f.id = p.target_id
+			LEFT JOIN parking_sessions AS s ON p.target_type = 'session' AND s.id = p.target_id
+			LEFT JOIN permits AS pm ON p.target_type = 'permit' AND pm.id = p.target_id
+			WHERE p.status = 'confirmed'
+				AND COALESCE(t.zone_id, f.zone_id, s.zone_id, pm.zone_id) = $1
+				AND p.updated_at >= $2 AND p.updated_at < $3
+		), zone_refunds AS (
+			SELECT r.amount, r.currency
+			FROM refunds AS r
+			JOIN tickets AS t ON t.id = r.ticket_id
+			WHERE r.status = 'confirmed'
+				AND t.zone_id = $1
+				AND r.creation_time >= $2 AND r.creation_time < $3
+		)
+		SELECT currency, SUM(paid)::BIGINT, SUM(refunded)::BIGINT
+		FROM (
+			SELECT currency, amount AS paid, 0 AS refunded FROM zone_payments
+			UNION ALL
+			SELECT currency, 0 AS paid, amount AS refunded FROM zone_refunds
+		) AS movements
+		GROUP BY currency
+		ORDER BY currency
+	`
+	rows, err := d.db.Query(c, query, zoneId, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zone revenue: %w", err)
+	}
+	defer rows.Close()
+
+	revenue := &api.ZoneRevenue{
+		ZoneId: zoneId,
+		From:   from,
+		To:     to,
+		Totals: []api.RevenueTotal{},
+	}
+	for rows.Next() {
+		var total api.RevenueTotal
+		if err := rows.Scan(&total.Currency, &total.Payments, &total.Refunds); err != nil {
+			return nil, fmt.Errorf("failed to scan zone revenue: %w", err)
+		}
+		total.Net = total.Payments - total.Refunds
+		revenue.Totals = append(revenue.Totals, total)
+	}
+
+	return revenue, nil
+}
@@ -0,0 +1,471 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Permit states, an approved permit is valid parking once paid, between
+// its valid_from and valid_until
+const (
+	PermitStatusPending   = "pending"
+	PermitStatusApproved  = "approved"
+	PermitStatusRejected  = "rejected"
+	PermitStatusCancelled = "cancelled"
+)
+
+// Permit kinds
+const (
+	PermitKindResidential = "residential"
+	PermitKindBusiness    = "business"
+)
+
+var (
+	ErrPermitTypeNotFound   = errors.New("permit type not found")
+	ErrPermitTypeInvalid    = errors.New("invalid permit type")
+	ErrPermitNotFound       = errors.New("permit not found")
+	ErrPermitInvalid        = errors.New("invalid permit application")
+	ErrPermitNotEligible    = errors.New("not eligible for this permit")
+	ErrPermitNotOwned       = errors.New("permit not owned by user")
+	ErrPermitNotPending     = errors.New("permit is not pending")
+	ErrPermitNotApproved    = errors.New("permit is not approved")
+	ErrPermitDecision       = errors.New("invalid permit decision")
+	ErrPermitRenewalClosed  = errors.New("permit renewal is not open")
+	ErrPermitAlreadyRenewed = errors.New("permit already has a renewal")
+	ErrPermitNotCancellable = errors.New("permit cannot be cancelled")
+)
+
+// Permit defaults
+const (
+	defaultPermitMaxPlates   = 1
+	defaultPermitRenewalDays = 30
+)
+
+// validPermitCondition selects the permits of alias p that are valid parking
+// at some point: approved and paid
+const validPermitCondition = "p.status = 'approved' AND p.paid = TRUE"
+
+// validParkingQuery lists what may park now as ticket rows: the paid tickets
+// whose paid time has not ended, with their permit_id the plates of the valid permits
+// that have not ended and, with their session_id, the active sessions, which
+// are billed when stopped
+const validParkingQuery = `
+	SELECT id, plate, start_date, end_date, price, currency, refunded_amount, refund_status, paid, creation_time, zone_id, paid_until, NULL::BIGINT AS permit_id, NULL::BIGINT AS session_id
+	FROM tickets
+	WHERE paid = TRUE AND paid_until >= NOW()
+	UNION ALL
+	SELECT 0, pp.plate, p.valid_from, p.valid_until, p.price, p.currency, 0, 'none', TRUE, p.creation_time, p.zone_id, p.valid_until, p.id, NULL
+	FROM permits AS p
+	JOIN permit_plates AS pp ON pp.permit_id = p.id
+	WHERE ` + validPermitCondition + ` AND p.valid_until >= NOW()
+	UNION ALL
+	SELECT 0, s.plate, s.start_date, s.max_end_date, 0, s.currency, 0, 'none', FALSE, s.creation_time, s.zone_id, NULL, NULL, s.id
+	FROM parking_sessions AS s
+	WHERE s.status = 'active' AND s.max_end_date >= NOW()
+`
+
+type PermitDao struct {
+	db db.DB
+}
+
+func NewPermitDao() *PermitDao {
+	return &PermitDao{
+		db: *db.GetDB(),
+	}
+}
+
+const permitTypeColumns = "id, zone_id, name, kind, price, currency, validity_days, max_plates, max_per_user, requires_proof, renewal_days, active"
+
+func scanPermitType(row pgx.Row) (*api.PermitType, error) {
+	var permitType api.PermitType
+	if err := row.Scan(&permitType.Id, &permitType.ZoneId, &permitType.Name, &permitType.Kind, &permitType.Price.Amount, &permitType.Price.Currency, &permitType.ValidityDays, &permitType.MaxPlates, &permitType.MaxPerUser, &permitType.RequiresProof, &permitType.RenewalDays, &permitType.Active); err != nil {
+		return nil, err
+	}
+	return &permitType, nil
+}
+
+// GetZonePermitTypes returns the permit types of a zone
+func (d *PermitDao) GetZonePermitTypes(c context.Context, zoneId int64) ([]api.PermitType, error) {
+	exists, err := NewZoneDao().ZoneExists(c, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check zone existence: %w", err)
+	}
+	if !exists {
+		return nil, ErrZoneNotFound
+	}
+
+	rows, err := d.db.Query(c, "SELECT "+permitTypeColumns+" FROM permit_types WHERE zone_id = $1 ORDER BY id", zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permit types: %w", err)
+	}
+	defer rows.Close()
+
+	permitTypes := []api.PermitType{}
+	for rows.Next() {
+		permitType, err := scanPermitType(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan permit type: %w", err)
+		}
+		permitTypes = append(permitTypes, *permitType)
+	}
+	return permitTypes, nil
+}
+
+func (d *PermitDao) GetPermitTypeById(c context.Context, id int64) (*api.PermitType, error) {
+	permitType, err := scanPermitType(d.db.QueryRow(c, "SELECT "+permitTypeColumns+" FROM permit_types WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPermitTypeNotFound
+		}
+		return nil, fmt.Errorf("failed to get permit type: %w", err)
+	}
+	return permitType, nil
+}
+
+// permitTypeValues checks a permit type request and fills in its defaults
+func permitTypeValues(request api.PermitTypeRequest) (maxPlates int, requiresProof bool, renewalDays int, active bool, err error) {
+	maxPlates, requiresProof, renewalDays, active = defaultPermitMaxPlates, true, defaultPermitRenewalDays, true
+	if request.MaxPlates != nil {
+		maxPlates = *request.MaxPlates
+	}
+	if request.RequiresProof != nil {
+		requiresProof = *request.RequiresProof
+	}
+	if request.RenewalDays != nil {
+		renewalDays = *request.RenewalDays
+	}
+	if request.Active != nil {
+		active = *request.Active
+	}
+
+	switch {
+	case strings.TrimSpace(request.Name) == "":
+		err = fmt.Errorf("%w: name is required", ErrPermitTypeInvalid)
+	case request.Kind != PermitKindResidential && request.Kind != PermitKindBusiness:
+		err = fmt.Errorf("%w: kind must be residential or business", ErrPermitTypeInvalid)
+	case request.Price < 0:
+		err = fmt.Errorf("%w: price cannot be negative", ErrPermitTypeInvalid)
+	case request.ValidityDays <= 0:
+		err = fmt.Errorf("%w: validity_days must be positive", ErrPermitTypeInvalid)
+	case maxPlates <= 0:
+		err = fmt.Errorf("%w: max_plates must be positive", ErrPermitTypeInvalid)
+	case request.MaxPerUser != nil && *request.MaxPerUser <= 0:
+		err = fmt.Errorf("%w: max_per_user must be positive", ErrPermitTypeInvalid)
+	case renewalDays < 0 || renewalDays > request.ValidityDays:
+		err = fmt.Errorf("%w: renewal_days must be between 0 and validity_days", ErrPermitTypeInvalid)
+	}
+	return
+}
+
+// CreateZonePermitType adds a permit type to a zone, priced in the zone
+// currency
+func (d *PermitDao) CreateZonePermitType(c context.Context, zoneId int64, request api.PermitTypeRequest) (*api.PermitType, error) {
+	maxPlates, requiresProof, renewalDays, active, err := permitTypeValues(request)
+	if err != nil {
+		return nil, err
+	}
+	zone, err := NewZoneDao().GetZoneById(c, zoneId)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO permit_types (zone_id, name, kind, price, currency, validity_days, max_plates, max_per_user, requires_proof, renewal_days, active) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING " + permitTypeColumns
+	permitType, err := scanPermitType(d.db.QueryRow(c, query, zoneId, request.Name, request.Kind, request.Price, zone.Currency, request.ValidityDays, maxPlates, request.MaxPerUser, requiresProof, renewalDays, active))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add permit type: %w", err)
+	}
+	return permitType, nil
+}
+
+// UpdatePermitType replaces the terms of a permit type. Permits already
+// applied for keep the price and validity they were applied with.
+func (d *PermitDao) UpdatePermitType(c context.Context, id int64, request api.PermitTypeRequest) (*api.PermitType, error) {
+	maxPlates, requiresProof, renewalDays, active, err := permitTypeValues(request)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE permit_types SET name = $2, kind = $3, price = $4, validity_days = $5, max_plates = $6, max_per_user = $7, requires_proof = $8, renewal_days = $9, active = $10 WHERE id = $1 RETURNING " + permitTypeColumns
+	permitType, err := scanPermitType(d.db.QueryRow(c, query, id, request.Name, request.Kind, request.Price, request.ValidityDays, maxPlates, request.MaxPerUser, requiresProof, renewalDays, active))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPermitTypeNotFound
+		}
+		return nil, fmt.Errorf("failed to update permit type: %w", err)
+	}
+	return permitType, nil
+}
+
+const permitColumns = `p.id, p.permit_type_id, p.zone_id, t.kind, p.username,
+	ARRAY(SELECT plate FROM permit_plates WHERE permit_id = p.id ORDER BY plate),
+	p.status, p.price, p.currency, p.paid, p.proof, p.start_date, p.valid_from, p.valid_until,
+	p.renews_permit_id, p.decided_by, p.decision_reason, p.decided_at, p.creation_time`
+
+const permitSource = "permits AS p JOIN permit_types AS t ON t.id = p.permit_type_id"
+
+func (d *PermitDao) queryPermits(c context.Context, query string, args ...any) ([]api.Permit, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permits: %w", err)
+	}
+	defer rows.Close()
+
+	permits := []api.Permit{}
+	for rows.Next() {
+		var permit api.Permit
+		if err := rows.Scan(&permit.Id, &permit.PermitTypeId, &permit.ZoneId, &permit.Kind, &permit.Username, &permit.Plates, &permit.Status, &permit.Price.Amount, &permit.Price.Currency, &permit.Paid, &permit.Proof, &permit.StartDate, &permit.ValidFrom, &permit.ValidUntil, &permit.RenewsPermitId, &permit.DecidedBy, &permit.DecisionReason, &permit.DecidedAt, &permit.CreationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan permit: %w", err)
+		}
+		permits = append(permits, permit)
+	}
+	return permits, nil
+}
+
+func (d *PermitDao) GetPermitById(c context.Context, id int64) (*api.Permit, error) {
+	permits, err := d.queryPermits(c, "SELECT "+permitColumns+" FROM "+permitSource+" WHERE p.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(permits) == 0 {
+		return nil, ErrPermitNotFound
+	}
+	return &permits[0], nil
+}
+
+// GetZonePermits returns the permits of a zone, newest first, optionally
+// only those in a status
+func (d *PermitDao) GetZonePermits(c context.Context, zoneId int64, status *string) ([]api.Permit, error) {
+	query := "SELECT " + permitColumns + " FROM " + permitSource + " WHERE p.zone_id = $1"
+	args := []any{zoneId}
+	if status != nil {
+		query += " AND p.status = $2"
+		args = append(args, *status)
+	}
+	query += " ORDER BY p.id DESC"
+	return d.queryPermits(c, query, args...)
+}
+
+// GetUserPermits returns the permits a user applied for, newest first
+func (d *PermitDao) GetUserPermits(c context.Context, username string) ([]api.Permit, error) {
+	return d.queryPermits(c, "SELECT "+permitColumns+" FROM "+permitSource+" WHERE p.username = $1 ORDER BY p.id DESC", username)
+}
+
+// GetPlatePermits returns the approved and paid permits covering a plate,
+// whether they are running, ended or yet to start
+func (d *PermitDao) GetPlatePermits(c context.Context, plate string) ([]api.Permit, error) {
+	query := "SELECT " + permitColumns + " FROM " + permitSource + " WHERE " + validPermitCondition + " AND p.id IN (SELECT permit_id FROM permit_plates WHERE plate = $1) ORDER BY p.valid_from"
+	return d.queryPermits(c, query, plate)
+}
+
+// apply records a permit application once the applicant and the plates
+// are found eligible. renews is the permit a renewal follows, it does not
+// count against max_per_user.
+func (d *PermitDao) apply(c context.Context, username string, permitType *api.PermitType, plates []string, proof *string, start *time.Time, renews *int64) (int64, error) {
+	if !permitType.Active {
+		return 0, fmt.Errorf("%w: the permit type takes no applications", ErrPermitNotEligible)
+	}
+	seen := map[string]bool{}
+	for _, plate := range plates {
+		if strings.TrimSpace(plate) == "" || seen[plate] {
+			return 0, fmt.Errorf("%w: plates must be distinct and not empty", ErrPermitInvalid)
+		}
+		seen[plate] = true
+	}
+	if len(plates) == 0 || len(plates) > permitType.MaxPlates {
+		return 0, fmt.Errorf("%w: between 1 and %d plates", ErrPermitInvalid, permitType.MaxPlates)
+	}
+	if permitType.RequiresProof && (proof == nil || strings.TrimSpace(*proof) == "") {
+		return 0, fmt.Errorf("%w: a proof of residence or business is required", ErrPermitInvalid)
+	}
+
+	var id int64
+	err := d.db.WithTx(c, func(c context.Context) error {
+		// Applications of a type are serialized so that max_per_user holds
+		if _, err := d.db.Exec(c, "SELECT 1 FROM permit_types WHERE id = $1 FOR UPDATE", permitType.Id); err != nil {
+			return fmt.Errorf("failed to lock permit type: %w", err)
+		}
+
+		query := "SELECT COUNT(*) FROM cars WHERE user_id = $1 AND plate = ANY($2)"
+		var owned int
+		if err := d.db.QueryRow(c, query, username, plates).Scan(&owned); err != nil {
+			return fmt.Errorf("failed to check plates: %w", err)
+		}
+		if owned != len(plates) {
+			return fmt.Errorf("%w: the plates must be cars of the applicant", ErrPermitNotEligible)
+		}
+
+		if permitType.MaxPerUser != nil {
+			// Pending and running permits, a renewal and the permit it
+			// follows counting once
+			query := `
+				SELECT COUNT(*) FROM permits AS p
+				WHERE p.permit_type_id = $1 AND p.username = $2 AND p.id <> $3
+					AND p.status IN ('pending', 'approved') AND (p.valid_until IS NULL OR p.valid_until > NOW())
+					AND NOT EXISTS (SELECT 1 FROM permits AS r WHERE r.renews_permit_id = p.id AND r.status IN ('pending', 'approved'))
+			`
+			var renewed int64
+			if renews != nil {
+				renewed = *renews
+			}
+			var running int
+			if err := d.db.QueryRow(c, query, permitType.Id, username, renewed).Scan(&running); err != nil {
+				return fmt.Errorf("failed to count permits: %w", err)
+			}
+			if running >= *permitType.MaxPerUser {
+				return fmt.Errorf("%w: at most %d permits of this type per applicant", ErrPermitNotEligible, *permitType.MaxPerUser)
+			}
+		}
+
+		insertQuery := "INSERT INTO permits (permit_type_id, zone_id, username, price, currency, validity_days, proof, start_date, renews_permit_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id"
+		if err := d.db.QueryRow(c, insertQuery, permitType.Id, permitType.ZoneId, username, permitType.Price.Amount, permitType.Price.Currency, permitType.ValidityDays, proof, start, renews).Scan(&id); err != nil {
+			return fmt.Errorf("failed to add permit: %w", err)
+		}
+		for _, plate := range plates {
+			if _, err := d.db.Exec(c, "INSERT INTO permit_plates (permit_id, plate) VALUES ($1, $2)", id, plate); err != nil {
+				return fmt.Errorf("failed to add permit plate: %w", err)
+			}
+		}
+		return nil
+	})
+	return id, err
+}
+
+// CreateZonePermit applies for a permit of a zone, pending the approval of
+// its admins
+func (d *PermitDao) CreateZonePermit(c context.Context, username string, zoneId int64, request api.PermitRequest) (*api.Permit, error) {
+	permitType, err := d.GetPermitTypeById(c, request.PermitTypeId)
+	if err != nil {
+		return nil, err
+	}
+	if permitType.ZoneId != zoneId {
+		return nil, ErrPermitTypeNotFound
+	}
+	if request.StartDate != nil && request.StartDate.Before(time.Now().Add(-time.Minute)) {
+		return nil, fmt.Errorf("%w: start_date must not be in the past", ErrPermitInvalid)
+	}
+
+	id, err := d.apply(c, username, permitType, request.Plates, request.Proof, request.StartDate, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetPermitById(c, id)
+}
+
+// DecidePermit approves or rejects a pending permit. An approved permit is
+// valid from its requested start, or now if that has passed, for the days
+// it was applied with; free permits need no payment.
+func (d *PermitDao) DecidePermit(c context.Context, admin string, id int64, decision api.PermitDecision) (*api.Permit, error) {
+	reason := ""
+	if decision.Reason != nil {
+		reason = strings.TrimSpace(*decision.Reason)
+	}
+	switch decision.Outcome {
+	case PermitStatusApproved:
+	case PermitStatusRejected:
+		if reason == "" {
+			return nil, fmt.Errorf("%w: a reason is required to reject", ErrPermitDecision)
+		}
+	default:
+		return nil, fmt.Errorf("%w: outcome must be approved or rejected", ErrPermitDecision)
+	}
+
+	err := d.db.WithTx(c, func(c context.Context) error {
+		var status string
+		if err := d.db.QueryRow(c, "SELECT status FROM permits WHERE id = $1 FOR UPDATE", id).Scan(&status); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrPermitNotFound
+			}
+			return fmt.Errorf("failed to lock permit: %w", err)
+		}
+		if status != PermitStatusPending {
+			return ErrPermitNotPending
+		}
+
+		query := "UPDATE permits SET status = $2, decided_by = $3, decision_reason = NULLIF($4, ''), decided_at = NOW() WHERE id = $1"
+		if decision.Outcome == PermitStatusApproved {
+			query = `
+				UPDATE permits SET status = $2, decided_by = $3, decision_reason = NULLIF($4, ''), decided_at = NOW(),
+					valid_from = GREATEST(start_date, NOW()),
+					valid_until = GREATEST(start_date, NOW()) + make_interval(days => validity_days),
+					paid = paid OR price = 0
+				WHERE id = $1
+			`
+		}
+		if _, err := d.db.Exec(c, query, id, decision.Outcome, admin, reason); err != nil {
+			return fmt.Errorf("failed to decide permit: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetPermitById(c, id)
+}
+
+// RenewPermit applies for the renewal of an approved permit, on the current
+// terms of its type, once the renewal window before its end is open. The
+// renewal starts when the permit ends and goes through approval again.
+func (d *PermitDao) RenewPermit(c context.Context, username string, id int64) (*api.Permit, error) {
+	permit, err := d.GetPermitById(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if permit.Username != username {
+		return nil, ErrPermitNotOwned
+	}
+	if permit.Status != PermitStatusApproved {
+		return nil, ErrPermitNotApproved
+	}
+	permitType, err := d.GetPermitTypeById(c, permit.PermitTypeId)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Before(permit.ValidUntil.AddDate(0, 0, -permitType.RenewalDays)) {
+		return nil, fmt.Errorf("%w: it opens %d days before the end of the permit", ErrPermitRenewalClosed, permitType.RenewalDays)
+	}
+
+	query := "SELECT EXISTS (SELECT 1 FROM permits WHERE renews_permit_id = $1 AND status IN ('pending', 'approved'))"
+	var renewed bool
+	if err := d.db.QueryRow(c, query, id).Scan(&renewed); err != nil {
+		return nil, fmt.Errorf("failed to check permit renewals: %w", err)
+	}
+	if renewed {
+		return nil, ErrPermitAlreadyRenewed
+	}
+
+	renewalId, err := d.apply(c, username, permitType, permit.Plates, permit.Proof, permit.ValidUntil, &id)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetPermitById(c, renewalId)
+}
+
+// CancelPermit withdraws a pending application or ends an approved permit
+// at once
+func (d *PermitDao) CancelPermit(c context.Context, id int64) (*api.Permit, error) {
+	query := `
+		UPDATE permits SET status = 'cancelled',
+			valid_until = CASE WHEN status = 'approved' THEN LEAST(valid_until, GREATEST(valid_from, NOW())) END
+		WHERE id = $1 AND status IN ('pending', 'approved')
+	`
+	result, err := d.db.Exec(c, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel permit: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		if _, err := d.GetPermitById(c, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrPermitNotCancellable
+	}
+
+	return d.GetPermitById(c, id)
+}
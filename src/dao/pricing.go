@@ -0,0 +1,144 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/money"
+	"OPP/backend/pricing"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+type PricingDao struct {
+	db db.DB
+}
+
+func NewPricingDao() *PricingDao {
+	return &PricingDao{
+		db: *db.GetDB(),
+	}
+}
+
+func zoneRounding(zone *api.ZoneResponse) money.Rounding {
+	return money.Rounding{Mode: zone.Rounding, Increment: int64(zone.RoundingIncrement)}
+}
+
+// atPriceLevel returns a copy of a zone priced at another level, for the
+// tickets and sessions priced before its level changed
+func atPriceLevel(zone *api.ZoneResponse, level int) *api.ZoneResponse {
+	priced := *zone
+	priced.PriceLevel = level
+	return &priced
+}
+
+func zoneFormula(zone *api.ZoneResponse) pricing.Formula {
+	return pricing.Formula{
+		Offset:   zone.PriceOffset,
+		Linear:   zone.PriceLin,
+		Exponent: zone.PriceExp,
+		Currency: zone.Currency,
+		Rounding: zoneRounding(zone),
+	}
+}
+
+// priceZoneStay prices parking `minutes` minutes from `start` in a zone,
+// with the zone tariff when it has one and with its price formula otherwise,
+// at the price level of the zone. It is the single pricing path shared by
+// tickets, sessions and quotes.
+func priceZoneStay(c context.Context, zone *api.ZoneResponse, start time.Time, minutes int) (*pricing.Breakdown, error) {
+	tariff, err := NewTariffDao().GetZonePricingTariff(c, zone)
+	if err != nil {
+		if errors.Is(err, ErrTariffNotFound) {
+			breakdown := zoneFormula(zone).AtLevel(zone.PriceLevel).Price(minutes)
+			return &breakdown, nil
+		}
+		return nil, fmt.Errorf("failed to get zone tariff: %w", err)
+	}
+
+	breakdown := pricing.TariffBreakdown(tariff.AtLevel(zone.PriceLevel).Price(start, start.Add(time.Duration(minutes)*time.Minute)), zone.Currency)
+	return &breakdown, nil
+}
+
+// QuoteZone prices parking `minutes` minutes from `start` in a zone without
+// writing anything, with the discounts a ticket would get. When a plate is
+// given the car must exist, as it must to buy a ticket, and the discounts
+// for the car apply.
+func (d *PricingDao) QuoteZone(c context.Context, zoneId int64, start time.Time, minutes int, plate *string, code *string) (*api.PriceQuote, error) {
+	zone, err := NewZoneDao().GetZoneById(c, zoneId)
+	if err != nil {
+		return nil, err
+	}
+
+	discountDao := NewDiscountDao()
+	var car *discountCar
+	if plate != nil {
+		if car, err = discountDao.getDiscountCar(c, *plate); err != nil {
+			return nil, err
+		}
+	}
+
+	breakdown, _, err := discountDao.priceDiscountedStay(c, zone, start, minutes, car, code, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return quoteFromBreakdown(zoneId, start, minutes, plate, breakdown), nil
+}
+
+func quoteFromBreakdown(zoneId int64, start time.Time, minutes int, plate *string, breakdown *pricing.Breakdown) *api.PriceQuote {
+	quote := &api.PriceQuote{
+		ZoneId:      zoneId,
+		Plate:       plate,
+		StartDate:   start,
+		EndDate:     start.Add(time.Duration(minutes) * time.Minute),
+		Duration:    minutes,
+		Currency:    breakdown.Currency,
+		Offset:      breakdown.Offset,
+		Linear:      breakdown.Linear,
+		Exponential: breakdown.Exponential,
+		Subtotal:    breakdown.Subtotal,
+		Discounts:   []api.QuoteDiscount{},
+		Price:       breakdown.Total,
+	}
+
+	for _, discount := range breakdown.Discounts {
+		quoteDiscount := api.QuoteDiscount{Name: discount.Name, Amount: discount.Amount}
+		if discount.RuleId != 0 {
+			ruleId := discount.RuleId
+			quoteDiscount.DiscountId = &ruleId
+		}
+		quote.Discounts = append(quote.Discounts, quoteDiscount)
+	}
+
+	if breakdown.Tariff != nil {
+		segments := []api.QuoteSegment{}
+		for _, s := range breakdown.Tariff.Segments {
+			segments = append(segments, api.QuoteSegment{
+				Band:        s.Band,
+				StartDate:   s.Start,
+				EndDate:     s.End,
+				RatePerHour: s.RatePerHour,
+				Amount:      int64(math.Round(s.Amount)),
+			})
+		}
+		days := []api.QuoteDay{}
+		for _, day := range breakdown.Tariff.Days {
+			date, _ := time.Parse(time.DateOnly, day.Date)
+			days = append(days, api.QuoteDay{
+				Date:     openapi_types.Date{Time: date},
+				Subtotal: day.Subtotal,
+				Charged:  day.Charged,
+				Capped:   day.Capped,
+			})
+		}
+		quote.Segments = &segments
+		quote.Days = &days
+	}
+
+	return quote
+}
@@ -0,0 +1,419 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/money"
+	"OPP/backend/payments"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Minimum number of unused minutes left on a ticket for the unused_time
+// refund policy to apply
+var REFUND_MIN_UNUSED_MINUTES = os.Getenv("REFUND_MIN_UNUSED_MINUTES")
+
+const defaultRefundMinUnusedMinutes = 30
+
+// How often the provider is asked about pending refunds
+const PendingRefundsInterval = 5 * time.Minute
+
+// Refund policies
+const (
+	RefundPolicyUnusedTime = "unused_time"
+)
+
+// Ticket refund states
+const (
+	TicketRefundStatusNone     = "none"
+	TicketRefundStatusPartial  = "partial"
+	TicketRefundStatusRefunded = "refunded"
+)
+
+var (
+	ErrRefundPolicyUnknown = errors.New("unknown refund policy")
+	ErrRefundNotAllowed    = errors.New("refund not allowed by policy")
+	ErrRefundTooLarge      = errors.New("refund exceeds the refundable amount")
+	ErrNothingToRefund     = errors.New("nothing to refund")
+	ErrRefundFailed        = errors.New("refund failed with the payment provider")
+)
+
+type RefundDao struct {
+	db       db.DB
+	provider payments.Provider
+}
+
+func NewRefundDao() *RefundDao {
+	return &RefundDao{
+		db:       *db.GetDB(),
+		provider: payments.GetProvider(),
+	}
+}
+
+func refundMinUnusedMinutes() int {
+	if REFUND_MIN_UNUSED_MINUTES != "" {
+		if m, err := strconv.Atoi(REFUND_MIN_UNUSED_MINUTES); err == nil && m >= 0 {
+			return m
+		}
+	}
+	return defaultRefundMinUnusedMinutes
+}
+
+const refundColumns = "id, payment_id, ticket_id, amount, currency, reason, policy, provider_ref, status, created_by, creation_time"
+
+func scanRefund(row pgx.Row) (*api.Refund, error) {
+	var refund api.Refund
+	if err := row.Scan(&refund.Id, &refund.PaymentId, &refund.TicketId, &refund.Amount.Amount, &refund.Amount.Currency, &refund.Reason, &refund.Policy, &refund.ProviderRef, &refund.Status, &refund.CreatedBy, &refund.CreationTime); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (d *RefundDao) GetTicketRefunds(c context.Context, ticketId int64) ([]api.Refund, error) {
+	query := "SELECT " + refundColumns + " FROM refunds WHERE ticket_id = $1 ORDER BY id"
+	rows, err := d.db.Query(c, query, ticketId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query refunds: %w", err)
+	}
+	defer rows.Close()
+
+	refunds := []api.Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan refund: %w", err)
+		}
+		refunds = append(refunds, *refund)
+	}
+
+	return refunds, nil
+}
+
+// unusedTimeRefund computes the unused_time policy refund of a ticket: the
+// price of the ticket minus the price of the minutes used so far, with the
+// discounts and at the price level the ticket was bought with, and minus
+// what was already refunded. The ticket then ends now.
+func unusedTimeRefund(c context.Context, ticket *api.TicketResponse, now time.Time) (int64, error) {
+	if !ticket.EndDate.After(now) {
+		return 0, fmt.Errorf("%w: the ticket has expired", ErrRefundNotAllowed)
+	}
+	unused := ticket.EndDate.Sub(now)
+	if ticket.StartDate.After(now) {
+		unused = ticket.EndDate.Sub(ticket.StartDate)
+	}
+	minUnused := refundMinUnusedMinutes()
+	if unused < time.Duration(minUnused)*time.Minute {
+		return 0, fmt.Errorf("%w: less than %d unused minutes", ErrRefundNotAllowed, minUnused)
+	}
+
+	// A ticket that has not started yet is refunded in full
+	if !ticket.StartDate.Before(now) {
+		return max(ticket.Price.Amount-ticket.Refunded.Amount, 0), nil
+	}
+
+	zone, err := NewTicketDao().ticketZone(c, ticket)
+	if err != nil {
+		return 0, err
+	}
+	usedMinutes := billedMinutes(ticket.StartDate, now)
+	used, err := priceZoneStay(c, zone, ticket.StartDate, usedMinutes)
+	if err != nil {
+		return 0, err
+	}
+	rules, err := NewDiscountDao().ticketDiscountRules(c, ticket.Id)
+	if err != nil {
+		return 0, err
+	}
+	if err := applyDiscountRules(c, zone, ticket.StartDate, usedMinutes, used, rules); err != nil {
+		return 0, err
+	}
+	if used.Currency != ticket.Price.Currency {
+		return 0, money.ErrCurrencyMismatch
+	}
+	return max(ticket.Price.Amount-used.Total-ticket.Refunded.Amount, 0), nil
+}
+
+type refundablePayment struct {
+	id         int64
+	provider   string
+	reference  string
+	refundable money.Money
+}
+
+// refundablePayments lists the confirmed payments of a ticket with what is
+// left to refund on each, newest first. Pending refunds may still be paid
+// out and are not refundable again.
+func (d *RefundDao) refundablePayments(c context.Context, ticketId int64) ([]refundablePayment, error) {
+	query := `
+		SELECT p.id, p.provider, p.provider_ref, p.amount - COALESCE(SUM(r.amount) FILTER (WHERE r.status IN ('pending', 'confirmed')), 0), p.currency
+		FROM payments AS p
+		LEFT JOIN refunds AS r ON r.payment_id = p.id
+		WHERE p.target_type = 'ticket' AND p.target_id = $1 AND p.status = 'confirmed'
+		GROUP BY p.id
+		ORDER BY p.id DESC
+	`
+	rows, err := d.db.Query(c, query, ticketId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ticket payments: %w", err)
+	}
+	defer rows.Close()
+
+	var result []refundablePayment
+	for rows.Next() {
+		var p refundablePayment
+		if err := rows.Scan(&p.id, &p.provider, &p.reference, &p.refundable.Amount, &p.refundable.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan ticket payment: %w", err)
+		}
+		if p.refundable.Amount > 0 {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// RefundTicket gives back money paid for a ticket. With a policy the amount
+// is computed by the policy, otherwise it is request.Amount or everything
+// still refundable. The refund is split across the ticket payments, newest
+// first. Full and policy refunds, and refunds of everything that is left,
+// end the ticket now.
+func (d *RefundDao) RefundTicket(c context.Context, username string, ticketId int64, request api.RefundRequest) ([]api.Refund, error) {
+	ticket, err := NewTicketDao().GetTicketById(c, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentsLeft, err := d.refundablePayments(c, ticketId)
+	if err != nil {
+		return nil, err
+	}
+	var refundable int64
+	for _, p := range paymentsLeft {
+		refundable += p.refundable.Amount
+	}
+
+	now := time.Now()
+	endTicket := false
+	var amount int64
+	switch {
+	case request.Policy != nil:
+		if *request.Policy != RefundPolicyUnusedTime {
+			return nil, fmt.Errorf("%w: %q", ErrRefundPolicyUnknown, *request.Policy)
+		}
+		amount, err = unusedTimeRefund(c, ticket, now)
+		if err != nil {
+			return nil, err
+		}
+		// The policy never refunds more than what is left of the payments
+		if amount > refundable {
+			amount = refundable
+		}
+		endTicket = true
+	case request.Amount != nil:
+		amount = *request.Amount
+	default:
+		amount = refundable
+		endTicket = true
+	}
+
+	if amount <= 0 {
+		return nil, ErrNothingToRefund
+	}
+	if amount > refundable {
+		return nil, ErrRefundTooLarge
+	}
+
+	// What was paid is what the confirmed payments brought minus the
+	// refunds, not the price, which includes unpaid extensions
+	status := TicketRefundStatusPartial
+	if amount == refundable {
+		status = TicketRefundStatusRefunded
+		endTicket = true
+	}
+	newEndDate := ticket.EndDate
+	if endTicket && now.Before(ticket.EndDate) {
+		newEndDate = now
+		if now.Before(ticket.StartDate) {
+			newEndDate = ticket.StartDate
+		}
+	}
+
+	// Reserve the amount on the ticket first, so that concurrent refunds
+	// cannot give back more than was paid. A concurrent extension changes
+	// the ticket under the amount computed here, so it aborts the refund.
+	reserveQuery := "UPDATE tickets SET refunded_amount = refunded_amount + $2, refund_status = $3, end_date = $4, paid_until = CASE WHEN paid_until > $4 THEN $4 ELSE paid_until END WHERE id = $1 AND refunded_amount = $5 AND end_date = $6 AND price = $7"
+	result, err := d.db.Exec(c, reserveQuery, ticketId, amount, status, newEndDate, ticket.Refunded.Amount, ticket.EndDate, ticket.Price.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update ticket refund: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, ErrTicketModified
+	}
+
+	refunds := []api.Refund{}
+	remaining := amount
+	for _, p := range paymentsLeft {
+		if remaining == 0 {
+			break
+		}
+		part := min(remaining, p.refundable.Amount)
+
+		refundStatus := payments.StatusConfirmed
+		var providerRef *string
+		if p.provider == PaymentProviderWallet {
+			// Payments made from a wallet are refunded to it
+			reference, err := NewWalletDao().refundToWallet(c, p.id, ticketId, money.New(part, p.refundable.Currency))
+			if err != nil {
+				refundStatus = payments.StatusFailed
+			} else {
+				providerRef = &reference
+			}
+		} else if d.provider == nil {
+			// Payments are disabled
+			refundStatus = payments.StatusFailed
+		} else {
+			providerRefund, err := d.provider.Refund(c, p.reference, money.New(part, p.refundable.Currency))
+			if err != nil {
+				refundStatus = payments.StatusFailed
+			} else {
+				providerRef = &providerRefund.Reference
+				refundStatus = providerRefund.Status
+			}
+		}
+
+		insertQuery := "INSERT INTO refunds (payment_id, ticket_id, amount, currency, reason, policy, provider_ref, status, created_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING " + refundColumns
+		refund, err := scanRefund(d.db.QueryRow(c, insertQuery, p.id, ticketId, part, p.refundable.Currency, request.Reason, request.Policy, providerRef, refundStatus, username))
+		if err != nil {
+			return nil, fmt.Errorf("failed to add refund: %w", err)
+		}
+		refunds = append(refunds, *refund)
+
+		// Pending refunds stay reserved until the provider settles them
+		switch refundStatus {
+		case payments.StatusConfirmed:
+			remaining -= part
+			NewInvoiceDao().creditRefund(c, refund.Id)
+		case payments.StatusPending:
+			remaining -= part
+		}
+	}
+
+	// Nothing was refunded, undo the reservation
+	if remaining == amount {
+		restoreQuery := "UPDATE tickets SET refunded_amount = refunded_amount - $2, refund_status = $3, end_date = $4, paid_until = $5 WHERE id = $1"
+		if _, err := d.db.Exec(c, restoreQuery, ticketId, amount, ticket.RefundStatus, ticket.EndDate, ticket.PaidUntil); err != nil {
+			return nil, fmt.Errorf("failed to release ticket refund: %w", err)
+		}
+		return refunds, ErrRefundFailed
+	}
+
+	// Release what the provider did not refund. What went through is less
+	// than what is left of the payments, so the ticket gets back the time
+	// the reservation cut, unless it was changed since.
+	if remaining > 0 {
+		releaseQuery := `
+			UPDATE tickets SET
+				refunded_amount = refunded_amount - $2,
+				refund_status = CASE WHEN refunded_amount - $2 = 0 THEN 'none' ELSE 'partial' END,
+				end_date = CASE WHEN end_date = $3 THEN $4 ELSE end_date END,
+				paid_until = CASE WHEN end_date = $3 THEN $5 ELSE paid_until END
+			WHERE id = $1
+		`
+		if _, err := d.db.Exec(c, releaseQuery, ticketId, remaining, newEndDate, ticket.EndDate, ticket.PaidUntil); err != nil {
+			return nil, fmt.Errorf("failed to release ticket refund: %w", err)
+		}
+	}
+
+	return refunds, nil
+}
+
+// SettlePendingRefunds asks the provider for the outcome of the pending
+// refunds. Confirmed refunds get their credit note, failed ones give their
+// amount back to refund on the ticket.
+func (d *RefundDao) SettlePendingRefunds(c context.Context) error {
+	if d.provider == nil {
+		return nil
+	}
+
+	query := "SELECT id, provider_ref FROM refunds WHERE status = 'pending' AND provider_ref IS NOT NULL ORDER BY id"
+	rows, err := d.db.Query(c, query)
+	if err != nil {
+		return fmt.Errorf("failed to query pending refunds: %w", err)
+	}
+	type pendingRefund struct {
+		id        int64
+		reference string
+	}
+	var pending []pendingRefund
+	for rows.Next() {
+		var r pendingRefund
+		if err := rows.Scan(&r.id, &r.reference); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pending refund: %w", err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query pending refunds: %w", err)
+	}
+
+	var errs []error
+	for _, r := range pending {
+		status, err := d.provider.RefundStatus(c, r.reference)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get status of refund %d: %w", r.id, err))
+			continue
+		}
+		if err := d.updateRefundStatus(c, r.id, status); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// updateRefundStatus moves a pending refund to status. A failed refund
+// releases its amount on the ticket.
+func (d *RefundDao) updateRefundStatus(c context.Context, refundId int64, status string) error {
+	if status != payments.StatusConfirmed && status != payments.StatusFailed {
+		return nil
+	}
+
+	err := d.db.WithTx(c, func(c context.Context) error {
+		query := "UPDATE refunds SET status = $2 WHERE id = $1 AND status = 'pending' RETURNING ticket_id, amount"
+		var ticketId, amount int64
+		if err := d.db.QueryRow(c, query, refundId, status).Scan(&ticketId, &amount); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("failed to update refund: %w", err)
+		}
+		if status != payments.StatusFailed {
+			return nil
+		}
+
+		releaseQuery := `
+			UPDATE tickets SET
+				refunded_amount = refunded_amount - $2,
+				refund_status = CASE WHEN refunded_amount - $2 = 0 THEN 'none' ELSE 'partial' END
+			WHERE id = $1
+		`
+		if _, err := d.db.Exec(c, releaseQuery, ticketId, amount); err != nil {
+			return fmt.Errorf("failed to release ticket refund: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if status == payments.StatusConfirmed {
+		NewInvoiceDao().creditRefund(c, refundId)
+	}
+	return nil
+}
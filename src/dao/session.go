@@ -0,0 +1,306 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Maximum length of a parking session in minutes, sessions still active at
+// that point are stopped automatically
+var PARKING_SESSION_MAX_MINUTES = os.Getenv("PARKING_SESSION_MAX_MINUTES")
+
+const defaultSessionMaxMinutes = 12 * 60
+
+const (
+	SessionStatusActive  = "active"
+	SessionStatusStopped = "stopped"
+	SessionStatusSettled = "settled"
+)
+
+var (
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrSessionNotOwned      = errors.New("session not owned by user")
+	ErrSessionAlreadyActive = errors.New("an active session already exists for this car")
+	ErrSessionNotActive     = errors.New("session is not active")
+	ErrSessionNotStopped    = errors.New("session is not stopped")
+)
+
+type SessionDao struct {
+	db db.DB
+}
+
+func NewSessionDao() *SessionDao {
+	return &SessionDao{
+		db: *db.GetDB(),
+	}
+}
+
+const sessionColumns = "id, zone_id, plate, start_date, end_date, max_end_date, price, currency, status, creation_time"
+
+func scanSession(row pgx.Row) (*api.SessionResponse, error) {
+	var session api.SessionResponse
+	var price *int64
+	var currency string
+	if err := row.Scan(&session.Id, &session.ZoneId, &session.Plate, &session.StartDate, &session.EndDate, &session.MaxEndDate, &price, &currency, &session.Status, &session.CreationTime); err != nil {
+		return nil, err
+	}
+	if price != nil {
+		session.Price = &api.Money{Amount: *price, Currency: currency}
+	}
+	return &session, nil
+}
+
+func (d *SessionDao) querySessions(c context.Context, query string, args ...any) ([]api.SessionResponse, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []api.SessionResponse{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, nil
+}
+
+func sessionMaxDuration() time.Duration {
+	minutes := defaultSessionMaxMinutes
+	if PARKING_SESSION_MAX_MINUTES != "" {
+		if m, err := strconv.Atoi(PARKING_SESSION_MAX_MINUTES); err == nil && m > 0 {
+			minutes = m
+		}
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// billedMinutes rounds the parked time up to the next started minute
+func billedMinutes(start time.Time, end time.Time) int {
+	minutes := int(math.Ceil(end.Sub(start).Minutes()))
+	if minutes < 1 {
+		minutes = 1
+	}
+	return minutes
+}
+
+func (d *SessionDao) GetSessionById(c context.Context, id int64) (*api.SessionResponse, error) {
+	query := "SELECT " + sessionColumns + " FROM parking_sessions WHERE id = $1"
+	session, err := scanSession(d.db.QueryRow(c, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return session, nil
+}
+
+func (d *SessionDao) StartZoneSession(c context.Context, username string, zoneId int64, request api.SessionRequest) (*api.SessionResponse, error) {
+	// Sessions are billed to the car owner, only the owner can start them
+	query := "SELECT 1 FROM cars WHERE plate = $1 AND user_id = $2"
+	var owned int
+	if err := d.db.QueryRow(c, query, request.Plate, username).Scan(&owned); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCarNotOwned
+		}
+		return nil, fmt.Errorf("failed to check car ownership: %w", err)
+	}
+
+	now := time.Now()
+	maxEnd := now.Add(sessionMaxDuration())
+	var session *api.SessionResponse
+	// The capacity of the zone holds until the session is written
+	err := d.db.WithTx(c, func(c context.Context) error {
+		if err := NewOccupancyDao().checkZoneCapacity(c, zoneId, request.Plate, now, maxEnd); err != nil {
+			return err
+		}
+
+		// Sessions are billed in the currency and at the price level of the
+		// zone when they start
+		insertQuery := "INSERT INTO parking_sessions (zone_id, plate, start_date, max_end_date, status, creation_time, currency, price_level) SELECT $1, $2, $3, $4, $5, $6, currency, price_level FROM zones WHERE id = $1 RETURNING " + sessionColumns
+		var err error
+		session, err = scanSession(d.db.QueryRow(c, insertQuery, zoneId, request.Plate, now, maxEnd, SessionStatusActive, now))
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+				return ErrSessionAlreadyActive
+			}
+			return fmt.Errorf("failed to start session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// stopSession bills an active session up to `end` and marks it stopped
+func (d *SessionDao) stopSession(c context.Context, session *api.SessionResponse, end time.Time) (*api.SessionResponse, error) {
+	if end.After(session.MaxEndDate) {
+		end = session.MaxEndDate
+	}
+
+	zone, err := NewZoneDao().GetZoneById(c, session.ZoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zone: %w", err)
+	}
+	// The session is billed at the price level it started at
+	query := "SELECT price_level FROM parking_sessions WHERE id = $1"
+	var level int
+	if err := d.db.QueryRow(c, query, session.Id).Scan(&level); err != nil {
+		return nil, fmt.Errorf("failed to get session price level: %w", err)
+	}
+	breakdown, err := priceZoneStay(c, atPriceLevel(zone, level), session.StartDate, billedMinutes(session.StartDate, end))
+	if err != nil {
+		return nil, err
+	}
+
+	// Only an active session can be stopped, a concurrent stop is a no-op
+	query = "UPDATE parking_sessions SET status = $2, end_date = $3, price = $4, currency = $5 WHERE id = $1 AND status = $6 RETURNING " + sessionColumns
+	stopped, err := scanSession(d.db.QueryRow(c, query, session.Id, SessionStatusStopped, end, breakdown.Total, breakdown.Currency, SessionStatusActive))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSessionNotActive
+		}
+		return nil, fmt.Errorf("failed to stop session: %w", err)
+	}
+	return stopped, nil
+}
+
+func (d *SessionDao) StopSession(c context.Context, username string, id int64) (*api.SessionResponse, error) {
+	session, err := d.GetSessionById(c, id)
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := d.isSessionOwner(c, session, username)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrSessionNotOwned
+	}
+
+	if session.Status != SessionStatusActive {
+		return nil, ErrSessionNotActive
+	}
+
+	return d.stopSession(c, session, time.Now())
+}
+
+func (d *SessionDao) PaySession(c context.Context, username string, id int64) (*api.SessionResponse, error) {
+	err := d.db.WithTx(c, func(c context.Context) error {
+		// Lock the session, a concurrent payment waits and then sees it settled
+		query := "SELECT " + sessionColumns + " FROM parking_sessions WHERE id = $1 FOR UPDATE"
+		session, err := scanSession(d.db.QueryRow(c, query, id))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrSessionNotFound
+			}
+			return fmt.Errorf("failed to lock session: %w", err)
+		}
+
+		owned, err := d.isSessionOwner(c, session, username)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return ErrSessionNotOwned
+		}
+
+		if session.Status != SessionStatusStopped {
+			return ErrSessionNotStopped
+		}
+
+		// The session is only settled once its confirmed payments cover its price
+		settled, err := NewPaymentDao().settle(c, PaymentTargetSession, id)
+		if err != nil {
+			return err
+		}
+		if !settled {
+			return ErrPaymentRequired
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.GetSessionById(c, id)
+}
+
+func (d *SessionDao) isSessionOwner(c context.Context, session *api.SessionResponse, username string) (bool, error) {
+	query := "SELECT 1 FROM cars WHERE plate = $1 AND user_id = $2"
+	var owned int
+	if err := d.db.QueryRow(c, query, session.Plate, username).Scan(&owned); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check session ownership: %w", err)
+	}
+	return true, nil
+}
+
+func (d *SessionDao) GetUserSessions(c context.Context, username string, activeOnly bool) ([]api.SessionResponse, error) {
+	query := "SELECT s.id, s.zone_id, s.plate, s.start_date, s.end_date, s.max_end_date, s.price, s.currency, s.status, s.creation_time FROM parking_sessions AS s JOIN cars AS c ON s.plate = c.plate WHERE c.user_id = $1"
+	if activeOnly {
+		query += " AND s.status = 'active'"
+	}
+	query += " ORDER BY s.id DESC"
+	return d.querySessions(c, query, username)
+}
+
+func (d *SessionDao) GetCarSessions(c context.Context, plate string) ([]api.SessionResponse, error) {
+	query := "SELECT " + sessionColumns + " FROM parking_sessions WHERE plate = $1 ORDER BY id DESC"
+	return d.querySessions(c, query, plate)
+}
+
+func (d *SessionDao) GetZoneSessions(c context.Context, zoneId int64, activeOnly bool, limit *int, offset *int) ([]api.SessionResponse, error) {
+	query := "SELECT " + sessionColumns + " FROM parking_sessions WHERE zone_id = $1"
+	if activeOnly {
+		query += " AND status = 'active'"
+	}
+	query += " ORDER BY id DESC LIMIT $2 OFFSET $3"
+	limitVal := 20
+	offsetVal := 0
+	if limit != nil {
+		limitVal = *limit
+	}
+	if offset != nil {
+		offsetVal = *offset
+	}
+	return d.querySessions(c, query, zoneId, limitVal, offsetVal)
+}
+
+// AutoStopExpiredSessions stops the active sessions that reached their
+// maximum length, billing them up to max_end_date
+func (d *SessionDao) AutoStopExpiredSessions(c context.Context) error {
+	query := "SELECT " + sessionColumns + " FROM parking_sessions WHERE status = 'active' AND max_end_date <= NOW()"
+	sessions, err := d.querySessions(c, query)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, session := range sessions {
+		if _, err := d.stopSession(c, &session, session.MaxEndDate); err != nil && !errors.Is(err, ErrSessionNotActive) {
+			errs = append(errs, fmt.Errorf("failed to auto-stop session %d: %w", session.Id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
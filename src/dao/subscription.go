@@ -0,0 +1,646 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/payments"
+	"OPP/backend/pricing"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Days a subscription keeps covering after its billing period ended
+// unpaid, and a new subscription waits for its first payment
+var SUBSCRIPTION_GRACE_DAYS = os.Getenv("SUBSCRIPTION_GRACE_DAYS")
+
+// Subscription states. Pending subscriptions wait for the payment of their
+// first period; past_due ones for the payment of a renewal, and cover
+// until grace_until; unpaid ones stopped covering after the grace period
+// and can still be paid until their open period ends.
+const (
+	SubscriptionStatusPending   = "pending"
+	SubscriptionStatusActive    = "active"
+	SubscriptionStatusPastDue   = "past_due"
+	SubscriptionStatusUnpaid    = "unpaid"
+	SubscriptionStatusCancelled = "cancelled"
+	SubscriptionStatusExpired   = "expired"
+)
+
+// Billing period states
+const (
+	PeriodStatusOpen = "open"
+	PeriodStatusPaid = "paid"
+	PeriodStatusVoid = "void"
+)
+
+const (
+	defaultSubscriptionGraceDays = 7
+	defaultSubscriptionMaxPlates = 1
+	// How long a billing job owns the charge of a period
+	subscriptionChargeLease = 15 * time.Minute
+	// How often a charge the provider has not decided yet is checked again
+	subscriptionChargePoll = time.Hour
+)
+
+// Waits after the first, second and third failed charge of a period.
+// There are no automatic charges after that, the subscription becomes
+// unpaid at the end of its grace period unless the user pays.
+var subscriptionRetryDelays = []time.Duration{24 * time.Hour, 48 * time.Hour, 48 * time.Hour}
+
+var (
+	ErrSubscriptionPlanNotFound = errors.New("subscription plan not found")
+	ErrSubscriptionPlanInvalid  = errors.New("invalid subscription plan")
+	ErrSubscriptionNotFound     = errors.New("subscription not found")
+	ErrSubscriptionInvalid      = errors.New("invalid subscription")
+	ErrSubscriptionNotEligible  = errors.New("not eligible for this subscription")
+	ErrSubscriptionNotOwned     = errors.New("subscription not owned by user")
+	ErrSubscriptionEnded        = errors.New("subscription has ended")
+)
+
+type SubscriptionDao struct {
+	db db.DB
+}
+
+func NewSubscriptionDao() *SubscriptionDao {
+	return &SubscriptionDao{
+		db: *db.GetDB(),
+	}
+}
+
+func subscriptionGraceDays() int {
+	if SUBSCRIPTION_GRACE_DAYS != "" {
+		if d, err := strconv.Atoi(SUBSCRIPTION_GRACE_DAYS); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return defaultSubscriptionGraceDays
+}
+
+const subscriptionPlanColumns = "id, name, zone_ids::BIGINT[], days, start_minute, end_minute, timezone, price, currency, max_plates, active"
+
+func scanSubscriptionPlan(row pgx.Row) (*api.SubscriptionPlan, error) {
+	var plan api.SubscriptionPlan
+	var startMinute, endMinute int
+	if err := row.Scan(&plan.Id, &plan.Name, &plan.ZoneIds, &plan.Days, &startMinute, &endMinute, &plan.Timezone, &plan.Price.Amount, &plan.Price.Currency, &plan.MaxPlates, &plan.Active); err != nil {
+		return nil, err
+	}
+	plan.StartTime = pricing.FormatClock(startMinute)
+	plan.EndTime = pricing.FormatClock(endMinute)
+	return &plan, nil
+}
+
+// GetSubscriptionPlans returns the plans open to new subscriptions
+func (d *SubscriptionDao) GetSubscriptionPlans(c context.Context) ([]api.SubscriptionPlan, error) {
+	rows, err := d.db.Query(c, "SELECT "+subscriptionPlanColumns+" FROM subscription_plans WHERE active = TRUE ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscription plans: %w", err)
+	}
+	defer rows.Close()
+
+	plans := []api.SubscriptionPlan{}
+	for rows.Next() {
+		plan, err := scanSubscriptionPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan subscription plan: %w", err)
+		}
+		plans = append(plans, *plan)
+	}
+	return plans, nil
+}
+
+func (d *SubscriptionDao) GetSubscriptionPlanById(c context.Context, id int64) (*api.SubscriptionPlan, error) {
+	plan, err := scanSubscriptionPlan(d.db.QueryRow(c, "SELECT "+subscriptionPlanColumns+" FROM subscription_plans WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSubscriptionPlanNotFound
+		}
+		return nil, fmt.Errorf("failed to get subscription plan: %w", err)
+	}
+	return plan, nil
+}
+
+// subscriptionPlanRecord is a checked plan request with its defaults
+type subscriptionPlanRecord struct {
+	startMinute int
+	endMinute   int
+	currency    string
+	maxPlates   int
+	active      bool
+}
+
+// subscriptionPlanValues checks a plan request. The zones of a plan must
+// exist and share their currency, the plan is priced in it.
+func (d *SubscriptionDao) subscriptionPlanValues(c context.Context, request api.SubscriptionPlanRequest) (*subscriptionPlanRecord, error) {
+	record := &subscriptionPlanRecord{maxPlates: defaultSubscriptionMaxPlates, active: true}
+	if request.MaxPlates != nil {
+		record.maxPlates = *request.MaxPlates
+	}
+	if request.Active != nil {
+		record.active = *request.Active
+	}
+
+	if strings.TrimSpace(request.Name) == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrSubscriptionPlanInvalid)
+	}
+	if request.Price <= 0 {
+		return nil, fmt.Errorf("%w: price must be positive", ErrSubscriptionPlanInvalid)
+	}
+	if record.maxPlates <= 0 {
+		return nil, fmt.Errorf("%w: max_plates must be positive", ErrSubscriptionPlanInvalid)
+	}
+	if _, err := time.LoadLocation(request.Timezone); err != nil || request.Timezone == "" {
+		return nil, fmt.Errorf("%w: unknown timezone %q", ErrSubscriptionPlanInvalid, request.Timezone)
+	}
+	var err error
+	if record.startMinute, err = pricing.ParseClock(request.StartTime); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSubscriptionPlanInvalid, err)
+	}
+	if record.endMinute, err = pricing.ParseClock(request.EndTime); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSubscriptionPlanInvalid, err)
+	}
+	if record.endMinute <= record.startMinute {
+		return nil, fmt.Errorf("%w: end_time must be after start_time", ErrSubscriptionPlanInvalid)
+	}
+
+	days := map[int]bool{}
+	for _, day := range request.Days {
+		if day < 0 || day > 6 || days[day] {
+			return nil, fmt.Errorf("%w: days must be distinct, from 0 (Sunday) to 6", ErrSubscriptionPlanInvalid)
+		}
+		days[day] = true
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("%w: at least one day is required", ErrSubscriptionPlanInvalid)
+	}
+
+	zones := map[int64]bool{}
+	for _, zoneId := range request.ZoneIds {
+		if zones[zoneId] {
+			return nil, fmt.Errorf("%w: zone_ids must be distinct", ErrSubscriptionPlanInvalid)
+		}
+		zones[zoneId] = true
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("%w: at least one zone is required", ErrSubscriptionPlanInvalid)
+	}
+	query := "SELECT COUNT(*), COUNT(DISTINCT currency), MIN(currency) FROM zones WHERE id = ANY($1)"
+	var found, currencies int
+	var currency *string
+	if err := d.db.QueryRow(c, query, request.ZoneIds).Scan(&found, &currencies, &currency); err != nil {
+		return nil, fmt.Errorf("failed to check plan zones: %w", err)
+	}
+	if found != len(zones) {
+		return nil, ErrZoneNotFound
+	}
+	if currencies != 1 {
+		return nil, fmt.Errorf("%w: the zones must share their currency", ErrSubscriptionPlanInvalid)
+	}
+	record.currency = *currency
+
+	return record, nil
+}
+
+// CreateSubscriptionPlan adds a plan
+func (d *SubscriptionDao) CreateSubscriptionPlan(c context.Context, request api.SubscriptionPlanRequest) (*api.SubscriptionPlan, error) {
+	record, err := d.subscriptionPlanValues(c, request)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO subscription_plans (name, zone_ids, days, start_minute, end_minute, timezone, price, currency, max_plates, active) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING " + subscriptionPlanColumns
+	plan, err := scanSubscriptionPlan(d.db.QueryRow(c, query, request.Name, request.ZoneIds, request.Days, record.startMinute, record.endMinute, request.Timezone, request.Price, record.currency, record.maxPlates, record.active))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add subscription plan: %w", err)
+	}
+	return plan, nil
+}
+
+// UpdateSubscriptionPlan replaces a plan. Running subscriptions follow its
+// zones and times at once, and its price from their next billing period.
+func (d *SubscriptionDao) UpdateSubscriptionPlan(c context.Context, id int64, request api.SubscriptionPlanRequest) (*api.SubscriptionPlan, error) {
+	record, err := d.subscriptionPlanValues(c, request)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE subscription_plans SET name = $2, zone_ids = $3, days = $4, start_minute = $5, end_minute = $6, timezone = $7, price = $8, currency = $9, max_plates = $10, active = $11 WHERE id = $1 RETURNING " + subscriptionPlanColumns
+	plan, err := scanSubscriptionPlan(d.db.QueryRow(c, query, id, request.Name, request.ZoneIds, request.Days, record.startMinute, record.endMinute, request.Timezone, request.Price, record.currency, record.maxPlates, record.active))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSubscriptionPlanNotFound
+		}
+		return nil, fmt.Errorf("failed to update subscription plan: %w", err)
+	}
+	return plan, nil
+}
+
+const subscriptionColumns = `s.id, s.plan_id, s.username,
+	ARRAY(SELECT plate FROM subscription_plates WHERE subscription_id = s.id ORDER BY plate),
+	s.status, s.current_period_start, s.current_period_end, s.grace_until, s.cancel_at_period_end, s.cancelled_at, s.creation_time`
+
+const subscriptionPeriodColumns = "id, subscription_id, period_start, period_end, price, currency, status, attempts, last_attempt_at, next_attempt_at, paid_at"
+
+func (d *SubscriptionDao) queryPeriods(c context.Context, query string, args ...any) ([]api.SubscriptionPeriod, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query billing periods: %w", err)
+	}
+	defer rows.Close()
+
+	periods := []api.SubscriptionPeriod{}
+	for rows.Next() {
+		var period api.SubscriptionPeriod
+		if err := rows.Scan(&period.Id, &period.SubscriptionId, &period.PeriodStart, &period.PeriodEnd, &period.Price.Amount, &period.Price.Currency, &period.Status, &period.Attempts, &period.LastAttemptAt, &period.NextAttemptAt, &period.PaidAt); err != nil {
+			return nil, fmt.Errorf("failed to scan billing period: %w", err)
+		}
+		periods = append(periods, period)
+	}
+	return periods, nil
+}
+
+// querySubscriptions lists subscriptions with their open period
+func (d *SubscriptionDao) querySubscriptions(c context.Context, query string, args ...any) ([]api.Subscription, error) {
+	rows, err := d.db.Query(c, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []api.Subscription{}
+	var ids []int64
+	for rows.Next() {
+		var subscription api.Subscription
+		if err := rows.Scan(&subscription.Id, &subscription.PlanId, &subscription.Username, &subscription.Plates, &subscription.Status, &subscription.CurrentPeriodStart, &subscription.CurrentPeriodEnd, &subscription.GraceUntil, &subscription.CancelAtPeriodEnd, &subscription.CancelledAt, &subscription.CreationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+		ids = append(ids, subscription.Id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return subscriptions, nil
+	}
+
+	open, err := d.queryPeriods(c, "SELECT "+subscriptionPeriodColumns+" FROM subscription_periods WHERE status = 'open' AND subscription_id = ANY($1)", ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range open {
+		for j := range subscriptions {
+			if subscriptions[j].Id == open[i].SubscriptionId {
+				subscriptions[j].OpenPeriod = &open[i]
+			}
+		}
+	}
+	return subscriptions, nil
+}
+
+func (d *SubscriptionDao) GetSubscriptionById(c context.Context, id int64) (*api.Subscription, error) {
+	subscriptions, err := d.querySubscriptions(c, "SELECT "+subscriptionColumns+" FROM subscriptions AS s WHERE s.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, ErrSubscriptionNotFound
+	}
+	return &subscriptions[0], nil
+}
+
+// GetUserSubscriptions returns the subscriptions of a user, newest first
+func (d *SubscriptionDao) GetUserSubscriptions(c context.Context, username string) ([]api.Subscription, error) {
+	return d.querySubscriptions(c, "SELECT "+subscriptionColumns+" FROM subscriptions AS s WHERE s.username = $1 ORDER BY s.id DESC", username)
+}
+
+// GetSubscriptionPeriods returns the billing periods of a subscription,
+// newest first
+func (d *SubscriptionDao) GetSubscriptionPeriods(c context.Context, id int64) ([]api.SubscriptionPeriod, error) {
+	return d.queryPeriods(c, "SELECT "+subscriptionPeriodColumns+" FROM subscription_periods WHERE subscription_id = $1 ORDER BY period_start DESC, id DESC", id)
+}
+
+// GetPlateSubscriptions returns the subscriptions covering a plate that
+// were paid for and covered it within the last day
+func (d *SubscriptionDao) GetPlateSubscriptions(c context.Context, plate string) ([]api.Subscription, error) {
+	query := `
+		SELECT ` + subscriptionColumns + ` FROM subscriptions AS s
+		WHERE s.id IN (SELECT subscription_id FROM subscription_plates WHERE plate = $1)
+			AND s.current_period_end IS NOT NULL
+			AND GREATEST(s.current_period_end, s.grace_until) > NOW() - INTERVAL '1 day'
+		ORDER BY s.id
+	`
+	return d.querySubscriptions(c, query, plate)
+}
+
+// CreateSubscription subscribes a user and some of their cars to a plan.
+// The subscription is pending until its first period, starting now, is
+// paid; it expires if that does not happen within the grace period.
+func (d *SubscriptionDao) CreateSubscription(c context.Context, username string, request api.SubscriptionRequest) (*api.Subscription, error) {
+	plan, err := d.GetSubscriptionPlanById(c, request.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Active {
+		return nil, fmt.Errorf("%w: the plan takes no new subscriptions", ErrSubscriptionNotEligible)
+	}
+	seen := map[string]bool{}
+	for _, plate := range request.Plates {
+		if strings.TrimSpace(plate) == "" || seen[plate] {
+			return nil, fmt.Errorf("%w: plates must be distinct and not empty", ErrSubscriptionInvalid)
+		}
+		seen[plate] = true
+	}
+	if len(request.Plates) == 0 || len(request.Plates) > plan.MaxPlates {
+		return nil, fmt.Errorf("%w: between 1 and %d plates", ErrSubscriptionInvalid, plan.MaxPlates)
+	}
+
+	var id int64
+	err = d.db.WithTx(c, func(c context.Context) error {
+		query := "SELECT COUNT(*) FROM cars WHERE user_id = $1 AND plate = ANY($2)"
+		var owned int
+		if err := d.db.QueryRow(c, query, username, request.Plates).Scan(&owned); err != nil {
+			return fmt.Errorf("failed to check plates: %w", err)
+		}
+		if owned != len(request.Plates) {
+			return fmt.Errorf("%w: the plates must be cars of the subscriber", ErrSubscriptionNotEligible)
+		}
+
+		query = `
+			SELECT EXISTS (
+				SELECT 1 FROM subscriptions AS s JOIN subscription_plates AS sp ON sp.subscription_id = s.id
+				WHERE s.plan_id = $1 AND sp.plate = ANY($2) AND s.status IN ('pending', 'active', 'past_due')
+			)
+		`
+		var subscribed bool
+		if err := d.db.QueryRow(c, query, plan.Id, request.Plates).Scan(&subscribed); err != nil {
+			return fmt.Errorf("failed to check subscriptions: %w", err)
+		}
+		if subscribed {
+			return fmt.Errorf("%w: a plate already has a subscription to this plan", ErrSubscriptionNotEligible)
+		}
+
+		query = "INSERT INTO subscriptions (plan_id, username, grace_until) VALUES ($1, $2, NOW() + make_interval(days => $3)) RETURNING id"
+		if err := d.db.QueryRow(c, query, plan.Id, username, subscriptionGraceDays()).Scan(&id); err != nil {
+			return fmt.Errorf("failed to add subscription: %w", err)
+		}
+		for _, plate := range request.Plates {
+			if _, err := d.db.Exec(c, "INSERT INTO subscription_plates (subscription_id, plate) VALUES ($1, $2)", id, plate); err != nil {
+				return fmt.Errorf("failed to add subscription plate: %w", err)
+			}
+		}
+		// The first period is paid by the user, it is not charged automatically
+		query = "INSERT INTO subscription_periods (subscription_id, period_start, period_end, price, currency) VALUES ($1, NOW(), NOW() + INTERVAL '1 month', $2, $3)"
+		if _, err := d.db.Exec(c, query, id, plan.Price.Amount, plan.Price.Currency); err != nil {
+			return fmt.Errorf("failed to add billing period: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetSubscriptionById(c, id)
+}
+
+// GetOpenPeriodId returns the billing period of a subscription of username
+// that is waiting for a payment
+func (d *SubscriptionDao) GetOpenPeriodId(c context.Context, username string, id int64) (int64, error) {
+	subscription, err := d.GetSubscriptionById(c, id)
+	if err != nil {
+		return 0, err
+	}
+	if subscription.Username != username {
+		return 0, ErrSubscriptionNotOwned
+	}
+	if subscription.OpenPeriod == nil {
+		return 0, ErrNothingToPay
+	}
+	return subscription.OpenPeriod.Id, nil
+}
+
+// CancelSubscription stops the renewals of a subscription. An active
+// subscription keeps covering until the end of its paid period; one
+// waiting for a payment ends at once and its open period is voided.
+func (d *SubscriptionDao) CancelSubscription(c context.Context, id int64) (*api.Subscription, error) {
+	err := d.db.WithTx(c, func(c context.Context) error {
+		var status string
+		if err := d.db.QueryRow(c, "SELECT status FROM subscriptions WHERE id = $1 FOR UPDATE", id).Scan(&status); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrSubscriptionNotFound
+			}
+			return fmt.Errorf("failed to lock subscription: %w", err)
+		}
+
+		switch status {
+		case SubscriptionStatusActive:
+			query := "UPDATE subscriptions SET cancel_at_period_end = TRUE, cancelled_at = COALESCE(cancelled_at, NOW()) WHERE id = $1"
+			if _, err := d.db.Exec(c, query, id); err != nil {
+				return fmt.Errorf("failed to cancel subscription: %w", err)
+			}
+		case SubscriptionStatusPending, SubscriptionStatusPastDue, SubscriptionStatusUnpaid:
+			query := "UPDATE subscriptions SET status = 'cancelled', cancel_at_period_end = TRUE, cancelled_at = NOW(), grace_until = NULL WHERE id = $1"
+			if _, err := d.db.Exec(c, query, id); err != nil {
+				return fmt.Errorf("failed to cancel subscription: %w", err)
+			}
+			query = "UPDATE subscription_periods SET status = 'void', next_attempt_at = NULL WHERE subscription_id = $1 AND status = 'open'"
+			if _, err := d.db.Exec(c, query, id); err != nil {
+				return fmt.Errorf("failed to void billing period: %w", err)
+			}
+		default:
+			return ErrSubscriptionEnded
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetSubscriptionById(c, id)
+}
+
+// BillSubscriptions runs the billing of subscriptions: it ends the
+// cancelled ones, opens and charges the renewals of those whose period
+// ended, retries failed charges and ends the subscriptions left unpaid
+func (d *SubscriptionDao) BillSubscriptions(c context.Context) error {
+	// Cancelled subscriptions and those of retired plans are not renewed
+	query := `
+		UPDATE subscriptions SET status = 'cancelled', cancelled_at = COALESCE(cancelled_at, NOW())
+		WHERE status = 'active' AND current_period_end <= NOW()
+			AND (cancel_at_period_end OR plan_id IN (SELECT id FROM subscription_plans WHERE active = FALSE))
+	`
+	if _, err := d.db.Exec(c, query); err != nil {
+		return fmt.Errorf("failed to end cancelled subscriptions: %w", err)
+	}
+
+	// Renewals follow the paid period at the current price of the plan
+	query = `
+		WITH due AS (
+			SELECT s.id, s.current_period_end, p.price, p.currency
+			FROM subscriptions AS s JOIN subscription_plans AS p ON p.id = s.plan_id
+			WHERE s.status = 'active' AND s.current_period_end <= NOW()
+			FOR UPDATE OF s SKIP LOCKED
+		), renewals AS (
+			INSERT INTO subscription_periods (subscription_id, period_start, period_end, price, currency, next_attempt_at)
+			SELECT id, current_period_end, current_period_end + INTERVAL '1 month', price, currency, NOW() FROM due
+			RETURNING subscription_id
+		)
+		UPDATE subscriptions SET status = 'past_due', grace_until = current_period_end + make_interval(days => $1)
+		WHERE id IN (SELECT subscription_id FROM renewals)
+	`
+	if _, err := d.db.Exec(c, query, subscriptionGraceDays()); err != nil {
+		return fmt.Errorf("failed to open subscription renewals: %w", err)
+	}
+
+	if err := d.chargeDuePeriods(c); err != nil {
+		return err
+	}
+
+	// Past the grace period, pending subscriptions expire and past due ones
+	// stop covering. Unpaid subscriptions expire with their open period.
+	query = `
+		WITH ended AS (
+			UPDATE subscriptions AS s
+			SET status = CASE
+				WHEN s.status = 'pending' THEN 'expired'
+				WHEN s.status = 'past_due' AND p.period_end > NOW() THEN 'unpaid'
+				ELSE 'expired' END
+			FROM subscription_periods AS p
+			WHERE p.subscription_id = s.id AND p.status = 'open'
+				AND ((s.status IN ('pending', 'past_due') AND s.grace_until <= NOW()) OR (s.status = 'unpaid' AND p.period_end <= NOW()))
+			RETURNING s.id, s.status
+		)
+		UPDATE subscription_periods AS p
+		SET status = CASE WHEN ended.status = 'expired' THEN 'void' ELSE p.status END, next_attempt_at = NULL
+		FROM ended
+		WHERE p.subscription_id = ended.id AND p.status = 'open'
+	`
+	if _, err := d.db.Exec(c, query); err != nil {
+		return fmt.Errorf("failed to end unpaid subscriptions: %w", err)
+	}
+	return nil
+}
+
+// chargeDuePeriods charges the open periods whose automatic charge is due.
+// Each period is leased first, so that the billing jobs of the backend
+// instances never charge it twice at the same time.
+func (d *SubscriptionDao) chargeDuePeriods(c context.Context) error {
+	query := `
+		UPDATE subscription_periods AS p SET next_attempt_at = NOW() + make_interval(secs => $1)
+		FROM subscriptions AS s
+		WHERE s.id = p.subscription_id AND s.status = 'past_due' AND p.status = 'open' AND p.next_attempt_at <= NOW()
+		RETURNING p.id, s.username
+	`
+	rows, err := d.db.Query(c, query, subscriptionChargeLease.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to lease billing periods: %w", err)
+	}
+	type due struct {
+		periodId int64
+		username string
+	}
+	var periods []due
+	for rows.Next() {
+		var period due
+		if err := rows.Scan(&period.periodId, &period.username); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan billing period: %w", err)
+		}
+		periods = append(periods, period)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to lease billing periods: %w", err)
+	}
+
+	var errs []error
+	for _, period := range periods {
+		if err := d.chargePeriod(c, period.periodId, period.username); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chargePeriod charges a billing period and schedules the next attempt
+// when the charge failed
+func (d *SubscriptionDao) chargePeriod(c context.Context, periodId int64, username string) error {
+	payment, chargeErr := NewPaymentDao().chargeOffSession(c, username, PaymentTargetSubscriptionPeriod, periodId)
+	if chargeErr == nil && payment.Status == payments.StatusPending {
+		query := "UPDATE subscription_periods SET next_attempt_at = NOW() + make_interval(secs => $2) WHERE id = $1 AND status = 'open'"
+		if _, err := d.db.Exec(c, query, periodId, subscriptionChargePoll.Seconds()); err != nil {
+			return fmt.Errorf("failed to schedule billing period: %w", err)
+		}
+		return nil
+	}
+
+	// Confirmed charges settled the period, only the attempt is recorded
+	query := `
+		UPDATE subscription_periods SET attempts = attempts + 1, last_attempt_at = NOW(),
+			next_attempt_at = CASE WHEN status = 'open' AND attempts < $2 THEN NOW() + make_interval(secs => ($3::DOUBLE PRECISION[])[attempts + 1]) END
+		WHERE id = $1
+	`
+	delays := make([]float64, len(subscriptionRetryDelays))
+	for i, delay := range subscriptionRetryDelays {
+		delays[i] = delay.Seconds()
+	}
+	if _, err := d.db.Exec(c, query, periodId, len(delays), delays); err != nil {
+		return fmt.Errorf("failed to record charge attempt: %w", err)
+	}
+	if chargeErr != nil && !errors.Is(chargeErr, ErrNothingToPay) {
+		return fmt.Errorf("failed to charge billing period %d: %w", periodId, chargeErr)
+	}
+	return nil
+}
+
+// subscriptionCoveredUntil returns when a subscription stops covering: the
+// end of its paid period, or of the grace period of an unpaid renewal
+func subscriptionCoveredUntil(subscription api.Subscription) (time.Time, bool) {
+	if subscription.CurrentPeriodEnd == nil {
+		return time.Time{}, false
+	}
+	end := *subscription.CurrentPeriodEnd
+	if subscription.GraceUntil != nil && subscription.GraceUntil.After(end) {
+		end = *subscription.GraceUntil
+	}
+	return end, true
+}
+
+// subscriptionWindow returns the time window of a plan on the day of now,
+// in the plan timezone, if the plan covers that day and the window has
+// started
+func subscriptionWindow(plan *api.SubscriptionPlan, now time.Time) (time.Time, time.Time, bool) {
+	location, err := time.LoadLocation(plan.Timezone)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	startMinute, err := pricing.ParseClock(plan.StartTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	endMinute, err := pricing.ParseClock(plan.EndTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	local := now.In(location)
+	covered := false
+	for _, day := range plan.Days {
+		covered = covered || day == int(local.Weekday())
+	}
+	if !covered {
+		return time.Time{}, time.Time{}, false
+	}
+
+	year, month, day := local.Date()
+	start := time.Date(year, month, day, 0, startMinute, 0, 0, location)
+	end := time.Date(year, month, day, 0, endMinute, 0, 0, location)
+	if start.After(now) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
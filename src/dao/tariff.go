@@ -0,0 +1,338 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/money"
+	"OPP/backend/pricing"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+var (
+	ErrTariffNotFound               = errors.New("tariff not found")
+	ErrTariffInvalid                = errors.New("invalid tariff")
+	ErrHolidayCalendarNotFound      = errors.New("holiday calendar not found")
+	ErrHolidayCalendarAlreadyExists = errors.New("holiday calendar already exists")
+)
+
+type TariffDao struct {
+	db db.DB
+}
+
+func NewTariffDao() *TariffDao {
+	return &TariffDao{
+		db: *db.GetDB(),
+	}
+}
+
+func (d *TariffDao) GetZoneTariff(c context.Context, zoneId int64) (*api.ZoneTariff, error) {
+	query := "SELECT timezone, daily_max, holiday_calendar_id FROM zone_tariffs WHERE zone_id = $1"
+	var tariff api.ZoneTariff
+	if err := d.db.QueryRow(c, query, zoneId).Scan(&tariff.Timezone, &tariff.DailyMax, &tariff.HolidayCalendarId); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTariffNotFound
+		}
+		return nil, fmt.Errorf("failed to get zone tariff: %w", err)
+	}
+
+	bandsQuery := "SELECT name, days, start_minute, end_minute, rate_per_hour FROM tariff_bands WHERE zone_id = $1 ORDER BY start_minute, id"
+	rows, err := d.db.Query(c, bandsQuery, zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tariff bands: %w", err)
+	}
+	defer rows.Close()
+
+	tariff.Bands = []api.TariffBand{}
+	for rows.Next() {
+		var band api.TariffBand
+		var name string
+		var startMinute, endMinute int
+		if err := rows.Scan(&name, &band.Days, &startMinute, &endMinute, &band.RatePerHour); err != nil {
+			return nil, fmt.Errorf("failed to scan tariff band: %w", err)
+		}
+		band.Name = &name
+		band.StartTime = pricing.FormatClock(startMinute)
+		band.EndTime = pricing.FormatClock(endMinute)
+		tariff.Bands = append(tariff.Bands, band)
+	}
+
+	return &tariff, nil
+}
+
+// tariffBandRecord is the JSON shape of a band passed to jsonb_to_recordset
+type tariffBandRecord struct {
+	Name        string `json:"name"`
+	Days        []int  `json:"days"`
+	StartMinute int    `json:"start_minute"`
+	EndMinute   int    `json:"end_minute"`
+	RatePerHour int64  `json:"rate_per_hour"`
+}
+
+// UpdateZoneTariff replaces the tariff of a zone and all its bands
+func (d *TariffDao) UpdateZoneTariff(c context.Context, zoneId int64, tariff api.ZoneTariff) (*api.ZoneTariff, error) {
+	if _, err := TariffFromApi(tariff, nil, money.DefaultRounding); err != nil {
+		return nil, err
+	}
+
+	bands := []tariffBandRecord{}
+	for _, band := range tariff.Bands {
+		startMinute, _ := pricing.ParseClock(band.StartTime)
+		endMinute, _ := pricing.ParseClock(band.EndTime)
+		record := tariffBandRecord{Days: band.Days, StartMinute: startMinute, EndMinute: endMinute, RatePerHour: band.RatePerHour}
+		if band.Name != nil {
+			record.Name = *band.Name
+		}
+		bands = append(bands, record)
+	}
+	bandsJSON, err := json.Marshal(bands)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single statement, so the tariff and its bands are replaced atomically
+	query := `
+		WITH tariff AS (
+			INSERT INTO zone_tariffs (zone_id, timezone, daily_max, holiday_calendar_id, updated_at)
+			VALUES ($1, $2, $3, $4, NOW())
+			ON CONFLICT (zone_id) DO UPDATE
+			SET timezone = $2, daily_max = $3, holiday_calendar_id = $4, updated_at = NOW()
+			RETURNING zone_id
+		), old_bands AS (
+			DELETE FROM tariff_bands WHERE zone_id = $1
+		)
+		INSERT INTO tariff_bands (zone_id, name, days, start_minute, end_minute, rate_per_hour)
+		SELECT tariff.zone_id, b.name, b.days, b.start_minute, b.end_minute, b.rate_per_hour
+		FROM tariff, jsonb_to_recordset($5::jsonb) AS b(name TEXT, days INTEGER[], start_minute INTEGER, end_minute INTEGER, rate_per_hour REAL)
+	`
+	_, err = d.db.Exec(c, query, zoneId, tariff.Timezone, tariff.DailyMax, tariff.HolidayCalendarId, string(bandsJSON))
+	if err != nil {
+		if strings.Contains(err.Error(), "zone_tariffs_zone_id_fkey") {
+			return nil, ErrZoneNotFound
+		}
+		if strings.Contains(err.Error(), "zone_tariffs_holiday_calendar_id_fkey") {
+			return nil, ErrHolidayCalendarNotFound
+		}
+		return nil, fmt.Errorf("failed to update zone tariff: %w", err)
+	}
+
+	return d.GetZoneTariff(c, zoneId)
+}
+
+// DeleteZoneTariff removes the tariff, the zone goes back to its price formula
+func (d *TariffDao) DeleteZoneTariff(c context.Context, zoneId int64) error {
+	query := "DELETE FROM zone_tariffs WHERE zone_id = $1"
+	result, err := d.db.Exec(c, query, zoneId)
+	if err != nil {
+		return fmt.Errorf("failed to delete zone tariff: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrTariffNotFound
+	}
+
+	return nil
+}
+
+// TariffFromApi validates a tariff and converts it for the pricing engine.
+// holidays are the dates of the tariff holiday calendar, rounding the
+// rounding rule of the zone.
+func TariffFromApi(tariff api.ZoneTariff, holidays []api.Holiday, rounding money.Rounding) (*pricing.Tariff, error) {
+	loc, err := time.LoadLocation(tariff.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown timezone %q", ErrTariffInvalid, tariff.Timezone)
+	}
+
+	result := &pricing.Tariff{Location: loc, Holidays: map[string]bool{}, DailyMax: tariff.DailyMax, Rounding: rounding}
+	for _, holiday := range holidays {
+		result.Holidays[holiday.Date.Format(time.DateOnly)] = true
+	}
+
+	for _, band := range tariff.Bands {
+		startMinute, err := pricing.ParseClock(band.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTariffInvalid, err)
+		}
+		endMinute, err := pricing.ParseClock(band.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTariffInvalid, err)
+		}
+		b := pricing.Band{Days: band.Days, StartMinute: startMinute, EndMinute: endMinute, RatePerHour: band.RatePerHour}
+		if band.Name != nil {
+			b.Name = *band.Name
+		}
+		result.Bands = append(result.Bands, b)
+	}
+
+	if err := result.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTariffInvalid, err)
+	}
+	return result, nil
+}
+
+// GetZonePricingTariff loads the tariff of a zone with its holidays, ready
+// for the pricing engine. It returns ErrTariffNotFound for zones priced by
+// their formula.
+func (d *TariffDao) GetZonePricingTariff(c context.Context, zone *api.ZoneResponse) (*pricing.Tariff, error) {
+	tariff, err := d.GetZoneTariff(c, zone.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var holidays []api.Holiday
+	if tariff.HolidayCalendarId != nil {
+		calendar, err := d.GetHolidayCalendarById(c, *tariff.HolidayCalendarId)
+		if err != nil && !errors.Is(err, ErrHolidayCalendarNotFound) {
+			return nil, err
+		}
+		if calendar != nil {
+			holidays = calendar.Holidays
+		}
+	}
+
+	return TariffFromApi(*tariff, holidays, zoneRounding(zone))
+}
+
+func (d *TariffDao) GetHolidayCalendars(c context.Context) ([]api.HolidayCalendar, error) {
+	query := "SELECT id FROM holiday_calendars ORDER BY id"
+	rows, err := d.db.Query(c, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query holiday calendars: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan holiday calendar: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	calendars := []api.HolidayCalendar{}
+	for _, id := range ids {
+		calendar, err := d.GetHolidayCalendarById(c, id)
+		if err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, *calendar)
+	}
+
+	return calendars, nil
+}
+
+func (d *TariffDao) GetHolidayCalendarById(c context.Context, id int64) (*api.HolidayCalendar, error) {
+	query := "SELECT id, name FROM holiday_calendars WHERE id = $1"
+	var calendar api.HolidayCalendar
+	if err := d.db.QueryRow(c, query, id).Scan(&calendar.Id, &calendar.Name); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrHolidayCalendarNotFound
+		}
+		return nil, fmt.Errorf("failed to get holiday calendar: %w", err)
+	}
+
+	holidaysQuery := "SELECT date, name FROM holidays WHERE calendar_id = $1 ORDER BY date"
+	rows, err := d.db.Query(c, holidaysQuery, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query holidays: %w", err)
+	}
+	defer rows.Close()
+
+	calendar.Holidays = []api.Holiday{}
+	for rows.Next() {
+		var date time.Time
+		var name string
+		if err := rows.Scan(&date, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan holiday: %w", err)
+		}
+		calendar.Holidays = append(calendar.Holidays, api.Holiday{Date: openapi_types.Date{Time: date}, Name: &name})
+	}
+
+	return &calendar, nil
+}
+
+type holidayRecord struct {
+	Date string `json:"date"`
+	Name string `json:"name"`
+}
+
+func holidaysJSON(holidays []api.Holiday) (string, error) {
+	records := []holidayRecord{}
+	for _, holiday := range holidays {
+		record := holidayRecord{Date: holiday.Date.Format(time.DateOnly)}
+		if holiday.Name != nil {
+			record.Name = *holiday.Name
+		}
+		records = append(records, record)
+	}
+	data, err := json.Marshal(records)
+	return string(data), err
+}
+
+func (d *TariffDao) CreateHolidayCalendar(c context.Context, request api.HolidayCalendarRequest) (*api.HolidayCalendar, error) {
+	holidays, err := holidaysJSON(request.Holidays)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		WITH calendar AS (
+			INSERT INTO holiday_calendars (name) VALUES ($1) RETURNING id
+		), days AS (
+			INSERT INTO holidays (calendar_id, date, name)
+			SELECT calendar.id, h.date, h.name
+			FROM calendar, jsonb_to_recordset($2::jsonb) AS h(date DATE, name TEXT)
+		)
+		SELECT id FROM calendar
+	`
+	var id int64
+	if err := d.db.QueryRow(c, query, request.Name, holidays).Scan(&id); err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return nil, ErrHolidayCalendarAlreadyExists
+		}
+		return nil, fmt.Errorf("failed to create holiday calendar: %w", err)
+	}
+
+	return d.GetHolidayCalendarById(c, id)
+}
+
+// UpdateHolidayCalendar renames a calendar and replaces all its holidays
+func (d *TariffDao) UpdateHolidayCalendar(c context.Context, id int64, request api.HolidayCalendarRequest) (*api.HolidayCalendar, error) {
+	holidays, err := holidaysJSON(request.Holidays)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		WITH calendar AS (
+			UPDATE holiday_calendars SET name = $2 WHERE id = $1 RETURNING id
+		), old_days AS (
+			DELETE FROM holidays WHERE calendar_id IN (SELECT id FROM calendar)
+		), days AS (
+			INSERT INTO holidays (calendar_id, date, name)
+			SELECT calendar.id, h.date, h.name
+			FROM calendar, jsonb_to_recordset($3::jsonb) AS h(date DATE, name TEXT)
+		)
+		SELECT id FROM calendar
+	`
+	var updatedId int64
+	if err := d.db.QueryRow(c, query, id, request.Name, holidays).Scan(&updatedId); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrHolidayCalendarNotFound
+		}
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return nil, ErrHolidayCalendarAlreadyExists
+		}
+		return nil, fmt.Errorf("failed to update holiday calendar: %w", err)
+	}
+
+	return d.GetHolidayCalendarById(c, id)
+}
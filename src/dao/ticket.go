@@ -3,17 +3,22 @@ package dao
 import (
 	"OPP/backend/api"
 	"OPP/backend/db"
+	"OPP/backend/logger"
+	"OPP/backend/money"
 	"context"
 	"errors"
 	"fmt"
-	"math"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 var (
 	ErrTicketNotFound    = errors.New("ticket not found")
 	ErrTicketAlreadyPaid = errors.New("ticket already paid")
 	ErrTicketNotOwned    = errors.New("ticket not owned by user")
+	ErrTicketExpired     = errors.New("ticket expired")
+	ErrTicketModified    = errors.New("ticket modified concurrently")
 )
 
 type TicketDao struct {
@@ -26,23 +31,38 @@ func NewTicketDao() *TicketDao {
 	}
 }
 
+// ticketListColumns are the columns of a ticket list, selected from either
+// ticketListSource or validParkingQuery
+const ticketListColumns = "t.id, t.plate, t.start_date, t.end_date, t.price, t.currency, t.refunded_amount, t.currency, t.refund_status, t.paid, t.creation_time, t.zone_id, t.paid_until, t.permit_id, t.session_id"
+
+// ticketSettledCondition tells whether a ticket is paid up to its end:
+// extending a paid ticket leaves it paid, but only up to paid_until
+const ticketSettledCondition = "paid = TRUE AND paid_until >= end_date"
+
+const ticketListSource = "(SELECT *, NULL::BIGINT AS permit_id, NULL::BIGINT AS session_id FROM tickets) AS t"
+
+// ticketListFrom returns the rows a ticket list selects from: every ticket,
+// or what may park now, permits included, when validOnly
+func ticketListFrom(validOnly bool) string {
+	if validOnly {
+		return "(" + validParkingQuery + ") AS t"
+	}
+	return ticketListSource
+}
+
 func (d *TicketDao) GetTickets(c context.Context, limit *int, offset *int, validOnly *bool, startDateAfter *time.Time, endDateBefore *time.Time) []api.TicketResponse {
-	query := "SELECT id, plate, start_date, end_date, price, paid, creation_time, zone_id FROM tickets"
+	query := "SELECT " + ticketListColumns + " FROM " + ticketListFrom(validOnly != nil && *validOnly)
 	var conditions []string
 	var params []any
 
-	if validOnly != nil && *validOnly {
-		conditions = append(conditions, "paid = TRUE AND end_date >= NOW()")
-	}
-
 	if startDateAfter != nil {
-		conditions = append(conditions, "start_date >= $1")
 		params = append(params, startDateAfter)
+		conditions = append(conditions, fmt.Sprintf("t.start_date >= $%d", len(params)))
 	}
 
 	if endDateBefore != nil {
-		conditions = append(conditions, "end_date <= $2")
 		params = append(params, endDateBefore)
+		conditions = append(conditions, fmt.Sprintf("t.end_date <= $%d", len(params)))
 	}
 
 	if len(conditions) > 0 {
@@ -52,7 +72,7 @@ func (d *TicketDao) GetTickets(c context.Context, limit *int, offset *int, valid
 		}
 	}
 
-	query += " ORDER BY id DESC LIMIT $1 OFFSET $2"
+	query += fmt.Sprintf(" ORDER BY t.id DESC, t.permit_id DESC, t.session_id DESC, t.plate LIMIT $%d OFFSET $%d", len(params)+1, len(params)+2)
 	limitVal := 20
 	offsetVal := 0
 	if limit != nil {
@@ -73,17 +93,22 @@ func (d *TicketDao) GetTickets(c context.Context, limit *int, offset *int, valid
 	// Update the scan to include zone_id
 	for rows.Next() {
 		var ticket api.TicketResponse
-		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId); err != nil {
+		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price.Amount, &ticket.Price.Currency, &ticket.Refunded.Amount, &ticket.Refunded.Currency, &ticket.RefundStatus, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId, &ticket.PaidUntil, &ticket.PermitId, &ticket.SessionId); err != nil {
 			continue
 		}
 		tickets = append(tickets, ticket)
 	}
 
+	// Without a signing key the tickets are listed without their token
+	if err := signTickets(c, tickets); err != nil {
+		logger.Error.Printf("failed to sign tickets: %v", err)
+	}
+
 	return tickets
 }
 
 func (d *TicketDao) GetTicketById(c context.Context, id int64) (*api.TicketResponse, error) {
-	query := "SELECT id, plate, start_date, end_date, price, paid, creation_time, zone_id FROM tickets WHERE id = $1"
+	query := "SELECT id, plate, start_date, end_date, price, currency, refunded_amount, currency, refund_status, paid, creation_time, zone_id, paid_until FROM tickets WHERE id = $1"
 	rows, err := d.db.Query(c, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query ticket: %w", err)
@@ -95,23 +120,173 @@ func (d *TicketDao) GetTicketById(c context.Context, id int64) (*api.TicketRespo
 	}
 
 	var ticket api.TicketResponse
-	if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId); err != nil {
+	if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price.Amount, &ticket.Price.Currency, &ticket.Refunded.Amount, &ticket.Refunded.Currency, &ticket.RefundStatus, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId, &ticket.PaidUntil); err != nil {
 		return nil, fmt.Errorf("failed to scan ticket: %w", err)
 	}
+	rows.Close()
 
-	return &ticket, nil
+	extensions, err := d.GetTicketExtensions(c, id)
+	if err != nil {
+		return nil, err
+	}
+	ticket.Extensions = &extensions
+
+	discounts, err := NewDiscountDao().GetTicketDiscounts(c, id)
+	if err != nil {
+		return nil, err
+	}
+	ticket.Discounts = &discounts
+
+	refunds, err := NewRefundDao().GetTicketRefunds(c, id)
+	if err != nil {
+		return nil, err
+	}
+	ticket.Refunds = &refunds
+
+	signed := []api.TicketResponse{ticket}
+	if err := signTickets(c, signed); err != nil {
+		return nil, err
+	}
+
+	return &signed[0], nil
 }
 
-func (d *TicketDao) CreateZoneTicket(c context.Context, zoneId int64, ticket api.TicketRequest) (*api.TicketResponse, error) {
-	carQuery := "SELECT * FROM cars WHERE plate = $1"
-	carRows, err := d.db.Query(c, carQuery, ticket.Plate)
+func (d *TicketDao) GetTicketExtensions(c context.Context, ticketId int64) ([]api.TicketExtension, error) {
+	query := "SELECT e.id, e.ticket_id, e.duration, e.previous_end_date, e.new_end_date, e.price, t.currency, e.creation_time FROM ticket_extensions AS e JOIN tickets AS t ON e.ticket_id = t.id WHERE e.ticket_id = $1 ORDER BY e.id"
+	rows, err := d.db.Query(c, query, ticketId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ticket extensions: %w", err)
+	}
+	defer rows.Close()
+
+	extensions := []api.TicketExtension{}
+	for rows.Next() {
+		var extension api.TicketExtension
+		if err := rows.Scan(&extension.Id, &extension.TicketId, &extension.Duration, &extension.PreviousEndDate, &extension.NewEndDate, &extension.Price.Amount, &extension.Price.Currency, &extension.CreationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan ticket extension: %w", err)
+		}
+		extensions = append(extensions, extension)
+	}
+
+	return extensions, nil
+}
+
+// ExtendTicket adds `minutes` to the end of a ticket. The ticket is repriced
+// on its combined duration, so the zone offset is only charged once, with
+// the discounts and at the price level it was bought with, and the
+// difference is recorded as an extension line item. Extending an unpaid
+// ticket raises the amount its payment must cover, extending a paid ticket
+// leaves the difference due, to be paid with a new payment, and the ticket
+// only covers the car up to its previous end until then.
+func (d *TicketDao) ExtendTicket(c context.Context, username string, id int64, minutes int) (*api.TicketResponse, error) {
+	ticket, err := d.GetTicketById(c, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the user owns the ticket
+	query := "SELECT 1 FROM cars WHERE plate = $1 AND user_id = $2"
+	var owned int
+	if err := d.db.QueryRow(c, query, ticket.Plate, username).Scan(&owned); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTicketNotOwned
+		}
+		return nil, fmt.Errorf("failed to check ticket ownership: %w", err)
+	}
+
+	if ticket.EndDate.Before(time.Now()) {
+		return nil, ErrTicketExpired
+	}
+
+	zone, err := d.ticketZone(c, ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	newEndDate := ticket.EndDate.Add(time.Duration(minutes) * time.Minute)
+	combinedMinutes := int(newEndDate.Sub(ticket.StartDate).Minutes())
+	breakdown, err := priceZoneStay(c, zone, ticket.StartDate, combinedMinutes)
+	if err != nil {
+		return nil, err
+	}
+	discountDao := NewDiscountDao()
+	rules, err := discountDao.ticketDiscountRules(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyDiscountRules(c, zone, ticket.StartDate, combinedMinutes, breakdown, rules); err != nil {
+		return nil, err
+	}
+	// A longer stay never costs less, even when the tariff went down since
+	newPrice := money.New(max(breakdown.Total, ticket.Price.Amount), breakdown.Currency)
+	extensionPrice, err := newPrice.Sub(money.New(ticket.Price.Amount, ticket.Price.Currency))
+	if err != nil {
+		return nil, err
+	}
+
+	// The ticket and its extension line item are written together, while
+	// the capacity of the zone holds
+	err = d.db.WithTx(c, func(c context.Context) error {
+		if err := NewOccupancyDao().checkZoneCapacity(c, ticket.ZoneId, ticket.Plate, ticket.EndDate, newEndDate); err != nil {
+			return err
+		}
+		// Only update the ticket if nobody extended it in the meantime
+		updateQuery := "UPDATE tickets SET end_date = $2, price = $3 WHERE id = $1 AND end_date = $4"
+		result, err := d.db.Exec(c, updateQuery, id, newEndDate, newPrice.Amount, ticket.EndDate)
+		if err != nil {
+			return fmt.Errorf("failed to extend ticket: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrTicketModified
+		}
+
+		insertQuery := "INSERT INTO ticket_extensions (ticket_id, duration, previous_end_date, new_end_date, price) VALUES ($1, $2, $3, $4, $5)"
+		if _, err := d.db.Exec(c, insertQuery, id, minutes, ticket.EndDate, newEndDate, extensionPrice.Amount); err != nil {
+			return fmt.Errorf("failed to record ticket extension: %w", err)
+		}
+		if err := discountDao.repriceRedemptions(c, id, breakdown, rules); err != nil {
+			return err
+		}
+		// A paid ticket stays paid up to its previous end until the
+		// extension is paid, unless its payments already cover it
+		if ticket.Paid {
+			if _, err := NewPaymentDao().settle(c, PaymentTargetTicket, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to check car: %w", err)
+		return nil, err
 	}
-	defer carRows.Close()
 
-	if !carRows.Next() {
-		return nil, ErrCarNotFound
+	return d.GetTicketById(c, id)
+}
+
+// ticketZone returns the zone of a ticket at the price level the ticket was
+// bought at
+func (d *TicketDao) ticketZone(c context.Context, ticket *api.TicketResponse) (*api.ZoneResponse, error) {
+	zone, err := NewZoneDao().GetZoneById(c, ticket.ZoneId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zone: %w", err)
+	}
+	query := "SELECT price_level FROM tickets WHERE id = $1"
+	var level int
+	if err := d.db.QueryRow(c, query, ticket.Id).Scan(&level); err != nil {
+		return nil, fmt.Errorf("failed to get ticket price level: %w", err)
+	}
+	return atPriceLevel(zone, level), nil
+}
+
+// CreateZoneTicket buys a ticket for a car. The discounts that apply are
+// taken off its price and recorded with it, along with the discount code
+// of the request, which must apply. Zones that refuse cars when full
+// refuse the ticket when they are full when it starts.
+func (d *TicketDao) CreateZoneTicket(c context.Context, zoneId int64, ticket api.TicketRequest) (*api.TicketResponse, error) {
+	discountDao := NewDiscountDao()
+	car, err := discountDao.getDiscountCar(c, ticket.Plate)
+	if err != nil {
+		return nil, err
 	}
 
 	endTime := ticket.StartDate.Add(time.Duration(ticket.Duration) * time.Minute)
@@ -122,51 +297,134 @@ func (d *TicketDao) CreateZoneTicket(c context.Context, zoneId int64, ticket api
 		return nil, fmt.Errorf("failed to get zone: %w", err)
 	}
 
-	durationInHours := float32(ticket.Duration) / 60.0
-	priceComponent := zone.PriceLin * durationInHours
-	price := zone.PriceOffset + float32(math.Pow(float64(priceComponent), float64(zone.PriceExp)))
-
 	creationTime := time.Now()
-	query := "INSERT INTO tickets (plate, start_date, end_date, price, paid, creation_time, zone_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
 	var lastId int64
-	err = d.db.QueryRow(c, query, ticket.Plate, ticket.StartDate, endTime, price, false, creationTime, zoneId).Scan(&lastId)
+	var price api.Money
+	var discounts []api.TicketDiscount
+	// The usage limits of the discounts and the capacity of the zone hold
+	// until the ticket is written
+	err = d.db.WithTx(c, func(c context.Context) error {
+		if err := NewOccupancyDao().checkZoneCapacity(c, zoneId, ticket.Plate, ticket.StartDate, endTime); err != nil {
+			return err
+		}
+		breakdown, rules, err := discountDao.priceDiscountedStay(c, zone, ticket.StartDate, ticket.Duration, car, ticket.DiscountCode, true)
+		if err != nil {
+			return err
+		}
+		price = api.Money{Amount: breakdown.Total, Currency: breakdown.Currency}
+
+		query := "INSERT INTO tickets (plate, start_date, end_date, price, currency, paid, creation_time, zone_id, price_level) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id"
+		if err := d.db.QueryRow(c, query, ticket.Plate, ticket.StartDate, endTime, price.Amount, price.Currency, false, creationTime, zoneId, zone.PriceLevel).Scan(&lastId); err != nil {
+			return fmt.Errorf("failed to add ticket: %w", err)
+		}
+
+		if err := discountDao.redeemDiscounts(c, lastId, car.owner, breakdown, rules); err != nil {
+			return err
+		}
+		discounts, err = discountDao.GetTicketDiscounts(c, lastId)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to add ticket: %w", err)
+		return nil, err
 	}
 
-	return &api.TicketResponse{
+	signed := []api.TicketResponse{{
 		Id:           lastId,
 		Plate:        ticket.Plate,
 		StartDate:    ticket.StartDate,
 		EndDate:      endTime,
 		Price:        price,
+		Refunded:     api.Money{Amount: 0, Currency: price.Currency},
+		RefundStatus: TicketRefundStatusNone,
 		Paid:         false,
 		CreationTime: creationTime,
 		ZoneId:       zoneId,
-	}, nil
+		Discounts:    &discounts,
+	}}
+	if err := signTickets(c, signed); err != nil {
+		return nil, err
+	}
+
+	return &signed[0], nil
 }
 
 func (d *TicketDao) PayTicket(c context.Context, id int64) (*api.TicketResponse, error) {
-	ticket, err := d.GetTicketById(c, id)
+	err := d.db.WithTx(c, func(c context.Context) error {
+		// Lock the ticket, a concurrent payment waits and then sees it paid
+		query := "SELECT " + ticketSettledCondition + " FROM tickets WHERE id = $1 FOR UPDATE"
+		var paid bool
+		if err := d.db.QueryRow(c, query, id).Scan(&paid); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrTicketNotFound
+			}
+			return fmt.Errorf("failed to lock ticket: %w", err)
+		}
+		if paid {
+			return ErrTicketAlreadyPaid
+		}
+
+		// The ticket is only paid once its confirmed payments cover its price
+		settled, err := NewPaymentDao().settle(c, PaymentTargetTicket, id)
+		if err != nil {
+			return err
+		}
+		if !settled {
+			return ErrPaymentRequired
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if ticket.Paid == true {
-		return nil, ErrTicketAlreadyPaid
-	}
+	return d.GetTicketById(c, id)
+}
 
-	query := "UPDATE tickets SET paid = TRUE WHERE id = $1"
-	_, err = d.db.Exec(c, query, id)
+// PayTicketWithWallet pays what is left due on a ticket from the wallet of
+// the user, and then marks it paid
+func (d *TicketDao) PayTicketWithWallet(c context.Context, username string, id int64) (*api.TicketResponse, error) {
+	var paymentId int64
+	err := d.db.WithTx(c, func(c context.Context) error {
+		// Lock the ticket, a concurrent payment waits and then sees it paid
+		query := "SELECT " + ticketSettledCondition + " FROM tickets WHERE id = $1 FOR UPDATE"
+		var paid bool
+		if err := d.db.QueryRow(c, query, id).Scan(&paid); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrTicketNotFound
+			}
+			return fmt.Errorf("failed to lock ticket: %w", err)
+		}
+		if paid {
+			return ErrTicketAlreadyPaid
+		}
+
+		// Confirmed payments may already cover the ticket
+		due, err := NewPaymentDao().amountDue(c, PaymentTargetTicket, id)
+		if err != nil && !errors.Is(err, ErrNothingToPay) {
+			return err
+		}
+		if err == nil {
+			paymentId, err = NewWalletDao().payFromWallet(c, username, PaymentTargetTicket, id, due)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = d.PayTicket(c, id)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update ticket: %w", err)
+		return nil, err
+	}
+	if paymentId != 0 {
+		NewInvoiceDao().billPayment(c, paymentId)
 	}
 
 	return d.GetTicketById(c, id)
 }
 
 func (d *TicketDao) GetCarTickets(c context.Context, plate string) ([]api.TicketResponse, error) {
-	query := "SELECT id, plate, start_date, end_date, price, paid, creation_time, zone_id FROM tickets WHERE plate = $1"
+	query := "SELECT id, plate, start_date, end_date, price, currency, refunded_amount, currency, refund_status, paid, creation_time, zone_id, paid_until FROM tickets WHERE plate = $1"
 	rows, err := d.db.Query(c, query, plate)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query tickets: %w", err)
@@ -176,20 +434,20 @@ func (d *TicketDao) GetCarTickets(c context.Context, plate string) ([]api.Ticket
 	tickets := []api.TicketResponse{}
 	for rows.Next() {
 		var ticket api.TicketResponse
-		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId); err != nil {
+		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price.Amount, &ticket.Price.Currency, &ticket.Refunded.Amount, &ticket.Refunded.Currency, &ticket.RefundStatus, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId, &ticket.PaidUntil); err != nil {
 			return nil, fmt.Errorf("failed to scan ticket: %w", err)
 		}
 		tickets = append(tickets, ticket)
 	}
+	if err := signTickets(c, tickets); err != nil {
+		return nil, err
+	}
 
 	return tickets, nil
 }
 
 func (d *TicketDao) GetUserTickets(c context.Context, username string, validOnly bool) ([]api.TicketResponse, error) {
-	query := "SELECT t.id, t.plate, t.start_date, t.end_date, t.price, t.paid, t.creation_time, t.zone_id FROM tickets AS t JOIN cars AS c ON t.plate = c.plate WHERE c.user_id = $1"
-	if validOnly {
-		query += " AND t.paid = TRUE AND t.end_date >= NOW()"
-	}
+	query := "SELECT " + ticketListColumns + " FROM " + ticketListFrom(validOnly) + " JOIN cars AS c ON t.plate = c.plate WHERE c.user_id = $1 ORDER BY t.id DESC, t.permit_id DESC, t.session_id DESC, t.plate"
 
 	rows, err := d.db.Query(c, query, username)
 	if err != nil {
@@ -200,56 +458,62 @@ func (d *TicketDao) GetUserTickets(c context.Context, username string, validOnly
 	tickets := []api.TicketResponse{}
 	for rows.Next() {
 		var ticket api.TicketResponse
-		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId); err != nil {
+		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price.Amount, &ticket.Price.Currency, &ticket.Refunded.Amount, &ticket.Refunded.Currency, &ticket.RefundStatus, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId, &ticket.PaidUntil, &ticket.PermitId, &ticket.SessionId); err != nil {
 			return nil, fmt.Errorf("failed to scan ticket: %w", err)
 		}
 		tickets = append(tickets, ticket)
 	}
+	if err := signTickets(c, tickets); err != nil {
+		return nil, err
+	}
 
 	return tickets, nil
 }
 
 func (d *TicketDao) DeleteTicketById(c context.Context, username string, id int64) error {
-	ticket, err := d.GetTicketById(c, id)
-	if err != nil {
-		if errors.Is(err, ErrTicketNotFound) {
-			return ErrTicketNotFound
+	return d.db.WithTx(c, func(c context.Context) error {
+		// Lock the ticket so that it cannot be paid while being deleted
+		lockQuery := "SELECT plate, paid FROM tickets WHERE id = $1 FOR UPDATE"
+		var plate string
+		var paid bool
+		if err := d.db.QueryRow(c, lockQuery, id).Scan(&plate, &paid); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrTicketNotFound
+			}
+			return fmt.Errorf("failed to get ticket: %w", err)
 		}
-		return fmt.Errorf("failed to get ticket: %w", err)
-	}
 
-	if ticket.Paid {
-		return ErrTicketAlreadyPaid
-	}
-
-	// Check if the user owns the ticket
-	query := "SELECT 1 FROM cars WHERE plate = $1 AND user_id = $2"
-	rows, err := d.db.Query(c, query, ticket.Plate, username)
-	if err != nil {
-		return fmt.Errorf("failed to check ticket ownership: %w", err)
-	}
-	defer rows.Close()
+		if paid {
+			return ErrTicketAlreadyPaid
+		}
 
-	if !rows.Next() {
-		return ErrTicketNotOwned
-	}
+		// Check if the user owns the ticket
+		query := "SELECT 1 FROM cars WHERE plate = $1 AND user_id = $2"
+		var owned int
+		if err := d.db.QueryRow(c, query, plate, username).Scan(&owned); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrTicketNotOwned
+			}
+			return fmt.Errorf("failed to check ticket ownership: %w", err)
+		}
 
-	deleteQuery := "DELETE FROM tickets WHERE id = $1"
-	result, err := d.db.Exec(c, deleteQuery, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete ticket: %w", err)
-	}
+		deleteQuery := "DELETE FROM tickets WHERE id = $1"
+		result, err := d.db.Exec(c, deleteQuery, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete ticket: %w", err)
+		}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return ErrTicketNotFound
-	}
+		rowsAffected := result.RowsAffected()
+		if rowsAffected == 0 {
+			return ErrTicketNotFound
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (d *FineDao) GetZoneTickets(ctx context.Context, zoneId int64, limit int, offset int) ([]api.TicketResponse, error) {
-	query := "SELECT id, plate, start_date, end_date, price, paid, creation_time, zone_id FROM tickets WHERE zone_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3"
+	query := "SELECT id, plate, start_date, end_date, price, currency, refunded_amount, currency, refund_status, paid, creation_time, zone_id, paid_until FROM tickets WHERE zone_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3"
 	rows, err := d.db.Query(ctx, query, zoneId, limit, offset)
 	if err != nil {
 		return nil, err
@@ -259,11 +523,24 @@ func (d *FineDao) GetZoneTickets(ctx context.Context, zoneId int64, limit int, o
 	tickets := []api.TicketResponse{}
 	for rows.Next() {
 		var ticket api.TicketResponse
-		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId); err != nil {
+		if err := rows.Scan(&ticket.Id, &ticket.Plate, &ticket.StartDate, &ticket.EndDate, &ticket.Price.Amount, &ticket.Price.Currency, &ticket.Refunded.Amount, &ticket.Refunded.Currency, &ticket.RefundStatus, &ticket.Paid, &ticket.CreationTime, &ticket.ZoneId, &ticket.PaidUntil); err != nil {
 			continue
 		}
 		tickets = append(tickets, ticket)
 	}
+	if err := signTickets(ctx, tickets); err != nil {
+		return nil, err
+	}
 
 	return tickets, nil
 }
+
+// IsTicketOwner tells whether username owns the car of a ticket
+func (d *TicketDao) IsTicketOwner(c context.Context, id int64, username string) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM tickets AS t JOIN cars ON cars.plate = t.plate WHERE t.id = $1 AND cars.user_id = $2)"
+	var owned bool
+	if err := d.db.QueryRow(c, query, id, username).Scan(&owned); err != nil {
+		return false, fmt.Errorf("failed to check ticket ownership: %w", err)
+	}
+	return owned, nil
+}
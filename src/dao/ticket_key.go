@@ -0,0 +1,288 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/tickettoken"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Days a ticket signing key is used before the next one takes over
+var TICKET_KEY_ROTATION_DAYS = os.Getenv("TICKET_KEY_ROTATION_DAYS")
+
+const (
+	defaultTicketKeyRotationDays = 30
+	// New keys are published this long before they sign tokens, so that
+	// controller apps syncing once a day know them in time
+	ticketKeyPrepublish = 24 * time.Hour
+	// Retired keys stay published this long after the last ticket whose
+	// token they may have signed ends
+	ticketKeyGrace = 24 * time.Hour
+	// How long an instance keeps signing with the key it loaded
+	ticketKeyCacheTTL = time.Minute
+)
+
+var ErrNoTicketKey = errors.New("no ticket signing key")
+
+// Signing key cached by this instance
+var cachedSigningKey struct {
+	mu     sync.Mutex
+	key    *tickettoken.Key
+	loaded time.Time
+}
+
+type TicketKeyDao struct {
+	db db.DB
+}
+
+func NewTicketKeyDao() *TicketKeyDao {
+	return &TicketKeyDao{
+		db: *db.GetDB(),
+	}
+}
+
+func ticketKeyRotation() time.Duration {
+	days := defaultTicketKeyRotationDays
+	if TICKET_KEY_ROTATION_DAYS != "" {
+		if d, err := strconv.Atoi(TICKET_KEY_ROTATION_DAYS); err == nil && d > 0 {
+			days = d
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GetTicketKeys returns the published verification keys, newest first:
+// the next key, the signing key and the retired keys still needed
+func (d *TicketKeyDao) GetTicketKeys(c context.Context) (*api.TicketKeySet, error) {
+	query := "SELECT kid, public_key, activates_at, expires_at FROM ticket_signing_keys WHERE expires_at IS NULL OR expires_at > NOW() ORDER BY activates_at DESC"
+	rows, err := d.db.Query(c, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ticket keys: %w", err)
+	}
+	defer rows.Close()
+
+	set := &api.TicketKeySet{Keys: []api.TicketKey{}}
+	for rows.Next() {
+		var kid string
+		var public []byte
+		var key api.TicketKey
+		if err := rows.Scan(&kid, &public, &key.ActivatesAt, &key.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan ticket key: %w", err)
+		}
+		jwk := tickettoken.PublicJWK(kid, ed25519.PublicKey(public))
+		key.Kty, key.Crv, key.Kid, key.X, key.Alg, key.Use = jwk.Kty, jwk.Crv, jwk.Kid, jwk.X, jwk.Alg, jwk.Use
+		set.Keys = append(set.Keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query ticket keys: %w", err)
+	}
+	return set, nil
+}
+
+// loadSigningKey reads the newest active key
+func (d *TicketKeyDao) loadSigningKey(c context.Context) (tickettoken.Key, error) {
+	query := "SELECT kid, private_key FROM ticket_signing_keys WHERE activates_at <= NOW() AND retired_at IS NULL ORDER BY activates_at DESC LIMIT 1"
+	var kid string
+	var sealed []byte
+	if err := d.db.QueryRow(c, query).Scan(&kid, &sealed); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return tickettoken.Key{}, ErrNoTicketKey
+		}
+		return tickettoken.Key{}, fmt.Errorf("failed to get ticket signing key: %w", err)
+	}
+	return tickettoken.OpenKey(kid, sealed, tickettoken.TICKET_KEY_SECRET)
+}
+
+// signingKey returns the key tokens are signed with, creating the first
+// one if needed
+func (d *TicketKeyDao) signingKey(c context.Context) (tickettoken.Key, error) {
+	cachedSigningKey.mu.Lock()
+	defer cachedSigningKey.mu.Unlock()
+	if cachedSigningKey.key != nil && time.Since(cachedSigningKey.loaded) < ticketKeyCacheTTL {
+		return *cachedSigningKey.key, nil
+	}
+
+	key, err := d.loadSigningKey(c)
+	if errors.Is(err, ErrNoTicketKey) {
+		if err := d.RotateTicketKeys(c); err != nil {
+			return tickettoken.Key{}, err
+		}
+		key, err = d.loadSigningKey(c)
+	}
+	if err != nil {
+		return tickettoken.Key{}, err
+	}
+	cachedSigningKey.key, cachedSigningKey.loaded = &key, time.Now()
+	return key, nil
+}
+
+// addKey creates a key that starts signing after delay
+func (d *TicketKeyDao) addKey(c context.Context, delay time.Duration) error {
+	key, err := tickettoken.GenerateKey()
+	if err != nil {
+		return err
+	}
+	sealed, err := key.Seal(tickettoken.TICKET_KEY_SECRET)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO ticket_signing_keys (kid, private_key, public_key, activates_at) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))"
+	if _, err := d.db.Exec(c, query, key.Id, sealed, []byte(key.Public()), delay.Seconds()); err != nil {
+		return fmt.Errorf("failed to add ticket signing key: %w", err)
+	}
+	return nil
+}
+
+// retireKeys retires the keys a newer active key took over from. They stay
+// published until the tickets created while they signed have ended.
+func (d *TicketKeyDao) retireKeys(c context.Context) error {
+	query := `
+		UPDATE ticket_signing_keys AS k
+		SET retired_at = s.superseded_at,
+			expires_at = GREATEST(
+				s.superseded_at,
+				(SELECT MAX(end_date) FROM tickets WHERE creation_time < s.superseded_at + make_interval(secs => $1))
+			) + make_interval(secs => $2)
+		FROM (
+			SELECT old.kid, (
+				SELECT MIN(newer.activates_at) FROM ticket_signing_keys AS newer
+				WHERE newer.activates_at > old.activates_at AND newer.activates_at <= NOW() AND newer.retired_at IS NULL
+			) AS superseded_at
+			FROM ticket_signing_keys AS old
+			WHERE old.retired_at IS NULL
+		) AS s
+		WHERE k.kid = s.kid AND s.superseded_at IS NOT NULL
+	`
+	if _, err := d.db.Exec(c, query, ticketKeyCacheTTL.Seconds(), ticketKeyGrace.Seconds()); err != nil {
+		return fmt.Errorf("failed to retire ticket signing keys: %w", err)
+	}
+	return nil
+}
+
+// lockKeys serializes the key changes of the backend instances
+func (d *TicketKeyDao) lockKeys(c context.Context) error {
+	if _, err := d.db.Exec(c, "LOCK TABLE ticket_signing_keys IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return fmt.Errorf("failed to lock ticket signing keys: %w", err)
+	}
+	return nil
+}
+
+// RotateTicketKeys runs the scheduled key rotation: it creates the first
+// key, publishes the next one ahead of its use, retires the keys it
+// replaced and removes those no longer needed
+func (d *TicketKeyDao) RotateTicketKeys(c context.Context) error {
+	return d.db.WithTx(c, func(c context.Context) error {
+		if err := d.lockKeys(c); err != nil {
+			return err
+		}
+
+		query := `
+			SELECT
+				COALESCE(BOOL_OR(activates_at <= NOW()), FALSE),
+				COALESCE(MAX(activates_at) <= NOW() - make_interval(secs => $1), FALSE)
+			FROM ticket_signing_keys
+			WHERE retired_at IS NULL
+		`
+		var active, due bool
+		if err := d.db.QueryRow(c, query, (ticketKeyRotation()-ticketKeyPrepublish).Seconds()).Scan(&active, &due); err != nil {
+			return fmt.Errorf("failed to check ticket signing keys: %w", err)
+		}
+		switch {
+		case !active:
+			if err := d.addKey(c, 0); err != nil {
+				return err
+			}
+		case due:
+			if err := d.addKey(c, ticketKeyPrepublish); err != nil {
+				return err
+			}
+		}
+
+		if err := d.retireKeys(c); err != nil {
+			return err
+		}
+		if _, err := d.db.Exec(c, "DELETE FROM ticket_signing_keys WHERE expires_at <= NOW()"); err != nil {
+			return fmt.Errorf("failed to delete expired ticket signing keys: %w", err)
+		}
+		return nil
+	})
+}
+
+// ForceRotateTicketKeys replaces the signing key at once. With revoke the
+// replaced key is withdrawn too and the tokens it signed stop verifying,
+// for when it leaked.
+func (d *TicketKeyDao) ForceRotateTicketKeys(c context.Context, revoke bool) (*api.TicketKeySet, error) {
+	err := d.db.WithTx(c, func(c context.Context) error {
+		if err := d.lockKeys(c); err != nil {
+			return err
+		}
+		if revoke {
+			query := "UPDATE ticket_signing_keys SET retired_at = NOW(), expires_at = NOW() WHERE activates_at <= NOW() AND retired_at IS NULL"
+			if _, err := d.db.Exec(c, query); err != nil {
+				return fmt.Errorf("failed to revoke ticket signing key: %w", err)
+			}
+		}
+		if err := d.addKey(c, 0); err != nil {
+			return err
+		}
+		return d.retireKeys(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cachedSigningKey.mu.Lock()
+	cachedSigningKey.key = nil
+	cachedSigningKey.mu.Unlock()
+
+	return d.GetTicketKeys(c)
+}
+
+// signTickets sets the token of tickets, signed with the current key.
+// Active sessions, which have neither a ticket id nor a price yet, are left
+// without one.
+func signTickets(c context.Context, tickets []api.TicketResponse) error {
+	key, err := NewTicketKeyDao().signingKey(c)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range tickets {
+		ticket := &tickets[i]
+		if ticket.SessionId != nil {
+			continue
+		}
+		var permit int64
+		if ticket.PermitId != nil {
+			permit = *ticket.PermitId
+		}
+		// Offline checks only accept the car for the paid time
+		end := ticket.EndDate
+		if ticket.Paid && ticket.PaidUntil != nil {
+			end = *ticket.PaidUntil
+		}
+		token, err := tickettoken.Sign(key, tickettoken.Ticket{
+			Id:     ticket.Id,
+			Permit: permit,
+			Plate:  ticket.Plate,
+			Zone:   ticket.ZoneId,
+			Start:  ticket.StartDate,
+			End:    end,
+			Paid:   ticket.Paid,
+		}, now)
+		if err != nil {
+			return err
+		}
+		ticket.Token = &token
+	}
+	return nil
+}
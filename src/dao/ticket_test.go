@@ -0,0 +1,294 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/money"
+	"OPP/backend/payments"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// coveredAt tells whether a ticket covers its car at a time
+func coveredAt(ticket *api.TicketResponse, at time.Time) bool {
+	for _, cv := range plateCoverage([]api.TicketResponse{*ticket}, nil, nil) {
+		if cv.covers(at) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExtendPaidTicketCoversPaidTimeOnly(t *testing.T) {
+	connectTestDB(t)
+	ticketId, _ := payFixture(t)
+	c := context.Background()
+	d := db.GetDB()
+
+	// 10.00 an hour, so the extension costs more than the 5.00 paid
+	if _, err := d.Exec(c, "UPDATE zones SET price_offset = 0, price_lin = 1000, price_exp = 1 WHERE id = (SELECT zone_id FROM tickets WHERE id = $1)", ticketId); err != nil {
+		t.Fatalf("failed to set zone prices: %v", err)
+	}
+
+	tickets := NewTicketDao()
+	paid, err := tickets.PayTicket(c, ticketId)
+	if err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+	oldEnd := paid.EndDate
+
+	extended, err := tickets.ExtendTicket(c, "race", ticketId, 60)
+	if err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if !extended.EndDate.After(oldEnd) {
+		t.Fatalf("end date %v not moved past %v", extended.EndDate, oldEnd)
+	}
+	if extended.PaidUntil == nil || !extended.PaidUntil.Equal(oldEnd) {
+		t.Fatalf("paid until %v, want the previous end %v", extended.PaidUntil, oldEnd)
+	}
+	afterOldEnd := oldEnd.Add(30 * time.Minute)
+	if coveredAt(extended, afterOldEnd) {
+		t.Fatal("unpaid extension covers the car")
+	}
+	if _, err := tickets.PayTicket(c, ticketId); !errors.Is(err, ErrPaymentRequired) {
+		t.Fatalf("expected ErrPaymentRequired, got %v", err)
+	}
+
+	paymentQuery := "INSERT INTO payments (target_type, target_id, username, amount, currency, provider, provider_ref, status) VALUES ($1, $2, 'race', $3, 'EUR', 'test', $4, 'confirmed')"
+	if _, err := d.Exec(c, paymentQuery, PaymentTargetTicket, ticketId, extended.Price.Amount-paid.Price.Amount, fmt.Sprintf("extension-%d", ticketId)); err != nil {
+		t.Fatalf("failed to create extension payment: %v", err)
+	}
+	settled, err := tickets.PayTicket(c, ticketId)
+	if err != nil {
+		t.Fatalf("pay extension: %v", err)
+	}
+	if settled.PaidUntil == nil || !settled.PaidUntil.Equal(settled.EndDate) {
+		t.Fatalf("paid until %v, want the new end %v", settled.PaidUntil, settled.EndDate)
+	}
+	if !coveredAt(settled, afterOldEnd) {
+		t.Fatal("paid extension does not cover the car")
+	}
+}
+
+func TestZoneCapacityCoversWholeStay(t *testing.T) {
+	connectTestDB(t)
+	c := context.Background()
+	d := db.GetDB()
+
+	zoneId, plate, suffix := zoneFixture(t)
+	other := "PEAK" + suffix[len(suffix)-6:]
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM cars WHERE plate = $1", other)
+	})
+	if _, err := d.Exec(c, "INSERT INTO cars (plate, user_id) VALUES ($1, 'race')", other); err != nil {
+		t.Fatalf("failed to create car: %v", err)
+	}
+	if _, err := d.Exec(c, "UPDATE zones SET capacity = 1, refuse_when_full = TRUE WHERE id = $1", zoneId); err != nil {
+		t.Fatalf("failed to set zone capacity: %v", err)
+	}
+
+	// The only spot is taken from two hours from now
+	now := time.Now().Truncate(time.Minute)
+	if _, err := d.Exec(c, "INSERT INTO tickets (zone_id, plate, start_date, end_date, price, currency, paid, paid_until) VALUES ($1, $2, $3, $4, 500, 'EUR', TRUE, $4)", zoneId, other, now.Add(2*time.Hour), now.Add(3*time.Hour)); err != nil {
+		t.Fatalf("failed to create ticket: %v", err)
+	}
+
+	tickets := NewTicketDao()
+	if _, err := tickets.CreateZoneTicket(c, zoneId, api.TicketRequest{Plate: plate, StartDate: now, Duration: 180}); !errors.Is(err, ErrZoneFull) {
+		t.Fatalf("expected ErrZoneFull for a stay over the taken spot, got %v", err)
+	}
+	ticket, err := tickets.CreateZoneTicket(c, zoneId, api.TicketRequest{Plate: plate, StartDate: now, Duration: 60})
+	if err != nil {
+		t.Fatalf("stay before the taken spot: %v", err)
+	}
+	if _, err := tickets.ExtendTicket(c, "race", ticket.Id, 120); !errors.Is(err, ErrZoneFull) {
+		t.Fatalf("expected ErrZoneFull for an extension over the taken spot, got %v", err)
+	}
+	if _, err := tickets.ExtendTicket(c, "race", ticket.Id, 60); err != nil {
+		t.Fatalf("extension before the taken spot: %v", err)
+	}
+}
+
+func TestFullRefundOfExtendedTicketEndsIt(t *testing.T) {
+	connectTestDB(t)
+	ticketId, _ := payFixture(t)
+	c := context.Background()
+	d := db.GetDB()
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM refunds WHERE ticket_id = $1", ticketId)
+	})
+
+	// The 5.00 payment is known to the provider that refunds it
+	provider := payments.NewMockProvider("")
+	intent, err := provider.CreateIntent(c, money.New(500, "EUR"), "refund test")
+	if err != nil {
+		t.Fatalf("create intent: %v", err)
+	}
+	if _, err := provider.Confirm(c, intent.Reference); err != nil {
+		t.Fatalf("confirm intent: %v", err)
+	}
+	if _, err := d.Exec(c, "UPDATE payments SET provider = 'mock', provider_ref = $2 WHERE target_type = 'ticket' AND target_id = $1", ticketId, intent.Reference); err != nil {
+		t.Fatalf("failed to link payment: %v", err)
+	}
+	if _, err := d.Exec(c, "UPDATE zones SET price_offset = 0, price_lin = 1000, price_exp = 1 WHERE id = (SELECT zone_id FROM tickets WHERE id = $1)", ticketId); err != nil {
+		t.Fatalf("failed to set zone prices: %v", err)
+	}
+
+	tickets := NewTicketDao()
+	if _, err := tickets.PayTicket(c, ticketId); err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+	if _, err := tickets.ExtendTicket(c, "race", ticketId, 60); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+
+	refundDao := &RefundDao{db: *d, provider: provider}
+	refunds, err := refundDao.RefundTicket(c, "admin", ticketId, api.RefundRequest{Reason: "test"})
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if len(refunds) != 1 || refunds[0].Amount.Amount != 500 {
+		t.Fatalf("refunds %+v, want one of 500", refunds)
+	}
+
+	refunded, err := tickets.GetTicketById(c, ticketId)
+	if err != nil {
+		t.Fatalf("get ticket: %v", err)
+	}
+	if refunded.RefundStatus != TicketRefundStatusRefunded {
+		t.Fatalf("refund status %q, want %q", refunded.RefundStatus, TicketRefundStatusRefunded)
+	}
+	if refunded.EndDate.After(time.Now()) {
+		t.Fatalf("refunded ticket still ends at %v", refunded.EndDate)
+	}
+	if coveredAt(refunded, time.Now().Add(10*time.Minute)) {
+		t.Fatal("fully refunded ticket still covers the car")
+	}
+	if _, err := NewPaymentDao().amountDue(c, PaymentTargetTicket, ticketId); !errors.Is(err, ErrNothingToPay) {
+		t.Fatalf("expected ErrNothingToPay, got %v", err)
+	}
+}
+
+func TestPartlyFailedRefundKeepsParkingTime(t *testing.T) {
+	connectTestDB(t)
+	ticketId, _ := payFixture(t)
+	c := context.Background()
+	d := db.GetDB()
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM refunds WHERE ticket_id = $1", ticketId)
+	})
+
+	// 2.00 of the 5.00 were paid through the provider, which does not know
+	// the newer 3.00 payment and fails its refund
+	provider := payments.NewMockProvider("")
+	intent, err := provider.CreateIntent(c, money.New(200, "EUR"), "refund test")
+	if err != nil {
+		t.Fatalf("create intent: %v", err)
+	}
+	if _, err := provider.Confirm(c, intent.Reference); err != nil {
+		t.Fatalf("confirm intent: %v", err)
+	}
+	if _, err := d.Exec(c, "UPDATE payments SET amount = 200, provider = 'mock', provider_ref = $2 WHERE target_type = 'ticket' AND target_id = $1", ticketId, intent.Reference); err != nil {
+		t.Fatalf("failed to link payment: %v", err)
+	}
+	paymentQuery := "INSERT INTO payments (target_type, target_id, username, amount, currency, provider, provider_ref, status) VALUES ($1, $2, 'race', 300, 'EUR', 'mock', $3, 'confirmed')"
+	if _, err := d.Exec(c, paymentQuery, PaymentTargetTicket, ticketId, fmt.Sprintf("unknown-%d", ticketId)); err != nil {
+		t.Fatalf("failed to create payment: %v", err)
+	}
+
+	tickets := NewTicketDao()
+	paid, err := tickets.PayTicket(c, ticketId)
+	if err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+
+	refundDao := &RefundDao{db: *d, provider: provider}
+	if _, err := refundDao.RefundTicket(c, "admin", ticketId, api.RefundRequest{Reason: "test"}); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+
+	refunded, err := tickets.GetTicketById(c, ticketId)
+	if err != nil {
+		t.Fatalf("get ticket: %v", err)
+	}
+	if refunded.Refunded.Amount != 200 || refunded.RefundStatus != TicketRefundStatusPartial {
+		t.Fatalf("refunded %d %q, want 200 %q", refunded.Refunded.Amount, refunded.RefundStatus, TicketRefundStatusPartial)
+	}
+	if !refunded.EndDate.Equal(paid.EndDate) {
+		t.Fatalf("end date %v, want the original %v", refunded.EndDate, paid.EndDate)
+	}
+	if !coveredAt(refunded, time.Now().Add(10*time.Minute)) {
+		t.Fatal("partly refunded ticket no longer covers the car")
+	}
+}
+
+func TestPendingRefundStaysReservedUntilItFails(t *testing.T) {
+	connectTestDB(t)
+	ticketId, _ := payFixture(t)
+	c := context.Background()
+	d := db.GetDB()
+	t.Cleanup(func() {
+		d.Exec(c, "DELETE FROM refunds WHERE ticket_id = $1", ticketId)
+	})
+
+	provider := payments.NewMockProvider("")
+	intent, err := provider.CreateIntent(c, money.New(500, "EUR"), "refund test")
+	if err != nil {
+		t.Fatalf("create intent: %v", err)
+	}
+	if _, err := provider.Confirm(c, intent.Reference); err != nil {
+		t.Fatalf("confirm intent: %v", err)
+	}
+	refund, err := provider.Refund(c, intent.Reference, money.New(200, "EUR"))
+	if err != nil {
+		t.Fatalf("refund intent: %v", err)
+	}
+
+	// A 2.00 refund the provider has not paid out yet
+	var paymentId int64
+	if err := d.QueryRow(c, "UPDATE payments SET provider = 'mock', provider_ref = $2 WHERE target_type = 'ticket' AND target_id = $1 RETURNING id", ticketId, intent.Reference).Scan(&paymentId); err != nil {
+		t.Fatalf("failed to link payment: %v", err)
+	}
+	if _, err := d.Exec(c, "UPDATE tickets SET refunded_amount = 200, refund_status = 'partial' WHERE id = $1", ticketId); err != nil {
+		t.Fatalf("failed to reserve refund: %v", err)
+	}
+	refundQuery := "INSERT INTO refunds (payment_id, ticket_id, amount, currency, reason, provider_ref, status, created_by) VALUES ($1, $2, 200, 'EUR', 'test', $3, 'pending', 'admin')"
+	if _, err := d.Exec(c, refundQuery, paymentId, ticketId, refund.Reference); err != nil {
+		t.Fatalf("failed to add refund: %v", err)
+	}
+
+	refundDao := &RefundDao{db: *d, provider: provider}
+	left, err := refundDao.refundablePayments(c, ticketId)
+	if err != nil {
+		t.Fatalf("refundable payments: %v", err)
+	}
+	if len(left) != 1 || left[0].refundable.Amount != 300 {
+		t.Fatalf("refundable %+v, want 300 left", left)
+	}
+
+	if err := provider.FailRefund(refund.Reference); err != nil {
+		t.Fatalf("fail refund: %v", err)
+	}
+	if err := refundDao.SettlePendingRefunds(c); err != nil {
+		t.Fatalf("settle pending refunds: %v", err)
+	}
+
+	ticket, err := NewTicketDao().GetTicketById(c, ticketId)
+	if err != nil {
+		t.Fatalf("get ticket: %v", err)
+	}
+	if ticket.Refunded.Amount != 0 || ticket.RefundStatus != TicketRefundStatusNone {
+		t.Fatalf("refunded %d %q after the refund failed, want 0 %q", ticket.Refunded.Amount, ticket.RefundStatus, TicketRefundStatusNone)
+	}
+	left, err = refundDao.refundablePayments(c, ticketId)
+	if err != nil {
+		t.Fatalf("refundable payments: %v", err)
+	}
+	if len(left) != 1 || left[0].refundable.Amount != 500 {
+		t.Fatalf("refundable %+v, want 500 left", left)
+	}
+}
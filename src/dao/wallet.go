@@ -0,0 +1,258 @@
+package dao
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/db"
+	"OPP/backend/money"
+	"OPP/backend/payments"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PaymentProviderWallet is the provider of the payments made from wallets,
+// their provider_ref is the ledger transaction
+const PaymentProviderWallet = "wallet"
+
+// Statuses of wallet top-ups
+const (
+	WalletTopUpStatusPending  = "pending"
+	WalletTopUpStatusCredited = "credited"
+)
+
+// Kinds of ledger transactions
+const (
+	LedgerTopUp   = "topup"
+	LedgerPayment = "payment"
+	LedgerRefund  = "refund"
+)
+
+// Ledger accounts money moves between, besides wallets
+const (
+	ledgerAccountWallet   = "wallet"
+	ledgerAccountProvider = "provider"
+	ledgerAccountRevenue  = "revenue"
+)
+
+var (
+	ErrWalletTopUpNotFound     = errors.New("wallet top-up not found")
+	ErrWalletTopUpInvalid      = errors.New("invalid wallet top-up")
+	ErrWalletInsufficientFunds = errors.New("insufficient wallet balance")
+)
+
+type WalletDao struct {
+	db db.DB
+}
+
+func NewWalletDao() *WalletDao {
+	return &WalletDao{
+		db: *db.GetDB(),
+	}
+}
+
+// GetUserWallet returns the balances of the wallets of a user
+func (d *WalletDao) GetUserWallet(c context.Context, username string) (*api.Wallet, error) {
+	query := "SELECT balance, currency FROM wallets WHERE username = $1 ORDER BY currency"
+	rows, err := d.db.Query(c, query, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query wallets: %w", err)
+	}
+	defer rows.Close()
+
+	wallet := &api.Wallet{Balances: []api.Money{}}
+	for rows.Next() {
+		var balance api.Money
+		if err := rows.Scan(&balance.Amount, &balance.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan wallet: %w", err)
+		}
+		wallet.Balances = append(wallet.Balances, balance)
+	}
+	return wallet, nil
+}
+
+// GetUserWalletTransactions lists the movements of the wallets of a user,
+// newest first, with the balance after each
+func (d *WalletDao) GetUserWalletTransactions(c context.Context, username string, limit *int, offset *int) ([]api.WalletTransaction, error) {
+	query := `
+		SELECT t.id, t.kind, e.amount, e.currency, SUM(e.amount) OVER (PARTITION BY e.wallet_id ORDER BY e.id), t.target_type, t.target_id, t.creation_time
+		FROM ledger_entries AS e
+		JOIN ledger_transactions AS t ON t.id = e.transaction_id
+		JOIN wallets AS w ON w.id = e.wallet_id
+		WHERE w.username = $1
+		ORDER BY e.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	params := []any{username, 20, 0}
+	if limit != nil {
+		params[1] = *limit
+	}
+	if offset != nil {
+		params[2] = *offset
+	}
+
+	rows, err := d.db.Query(c, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query wallet transactions: %w", err)
+	}
+	defer rows.Close()
+
+	transactions := []api.WalletTransaction{}
+	for rows.Next() {
+		var transaction api.WalletTransaction
+		if err := rows.Scan(&transaction.Id, &transaction.Kind, &transaction.Amount.Amount, &transaction.Amount.Currency, &transaction.Balance.Amount, &transaction.TargetType, &transaction.TargetId, &transaction.CreationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan wallet transaction: %w", err)
+		}
+		transaction.Balance.Currency = transaction.Amount.Currency
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
+
+// CreateWalletTopUp opens a payment with the provider to credit amount to
+// the wallet of a user, the wallet is credited when the payment is
+// confirmed
+func (d *WalletDao) CreateWalletTopUp(c context.Context, username string, amount api.Money) (*api.Payment, error) {
+	if err := NewPaymentDao().checkProvider(); err != nil {
+		return nil, err
+	}
+	if amount.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrWalletTopUpInvalid)
+	}
+	if err := money.ValidateCurrency(amount.Currency); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWalletTopUpInvalid, err)
+	}
+
+	// Creates the wallet on its first top-up
+	walletQuery := `
+		INSERT INTO wallets (username, currency) VALUES ($1, $2)
+		ON CONFLICT (username, currency) DO UPDATE SET username = EXCLUDED.username
+		RETURNING id
+	`
+	var walletId int64
+	if err := d.db.QueryRow(c, walletQuery, username, amount.Currency).Scan(&walletId); err != nil {
+		return nil, fmt.Errorf("failed to get wallet: %w", err)
+	}
+
+	// A top-up whose payment is never confirmed is never credited
+	topUpQuery := "INSERT INTO wallet_topups (wallet_id, amount, currency) VALUES ($1, $2, $3) RETURNING id"
+	var topUpId int64
+	if err := d.db.QueryRow(c, topUpQuery, walletId, amount.Amount, amount.Currency).Scan(&topUpId); err != nil {
+		return nil, fmt.Errorf("failed to add wallet top-up: %w", err)
+	}
+
+	return NewPaymentDao().CreatePayment(c, username, PaymentTargetWalletTopUp, topUpId)
+}
+
+// creditTopUp credits a top-up to its wallet once its confirmed payments
+// cover it. It returns false when they do not or it was already credited.
+func (d *WalletDao) creditTopUp(c context.Context, topUpId int64) (bool, error) {
+	query := `
+		UPDATE wallet_topups SET status = 'credited', credited_at = NOW()
+		WHERE id = $2 AND status = 'pending' AND amount <= (` + confirmedPaymentsQuery + `)
+		RETURNING wallet_id, amount, currency
+	`
+	var walletId int64
+	var amount money.Money
+	if err := d.db.QueryRow(c, query, PaymentTargetWalletTopUp, topUpId).Scan(&walletId, &amount.Amount, &amount.Currency); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to credit wallet top-up: %w", err)
+	}
+
+	if _, err := d.post(c, LedgerTopUp, PaymentTargetWalletTopUp, topUpId, walletId, ledgerAccountProvider, amount); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// post records a ledger transaction moving amount into a wallet from
+// account, or out of it to account when amount is negative, and updates
+// the wallet balance. The balance is checked and updated by a single
+// statement, so concurrent debits can never overdraw the wallet.
+func (d *WalletDao) post(c context.Context, kind string, targetType string, targetId int64, walletId int64, account string, amount money.Money) (int64, error) {
+	var transactionId int64
+	err := d.db.WithTx(c, func(c context.Context) error {
+		balanceQuery := "UPDATE wallets SET balance = balance + $2 WHERE id = $1 AND currency = $3 AND balance + $2 >= 0"
+		result, err := d.db.Exec(c, balanceQuery, walletId, amount.Amount, amount.Currency)
+		if err != nil {
+			return fmt.Errorf("failed to update wallet balance: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrWalletInsufficientFunds
+		}
+
+		transactionQuery := "INSERT INTO ledger_transactions (kind, target_type, target_id) VALUES ($1, $2, $3) RETURNING id"
+		if err := d.db.QueryRow(c, transactionQuery, kind, targetType, targetId).Scan(&transactionId); err != nil {
+			return fmt.Errorf("failed to add ledger transaction: %w", err)
+		}
+
+		// Both sides of the transaction, they sum to zero
+		entryQuery := "INSERT INTO ledger_entries (transaction_id, account, wallet_id, amount, currency) VALUES ($1, $2, $3, $4, $5), ($1, $6, NULL, $7, $5)"
+		if _, err := d.db.Exec(c, entryQuery, transactionId, ledgerAccountWallet, walletId, amount.Amount, amount.Currency, account, -amount.Amount); err != nil {
+			return fmt.Errorf("failed to add ledger entries: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return transactionId, nil
+}
+
+// ledgerReference is the provider_ref of the payments and refunds made
+// through a ledger transaction
+func ledgerReference(transactionId int64) string {
+	return fmt.Sprintf("ledger_%d", transactionId)
+}
+
+// payFromWallet pays what is left due on a target from the wallet of a
+// user, in the currency of the target, with a confirmed payment, and
+// returns its id. The caller settles the target within its transaction, and
+// bills the payment once that is committed.
+func (d *WalletDao) payFromWallet(c context.Context, username string, targetType string, targetId int64, due money.Money) (int64, error) {
+	var paymentId int64
+	err := d.db.WithTx(c, func(c context.Context) error {
+		walletQuery := "SELECT id FROM wallets WHERE username = $1 AND currency = $2"
+		var walletId int64
+		if err := d.db.QueryRow(c, walletQuery, username, due.Currency).Scan(&walletId); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrWalletInsufficientFunds
+			}
+			return fmt.Errorf("failed to get wallet: %w", err)
+		}
+
+		transactionId, err := d.post(c, LedgerPayment, targetType, targetId, walletId, ledgerAccountRevenue, money.New(-due.Amount, due.Currency))
+		if err != nil {
+			return err
+		}
+
+		paymentQuery := "INSERT INTO payments (target_type, target_id, username, amount, currency, provider, provider_ref, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id"
+		if err := d.db.QueryRow(c, paymentQuery, targetType, targetId, username, due.Amount, due.Currency, PaymentProviderWallet, ledgerReference(transactionId), payments.StatusConfirmed).Scan(&paymentId); err != nil {
+			return fmt.Errorf("failed to add wallet payment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return paymentId, nil
+}
+
+// refundToWallet gives back part of a payment made from a wallet to that
+// wallet, and returns the reference of the refund
+func (d *WalletDao) refundToWallet(c context.Context, paymentId int64, ticketId int64, amount money.Money) (string, error) {
+	query := "SELECT w.id FROM payments AS p JOIN wallets AS w ON w.username = p.username AND w.currency = p.currency WHERE p.id = $1"
+	var walletId int64
+	if err := d.db.QueryRow(c, query, paymentId).Scan(&walletId); err != nil {
+		return "", fmt.Errorf("failed to get wallet of payment: %w", err)
+	}
+
+	transactionId, err := d.post(c, LedgerRefund, PaymentTargetTicket, ticketId, walletId, ledgerAccountRevenue, amount)
+	if err != nil {
+		return "", err
+	}
+	return ledgerReference(transactionId), nil
+}
@@ -3,6 +3,7 @@ package dao
 import (
 	"OPP/backend/api"
 	"OPP/backend/db"
+	"OPP/backend/money"
 	"context"
 	"errors"
 	"fmt"
@@ -18,6 +19,8 @@ var (
 	ErrZoneUserRoleNotFound      = errors.New("zone user role not found")
 	ErrZoneUserRoleAlreadyExists = errors.New("zone user role already exists")
 	ErrZoneUserRoleInvalid       = errors.New("invalid zone user role")
+	ErrZonePricingInvalid        = errors.New("invalid zone pricing")
+	ErrZoneCapacityInvalid       = errors.New("invalid zone capacity")
 )
 
 type ZoneDao struct {
@@ -30,7 +33,42 @@ func NewZoneDao() *ZoneDao {
 	}
 }
 
+// zoneMoney returns the currency and rounding rule of a zone request,
+// defaulting the ones that are not set
+func zoneMoney(zone api.ZoneRequest) (string, money.Rounding, error) {
+	currency := money.DefaultCurrency
+	if zone.Currency != nil {
+		currency = *zone.Currency
+	}
+	rounding := money.DefaultRounding
+	if zone.Rounding != nil {
+		rounding.Mode = *zone.Rounding
+	}
+	if zone.RoundingIncrement != nil {
+		rounding.Increment = int64(*zone.RoundingIncrement)
+	}
+
+	if err := money.ValidateCurrency(currency); err != nil {
+		return "", rounding, fmt.Errorf("%w: %v", ErrZonePricingInvalid, err)
+	}
+	if err := rounding.Validate(); err != nil {
+		return "", rounding, fmt.Errorf("%w: %v", ErrZonePricingInvalid, err)
+	}
+	if zone.PriceOffset < 0 || zone.PriceLin < 0 {
+		return "", rounding, fmt.Errorf("%w: prices must not be negative", ErrZonePricingInvalid)
+	}
+	return currency, rounding, nil
+}
+
 func (z *ZoneDao) CreateZone(c context.Context, zone api.ZoneRequest) (*api.ZoneResponse, error) {
+	currency, rounding, err := zoneMoney(zone)
+	if err != nil {
+		return nil, err
+	}
+	if zone.Capacity != nil && *zone.Capacity <= 0 {
+		return nil, fmt.Errorf("%w: capacity must be positive", ErrZoneCapacityInvalid)
+	}
+
 	query := `
 		INSERT INTO zones (
 			name, 
@@ -39,9 +77,14 @@ func (z *ZoneDao) CreateZone(c context.Context, zone api.ZoneRequest) (*api.Zone
 			metadata, 
 			price_offset, 
 			price_lin, 
-			price_exp
+			price_exp,
+			currency,
+			rounding,
+			rounding_increment,
+			capacity,
+			refuse_when_full
 		) 
-		VALUES ($1, $2, ST_GeomFromGeoJSON($3), $4, $5, $6, $7) 
+		VALUES ($1, $2, ST_GeomFromGeoJSON($3), $4, $5, $6, $7, $8, $9, $10, $11, COALESCE($12, FALSE)) 
 		RETURNING 
 			id, 
 			name, 
@@ -52,7 +95,13 @@ func (z *ZoneDao) CreateZone(c context.Context, zone api.ZoneRequest) (*api.Zone
 			updated_at, 
 			price_offset, 
 			price_lin, 
-			price_exp
+			price_exp,
+			currency,
+			rounding,
+			rounding_increment,
+			capacity,
+			refuse_when_full,
+			price_level
 	`
 
 	row := z.db.QueryRow(
@@ -65,12 +114,17 @@ func (z *ZoneDao) CreateZone(c context.Context, zone api.ZoneRequest) (*api.Zone
 		zone.PriceOffset,
 		zone.PriceLin,
 		zone.PriceExp,
+		currency,
+		rounding.Mode,
+		rounding.Increment,
+		zone.Capacity,
+		zone.RefuseWhenFull,
 	)
 
 	var response api.ZoneResponse
 	var geometryJSON string
 
-	err := row.Scan(
+	err = row.Scan(
 		&response.Id,
 		&response.Name,
 		&response.Available,
@@ -81,6 +135,12 @@ func (z *ZoneDao) CreateZone(c context.Context, zone api.ZoneRequest) (*api.Zone
 		&response.PriceOffset,
 		&response.PriceLin,
 		&response.PriceExp,
+		&response.Currency,
+		&response.Rounding,
+		&response.RoundingIncrement,
+		&response.Capacity,
+		&response.RefuseWhenFull,
+		&response.PriceLevel,
 	)
 
 	if err != nil {
@@ -109,7 +169,13 @@ func (z *ZoneDao) GetAllZones(c context.Context) ([]api.ZoneResponse, error) {
 			updated_at, 
 			price_offset, 
 			price_lin, 
-			price_exp
+			price_exp,
+			currency,
+			rounding,
+			rounding_increment,
+			capacity,
+			refuse_when_full,
+			price_level
 		FROM zones
 	`
 
@@ -135,6 +201,12 @@ func (z *ZoneDao) GetAllZones(c context.Context) ([]api.ZoneResponse, error) {
 			&zone.PriceOffset,
 			&zone.PriceLin,
 			&zone.PriceExp,
+			&zone.Currency,
+			&zone.Rounding,
+			&zone.RoundingIncrement,
+			&zone.Capacity,
+			&zone.RefuseWhenFull,
+			&zone.PriceLevel,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan zone: %w", err)
 		}
@@ -158,7 +230,13 @@ func (z *ZoneDao) GetZoneById(c context.Context, id int64) (*api.ZoneResponse, e
 			updated_at, 
 			price_offset, 
 			price_lin, 
-			price_exp
+			price_exp,
+			currency,
+			rounding,
+			rounding_increment,
+			capacity,
+			refuse_when_full,
+			price_level
 		FROM zones
 		WHERE id = $1
 	`
@@ -179,6 +257,12 @@ func (z *ZoneDao) GetZoneById(c context.Context, id int64) (*api.ZoneResponse, e
 		&zone.PriceOffset,
 		&zone.PriceLin,
 		&zone.PriceExp,
+		&zone.Currency,
+		&zone.Rounding,
+		&zone.RoundingIncrement,
+		&zone.Capacity,
+		&zone.RefuseWhenFull,
+		&zone.PriceLevel,
 	); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrZoneNotFound
@@ -191,6 +275,14 @@ func (z *ZoneDao) GetZoneById(c context.Context, id int64) (*api.ZoneResponse, e
 }
 
 func (z *ZoneDao) UpdateZone(c context.Context, id int64, zone api.ZoneRequest) (*api.ZoneResponse, error) {
+	// Currency and rounding are only changed when set
+	if _, _, err := zoneMoney(zone); err != nil {
+		return nil, err
+	}
+	if zone.Capacity != nil && *zone.Capacity <= 0 {
+		return nil, fmt.Errorf("%w: capacity must be positive", ErrZoneCapacityInvalid)
+	}
+
 	query := `
 		UPDATE zones 
 		SET 
@@ -201,8 +293,13 @@ func (z *ZoneDao) UpdateZone(c context.Context, id int64, zone api.ZoneRequest)
 			updated_at = NOW(),
 			price_offset = $5,
 			price_lin = $6,
-			price_exp = $7
-		WHERE id = $8
+			price_exp = $7,
+			currency = COALESCE($8, currency),
+			rounding = COALESCE($9, rounding),
+			rounding_increment = COALESCE($10, rounding_increment),
+			capacity = COALESCE($12, capacity),
+			refuse_when_full = COALESCE($13, refuse_when_full)
+		WHERE id = $11
 		RETURNING 
 			id, 
 			name, 
@@ -213,7 +310,13 @@ func (z *ZoneDao) UpdateZone(c context.Context, id int64, zone api.ZoneRequest)
 			updated_at, 
 			price_offset, 
 			price_lin, 
-			price_exp
+			price_exp,
+			currency,
+			rounding,
+			rounding_increment,
+			capacity,
+			refuse_when_full,
+			price_level
 	`
 
 	row := z.db.QueryRow(
@@ -226,7 +329,12 @@ func (z *ZoneDao) UpdateZone(c context.Context, id int64, zone api.ZoneRequest)
 		zone.PriceOffset,
 		zone.PriceLin,
 		zone.PriceExp,
+		zone.Currency,
+		zone.Rounding,
+		zone.RoundingIncrement,
 		id,
+		zone.Capacity,
+		zone.RefuseWhenFull,
 	)
 
 	var updatedZone api.ZoneResponse
@@ -243,6 +351,12 @@ func (z *ZoneDao) UpdateZone(c context.Context, id int64, zone api.ZoneRequest)
 		&updatedZone.PriceOffset,
 		&updatedZone.PriceLin,
 		&updatedZone.PriceExp,
+		&updatedZone.Currency,
+		&updatedZone.Rounding,
+		&updatedZone.RoundingIncrement,
+		&updatedZone.Capacity,
+		&updatedZone.RefuseWhenFull,
+		&updatedZone.PriceLevel,
 	); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrZoneNotFound
@@ -287,7 +401,13 @@ func (z *ZoneDao) GetZoneByName(c context.Context, name string) (*api.ZoneRespon
 			updated_at, 
 			price_offset, 
 			price_lin, 
-			price_exp
+			price_exp,
+			currency,
+			rounding,
+			rounding_increment,
+			capacity,
+			refuse_when_full,
+			price_level
 		FROM zones
 		WHERE name = $1
 	`
@@ -308,6 +428,12 @@ func (z *ZoneDao) GetZoneByName(c context.Context, name string) (*api.ZoneRespon
 		&zone.PriceOffset,
 		&zone.PriceLin,
 		&zone.PriceExp,
+		&zone.Currency,
+		&zone.Rounding,
+		&zone.RoundingIncrement,
+		&zone.Capacity,
+		&zone.RefuseWhenFull,
+		&zone.PriceLevel,
 	); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrZoneNotFound
@@ -345,6 +471,12 @@ func (z *ZoneDao) FindAllZonesContainingPoint(c context.Context, longitude float
             price_offset, 
             price_lin, 
             price_exp,
+            currency,
+            rounding,
+            rounding_increment,
+            capacity,
+            refuse_when_full,
+            price_level,
             ST_AsGeoJSON(geometry) AS geometry,
             metadata,
             created_at,
@@ -371,6 +503,12 @@ func (z *ZoneDao) FindAllZonesContainingPoint(c context.Context, longitude float
 			&zone.PriceOffset,
 			&zone.PriceLin,
 			&zone.PriceExp,
+			&zone.Currency,
+			&zone.Rounding,
+			&zone.RoundingIncrement,
+			&zone.Capacity,
+			&zone.RefuseWhenFull,
+			&zone.PriceLevel,
 			&geometryJSON,
 			&zone.Metadata,
 			&zone.CreatedAt,
@@ -400,6 +538,12 @@ func (z *ZoneDao) IsCoordinateInZone(c context.Context, latitude float64, longit
             price_offset, 
             price_lin, 
             price_exp,
+            currency,
+            rounding,
+            rounding_increment,
+            capacity,
+            refuse_when_full,
+            price_level,
             ST_AsGeoJSON(geometry) AS geometry,
             metadata,
             created_at,
@@ -421,6 +565,12 @@ func (z *ZoneDao) IsCoordinateInZone(c context.Context, latitude float64, longit
 		&zone.PriceOffset,
 		&zone.PriceLin,
 		&zone.PriceExp,
+		&zone.Currency,
+		&zone.Rounding,
+		&zone.RoundingIncrement,
+		&zone.Capacity,
+		&zone.RefuseWhenFull,
+		&zone.PriceLevel,
 		&geometryJSON,
 		&zone.Metadata,
 		&zone.CreatedAt,
@@ -557,7 +707,13 @@ func (z *ZoneDao) GetUserZones(c context.Context, username string) ([]api.ZoneRe
 			z.updated_at, 
 			z.price_offset, 
 			z.price_lin, 
-			z.price_exp
+			z.price_exp,
+			z.currency,
+			z.rounding,
+			z.rounding_increment,
+			z.capacity,
+			z.refuse_when_full,
+			z.price_level
 		FROM zones z
 		JOIN zone_user_roles zur ON z.id = zur.zone_id
 		WHERE zur.user_id = $1
@@ -585,6 +741,12 @@ func (z *ZoneDao) GetUserZones(c context.Context, username string) ([]api.ZoneRe
 			&zone.PriceOffset,
 			&zone.PriceLin,
 			&zone.PriceExp,
+			&zone.Currency,
+			&zone.Rounding,
+			&zone.RoundingIncrement,
+			&zone.Capacity,
+			&zone.RefuseWhenFull,
+			&zone.PriceLevel,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan user zone: %w", err)
 		}
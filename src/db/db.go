@@ -21,15 +21,25 @@ var instance *DB
 var once sync.Once
 var initErr error
 
-var schemaPath = "postgres_schema_v1.sql"
-
 var OPP_BACKEND_DB_HOST = os.Getenv("OPP_BACKEND_DB_HOST")
 var OPP_BACKEND_DB_PORT = os.Getenv("OPP_BACKEND_DB_PORT")
 var POSTGRES_BACKEND_USER = os.Getenv("POSTGRES_BACKEND_USER")
 var POSTGRES_BACKEND_PASSWORD = os.Getenv("POSTGRES_BACKEND_PASSWORD")
 var POSTGRES_BACKEND_DB = os.Getenv("POSTGRES_BACKEND_DB")
 
+// Init connects to the database and applies pending migrations
 func Init() error {
+	if err := Connect(); err != nil {
+		return err
+	}
+	if err := instance.MigrateUp(context.Background()); err != nil {
+		return fmt.Errorf("failed to apply database migrations: %w", err)
+	}
+	return nil
+}
+
+// Connect creates the connection pool without touching the schema
+func Connect() error {
 	once.Do(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -52,21 +62,6 @@ func Init() error {
 			return
 		}
 
-		// Read schema file
-		schemaSQL, err := os.ReadFile("db/" + schemaPath)
-		if err != nil {
-			pool.Close()
-			initErr = fmt.Errorf("failed to read schema file: %w", err)
-			return
-		}
-		// Apply schema
-		_, err = pool.Exec(ctx, string(schemaSQL))
-		if err != nil {
-			pool.Close()
-			initErr = fmt.Errorf("failed to apply database schema: %w", err)
-			return
-		}
-
 		instance = &DB{pool: pool}
 		fmt.Println("Database connection pool created successfully")
 	})
@@ -85,7 +80,47 @@ func (d *DB) Close() {
 	}
 }
 
+// txKey carries the transaction of a context, see WithTx
+type txKey struct{}
+
+func txFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// WithTx runs fn in a transaction, committed when fn returns nil and rolled
+// back otherwise. Queries made with the context given to fn run in the
+// transaction, whichever DAO makes them, and a WithTx nested in fn joins
+// the outer transaction. The transaction holds a single connection, rows
+// must be closed before the next query.
+func (d *DB) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); ok {
+		return fn(ctx)
+	}
+	if d.pool == nil {
+		return pgx.ErrTxClosed
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// No-op once committed
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 func (d *DB) Query(ctx context.Context, query string, args ...any) (pgx.Rows, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.Query(ctx, query, args...)
+	}
 	if d.pool == nil {
 		return nil, pgx.ErrTxClosed
 	}
@@ -93,6 +128,9 @@ func (d *DB) Query(ctx context.Context, query string, args ...any) (pgx.Rows, er
 }
 
 func (d *DB) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.QueryRow(ctx, query, args...)
+	}
 	if d.pool == nil {
 		return nil
 	}
@@ -100,6 +138,9 @@ func (d *DB) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
 }
 
 func (d *DB) Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.Exec(ctx, query, args...)
+	}
 	if d.pool == nil {
 		return pgconn.CommandTag{}, pgx.ErrTxClosed
 	}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+
+	for i, m := range migrations {
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("migration %d %q lacks an up or a down file", m.Version, m.Name)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("migration %d %q follows %d", m.Version, m.Name, migrations[i-1].Version)
+		}
+	}
+
+	// Every file belongs to exactly one loaded migration
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2*len(migrations) {
+		t.Fatalf("%d migration files for %d migrations, want 2 each", len(files), len(migrations))
+	}
+}
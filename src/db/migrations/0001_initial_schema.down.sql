@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS totems;
+DROP TABLE IF EXISTS fines;
+DROP TABLE IF EXISTS tickets;
+DROP TABLE IF EXISTS zone_user_roles;
+DROP TABLE IF EXISTS zones;
+DROP TABLE IF EXISTS cars;
@@ -74,4 +74,4 @@ CREATE TABLE IF NOT EXISTS totems (
     location GEOMETRY(POINT, 4326) GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)) STORED,
     registration_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
     FOREIGN KEY (zone_id) REFERENCES zones(id) ON DELETE CASCADE
-);
\ No newline at end of file
+);
@@ -0,0 +1,24 @@
+-- Parking sessions table
+-- Open-ended parking: started and stopped by the driver, billed per minute.
+-- status: active -> stopped (price computed) -> settled (paid)
+CREATE TABLE IF NOT EXISTS parking_sessions (
+    id SERIAL PRIMARY KEY,
+    zone_id INTEGER NOT NULL,
+    plate TEXT NOT NULL,
+    start_date TIMESTAMP NOT NULL,
+    end_date TIMESTAMP,
+    max_end_date TIMESTAMP NOT NULL,
+    price REAL,
+    status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'stopped', 'settled')),
+    creation_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (plate) REFERENCES cars(plate) ON DELETE CASCADE,
+    FOREIGN KEY (zone_id) REFERENCES zones(id) ON DELETE CASCADE,
+    CONSTRAINT stopped_session_has_end CHECK (status = 'active' OR (end_date IS NOT NULL AND price IS NOT NULL))
+);
+
+-- A plate can only have one active session at a time
+CREATE UNIQUE INDEX IF NOT EXISTS parking_sessions_one_active_per_plate
+    ON parking_sessions (plate) WHERE status = 'active';
+
+CREATE INDEX IF NOT EXISTS parking_sessions_active_max_end
+    ON parking_sessions (max_end_date) WHERE status = 'active';
@@ -0,0 +1,14 @@
+-- Ticket extensions table
+-- One line item per extension, price is the incremental amount charged
+CREATE TABLE IF NOT EXISTS ticket_extensions (
+    id SERIAL PRIMARY KEY,
+    ticket_id INTEGER NOT NULL,
+    duration INTEGER NOT NULL CHECK (duration > 0),
+    previous_end_date TIMESTAMP NOT NULL,
+    new_end_date TIMESTAMP NOT NULL,
+    price REAL NOT NULL,
+    creation_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (ticket_id) REFERENCES tickets(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS ticket_extensions_ticket_id ON ticket_extensions (ticket_id);
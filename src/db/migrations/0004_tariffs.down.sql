@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS tariff_bands;
+DROP TABLE IF EXISTS zone_tariffs;
+DROP TABLE IF EXISTS holidays;
+DROP TABLE IF EXISTS holiday_calendars;
@@ -0,0 +1,39 @@
+-- Holiday calendars, shared by the zones of a municipality
+CREATE TABLE IF NOT EXISTS holiday_calendars (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS holidays (
+    calendar_id INTEGER NOT NULL REFERENCES holiday_calendars(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (calendar_id, date)
+);
+
+-- Zone tariffs table
+-- A zone with a tariff is priced by its rate bands instead of the
+-- price_offset/price_lin/price_exp formula
+CREATE TABLE IF NOT EXISTS zone_tariffs (
+    zone_id INTEGER PRIMARY KEY REFERENCES zones(id) ON DELETE CASCADE,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    daily_max REAL CHECK (daily_max >= 0),
+    holiday_calendar_id INTEGER REFERENCES holiday_calendars(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+-- Tariff bands table
+-- days follow Go time.Weekday (0 = Sunday), 7 is a public holiday
+-- start_minute/end_minute are minutes from midnight, time outside bands is free
+CREATE TABLE IF NOT EXISTS tariff_bands (
+    id SERIAL PRIMARY KEY,
+    zone_id INTEGER NOT NULL REFERENCES zone_tariffs(zone_id) ON DELETE CASCADE,
+    name TEXT NOT NULL DEFAULT '',
+    days INTEGER[] NOT NULL,
+    start_minute INTEGER NOT NULL CHECK (start_minute >= 0 AND start_minute < 1440),
+    end_minute INTEGER NOT NULL CHECK (end_minute > start_minute AND end_minute <= 1440),
+    rate_per_hour REAL NOT NULL CHECK (rate_per_hour >= 0),
+    CONSTRAINT valid_days CHECK (days <@ ARRAY[0, 1, 2, 3, 4, 5, 6, 7])
+);
+
+CREATE INDEX IF NOT EXISTS tariff_bands_zone_id ON tariff_bands (zone_id);
@@ -0,0 +1,34 @@
+ALTER TABLE zone_tariffs
+    ALTER COLUMN daily_max TYPE REAL USING daily_max / 100.0;
+
+ALTER TABLE tariff_bands
+    ALTER COLUMN rate_per_hour TYPE REAL USING rate_per_hour / 100.0;
+
+ALTER TABLE parking_sessions
+    DROP COLUMN currency,
+    ALTER COLUMN price TYPE REAL USING price / 100.0;
+
+ALTER TABLE fines
+    DROP COLUMN currency,
+    ALTER COLUMN amount TYPE REAL USING amount / 100.0;
+
+ALTER TABLE ticket_extensions
+    ALTER COLUMN price TYPE REAL USING price / 100.0;
+
+ALTER TABLE tickets
+    DROP COLUMN currency,
+    ALTER COLUMN price TYPE REAL USING price / 100.0;
+
+ALTER TABLE zones
+    ALTER COLUMN price_offset DROP DEFAULT,
+    ALTER COLUMN price_lin DROP DEFAULT;
+
+ALTER TABLE zones
+    DROP COLUMN rounding_increment,
+    DROP COLUMN rounding,
+    DROP COLUMN currency,
+    ALTER COLUMN price_offset TYPE REAL USING price_offset / 100.0,
+    ALTER COLUMN price_lin TYPE REAL USING price_lin / 100.0,
+    ALTER COLUMN price_exp TYPE REAL,
+    ALTER COLUMN price_offset SET DEFAULT 0.0,
+    ALTER COLUMN price_lin SET DEFAULT 1.0;
@@ -0,0 +1,48 @@
+-- Amounts are stored as integer minor units (cents) of an ISO 4217 currency.
+-- Existing rows become EUR, the default zone currency, so the conversion
+-- multiplies by 100 and rounds half away from zero.
+
+-- Zones own the currency and the rounding rule of their prices
+ALTER TABLE zones
+    ADD COLUMN currency TEXT NOT NULL DEFAULT 'EUR' CHECK (currency ~ '^[A-Z]{3}$'),
+    ADD COLUMN rounding TEXT NOT NULL DEFAULT 'half_up' CHECK (rounding IN ('half_up', 'half_even', 'up', 'down')),
+    ADD COLUMN rounding_increment INTEGER NOT NULL DEFAULT 1 CHECK (rounding_increment > 0),
+    ALTER COLUMN price_offset DROP DEFAULT,
+    ALTER COLUMN price_lin DROP DEFAULT;
+
+ALTER TABLE zones
+    ALTER COLUMN price_offset TYPE BIGINT USING round(price_offset::NUMERIC * 100),
+    ALTER COLUMN price_lin TYPE BIGINT USING round(price_lin::NUMERIC * 100),
+    ALTER COLUMN price_exp TYPE DOUBLE PRECISION,
+    ALTER COLUMN price_offset SET DEFAULT 0,
+    ALTER COLUMN price_lin SET DEFAULT 100;
+
+-- Tickets, fines and sessions keep the currency they were charged in,
+-- so that changing the zone currency does not rewrite history
+ALTER TABLE tickets
+    ALTER COLUMN price TYPE BIGINT USING round(price::NUMERIC * 100),
+    ADD COLUMN currency TEXT;
+UPDATE tickets SET currency = zones.currency FROM zones WHERE zones.id = tickets.zone_id;
+ALTER TABLE tickets ALTER COLUMN currency SET NOT NULL;
+
+ALTER TABLE ticket_extensions
+    ALTER COLUMN price TYPE BIGINT USING round(price::NUMERIC * 100);
+
+ALTER TABLE fines
+    ALTER COLUMN amount TYPE BIGINT USING round(amount::NUMERIC * 100),
+    ADD COLUMN currency TEXT;
+UPDATE fines SET currency = zones.currency FROM zones WHERE zones.id = fines.zone_id;
+ALTER TABLE fines ALTER COLUMN currency SET NOT NULL;
+
+ALTER TABLE parking_sessions
+    ALTER COLUMN price TYPE BIGINT USING round(price::NUMERIC * 100),
+    ADD COLUMN currency TEXT;
+UPDATE parking_sessions SET currency = zones.currency FROM zones WHERE zones.id = parking_sessions.zone_id;
+ALTER TABLE parking_sessions ALTER COLUMN currency SET NOT NULL;
+
+-- Tariff rates and caps are in minor units of the zone currency
+ALTER TABLE tariff_bands
+    ALTER COLUMN rate_per_hour TYPE BIGINT USING round(rate_per_hour::NUMERIC * 100);
+
+ALTER TABLE zone_tariffs
+    ALTER COLUMN daily_max TYPE BIGINT USING round(daily_max::NUMERIC * 100);
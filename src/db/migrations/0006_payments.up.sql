@@ -0,0 +1,20 @@
+-- Payments table
+-- One row per payment attempt with the provider. A ticket, fine or session
+-- is only marked paid once its confirmed payments cover its amount.
+-- username is a "soft" foreign key to Auth service users table
+CREATE TABLE IF NOT EXISTS payments (
+    id SERIAL PRIMARY KEY,
+    target_type TEXT NOT NULL CHECK (target_type IN ('ticket', 'fine', 'session')),
+    target_id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    provider_ref TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'confirmed', 'failed')),
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    UNIQUE (provider, provider_ref)
+);
+
+CREATE INDEX IF NOT EXISTS payments_target ON payments (target_type, target_id);
@@ -0,0 +1,5 @@
+ALTER TABLE tickets
+    DROP COLUMN refund_status,
+    DROP COLUMN refunded_amount;
+
+DROP TABLE IF EXISTS refunds;
@@ -0,0 +1,25 @@
+-- Refunds table
+-- One row per refund issued on a payment, a refund larger than a single
+-- payment is split across the payments of the ticket
+-- created_by is a "soft" foreign key to Auth service users table
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    payment_id INTEGER NOT NULL REFERENCES payments(id),
+    ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    policy TEXT,
+    provider_ref TEXT,
+    status TEXT NOT NULL CHECK (status IN ('confirmed', 'failed')),
+    created_by TEXT NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS refunds_ticket_id ON refunds (ticket_id);
+CREATE INDEX IF NOT EXISTS refunds_payment_id ON refunds (payment_id);
+
+-- refunded_amount is the sum of the confirmed refunds of the ticket
+ALTER TABLE tickets
+    ADD COLUMN refunded_amount BIGINT NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0),
+    ADD COLUMN refund_status TEXT NOT NULL DEFAULT 'none' CHECK (refund_status IN ('none', 'partial', 'refunded'));
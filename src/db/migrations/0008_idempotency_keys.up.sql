@@ -0,0 +1,18 @@
+-- Idempotency keys
+-- Responses of mutating requests sent with an Idempotency-Key header, kept
+-- until expires_at so that retried requests are replayed. A NULL
+-- status_code means the first request is still being handled.
+-- username is a "soft" foreign key to Auth service users table
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    username TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER,
+    content_type TEXT,
+    body BYTEA,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (username, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,10 @@
+ALTER TABLE fines
+    DROP COLUMN override_reason,
+    DROP COLUMN override,
+    DROP COLUMN longitude,
+    DROP COLUMN latitude,
+    DROP COLUMN issued_by,
+    DROP COLUMN verdict,
+    DROP COLUMN offense;
+
+DROP TABLE IF EXISTS zone_fine_schedules;
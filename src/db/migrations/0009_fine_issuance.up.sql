@@ -0,0 +1,23 @@
+-- Fine schedules
+-- Amount of the fine for each offense in a zone, in minor units of the
+-- zone currency. Offenses default to the enforcement verdicts (expired,
+-- wrong_zone, not_covered).
+CREATE TABLE IF NOT EXISTS zone_fine_schedules (
+    zone_id INTEGER NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    offense TEXT NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    PRIMARY KEY (zone_id, offense)
+);
+
+-- Fines record how they were issued: the enforcement verdict at issue
+-- time, the issuing controller, its position and whether the controller
+-- overrode a valid coverage. Older fines have none of it.
+-- issued_by is a "soft" foreign key to Auth service users table
+ALTER TABLE fines
+    ADD COLUMN offense TEXT,
+    ADD COLUMN verdict TEXT,
+    ADD COLUMN issued_by TEXT,
+    ADD COLUMN latitude DOUBLE PRECISION,
+    ADD COLUMN longitude DOUBLE PRECISION,
+    ADD COLUMN override BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN override_reason TEXT;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS fine_appeal_events;
+DROP TABLE IF EXISTS fine_appeals;
+
+ALTER TABLE fines DROP COLUMN status;
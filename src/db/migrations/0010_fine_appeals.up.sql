@@ -0,0 +1,39 @@
+-- Fines are open, frozen while appealed, or cancelled by an appeal
+ALTER TABLE fines
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'appealed', 'cancelled'));
+
+-- Fine appeals
+-- A driver appeals a fine once at a time, a zone admin upholds it, reduces
+-- the fine amount or cancels the fine.
+-- opened_by and decided_by are "soft" foreign keys to Auth service users table
+CREATE TABLE IF NOT EXISTS fine_appeals (
+    id SERIAL PRIMARY KEY,
+    fine_id INTEGER NOT NULL REFERENCES fines(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'upheld', 'reduced', 'cancelled')),
+    text TEXT NOT NULL,
+    evidence TEXT[] NOT NULL DEFAULT '{}',
+    opened_by TEXT NOT NULL,
+    original_amount BIGINT NOT NULL,
+    decided_amount BIGINT,
+    currency TEXT NOT NULL,
+    decided_by TEXT,
+    decision_reason TEXT,
+    decided_at TIMESTAMP WITH TIME ZONE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS fine_appeals_fine_id ON fine_appeals (fine_id);
+CREATE UNIQUE INDEX IF NOT EXISTS fine_appeals_one_pending ON fine_appeals (fine_id) WHERE status = 'pending';
+
+-- Every state transition of an appeal, from_status is NULL when it opens
+CREATE TABLE IF NOT EXISTS fine_appeal_events (
+    id SERIAL PRIMARY KEY,
+    appeal_id INTEGER NOT NULL REFERENCES fine_appeals(id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    note TEXT,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS fine_appeal_events_appeal_id ON fine_appeal_events (appeal_id);
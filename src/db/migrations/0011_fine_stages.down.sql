@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS fine_stage_events;
+
+-- Fines keep the amount of their current stage
+ALTER TABLE fines
+    DROP COLUMN amount_final,
+    DROP COLUMN stage_since,
+    DROP COLUMN stage,
+    DROP COLUMN base_amount;
+
+DROP TABLE IF EXISTS zone_fine_stages;
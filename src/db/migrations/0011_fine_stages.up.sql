@@ -0,0 +1,38 @@
+-- Fine stages
+-- Early payment discounts and late surcharges of a zone: from
+-- starts_after_days days after it was issued, a fine costs its base
+-- amount adjusted by adjustment_percent.
+CREATE TABLE IF NOT EXISTS zone_fine_stages (
+    zone_id INTEGER NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    starts_after_days INTEGER NOT NULL CHECK (starts_after_days >= 0),
+    adjustment_percent INTEGER NOT NULL CHECK (adjustment_percent >= -100),
+    PRIMARY KEY (zone_id, starts_after_days),
+    UNIQUE (zone_id, name)
+);
+
+-- amount becomes the amount due in the current stage, base_amount the
+-- amount the stages apply to. amount_final is set when an appeal fixes
+-- the amount, stages no longer apply then.
+ALTER TABLE fines
+    ADD COLUMN base_amount BIGINT,
+    ADD COLUMN stage TEXT,
+    ADD COLUMN stage_since TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN amount_final BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE fines SET base_amount = amount;
+
+ALTER TABLE fines ALTER COLUMN base_amount SET NOT NULL;
+
+-- Every stage change of a fine
+CREATE TABLE IF NOT EXISTS fine_stage_events (
+    id SERIAL PRIMARY KEY,
+    fine_id INTEGER NOT NULL REFERENCES fines(id) ON DELETE CASCADE,
+    from_stage TEXT,
+    to_stage TEXT NOT NULL,
+    from_amount BIGINT NOT NULL,
+    to_amount BIGINT NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS fine_stage_events_fine_id ON fine_stage_events (fine_id);
@@ -0,0 +1,23 @@
+-- Fine evidence
+-- Photos and notes attached to a fine by the zone staff. Photos are kept in
+-- the blob store under blob_key, sha256 is the hash of the stored file and
+-- original_sha256 the hash of the upload, before its GPS data was removed.
+-- uploaded_by is a "soft" foreign key to Auth service users table
+CREATE TABLE IF NOT EXISTS fine_evidence (
+    id SERIAL PRIMARY KEY,
+    fine_id INTEGER NOT NULL REFERENCES fines(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('photo', 'note')),
+    blob_key TEXT,
+    content_type TEXT,
+    size BIGINT,
+    sha256 TEXT,
+    original_sha256 TEXT,
+    gps_stripped BOOLEAN,
+    note TEXT,
+    uploaded_by TEXT NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    CHECK (kind = 'note' OR (blob_key IS NOT NULL AND sha256 IS NOT NULL)),
+    CHECK (kind = 'photo' OR note IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS fine_evidence_fine_id ON fine_evidence (fine_id);
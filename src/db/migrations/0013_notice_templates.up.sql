@@ -0,0 +1,14 @@
+-- Fine notice templates
+-- Text and branding of the printed fine notices of a zone. NULL fields use
+-- the backend defaults.
+CREATE TABLE IF NOT EXISTS zone_notice_templates (
+    zone_id INTEGER PRIMARY KEY REFERENCES zones(id) ON DELETE CASCADE,
+    title TEXT,
+    issuer TEXT,
+    body TEXT,
+    footer TEXT,
+    color TEXT,
+    payment_url TEXT,
+    timezone TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
@@ -0,0 +1,15 @@
+-- Ticket signing keys
+-- Ed25519 keys the ticket tokens are signed with. private_key is sealed
+-- with TICKET_KEY_SECRET when it is set. A key is published from its
+-- creation, used for signing from activates_at until a newer key takes
+-- over (retired_at), and published until expires_at, once no ticket whose
+-- token it may have signed is still running.
+CREATE TABLE IF NOT EXISTS ticket_signing_keys (
+    kid TEXT PRIMARY KEY,
+    private_key BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    activates_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    retired_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
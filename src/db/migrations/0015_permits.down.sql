@@ -0,0 +1,7 @@
+DELETE FROM payments WHERE target_type = 'permit';
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_target_type_check;
+ALTER TABLE payments ADD CONSTRAINT payments_target_type_check CHECK (target_type IN ('ticket', 'fine', 'session'));
+
+DROP TABLE IF EXISTS permit_plates;
+DROP TABLE IF EXISTS permits;
+DROP TABLE IF EXISTS permit_types;
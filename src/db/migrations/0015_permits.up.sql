@@ -0,0 +1,60 @@
+-- Parking permits
+-- Long-lived permits of a zone, residential or business. permit_types are
+-- the offers of a zone with their price, validity and eligibility rules;
+-- permits are the applications of drivers, approved or rejected by the zone
+-- admins. Price and validity are copied from the type on application, and
+-- valid_from/valid_until are set on approval. renews_permit_id links a
+-- renewal to the permit it follows.
+-- username and decided_by are "soft" foreign keys to Auth service users table
+CREATE TABLE IF NOT EXISTS permit_types (
+    id SERIAL PRIMARY KEY,
+    zone_id INTEGER NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('residential', 'business')),
+    price BIGINT NOT NULL CHECK (price >= 0),
+    currency TEXT NOT NULL,
+    validity_days INTEGER NOT NULL CHECK (validity_days > 0),
+    max_plates INTEGER NOT NULL DEFAULT 1 CHECK (max_plates > 0),
+    max_per_user INTEGER CHECK (max_per_user > 0),
+    requires_proof BOOLEAN NOT NULL DEFAULT TRUE,
+    renewal_days INTEGER NOT NULL DEFAULT 30 CHECK (renewal_days >= 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS permits (
+    id SERIAL PRIMARY KEY,
+    permit_type_id INTEGER NOT NULL REFERENCES permit_types(id) ON DELETE CASCADE,
+    zone_id INTEGER NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'cancelled')),
+    price BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    validity_days INTEGER NOT NULL,
+    paid BOOLEAN NOT NULL DEFAULT FALSE,
+    proof TEXT,
+    start_date TIMESTAMP WITH TIME ZONE,
+    valid_from TIMESTAMP WITH TIME ZONE,
+    valid_until TIMESTAMP WITH TIME ZONE,
+    renews_permit_id INTEGER REFERENCES permits(id) ON DELETE SET NULL,
+    decided_by TEXT,
+    decision_reason TEXT,
+    decided_at TIMESTAMP WITH TIME ZONE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    CHECK (status <> 'approved' OR (valid_from IS NOT NULL AND valid_until IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS permits_zone_id ON permits (zone_id);
+CREATE INDEX IF NOT EXISTS permits_username ON permits (username);
+
+CREATE TABLE IF NOT EXISTS permit_plates (
+    permit_id INTEGER NOT NULL REFERENCES permits(id) ON DELETE CASCADE,
+    plate TEXT NOT NULL,
+    PRIMARY KEY (permit_id, plate)
+);
+
+CREATE INDEX IF NOT EXISTS permit_plates_plate ON permit_plates (plate);
+
+-- Permits are paid like tickets, fines and sessions
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_target_type_check;
+ALTER TABLE payments ADD CONSTRAINT payments_target_type_check CHECK (target_type IN ('ticket', 'fine', 'session', 'permit'));
@@ -0,0 +1,8 @@
+DELETE FROM payments WHERE target_type = 'subscription_period';
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_target_type_check;
+ALTER TABLE payments ADD CONSTRAINT payments_target_type_check CHECK (target_type IN ('ticket', 'fine', 'session', 'permit'));
+
+DROP TABLE IF EXISTS subscription_periods;
+DROP TABLE IF EXISTS subscription_plates;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS subscription_plans;
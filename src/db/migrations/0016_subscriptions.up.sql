@@ -0,0 +1,70 @@
+-- Subscriptions
+-- subscription_plans cover some zones on some days between two times of the
+-- day (minutes from midnight in the plan timezone, days following
+-- time.Weekday), for a monthly price. subscriptions tie a user and their
+-- cars to a plan and are billed by contiguous monthly periods; a period is
+-- open until a confirmed payment covers its price. attempts and
+-- next_attempt_at track the automatic charges of a period, and a
+-- subscription whose period is unpaid keeps covering until grace_until.
+-- username is a "soft" foreign key to Auth service users table
+CREATE TABLE IF NOT EXISTS subscription_plans (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    zone_ids INTEGER[] NOT NULL,
+    days INTEGER[] NOT NULL,
+    start_minute INTEGER NOT NULL CHECK (start_minute >= 0 AND start_minute < 1440),
+    end_minute INTEGER NOT NULL CHECK (end_minute > start_minute AND end_minute <= 1440),
+    timezone TEXT NOT NULL,
+    price BIGINT NOT NULL CHECK (price > 0),
+    currency TEXT NOT NULL,
+    max_plates INTEGER NOT NULL DEFAULT 1 CHECK (max_plates > 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id SERIAL PRIMARY KEY,
+    plan_id INTEGER NOT NULL REFERENCES subscription_plans(id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'past_due', 'unpaid', 'cancelled', 'expired')),
+    current_period_start TIMESTAMP WITH TIME ZONE,
+    current_period_end TIMESTAMP WITH TIME ZONE,
+    grace_until TIMESTAMP WITH TIME ZONE,
+    cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+    cancelled_at TIMESTAMP WITH TIME ZONE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS subscriptions_username ON subscriptions (username);
+CREATE INDEX IF NOT EXISTS subscriptions_status ON subscriptions (status);
+
+CREATE TABLE IF NOT EXISTS subscription_plates (
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    plate TEXT NOT NULL,
+    PRIMARY KEY (subscription_id, plate)
+);
+
+CREATE INDEX IF NOT EXISTS subscription_plates_plate ON subscription_plates (plate);
+
+CREATE TABLE IF NOT EXISTS subscription_periods (
+    id SERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    price BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'paid', 'void')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+-- A subscription has at most one open period
+CREATE UNIQUE INDEX IF NOT EXISTS subscription_periods_open ON subscription_periods (subscription_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS subscription_periods_next_attempt ON subscription_periods (next_attempt_at) WHERE status = 'open';
+
+-- Billing periods are paid like tickets, fines, sessions and permits
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_target_type_check;
+ALTER TABLE payments ADD CONSTRAINT payments_target_type_check CHECK (target_type IN ('ticket', 'fine', 'session', 'permit', 'subscription_period'));
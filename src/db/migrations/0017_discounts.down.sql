@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS discount_redemptions;
+DROP TABLE IF EXISTS discounts;
+
+ALTER TABLE cars DROP COLUMN IF EXISTS disabled_badge;
+ALTER TABLE cars DROP COLUMN IF EXISTS electric;
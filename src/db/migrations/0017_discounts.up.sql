@@ -0,0 +1,49 @@
+-- Discounts
+-- Rules taking an amount off ticket prices. A discount without a code
+-- applies to every eligible ticket, a discount with a code only when the
+-- driver enters it. kind is a percentage of the price, a fixed amount in
+-- minor units of currency or a number of free minutes from the start of the
+-- stay. zone_ids NULL applies in every zone, requires restricts the discount
+-- to cars with an attribute. The validity window is checked against the
+-- start of the stay. discount_redemptions record the discounts applied to
+-- each ticket with a copy of the rule, for audit and repricing.
+-- username is a "soft" foreign key to Auth service users table, the owner
+-- of the car
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS electric BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS disabled_badge BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS discounts (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    code TEXT CHECK (code = upper(code)),
+    kind TEXT NOT NULL CHECK (kind IN ('percent', 'amount', 'free_minutes')),
+    value BIGINT NOT NULL CHECK (value > 0 AND (kind <> 'percent' OR value <= 100)),
+    currency TEXT CHECK (kind <> 'amount' OR currency IS NOT NULL),
+    zone_ids INTEGER[],
+    requires TEXT CHECK (requires IN ('electric', 'disabled_badge')),
+    valid_from TIMESTAMP WITH TIME ZONE,
+    valid_until TIMESTAMP WITH TIME ZONE CHECK (valid_until > valid_from),
+    max_uses INTEGER CHECK (max_uses > 0),
+    max_uses_per_user INTEGER CHECK (max_uses_per_user > 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS discounts_code ON discounts (code) WHERE code IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS discount_redemptions (
+    id SERIAL PRIMARY KEY,
+    discount_id INTEGER NOT NULL REFERENCES discounts(id) ON DELETE CASCADE,
+    ticket_id INTEGER NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    code TEXT,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    value BIGINT NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount >= 0),
+    currency TEXT NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    UNIQUE (ticket_id, discount_id)
+);
+
+CREATE INDEX IF NOT EXISTS discount_redemptions_discount ON discount_redemptions (discount_id, username);
@@ -0,0 +1,8 @@
+DELETE FROM payments WHERE target_type = 'wallet_topup';
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_target_type_check;
+ALTER TABLE payments ADD CONSTRAINT payments_target_type_check CHECK (target_type IN ('ticket', 'fine', 'session', 'permit', 'subscription_period'));
+
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_transactions;
+DROP TABLE IF EXISTS wallet_topups;
+DROP TABLE IF EXISTS wallets;
@@ -0,0 +1,54 @@
+-- Wallets
+-- Prepaid balances of users, one wallet per user and currency. Money moves
+-- through a double-entry ledger: each ledger_transactions row has entries
+-- summing to zero, on the wallet and on one of the accounts money comes
+-- from or goes to: 'provider' for top-ups paid through the payment
+-- provider, 'revenue' for tickets and fines paid from the wallet and their
+-- refunds. wallets.balance is the sum of the wallet entries, kept up to
+-- date in the same transaction, and never negative. Ledger rows are never
+-- updated or deleted.
+-- wallet_topups are the amounts users pay to credit their wallet, credited
+-- once a confirmed payment covers them.
+-- username is a "soft" foreign key to Auth service users table
+CREATE TABLE IF NOT EXISTS wallets (
+    id SERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    UNIQUE (username, currency)
+);
+
+CREATE TABLE IF NOT EXISTS wallet_topups (
+    id SERIAL PRIMARY KEY,
+    wallet_id INTEGER NOT NULL REFERENCES wallets(id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'credited')),
+    credited_at TIMESTAMP WITH TIME ZONE,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id SERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('topup', 'payment', 'refund')),
+    target_type TEXT NOT NULL,
+    target_id INTEGER NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER NOT NULL REFERENCES ledger_transactions(id),
+    account TEXT NOT NULL CHECK (account IN ('wallet', 'provider', 'revenue')),
+    wallet_id INTEGER REFERENCES wallets(id),
+    amount BIGINT NOT NULL CHECK (amount <> 0),
+    currency TEXT NOT NULL,
+    CHECK ((account = 'wallet') = (wallet_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction ON ledger_entries (transaction_id);
+CREATE INDEX IF NOT EXISTS ledger_entries_wallet ON ledger_entries (wallet_id, id) WHERE wallet_id IS NOT NULL;
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_target_type_check;
+ALTER TABLE payments ADD CONSTRAINT payments_target_type_check CHECK (target_type IN ('ticket', 'fine', 'session', 'permit', 'subscription_period', 'wallet_topup'));
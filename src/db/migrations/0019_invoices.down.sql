@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS invoice_lines;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS billing_profiles;
+ALTER TABLE zones DROP COLUMN IF EXISTS operator_id;
+DROP TABLE IF EXISTS operators;
@@ -0,0 +1,81 @@
+-- Invoices
+-- operators are the companies issuing the invoices of the payments made in
+-- their zones, with their legal details and VAT rate in basis points (2200
+-- is 22%); prices include VAT. Invoices and credit notes of an operator
+-- share one gapless series, next_invoice_number is the next one.
+-- billing_profiles are the company details users want on their invoices;
+-- consolidated users get one invoice per operator and month instead of one
+-- per payment. invoices keep the details of both parties as they were when
+-- issued and are never updated. Credit notes have negative amounts and
+-- credit the invoice of a refunded payment. Each payment is invoiced once,
+-- and so is each refund.
+-- username is a "soft" foreign key to Auth service users table
+CREATE TABLE IF NOT EXISTS operators (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL,
+    country TEXT NOT NULL,
+    vat_number TEXT NOT NULL,
+    vat_rate INTEGER NOT NULL CHECK (vat_rate >= 0 AND vat_rate <= 10000),
+    invoice_prefix TEXT NOT NULL DEFAULT '',
+    next_invoice_number BIGINT NOT NULL DEFAULT 1,
+    creation_time TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS operator_id INTEGER REFERENCES operators(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS billing_profiles (
+    username TEXT PRIMARY KEY,
+    company_name TEXT NOT NULL,
+    vat_number TEXT,
+    address TEXT NOT NULL,
+    country TEXT NOT NULL,
+    consolidated BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id SERIAL PRIMARY KEY,
+    operator_id INTEGER NOT NULL REFERENCES operators(id),
+    sequence BIGINT NOT NULL,
+    number TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('payment', 'monthly', 'credit_note')),
+    username TEXT NOT NULL,
+    credited_invoice_id INTEGER REFERENCES invoices(id),
+    period_start TIMESTAMP WITH TIME ZONE,
+    period_end TIMESTAMP WITH TIME ZONE,
+    issuer_name TEXT NOT NULL,
+    issuer_address TEXT NOT NULL,
+    issuer_country TEXT NOT NULL,
+    issuer_vat_number TEXT NOT NULL,
+    buyer_name TEXT,
+    buyer_address TEXT,
+    buyer_country TEXT,
+    buyer_vat_number TEXT,
+    currency TEXT NOT NULL,
+    net BIGINT NOT NULL,
+    vat BIGINT NOT NULL,
+    total BIGINT NOT NULL CHECK (total = net + vat),
+    issue_time TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    UNIQUE (operator_id, sequence),
+    CHECK ((kind = 'credit_note') = (credited_invoice_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS invoices_username ON invoices (username);
+CREATE INDEX IF NOT EXISTS invoices_operator_issue_time ON invoices (operator_id, issue_time);
+
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id SERIAL PRIMARY KEY,
+    invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    payment_id INTEGER NOT NULL REFERENCES payments(id),
+    -- Not a foreign key: refunds go with their ticket, credit notes stay
+    refund_id INTEGER UNIQUE,
+    description TEXT NOT NULL,
+    vat_rate INTEGER NOT NULL,
+    net BIGINT NOT NULL,
+    vat BIGINT NOT NULL,
+    total BIGINT NOT NULL CHECK (total = net + vat)
+);
+
+CREATE INDEX IF NOT EXISTS invoice_lines_invoice_id ON invoice_lines (invoice_id);
+CREATE UNIQUE INDEX IF NOT EXISTS invoice_lines_payment ON invoice_lines (payment_id) WHERE refund_id IS NULL;
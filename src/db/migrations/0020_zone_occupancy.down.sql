@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS zone_occupancy_snapshots;
+ALTER TABLE zones DROP COLUMN IF EXISTS sensor_updated_at;
+ALTER TABLE zones DROP COLUMN IF EXISTS sensor_occupied;
+ALTER TABLE zones DROP COLUMN IF EXISTS refuse_when_full;
+ALTER TABLE zones DROP COLUMN IF EXISTS capacity;
@@ -0,0 +1,20 @@
+-- Zone occupancy
+-- capacity is the number of spots of a zone, NULL when unknown. Zones that
+-- refuse_when_full refuse new tickets and sessions while they are full.
+-- sensor_occupied is the last count of taken spots reported by the sensors
+-- of the zone; fresh readings take over from the count of tickets and
+-- sessions. zone_occupancy_snapshots keep the occupancy of each zone every
+-- quarter of an hour for analytics.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS capacity INTEGER CHECK (capacity > 0);
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS refuse_when_full BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS sensor_occupied INTEGER CHECK (sensor_occupied >= 0);
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS sensor_updated_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS zone_occupancy_snapshots (
+    zone_id INTEGER NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    time TIMESTAMP WITH TIME ZONE NOT NULL,
+    occupied INTEGER NOT NULL,
+    capacity INTEGER,
+    source TEXT NOT NULL CHECK (source IN ('sensor', 'tickets')),
+    PRIMARY KEY (zone_id, time)
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS zone_price_levels;
+DROP TABLE IF EXISTS dynamic_pricing_policies;
+ALTER TABLE parking_sessions DROP COLUMN IF EXISTS price_level;
+ALTER TABLE tickets DROP COLUMN IF EXISTS price_level;
+ALTER TABLE zones DROP COLUMN IF EXISTS price_level;
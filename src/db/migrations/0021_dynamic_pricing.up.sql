@@ -0,0 +1,38 @@
+-- Dynamic pricing
+-- price_level is the current price level of a zone in basis points of its
+-- base prices (10000 charges them unchanged); it scales the hourly rates,
+-- offsets and daily maximum of the zone. dynamic_pricing_policies move it
+-- with the occupancy of the zone, occupancies and steps in basis points
+-- too. Every change is a new version in zone_price_levels, with the reason
+-- and the occupancy it was made at. Tickets and sessions keep the level
+-- they were priced at, so extensions, refunds and the billing of sessions
+-- never reprice them at a later level.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS price_level INTEGER NOT NULL DEFAULT 10000 CHECK (price_level > 0);
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS price_level INTEGER NOT NULL DEFAULT 10000;
+ALTER TABLE parking_sessions ADD COLUMN IF NOT EXISTS price_level INTEGER NOT NULL DEFAULT 10000;
+
+CREATE TABLE IF NOT EXISTS dynamic_pricing_policies (
+    zone_id INTEGER PRIMARY KEY REFERENCES zones(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    high_occupancy INTEGER NOT NULL CHECK (high_occupancy > 0 AND high_occupancy <= 10000),
+    raise_step INTEGER NOT NULL CHECK (raise_step >= 0),
+    low_occupancy INTEGER NOT NULL CHECK (low_occupancy >= 0 AND low_occupancy < high_occupancy),
+    lower_step INTEGER NOT NULL CHECK (lower_step >= 0),
+    min_level INTEGER NOT NULL CHECK (min_level > 0 AND min_level <= 10000),
+    max_level INTEGER NOT NULL CHECK (max_level >= 10000),
+    interval_minutes INTEGER NOT NULL CHECK (interval_minutes > 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS zone_price_levels (
+    id SERIAL PRIMARY KEY,
+    zone_id INTEGER NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    level INTEGER NOT NULL CHECK (level > 0),
+    previous_level INTEGER NOT NULL,
+    occupied INTEGER,
+    capacity INTEGER,
+    reason TEXT NOT NULL,
+    effective_from TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS zone_price_levels_zone_id ON zone_price_levels (zone_id, effective_from);
@@ -0,0 +1,7 @@
+-- Paid coverage of tickets
+-- paid_until is the end of the stay covered by the confirmed payments of a
+-- paid ticket. Extending a paid ticket moves its end_date and price right
+-- away, but the ticket only covers the car up to paid_until until the
+-- extension is paid as well.
+ALTER TABLE tickets ADD COLUMN IF NOT EXISTS paid_until TIMESTAMP;
+UPDATE tickets SET paid_until = end_date WHERE paid = TRUE AND paid_until IS NULL;
@@ -0,0 +1,5 @@
+-- Client secrets of payments
+-- A pending payment is handed out again, with the client secret the
+-- provider issued for it, when its target is paid again for the same
+-- amount, rather than opening a second intent for the same amount due.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS client_secret TEXT;
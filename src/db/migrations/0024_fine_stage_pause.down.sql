@@ -0,0 +1 @@
+ALTER TABLE fines DROP COLUMN IF EXISTS stage_paused;
@@ -0,0 +1,13 @@
+-- Fine stages paused during appeals
+-- stage_paused is the time a fine spent under appeal. Its stages start
+-- that much later, so the time an appeal takes to be decided does not move
+-- an upheld fine to a later stage.
+ALTER TABLE fines ADD COLUMN IF NOT EXISTS stage_paused INTERVAL NOT NULL DEFAULT '0';
+UPDATE fines SET stage_paused = appealed.paused
+FROM (
+    SELECT fine_id, SUM(decided_at - creation_time) AS paused
+    FROM fine_appeals
+    WHERE decided_at IS NOT NULL
+    GROUP BY fine_id
+) AS appealed
+WHERE fines.id = appealed.fine_id;
@@ -0,0 +1,2 @@
+-- The attributes drivers declared are not restored
+SELECT 1;
@@ -0,0 +1,4 @@
+-- Verified car attributes
+-- electric and disabled_badge grant discounts and are now only set by
+-- superusers, the values drivers declared themselves are cleared.
+UPDATE cars SET electric = FALSE, disabled_badge = FALSE;
@@ -0,0 +1,2 @@
+DELETE FROM payments WHERE provider_ref IS NULL;
+ALTER TABLE payments ALTER COLUMN provider_ref SET NOT NULL;
@@ -0,0 +1,5 @@
+-- Reserved payments
+-- A payment is reserved, without a provider reference, while its target is
+-- locked, and only opened with the provider once that lock is released.
+-- The reference is filled in when the provider answers.
+ALTER TABLE payments ALTER COLUMN provider_ref DROP NOT NULL;
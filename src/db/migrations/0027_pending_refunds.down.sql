@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS refunds_pending;
+UPDATE refunds SET status = 'failed' WHERE status = 'pending';
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_status_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_status_check CHECK (status IN ('confirmed', 'failed'));
@@ -0,0 +1,7 @@
+-- Pending refunds
+-- Providers may pay a refund out later. A pending refund stays reserved on
+-- its ticket and its payment until the provider reports it confirmed or
+-- failed, only a failed refund gives the amount back to refund.
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_status_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_status_check CHECK (status IN ('pending', 'confirmed', 'failed'));
+CREATE INDEX IF NOT EXISTS refunds_pending ON refunds (id) WHERE status = 'pending';
@@ -0,0 +1,93 @@
+// Package evidence prepares the photos attached to fines for storage:
+// it checks they are images, removes their location data and hashes them.
+package evidence
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Set to true to keep the GPS position recorded in photos
+var EVIDENCE_KEEP_GPS = os.Getenv("EVIDENCE_KEEP_GPS")
+
+// MaxSize is the largest photo accepted, in bytes
+const MaxSize = 10 << 20
+
+// Supported photo types
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+)
+
+var (
+	ErrTooLarge        = errors.New("photo too large")
+	ErrUnsupportedType = errors.New("photo must be a JPEG or PNG image")
+	ErrInvalidImage    = errors.New("invalid image")
+)
+
+// Photo is a photo ready to be stored
+type Photo struct {
+	ContentType string
+	Data        []byte
+	// SHA256 is the hash of Data, OriginalSHA256 the hash of the upload
+	SHA256         string
+	OriginalSHA256 string
+	GPSStripped    bool
+}
+
+// Hash returns the hex SHA-256 of data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// KeepGPS tells whether the policy keeps the location data of photos
+func KeepGPS() bool {
+	keep, _ := strconv.ParseBool(EVIDENCE_KEEP_GPS)
+	return keep
+}
+
+// Prepare checks an uploaded photo and removes its GPS data unless keepGPS
+// is set. The upload is left untouched.
+func Prepare(data []byte, keepGPS bool) (*Photo, error) {
+	if len(data) > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if contentType != TypeJPEG && contentType != TypePNG {
+		return nil, ErrUnsupportedType
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	photo := &Photo{
+		ContentType:    contentType,
+		Data:           data,
+		OriginalSHA256: Hash(data),
+	}
+	if !keepGPS {
+		var stripped []byte
+		var err error
+		if contentType == TypeJPEG {
+			stripped, photo.GPSStripped, err = stripJPEGGPS(data)
+		} else {
+			stripped, photo.GPSStripped, err = stripPNGGPS(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		photo.Data = stripped
+	}
+	photo.SHA256 = Hash(photo.Data)
+	return photo, nil
+}
@@ -0,0 +1,165 @@
+package evidence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Tag of the IFD0 entry pointing to the GPS IFD
+const gpsInfoTag = 0x8825
+
+// Size in bytes of a value of each TIFF field type
+var tiffTypeSize = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// stripTIFFGPS clears the GPS IFD of an Exif TIFF structure in place. The
+// IFD is emptied and its values zeroed, the rest of the Exif data is kept.
+// It reports whether there was GPS data.
+func stripTIFFGPS(tiff []byte) (bool, error) {
+	if len(tiff) < 8 {
+		return false, fmt.Errorf("%w: short Exif header", ErrInvalidImage)
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return false, fmt.Errorf("%w: unknown Exif byte order", ErrInvalidImage)
+	}
+
+	// inBounds tells whether n bytes from offset are inside the structure
+	inBounds := func(offset uint32, n uint64) bool {
+		return uint64(offset)+n <= uint64(len(tiff))
+	}
+
+	ifd0 := order.Uint32(tiff[4:8])
+	if !inBounds(ifd0, 2) {
+		return false, fmt.Errorf("%w: bad Exif IFD offset", ErrInvalidImage)
+	}
+	count := uint32(order.Uint16(tiff[ifd0:]))
+	if !inBounds(ifd0+2, uint64(count)*12) {
+		return false, fmt.Errorf("%w: bad Exif IFD", ErrInvalidImage)
+	}
+
+	var gps uint32
+	found := false
+	for i := uint32(0); i < count; i++ {
+		entry := tiff[ifd0+2+i*12:]
+		if order.Uint16(entry) == gpsInfoTag {
+			gps = order.Uint32(entry[8:12])
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	if !inBounds(gps, 2) {
+		return false, fmt.Errorf("%w: bad GPS IFD offset", ErrInvalidImage)
+	}
+	gpsCount := uint32(order.Uint16(tiff[gps:]))
+	if !inBounds(gps+2, uint64(gpsCount)*12+4) {
+		return false, fmt.Errorf("%w: bad GPS IFD", ErrInvalidImage)
+	}
+
+	// Values longer than 4 bytes are stored outside of the entries
+	for i := uint32(0); i < gpsCount; i++ {
+		entry := tiff[gps+2+i*12:]
+		size := tiffTypeSize[order.Uint16(entry[2:4])] * uint64(order.Uint32(entry[4:8]))
+		if size <= 4 {
+			continue
+		}
+		offset := order.Uint32(entry[8:12])
+		if inBounds(offset, size) {
+			clear(tiff[offset : uint64(offset)+size])
+		}
+	}
+	// An IFD with no entries and no next IFD
+	clear(tiff[gps : gps+2+gpsCount*12+4])
+	return true, nil
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// stripJPEGGPS returns a copy of a JPEG image without GPS data in its Exif
+// segments
+func stripJPEGGPS(data []byte) ([]byte, bool, error) {
+	out := bytes.Clone(data)
+	stripped := false
+
+	i := 2
+	for i+4 <= len(out) {
+		if out[i] != 0xFF {
+			return nil, false, fmt.Errorf("%w: bad JPEG marker", ErrInvalidImage)
+		}
+		marker := out[i+1]
+		// Segments without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8) || marker == 0xFF {
+			i += 2
+			continue
+		}
+		// The image data starts, there are no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(out[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(out) {
+			return nil, false, fmt.Errorf("%w: bad JPEG segment", ErrInvalidImage)
+		}
+		segment := out[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			found, err := stripTIFFGPS(segment[len(exifHeader):])
+			if err != nil {
+				return nil, false, err
+			}
+			stripped = stripped || found
+		}
+		i = end
+	}
+	return out, stripped, nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNGGPS returns a copy of a PNG image without GPS data in its eXIf
+// chunk
+func stripPNGGPS(data []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, false, fmt.Errorf("%w: bad PNG signature", ErrInvalidImage)
+	}
+	out := bytes.Clone(data)
+	stripped := false
+
+	i := len(pngSignature)
+	for i+12 <= len(out) {
+		length := int(binary.BigEndian.Uint32(out[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(out) {
+			return nil, false, fmt.Errorf("%w: bad PNG chunk", ErrInvalidImage)
+		}
+		kind := string(out[i+4 : i+8])
+		if kind == "eXIf" {
+			found, err := stripTIFFGPS(out[i+8 : i+8+length])
+			if err != nil {
+				return nil, false, err
+			}
+			if found {
+				// The chunk changed, so does its checksum
+				binary.BigEndian.PutUint32(out[i+8+length:], crc32.ChecksumIEEE(out[i+4:i+8+length]))
+				stripped = true
+			}
+		}
+		if kind == "IEND" {
+			break
+		}
+		i = end
+	}
+	return out, stripped, nil
+}
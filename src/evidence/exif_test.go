@@ -0,0 +1,98 @@
+package evidence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// exifWithGPS builds a little endian Exif TIFF structure with an IFD0
+// holding a camera make and a pointer to a GPS IFD with a latitude
+func exifWithGPS() []byte {
+	le := binary.LittleEndian
+	tiff := make([]byte, 0, 128)
+	tiff = append(tiff, 'I', 'I')
+	tiff = le.AppendUint16(tiff, 42)
+	tiff = le.AppendUint32(tiff, 8)
+
+	// IFD0 at 8: Make "OPP" inline, GPSInfo pointing to 38
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x0F, 0x01, 2, 0)
+	tiff = le.AppendUint32(tiff, 4)
+	tiff = append(tiff, 'O', 'P', 'P', 0)
+	tiff = le.AppendUint16(tiff, gpsInfoTag)
+	tiff = le.AppendUint16(tiff, 4)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 38)
+	tiff = le.AppendUint32(tiff, 0)
+
+	// GPS IFD at 38: GPSLatitude, three rationals at 56
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint16(tiff, 5)
+	tiff = le.AppendUint32(tiff, 3)
+	tiff = le.AppendUint32(tiff, 56)
+	tiff = le.AppendUint32(tiff, 0)
+	for _, v := range []uint32{45, 1, 27, 1, 3600, 100} {
+		tiff = le.AppendUint32(tiff, v)
+	}
+	return tiff
+}
+
+func jpegWithExif(tiff []byte) []byte {
+	segment := append(append([]byte{}, exifHeader...), tiff...)
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+2))
+	data = append(data, segment...)
+	return append(data, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+}
+
+func TestStripJPEGGPS(t *testing.T) {
+	data := jpegWithExif(exifWithGPS())
+	original := bytes.Clone(data)
+
+	out, stripped, err := stripJPEGGPS(data)
+	if err != nil {
+		t.Fatalf("stripJPEGGPS: %v", err)
+	}
+	if !stripped {
+		t.Fatal("GPS data not reported as stripped")
+	}
+	if !bytes.Equal(data, original) {
+		t.Fatal("the upload was modified")
+	}
+	if len(out) != len(data) {
+		t.Fatalf("stripped image is %d bytes, want %d", len(out), len(data))
+	}
+
+	tiff := out[4+2+len(exifHeader):]
+	if !bytes.Equal(tiff[18:22], []byte("OPP\x00")) {
+		t.Errorf("camera make lost: %q", tiff[18:22])
+	}
+	if count := binary.LittleEndian.Uint16(tiff[38:]); count != 0 {
+		t.Errorf("GPS IFD still has %d entries", count)
+	}
+	if !bytes.Equal(tiff[56:80], make([]byte, 24)) {
+		t.Errorf("latitude not cleared: %v", tiff[56:80])
+	}
+}
+
+func TestStripJPEGWithoutGPS(t *testing.T) {
+	data := []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9}
+	out, stripped, err := stripJPEGGPS(data)
+	if err != nil {
+		t.Fatalf("stripJPEGGPS: %v", err)
+	}
+	if stripped || !bytes.Equal(out, data) {
+		t.Fatal("an image without Exif data was changed")
+	}
+}
+
+func TestStripTruncatedExif(t *testing.T) {
+	tiff := exifWithGPS()
+	// The GPS IFD points past the end of the structure
+	binary.LittleEndian.PutUint32(tiff[30:], 1000)
+	if _, _, err := stripJPEGGPS(jpegWithExif(tiff)); err == nil {
+		t.Fatal("a GPS IFD out of bounds was accepted")
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AppealHandlers struct {
+	dao dao.AppealDao
+}
+
+func NewAppealHandler() *AppealHandlers {
+	return &AppealHandlers{
+		dao: *dao.NewAppealDao(),
+	}
+}
+
+func (ah *AppealHandlers) GetFineAppeals(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	fine, err := dao.NewFineDao().GetFineById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrFineNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine"})
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, fine.ZoneId, username, role) {
+		owned, err := ah.dao.IsFineOwner(c.Request.Context(), id, username)
+		if err != nil || !owned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
+	appeals, err := ah.dao.GetFineAppeals(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get appeals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, appeals)
+}
+
+func (ah *AppealHandlers) CreateFineAppeal(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.FineAppealRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	appeal, err := ah.dao.CreateFineAppeal(c.Request.Context(), username, id, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrFineNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
+		case errors.Is(err, dao.ErrFineNotOwned):
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		case errors.Is(err, dao.ErrAppealInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrFineAlreadyPaid), errors.Is(err, dao.ErrFineCancelled), errors.Is(err, dao.ErrAppealAlreadyPending):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create appeal"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, appeal)
+}
+
+func (ah *AppealHandlers) DecideFineAppeal(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	zoneId, err := ah.dao.GetAppealZone(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrAppealNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "appeal not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get appeal"})
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, zoneId, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var decision api.FineAppealDecision
+	if err := c.ShouldBindJSON(&decision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	appeal, err := ah.dao.DecideFineAppeal(c.Request.Context(), username, id, decision)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrAppealNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "appeal not found"})
+		case errors.Is(err, dao.ErrAppealDecisionInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrAppealAlreadyDecided):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decide appeal"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, appeal)
+}
+
+func (ah *AppealHandlers) GetZoneAppeals(c *gin.Context, id int64, params api.GetZoneAppealsParams) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	pendingOnly := params.PendingOnly != nil && *params.PendingOnly
+	appeals, err := ah.dao.GetZoneAppeals(c.Request.Context(), id, pendingOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get appeals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, appeals)
+}
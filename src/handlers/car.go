@@ -131,3 +131,34 @@ func (ch *CarHandlers) AddUserCar(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, gin.H{"message": "car added successfully"})
 }
+
+func (ch *CarHandlers) SetCarAttributes(c *gin.Context, plate string) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	// Drivers could otherwise grant themselves discounts
+	if role != "superuser" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var attributes api.CarAttributes
+	if err := c.ShouldBindJSON(&attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	car, err := ch.dao.SetCarAttributes(c.Request.Context(), plate, attributes)
+	if err != nil {
+		if errors.Is(err, dao.ErrCarNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "car not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set car attributes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, car)
+}
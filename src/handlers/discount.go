@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DiscountHandlers struct {
+	dao dao.DiscountDao
+}
+
+func NewDiscountHandler() *DiscountHandlers {
+	return &DiscountHandlers{
+		dao: *dao.NewDiscountDao(),
+	}
+}
+
+// canManageDiscount tells whether the user may manage a discount of zones:
+// superusers, and admins of all of them. Discounts of every zone, without
+// zone_ids, are for superusers only.
+func canManageDiscount(c *gin.Context, zoneIds *[]int64, username string, role string) bool {
+	if zoneIds == nil {
+		return role == "superuser"
+	}
+	return isAdminOfZones(c, *zoneIds, username, role)
+}
+
+// getManagedDiscount loads a discount the user may manage, writing the
+// error response when it fails
+func (dh *DiscountHandlers) getManagedDiscount(c *gin.Context, id int64, username string, role string) (*api.Discount, bool) {
+	discount, err := dh.dao.GetDiscountById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrDiscountNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "discount not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get discount"})
+		return nil, false
+	}
+	if !canManageDiscount(c, discount.ZoneIds, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return discount, true
+}
+
+func (dh *DiscountHandlers) GetDiscounts(c *gin.Context) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	discounts, err := dh.dao.GetDiscounts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get discounts"})
+		return
+	}
+
+	managed := []api.Discount{}
+	for _, discount := range discounts {
+		if canManageDiscount(c, discount.ZoneIds, username, role) {
+			managed = append(managed, discount)
+		}
+	}
+
+	c.JSON(http.StatusOK, managed)
+}
+
+func (dh *DiscountHandlers) CreateDiscount(c *gin.Context) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.DiscountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !canManageDiscount(c, request.ZoneIds, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	discount, err := dh.dao.CreateDiscount(c.Request.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrZoneNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+		case errors.Is(err, dao.ErrDiscountInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrDiscountCodeTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add discount"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, discount)
+}
+
+func (dh *DiscountHandlers) UpdateDiscount(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	if _, ok := dh.getManagedDiscount(c, id, username, role); !ok {
+		return
+	}
+
+	var request api.DiscountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	// The zones the discount moves to must be managed by the user too
+	if !canManageDiscount(c, request.ZoneIds, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	discount, err := dh.dao.UpdateDiscount(c.Request.Context(), id, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrDiscountNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "discount not found"})
+		case errors.Is(err, dao.ErrZoneNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+		case errors.Is(err, dao.ErrDiscountInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrDiscountCodeTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update discount"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, discount)
+}
+
+func (dh *DiscountHandlers) GetDiscountRedemptions(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	if _, ok := dh.getManagedDiscount(c, id, username, role); !ok {
+		return
+	}
+
+	redemptions, err := dh.dao.GetDiscountRedemptions(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get discount redemptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, redemptions)
+}
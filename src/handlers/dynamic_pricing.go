@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DynamicPricingHandlers struct {
+	dao dao.DynamicPricingDao
+}
+
+func NewDynamicPricingHandler() *DynamicPricingHandlers {
+	return &DynamicPricingHandlers{
+		dao: *dao.NewDynamicPricingDao(),
+	}
+}
+
+func (dh *DynamicPricingHandlers) GetZoneDynamicPricing(c *gin.Context, id int64) {
+	policy, err := dh.dao.GetZoneDynamicPricing(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrDynamicPricingNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dynamic pricing policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get dynamic pricing policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func (dh *DynamicPricingHandlers) UpdateZoneDynamicPricing(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.DynamicPricingPolicy
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	policy, err := dh.dao.UpdateZoneDynamicPricing(c.Request.Context(), id, request)
+	if err != nil {
+		if errors.Is(err, dao.ErrDynamicPricingInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set dynamic pricing policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func (dh *DynamicPricingHandlers) DeleteZoneDynamicPricing(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	if err := dh.dao.DeleteZoneDynamicPricing(c.Request.Context(), id); err != nil {
+		if errors.Is(err, dao.ErrDynamicPricingNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dynamic pricing policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete dynamic pricing policy"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (dh *DynamicPricingHandlers) GetZonePriceLevels(c *gin.Context, id int64, params api.GetZonePriceLevelsParams) {
+	levels, err := dh.dao.GetZonePriceLevels(c.Request.Context(), id, params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get price levels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, levels)
+}
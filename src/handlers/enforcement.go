@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EnforcementHandlers struct {
+	dao dao.EnforcementDao
+}
+
+func NewEnforcementHandler() *EnforcementHandlers {
+	return &EnforcementHandlers{
+		dao: *dao.NewEnforcementDao(),
+	}
+}
+
+func (eh *EnforcementHandlers) CheckEnforcement(c *gin.Context, params api.CheckEnforcementParams) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	zone, err := eh.dao.ZoneAt(c.Request.Context(), params.Lat, params.Lon)
+	if err != nil {
+		if errors.Is(err, dao.ErrNoZoneAtLocation) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no zone at this location"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve zone"})
+		return
+	}
+
+	// Only the controllers of the zone enforce it
+	if role != "superuser" {
+		isController, err := NewZoneHandler().isZoneController(c, zone.Id, username)
+		if !isController || err != nil {
+			if !c.Writer.Written() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			}
+			return
+		}
+	}
+
+	check, err := eh.dao.CheckPlate(c.Request.Context(), params.Plate, params.Lat, params.Lon, zone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check plate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, check)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/blobstore"
+	"OPP/backend/dao"
+	"OPP/backend/evidence"
+	"OPP/backend/logger"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header carrying the hex SHA-256 of an evidence file
+const contentSHA256Header = "X-Content-SHA256"
+
+type EvidenceHandlers struct {
+	dao dao.EvidenceDao
+}
+
+func NewEvidenceHandler() *EvidenceHandlers {
+	return &EvidenceHandlers{
+		dao: *dao.NewEvidenceDao(),
+	}
+}
+
+// evidenceError writes the response for an evidence upload error
+func evidenceError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, evidence.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, evidence.ErrUnsupportedType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, evidence.ErrInvalidImage), errors.Is(err, dao.ErrEvidenceEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+func (eh *EvidenceHandlers) GetFineEvidence(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if _, ok := canSeeFine(c, id, username, role); !ok {
+		return
+	}
+
+	items, err := eh.dao.GetFineEvidence(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get evidence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+func (eh *EvidenceHandlers) AddFineEvidence(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	fine, err := dao.NewFineDao().GetFineById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrFineNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine"})
+		return
+	}
+	// Evidence is added by the staff of the zone
+	if !isZoneStaff(c, fine.ZoneId, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var upload api.EvidenceUpload
+	if err := c.ShouldBindJSON(&upload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	items, err := eh.dao.AddFineEvidence(c.Request.Context(), id, username, []api.EvidenceUpload{upload})
+	if err != nil {
+		if evidenceError(c, err) {
+			return
+		}
+		if errors.Is(err, dao.ErrFineNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add evidence"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, items[0])
+}
+
+func (eh *EvidenceHandlers) GetFineEvidenceFile(c *gin.Context, id int64, evidenceId int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if _, ok := canSeeFine(c, id, username, role); !ok {
+		return
+	}
+
+	item, data, err := eh.dao.GetFineEvidenceFile(c.Request.Context(), id, evidenceId)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrEvidenceNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "evidence not found"})
+		case errors.Is(err, dao.ErrEvidenceTampered), errors.Is(err, blobstore.ErrNotFound):
+			logger.Error.Printf("fine %d evidence %d failed the integrity check: %v", id, evidenceId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "evidence file failed the integrity check"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get evidence"})
+		}
+		return
+	}
+
+	c.Header(contentSHA256Header, *item.Sha256)
+	c.Data(http.StatusOK, *item.ContentType, data)
+}
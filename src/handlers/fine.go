@@ -20,6 +20,29 @@ func NewFineHandler() *FineHandlers {
 	}
 }
 
+// canSeeFine returns the fine when the user owns it or is staff of its
+// zone, and writes the response otherwise
+func canSeeFine(c *gin.Context, fineId int64, username string, role string) (*api.FineResponse, bool) {
+	fine, err := dao.NewFineDao().GetFineById(c.Request.Context(), fineId)
+	if err != nil {
+		if errors.Is(err, dao.ErrFineNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine"})
+		return nil, false
+	}
+	if isZoneStaff(c, fine.ZoneId, username, role) {
+		return fine, true
+	}
+	owned, err := dao.NewAppealDao().IsFineOwner(c.Request.Context(), fineId, username)
+	if err != nil || !owned {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return fine, true
+}
+
 func (fh *FineHandlers) GetFines(c *gin.Context, params api.GetFinesParams) {
 	_, role, err := auth.GetPermissions(c)
 	if err != nil {
@@ -69,11 +92,13 @@ func (fh *FineHandlers) CreateZoneFine(c *gin.Context, zoneId int64) {
 	if err != nil {
 		return
 	}
-	zh := NewZoneHandler()
-	isAdmin, errAdmin := zh.isZoneAdmin(c, zoneId, username)
-	isController, errController := zh.isZoneController(c, zoneId, username)
-	if role != "superuser" && !isAdmin && !isController && errAdmin != nil && errController != nil {
-		return
+	// Fines are issued by the controllers and admins of the zone
+	if role != "superuser" {
+		zoneRole, err := dao.NewZoneDao().GetZoneUserRole(c.Request.Context(), zoneId, username)
+		if err != nil || (zoneRole.Role != "controller" && zoneRole.Role != "admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
 	}
 
 	var fineRequest api.FineRequest
@@ -82,19 +107,132 @@ func (fh *FineHandlers) CreateZoneFine(c *gin.Context, zoneId int64) {
 		return
 	}
 
-	fine, err := fh.dao.CreateZoneFine(c.Request.Context(), zoneId, fineRequest)
+	fine, check, err := fh.dao.CreateZoneFine(c.Request.Context(), zoneId, username, fineRequest)
 	if err != nil {
-		if errors.Is(err, dao.ErrCarNotFound) {
+		switch {
+		case errors.Is(err, dao.ErrCarNotFound):
 			c.JSON(http.StatusNotFound, gin.H{"error": "car not found"})
-			return
+		case errors.Is(err, dao.ErrFineNotJustified):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "check": check})
+		case errors.Is(err, dao.ErrFineOutsideZone), errors.Is(err, dao.ErrFineOverrideReason), errors.Is(err, dao.ErrFineOffenseRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrFineScheduleMissing):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			if !evidenceError(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add fine"})
+			}
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add fine"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, fine)
 }
 
+func (fh *FineHandlers) GetZoneFineSchedule(c *gin.Context, id int64) {
+	schedule, err := fh.dao.GetZoneFineSchedule(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (fh *FineHandlers) UpdateZoneFineSchedule(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		isAdmin, err := NewZoneHandler().isZoneAdmin(c, id, username)
+		if !isAdmin || err != nil {
+			if !c.Writer.Written() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			}
+			return
+		}
+	}
+
+	var request api.FineScheduleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	schedule, err := fh.dao.UpdateZoneFineSchedule(c.Request.Context(), id, request)
+	if err != nil {
+		if errors.Is(err, dao.ErrFineScheduleInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update fine schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (fh *FineHandlers) GetZoneFineStages(c *gin.Context, id int64) {
+	stages, err := fh.dao.GetZoneFineStages(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine stages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stages)
+}
+
+func (fh *FineHandlers) UpdateZoneFineStages(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		isAdmin, err := NewZoneHandler().isZoneAdmin(c, id, username)
+		if !isAdmin || err != nil {
+			if !c.Writer.Written() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			}
+			return
+		}
+	}
+
+	var request api.FineStagesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	stages, err := fh.dao.UpdateZoneFineStages(c.Request.Context(), id, request)
+	if err != nil {
+		if errors.Is(err, dao.ErrFineStagesInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update fine stages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stages)
+}
+
 func (fh *FineHandlers) DeleteFines(c *gin.Context) {
 	_, role, err := auth.GetPermissions(c)
 	if err != nil {
@@ -171,17 +309,40 @@ func (fh *FineHandlers) DeleteFineById(c *gin.Context, id int64) {
 	c.JSON(http.StatusOK, gin.H{"message": "fine deleted successfully"})
 }
 
-func (fh *FineHandlers) PayFine(c *gin.Context, id int64) {
-	_, _, err := auth.GetPermissions(c)
+// PayFine marks a fine paid once confirmed payments cover it, after paying
+// what is left due from the wallet of the user with use_wallet
+func (fh *FineHandlers) PayFine(c *gin.Context, id int64, params api.PayFineParams) {
+	username, _, err := auth.GetPermissions(c)
 	if err != nil {
 		return
 	}
 
-	if err := fh.dao.PayFine(c.Request.Context(), id); err != nil {
+	if params.UseWallet != nil && *params.UseWallet {
+		err = fh.dao.PayFineWithWallet(c.Request.Context(), username, id)
+	} else {
+		err = fh.dao.PayFine(c.Request.Context(), id)
+	}
+	if err != nil {
 		if errors.Is(err, dao.ErrFineNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
 			return
 		}
+		if errors.Is(err, dao.ErrFineAlreadyPaid) {
+			c.JSON(http.StatusConflict, gin.H{"error": "fine already paid"})
+			return
+		}
+		if errors.Is(err, dao.ErrFineAppealPending) || errors.Is(err, dao.ErrFineCancelled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, dao.ErrPaymentRequired) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "no confirmed payment covers the fine"})
+			return
+		}
+		if errors.Is(err, dao.ErrWalletInsufficientFunds) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to pay fine"})
 		return
 	}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InvoiceHandlers struct {
+	dao dao.InvoiceDao
+}
+
+func NewInvoiceHandler() *InvoiceHandlers {
+	return &InvoiceHandlers{
+		dao: *dao.NewInvoiceDao(),
+	}
+}
+
+func (ih *InvoiceHandlers) GetUserBillingProfile(c *gin.Context) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	profile, err := ih.dao.GetUserBillingProfile(c.Request.Context(), username)
+	if err != nil {
+		if errors.Is(err, dao.ErrBillingProfileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "billing profile not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get billing profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (ih *InvoiceHandlers) UpdateUserBillingProfile(c *gin.Context) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.BillingProfile
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	profile, err := ih.dao.UpdateUserBillingProfile(c.Request.Context(), username, request)
+	if err != nil {
+		if errors.Is(err, dao.ErrBillingProfileInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set billing profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (ih *InvoiceHandlers) GetUserInvoices(c *gin.Context, params api.GetUserInvoicesParams) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	invoices, err := ih.dao.GetUserInvoices(c.Request.Context(), username, params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invoices)
+}
+
+func (ih *InvoiceHandlers) GetOperatorInvoices(c *gin.Context, id int64, params api.GetOperatorInvoicesParams) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	invoices, err := ih.dao.GetOperatorInvoices(c.Request.Context(), id, params.From, params.To, params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invoices)
+}
+
+// getOwnInvoice loads an invoice of the user, any invoice for superusers,
+// writing the error response when it fails
+func (ih *InvoiceHandlers) getOwnInvoice(c *gin.Context, id int64) (*api.Invoice, bool) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return nil, false
+	}
+
+	if role != "superuser" {
+		owned, err := ih.dao.IsInvoiceOwner(c.Request.Context(), id, username)
+		if err != nil {
+			if errors.Is(err, dao.ErrInvoiceNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+				return nil, false
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invoice"})
+			return nil, false
+		}
+		if !owned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return nil, false
+		}
+	}
+
+	inv, err := ih.dao.GetInvoiceById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrInvoiceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invoice"})
+		return nil, false
+	}
+	return inv, true
+}
+
+func (ih *InvoiceHandlers) GetInvoice(c *gin.Context, id int64) {
+	inv, ok := ih.getOwnInvoice(c, id)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, inv)
+}
+
+func (ih *InvoiceHandlers) GetInvoicePdf(c *gin.Context, id int64) {
+	inv, ok := ih.getOwnInvoice(c, id)
+	if !ok {
+		return
+	}
+
+	pdf, err := ih.dao.RenderInvoicePdf(c.Request.Context(), inv)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render invoice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"invoice-%d.pdf\"", id))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+func (ih *InvoiceHandlers) GetInvoiceXml(c *gin.Context, id int64) {
+	inv, ok := ih.getOwnInvoice(c, id)
+	if !ok {
+		return
+	}
+
+	document, err := ih.dao.RenderInvoiceXml(c.Request.Context(), inv)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render invoice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoice-%d.xml\"", id))
+	c.Data(http.StatusOK, "application/xml", document)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"OPP/backend/notice"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NoticeHandlers struct {
+	dao dao.NoticeDao
+}
+
+func NewNoticeHandler() *NoticeHandlers {
+	return &NoticeHandlers{
+		dao: *dao.NewNoticeDao(),
+	}
+}
+
+func (nh *NoticeHandlers) GetFineNotice(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	fine, ok := canSeeFine(c, id, username, role)
+	if !ok {
+		return
+	}
+
+	pdf, err := nh.dao.RenderFineNotice(c.Request.Context(), fine)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render notice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"fine-%d-notice.pdf\"", id))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+func (nh *NoticeHandlers) GetZoneNoticeTemplate(c *gin.Context, id int64) {
+	template, err := nh.dao.GetZoneNoticeTemplate(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get notice template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+func (nh *NoticeHandlers) UpdateZoneNoticeTemplate(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.NoticeTemplateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	template, err := nh.dao.UpdateZoneNoticeTemplate(c.Request.Context(), id, request)
+	if err != nil {
+		if errors.Is(err, notice.ErrInvalidTemplate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update notice template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OccupancyHandlers struct {
+	dao dao.OccupancyDao
+}
+
+func NewOccupancyHandler() *OccupancyHandlers {
+	return &OccupancyHandlers{
+		dao: *dao.NewOccupancyDao(),
+	}
+}
+
+func (oh *OccupancyHandlers) GetZoneOccupancy(c *gin.Context, id int64) {
+	occupancy, err := oh.dao.GetZoneOccupancy(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone occupancy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, occupancy)
+}
+
+// UpdateZoneOccupancySensor records a sensor reading, sent by the staff of
+// the zone or by the integrations they run
+func (oh *OccupancyHandlers) UpdateZoneOccupancySensor(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneStaff(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var reading api.ZoneSensorReading
+	if err := c.ShouldBindJSON(&reading); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	occupancy, err := oh.dao.UpdateZoneSensor(c.Request.Context(), id, reading)
+	if err != nil {
+		if errors.Is(err, dao.ErrOccupancyInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update zone sensor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, occupancy)
+}
+
+func (oh *OccupancyHandlers) GetZoneOccupancyHistory(c *gin.Context, id int64, params api.GetZoneOccupancyHistoryParams) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	snapshots, err := oh.dao.GetZoneOccupancyHistory(c.Request.Context(), id, params.From, params.To)
+	if err != nil {
+		if errors.Is(err, dao.ErrOccupancyInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone occupancy history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshots)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OperatorHandlers struct {
+	dao dao.OperatorDao
+}
+
+func NewOperatorHandler() *OperatorHandlers {
+	return &OperatorHandlers{
+		dao: *dao.NewOperatorDao(),
+	}
+}
+
+func (oh *OperatorHandlers) GetOperators(c *gin.Context) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	operators, err := oh.dao.GetOperators(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get operators"})
+		return
+	}
+
+	c.JSON(http.StatusOK, operators)
+}
+
+// writeOperatorError writes the response of a failed operator change
+func writeOperatorError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, dao.ErrOperatorNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "operator not found"})
+	case errors.Is(err, dao.ErrZoneNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+	case errors.Is(err, dao.ErrOperatorInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dao.ErrOperatorZoneTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func (oh *OperatorHandlers) CreateOperator(c *gin.Context) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.OperatorRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	operator, err := oh.dao.CreateOperator(c.Request.Context(), request)
+	if err != nil {
+		writeOperatorError(c, err, "failed to add operator")
+		return
+	}
+
+	c.JSON(http.StatusCreated, operator)
+}
+
+func (oh *OperatorHandlers) UpdateOperator(c *gin.Context, id int64) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.OperatorRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	operator, err := oh.dao.UpdateOperator(c.Request.Context(), id, request)
+	if err != nil {
+		writeOperatorError(c, err, "failed to update operator")
+		return
+	}
+
+	c.JSON(http.StatusOK, operator)
+}
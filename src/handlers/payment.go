@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"OPP/backend/payments"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PaymentHandlers struct {
+	dao dao.PaymentDao
+}
+
+func NewPaymentHandler() *PaymentHandlers {
+	return &PaymentHandlers{
+		dao: *dao.NewPaymentDao(),
+	}
+}
+
+func (ph *PaymentHandlers) createPayment(c *gin.Context, targetType string, targetId int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	payment, err := ph.dao.CreatePayment(c.Request.Context(), username, targetType, targetId)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrTicketNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+		case errors.Is(err, dao.ErrFineNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
+		case errors.Is(err, dao.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		case errors.Is(err, dao.ErrPermitNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit not found"})
+		case errors.Is(err, dao.ErrFineAppealPending), errors.Is(err, dao.ErrFineCancelled):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrSessionNotStopped):
+			c.JSON(http.StatusConflict, gin.H{"error": "only stopped sessions can be paid"})
+		case errors.Is(err, dao.ErrPermitNotApproved):
+			c.JSON(http.StatusConflict, gin.H{"error": "only approved permits can be paid"})
+		case errors.Is(err, dao.ErrNothingToPay):
+			c.JSON(http.StatusConflict, gin.H{"error": "nothing to pay"})
+		case errors.Is(err, payments.ErrNoProvider):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "payments are disabled"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create payment"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, payment)
+}
+
+func (ph *PaymentHandlers) CreateTicketPayment(c *gin.Context, id int64) {
+	ph.createPayment(c, dao.PaymentTargetTicket, id)
+}
+
+func (ph *PaymentHandlers) CreateFinePayment(c *gin.Context, id int64) {
+	ph.createPayment(c, dao.PaymentTargetFine, id)
+}
+
+func (ph *PaymentHandlers) CreateSessionPayment(c *gin.Context, id int64) {
+	ph.createPayment(c, dao.PaymentTargetSession, id)
+}
+
+func (ph *PaymentHandlers) CreatePermitPayment(c *gin.Context, id int64) {
+	ph.createPayment(c, dao.PaymentTargetPermit, id)
+}
+
+// CreateSubscriptionPayment pays the open billing period of a subscription,
+// the first one or a renewal whose automatic charge failed
+func (ph *PaymentHandlers) CreateSubscriptionPayment(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	periodId, err := dao.NewSubscriptionDao().GetOpenPeriodId(c.Request.Context(), username, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrSubscriptionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		case errors.Is(err, dao.ErrSubscriptionNotOwned):
+			c.JSON(http.StatusForbidden, gin.H{"error": "subscription not owned by user"})
+		case errors.Is(err, dao.ErrNothingToPay):
+			c.JSON(http.StatusConflict, gin.H{"error": "nothing to pay"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get subscription"})
+		}
+		return
+	}
+
+	ph.createPayment(c, dao.PaymentTargetSubscriptionPeriod, periodId)
+}
+
+func (ph *PaymentHandlers) GetPaymentById(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	if role != "superuser" && role != "admin" {
+		owned, err := ph.dao.IsPaymentOwner(c.Request.Context(), id, username)
+		if err != nil {
+			if errors.Is(err, dao.ErrPaymentNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get payment"})
+			return
+		}
+		if !owned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
+	payment, err := ph.dao.GetPaymentById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+func (ph *PaymentHandlers) ConfirmPayment(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	payment, err := ph.dao.ConfirmPayment(c.Request.Context(), username, id)
+	if err != nil {
+		if errors.Is(err, dao.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrPaymentNotOwned) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "payment not owned by user"})
+			return
+		}
+		if errors.Is(err, dao.ErrPaymentNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": "payment is not pending"})
+			return
+		}
+		if errors.Is(err, payments.ErrNoProvider) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "payments are disabled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+func (ph *PaymentHandlers) HandlePaymentWebhook(c *gin.Context) {
+	payload, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := ph.dao.HandleWebhook(c.Request.Context(), payload, c.Request.Header); err != nil {
+		if errors.Is(err, payments.ErrNoProvider) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "payments are disabled"})
+			return
+		}
+		if errors.Is(err, payments.ErrInvalidSignature) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+			return
+		}
+		if errors.Is(err, payments.ErrInvalidEvent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event"})
+			return
+		}
+		if errors.Is(err, dao.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process webhook"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (ph *PaymentHandlers) GetZoneRevenue(c *gin.Context, id int64, params api.GetZoneRevenueParams) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		isAdmin, err := NewZoneHandler().isZoneAdmin(c, id, username)
+		if !isAdmin || err != nil {
+			if !c.Writer.Written() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			}
+			return
+		}
+	}
+
+	if !params.From.Before(params.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	revenue, err := ph.dao.GetZoneRevenue(c.Request.Context(), id, params.From, params.To)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone revenue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revenue)
+}
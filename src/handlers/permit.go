@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PermitHandlers struct {
+	dao dao.PermitDao
+}
+
+func NewPermitHandler() *PermitHandlers {
+	return &PermitHandlers{
+		dao: *dao.NewPermitDao(),
+	}
+}
+
+// getPermit loads a permit, writing the error response when it fails
+func (ph *PermitHandlers) getPermit(c *gin.Context, id int64) (*api.Permit, bool) {
+	permit, err := ph.dao.GetPermitById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrPermitNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get permit"})
+		return nil, false
+	}
+	return permit, true
+}
+
+func (ph *PermitHandlers) GetZonePermitTypes(c *gin.Context, id int64) {
+	permitTypes, err := ph.dao.GetZonePermitTypes(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get permit types"})
+		return
+	}
+
+	c.JSON(http.StatusOK, permitTypes)
+}
+
+func (ph *PermitHandlers) CreateZonePermitType(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.PermitTypeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	permitType, err := ph.dao.CreateZonePermitType(c.Request.Context(), id, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrZoneNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+		case errors.Is(err, dao.ErrPermitTypeInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add permit type"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, permitType)
+}
+
+func (ph *PermitHandlers) UpdatePermitType(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	permitType, err := ph.dao.GetPermitTypeById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrPermitTypeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit type not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get permit type"})
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, permitType.ZoneId, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.PermitTypeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	permitType, err = ph.dao.UpdatePermitType(c.Request.Context(), id, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrPermitTypeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit type not found"})
+		case errors.Is(err, dao.ErrPermitTypeInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update permit type"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, permitType)
+}
+
+func (ph *PermitHandlers) GetZonePermits(c *gin.Context, id int64, params api.GetZonePermitsParams) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneStaff(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	permits, err := ph.dao.GetZonePermits(c.Request.Context(), id, params.Status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get permits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, permits)
+}
+
+func (ph *PermitHandlers) CreateZonePermit(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.PermitRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	permit, err := ph.dao.CreateZonePermit(c.Request.Context(), username, id, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrPermitTypeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit type not found"})
+		case errors.Is(err, dao.ErrPermitInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrPermitNotEligible):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply for permit"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, permit)
+}
+
+func (ph *PermitHandlers) GetUserPermits(c *gin.Context) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	permits, err := ph.dao.GetUserPermits(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user permits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, permits)
+}
+
+func (ph *PermitHandlers) GetPermitById(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	permit, ok := ph.getPermit(c, id)
+	if !ok {
+		return
+	}
+	if permit.Username != username && !isZoneStaff(c, permit.ZoneId, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	c.JSON(http.StatusOK, permit)
+}
+
+func (ph *PermitHandlers) DecidePermit(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	permit, ok := ph.getPermit(c, id)
+	if !ok {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, permit.ZoneId, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var decision api.PermitDecision
+	if err := c.ShouldBindJSON(&decision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	permit, err = ph.dao.DecidePermit(c.Request.Context(), username, id, decision)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrPermitNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit not found"})
+		case errors.Is(err, dao.ErrPermitDecision):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrPermitNotPending):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decide permit"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, permit)
+}
+
+func (ph *PermitHandlers) RenewPermit(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	permit, err := ph.dao.RenewPermit(c.Request.Context(), username, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrPermitNotFound), errors.Is(err, dao.ErrPermitTypeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit not found"})
+		case errors.Is(err, dao.ErrPermitNotOwned):
+			c.JSON(http.StatusForbidden, gin.H{"error": "permit not owned by user"})
+		case errors.Is(err, dao.ErrPermitInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrPermitNotEligible):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrPermitNotApproved), errors.Is(err, dao.ErrPermitRenewalClosed), errors.Is(err, dao.ErrPermitAlreadyRenewed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to renew permit"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, permit)
+}
+
+func (ph *PermitHandlers) CancelPermit(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	permit, ok := ph.getPermit(c, id)
+	if !ok {
+		return
+	}
+	if permit.Username != username && !isZoneAdminOrSuperuser(c, permit.ZoneId, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	permit, err = ph.dao.CancelPermit(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrPermitNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "permit not found"})
+		case errors.Is(err, dao.ErrPermitNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel permit"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, permit)
+}
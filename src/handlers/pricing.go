@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PricingHandlers struct {
+	dao dao.PricingDao
+}
+
+func NewPricingHandler() *PricingHandlers {
+	return &PricingHandlers{
+		dao: *dao.NewPricingDao(),
+	}
+}
+
+// GetZoneQuote prices a stay in a zone with the same logic used to create
+// tickets, nothing is stored
+func (ph *PricingHandlers) GetZoneQuote(c *gin.Context, id int64, params api.GetZoneQuoteParams) {
+	if params.Duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be positive"})
+		return
+	}
+
+	quote, err := ph.dao.QuoteZone(c.Request.Context(), id, params.Start, params.Duration, params.Plate, params.Code)
+	if err != nil {
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrCarNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "car not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrDiscountCodeInvalid) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute quote"})
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RefundHandlers struct {
+	dao dao.RefundDao
+}
+
+func NewRefundHandler() *RefundHandlers {
+	return &RefundHandlers{
+		dao: *dao.NewRefundDao(),
+	}
+}
+
+func (rh *RefundHandlers) getTicket(c *gin.Context, id int64) *api.TicketResponse {
+	ticket, err := dao.NewTicketDao().GetTicketById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return nil
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get ticket"})
+		return nil
+	}
+	return ticket
+}
+
+func (rh *RefundHandlers) GetTicketRefunds(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	ticket := rh.getTicket(c, id)
+	if ticket == nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, ticket.ZoneId, username, role) {
+		owned, err := dao.NewTicketDao().IsTicketOwner(c.Request.Context(), id, username)
+		if err != nil || !owned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
+	refunds, err := rh.dao.GetTicketRefunds(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, refunds)
+}
+
+func (rh *RefundHandlers) CreateTicketRefund(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.RefundRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	ticket := rh.getTicket(c, id)
+	if ticket == nil {
+		return
+	}
+	// Superusers and zone admins refund any amount, drivers may only ask
+	// for a refund computed by a policy
+	if !isZoneAdminOrSuperuser(c, ticket.ZoneId, username, role) {
+		owned, err := dao.NewTicketDao().IsTicketOwner(c.Request.Context(), id, username)
+		if err != nil || !owned || request.Policy == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
+	refunds, err := rh.dao.RefundTicket(c.Request.Context(), username, id, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrTicketNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+		case errors.Is(err, dao.ErrRefundPolicyUnknown), errors.Is(err, dao.ErrRefundTooLarge):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrRefundNotAllowed), errors.Is(err, dao.ErrNothingToRefund):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrTicketModified):
+			c.JSON(http.StatusConflict, gin.H{"error": "ticket was modified concurrently, retry"})
+		case errors.Is(err, dao.ErrRefundFailed):
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "refunds": refunds})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refund ticket"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, refunds)
+}
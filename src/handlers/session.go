@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandlers struct {
+	dao dao.SessionDao
+}
+
+func NewSessionHandler() *SessionHandlers {
+	return &SessionHandlers{
+		dao: *dao.NewSessionDao(),
+	}
+}
+
+func (sh *SessionHandlers) StartZoneSession(c *gin.Context, zoneId int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var sessionRequest api.SessionRequest
+	if err := c.ShouldBindJSON(&sessionRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	// Ensure Zone exists before starting a session
+	res, err := dao.NewZoneDao().ZoneExists(c, zoneId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check zone existence"})
+		return
+	}
+	if !res {
+		c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+		return
+	}
+
+	session, err := sh.dao.StartZoneSession(c.Request.Context(), username, zoneId, sessionRequest)
+	if err != nil {
+		if errors.Is(err, dao.ErrCarNotOwned) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "car not owned by user"})
+			return
+		}
+		if errors.Is(err, dao.ErrSessionAlreadyActive) {
+			c.JSON(http.StatusConflict, gin.H{"error": "an active session already exists for this car"})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneFull) {
+			c.JSON(http.StatusConflict, gin.H{"error": "zone is full"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+func (sh *SessionHandlers) GetSessionById(c *gin.Context, id int64) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" && role != "admin" && role != "controller" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	session, err := sh.dao.GetSessionById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (sh *SessionHandlers) StopSession(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	session, err := sh.dao.StopSession(c.Request.Context(), username, id)
+	if err != nil {
+		if errors.Is(err, dao.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrSessionNotOwned) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "session not owned by user"})
+			return
+		}
+		if errors.Is(err, dao.ErrSessionNotActive) {
+			c.JSON(http.StatusConflict, gin.H{"error": "session is not active"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stop session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (sh *SessionHandlers) PaySession(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	session, err := sh.dao.PaySession(c.Request.Context(), username, id)
+	if err != nil {
+		if errors.Is(err, dao.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrSessionNotOwned) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "session not owned by user"})
+			return
+		}
+		if errors.Is(err, dao.ErrSessionNotStopped) {
+			c.JSON(http.StatusConflict, gin.H{"error": "only stopped sessions can be paid"})
+			return
+		}
+		if errors.Is(err, dao.ErrPaymentRequired) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "no confirmed payment covers the session"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to pay session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (sh *SessionHandlers) GetUserSessions(c *gin.Context, params api.GetUserSessionsParams) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	activeOnly := params.ActiveOnly != nil && *params.ActiveOnly
+	sessions, err := sh.dao.GetUserSessions(c.Request.Context(), username, activeOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (sh *SessionHandlers) GetCarSessions(c *gin.Context, plate string) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" && role != "admin" && role != "controller" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	sessions, err := sh.dao.GetCarSessions(c.Request.Context(), plate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (sh *SessionHandlers) GetZoneSessions(c *gin.Context, zoneId int64, params api.GetZoneSessionsParams) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	zh := NewZoneHandler()
+	isAdmin, errAdmin := zh.isZoneAdmin(c, zoneId, username)
+	isController, errController := zh.isZoneController(c, zoneId, username)
+
+	if role != "superuser" && (errAdmin != nil || !isAdmin) && (errController != nil || !isController) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	activeOnly := params.ActiveOnly != nil && *params.ActiveOnly
+	sessions, err := sh.dao.GetZoneSessions(c.Request.Context(), zoneId, activeOnly, params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
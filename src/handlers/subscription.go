@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubscriptionHandlers struct {
+	dao dao.SubscriptionDao
+}
+
+func NewSubscriptionHandler() *SubscriptionHandlers {
+	return &SubscriptionHandlers{
+		dao: *dao.NewSubscriptionDao(),
+	}
+}
+
+// isAdminOfZones tells whether the user may manage a plan covering zones:
+// superusers, and admins of all of them
+func isAdminOfZones(c *gin.Context, zoneIds []int64, username string, role string) bool {
+	for _, zoneId := range zoneIds {
+		if !isZoneAdminOrSuperuser(c, zoneId, username, role) {
+			return false
+		}
+	}
+	return role == "superuser" || len(zoneIds) > 0
+}
+
+// getOwnSubscription loads a subscription the user owns, or any for
+// superusers, writing the error response when it fails
+func (sh *SubscriptionHandlers) getOwnSubscription(c *gin.Context, id int64) (*api.Subscription, bool) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return nil, false
+	}
+
+	subscription, err := sh.dao.GetSubscriptionById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrSubscriptionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get subscription"})
+		return nil, false
+	}
+	if subscription.Username != username && role != "superuser" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return subscription, true
+}
+
+func (sh *SubscriptionHandlers) GetSubscriptionPlans(c *gin.Context) {
+	plans, err := sh.dao.GetSubscriptionPlans(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get subscription plans"})
+		return
+	}
+
+	c.JSON(http.StatusOK, plans)
+}
+
+func (sh *SubscriptionHandlers) CreateSubscriptionPlan(c *gin.Context) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.SubscriptionPlanRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !isAdminOfZones(c, request.ZoneIds, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	plan, err := sh.dao.CreateSubscriptionPlan(c.Request.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrZoneNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+		case errors.Is(err, dao.ErrSubscriptionPlanInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add subscription plan"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, plan)
+}
+
+func (sh *SubscriptionHandlers) UpdateSubscriptionPlan(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	plan, err := sh.dao.GetSubscriptionPlanById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrSubscriptionPlanNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "subscription plan not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get subscription plan"})
+		return
+	}
+
+	var request api.SubscriptionPlanRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	// The zones the plan leaves must be managed by the user too
+	if !isAdminOfZones(c, plan.ZoneIds, username, role) || !isAdminOfZones(c, request.ZoneIds, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	plan, err = sh.dao.UpdateSubscriptionPlan(c.Request.Context(), id, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrSubscriptionPlanNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "subscription plan not found"})
+		case errors.Is(err, dao.ErrZoneNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+		case errors.Is(err, dao.ErrSubscriptionPlanInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update subscription plan"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (sh *SubscriptionHandlers) CreateSubscription(c *gin.Context) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.SubscriptionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	subscription, err := sh.dao.CreateSubscription(c.Request.Context(), username, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrSubscriptionPlanNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "subscription plan not found"})
+		case errors.Is(err, dao.ErrSubscriptionInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrSubscriptionNotEligible):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add subscription"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, subscription)
+}
+
+func (sh *SubscriptionHandlers) GetUserSubscriptions(c *gin.Context) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	subscriptions, err := sh.dao.GetUserSubscriptions(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+func (sh *SubscriptionHandlers) GetSubscriptionById(c *gin.Context, id int64) {
+	subscription, ok := sh.getOwnSubscription(c, id)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (sh *SubscriptionHandlers) GetSubscriptionPeriods(c *gin.Context, id int64) {
+	if _, ok := sh.getOwnSubscription(c, id); !ok {
+		return
+	}
+
+	periods, err := sh.dao.GetSubscriptionPeriods(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get billing periods"})
+		return
+	}
+
+	c.JSON(http.StatusOK, periods)
+}
+
+func (sh *SubscriptionHandlers) CancelSubscription(c *gin.Context, id int64) {
+	if _, ok := sh.getOwnSubscription(c, id); !ok {
+		return
+	}
+
+	subscription, err := sh.dao.CancelSubscription(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrSubscriptionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		case errors.Is(err, dao.ErrSubscriptionEnded):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel subscription"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TariffHandlers struct {
+	dao dao.TariffDao
+}
+
+func NewTariffHandler() *TariffHandlers {
+	return &TariffHandlers{
+		dao: *dao.NewTariffDao(),
+	}
+}
+
+func (th *TariffHandlers) GetZoneTariff(c *gin.Context, id int64) {
+	tariff, err := th.dao.GetZoneTariff(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrTariffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tariff not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get tariff"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tariff)
+}
+
+func (th *TariffHandlers) UpdateZoneTariff(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.ZoneTariff
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	tariff, err := th.dao.UpdateZoneTariff(c.Request.Context(), id, request)
+	if err != nil {
+		if errors.Is(err, dao.ErrTariffInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrHolidayCalendarNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "holiday calendar not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update tariff"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tariff)
+}
+
+func (th *TariffHandlers) DeleteZoneTariff(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if !isZoneAdminOrSuperuser(c, id, username, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	if err := th.dao.DeleteZoneTariff(c.Request.Context(), id); err != nil {
+		if errors.Is(err, dao.ErrTariffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tariff not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete tariff"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (th *TariffHandlers) GetHolidayCalendars(c *gin.Context) {
+	calendars, err := th.dao.GetHolidayCalendars(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get holiday calendars"})
+		return
+	}
+
+	c.JSON(http.StatusOK, calendars)
+}
+
+func (th *TariffHandlers) GetHolidayCalendarById(c *gin.Context, id int64) {
+	calendar, err := th.dao.GetHolidayCalendarById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrHolidayCalendarNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "holiday calendar not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get holiday calendar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, calendar)
+}
+
+func (th *TariffHandlers) CreateHolidayCalendar(c *gin.Context) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" && role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.HolidayCalendarRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	calendar, err := th.dao.CreateHolidayCalendar(c.Request.Context(), request)
+	if err != nil {
+		if errors.Is(err, dao.ErrHolidayCalendarAlreadyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "holiday calendar already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create holiday calendar"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, calendar)
+}
+
+func (th *TariffHandlers) UpdateHolidayCalendar(c *gin.Context, id int64) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" && role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var request api.HolidayCalendarRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	calendar, err := th.dao.UpdateHolidayCalendar(c.Request.Context(), id, request)
+	if err != nil {
+		if errors.Is(err, dao.ErrHolidayCalendarNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "holiday calendar not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrHolidayCalendarAlreadyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "holiday calendar already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update holiday calendar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, calendar)
+}
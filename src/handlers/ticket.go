@@ -4,9 +4,12 @@ import (
 	"OPP/backend/api"
 	"OPP/backend/auth"
 	"OPP/backend/dao"
+	"OPP/backend/qrcode"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"image/png"
 	"net/http"
 	"time"
 
@@ -136,17 +139,63 @@ func (th *TicketHandlers) CreateZoneTicket(c *gin.Context, zoneId int64) {
 
 	ticket, err := th.dao.CreateZoneTicket(c.Request.Context(), zoneId, ticketRequest)
 	if err != nil {
-		if errors.Is(err, dao.ErrCarNotFound) {
+		switch {
+		case errors.Is(err, dao.ErrCarNotFound):
 			c.JSON(http.StatusNotFound, gin.H{"error": "car not found"})
-			return
+		case errors.Is(err, dao.ErrDiscountCodeInvalid):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrZoneFull):
+			c.JSON(http.StatusConflict, gin.H{"error": "zone is full"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add ticket"})
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add ticket"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, ticket)
 }
 
+// GetTicketQr renders the signed token of a ticket as a QR code, for the
+// owner to show to a controller
+func (th *TicketHandlers) GetTicketQr(c *gin.Context, id int64) {
+	username, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	ticket, err := th.dao.GetTicketById(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, dao.ErrTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get ticket"})
+		return
+	}
+	if !isZoneStaff(c, ticket.ZoneId, username, role) {
+		owned, err := th.dao.IsTicketOwner(c.Request.Context(), id, username)
+		if err != nil || !owned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
+	code, err := qrcode.Encode(*ticket.Token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode ticket token"})
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code.Image(8)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode ticket token"})
+		return
+	}
+
+	// The token changes when the ticket does
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
 func (th *TicketHandlers) GetCarTickets(c *gin.Context, plate string) {
 	_, role, err := auth.GetPermissions(c)
 	if err != nil {
@@ -169,8 +218,20 @@ func (th *TicketHandlers) GetCarTickets(c *gin.Context, plate string) {
 	c.JSON(http.StatusOK, tickets)
 }
 
-func (th *TicketHandlers) PayTicket(c *gin.Context, id int64) {
-	ticket, err := th.dao.PayTicket(c.Request.Context(), id)
+// PayTicket marks a ticket paid once confirmed payments cover it, after
+// paying what is left due from the wallet of the user with use_wallet
+func (th *TicketHandlers) PayTicket(c *gin.Context, id int64, params api.PayTicketParams) {
+	var ticket *api.TicketResponse
+	var err error
+	if params.UseWallet != nil && *params.UseWallet {
+		username, _, authErr := auth.GetPermissions(c)
+		if authErr != nil {
+			return
+		}
+		ticket, err = th.dao.PayTicketWithWallet(c.Request.Context(), username, id)
+	} else {
+		ticket, err = th.dao.PayTicket(c.Request.Context(), id)
+	}
 	if err != nil {
 		if errors.Is(err, dao.ErrTicketAlreadyPaid) {
 			c.JSON(http.StatusConflict, gin.H{"error": "ticket already paid"})
@@ -180,6 +241,14 @@ func (th *TicketHandlers) PayTicket(c *gin.Context, id int64) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
 			return
 		}
+		if errors.Is(err, dao.ErrPaymentRequired) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "no confirmed payment covers the ticket"})
+			return
+		}
+		if errors.Is(err, dao.ErrWalletInsufficientFunds) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to pay ticket"})
 		return
 	}
@@ -187,6 +256,51 @@ func (th *TicketHandlers) PayTicket(c *gin.Context, id int64) {
 	c.JSON(http.StatusOK, ticket)
 }
 
+func (th *TicketHandlers) ExtendTicket(c *gin.Context, id int64) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var extensionRequest api.TicketExtensionRequest
+	if err := c.ShouldBindJSON(&extensionRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if extensionRequest.Duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be greater than zero"})
+		return
+	}
+
+	ticket, err := th.dao.ExtendTicket(c.Request.Context(), username, id, extensionRequest.Duration)
+	if err != nil {
+		if errors.Is(err, dao.ErrTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+		if errors.Is(err, dao.ErrTicketNotOwned) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "ticket not owned by user"})
+			return
+		}
+		if errors.Is(err, dao.ErrTicketExpired) {
+			c.JSON(http.StatusConflict, gin.H{"error": "ticket expired"})
+			return
+		}
+		if errors.Is(err, dao.ErrTicketModified) {
+			c.JSON(http.StatusConflict, gin.H{"error": "ticket was modified, retry"})
+			return
+		}
+		if errors.Is(err, dao.ErrZoneFull) {
+			c.JSON(http.StatusConflict, gin.H{"error": "zone is full"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to extend ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ticket)
+}
+
 func (th *TicketHandlers) GetUserTickets(c *gin.Context, params api.GetUserTicketsParams) {
 	username, _, err := auth.GetPermissions(c)
 	if err != nil {
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TicketKeyHandlers struct {
+	dao dao.TicketKeyDao
+}
+
+func NewTicketKeyHandler() *TicketKeyHandlers {
+	return &TicketKeyHandlers{
+		dao: *dao.NewTicketKeyDao(),
+	}
+}
+
+func (kh *TicketKeyHandlers) GetTicketKeys(c *gin.Context) {
+	keys, err := kh.dao.GetTicketKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get ticket keys"})
+		return
+	}
+
+	// Next keys are published a day ahead, an hour of caching is safe
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, keys)
+}
+
+func (kh *TicketKeyHandlers) RotateTicketKeys(c *gin.Context, params api.RotateTicketKeysParams) {
+	_, role, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+	if role != "superuser" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	keys, err := kh.dao.ForceRotateTicketKeys(c.Request.Context(), params.Revoke != nil && *params.Revoke)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate ticket keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"OPP/backend/api"
+	"OPP/backend/auth"
+	"OPP/backend/dao"
+	"OPP/backend/payments"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WalletHandlers struct {
+	dao dao.WalletDao
+}
+
+func NewWalletHandler() *WalletHandlers {
+	return &WalletHandlers{
+		dao: *dao.NewWalletDao(),
+	}
+}
+
+func (wh *WalletHandlers) GetUserWallet(c *gin.Context) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	wallet, err := wh.dao.GetUserWallet(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get wallet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, wallet)
+}
+
+// CreateWalletTopUp opens the payment of a top-up, the wallet is credited
+// when the payment is confirmed
+func (wh *WalletHandlers) CreateWalletTopUp(c *gin.Context) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	var request api.WalletTopUpRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	payment, err := wh.dao.CreateWalletTopUp(c.Request.Context(), username, request.Amount)
+	if err != nil {
+		if errors.Is(err, dao.ErrWalletTopUpInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, payments.ErrNoProvider) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "payments are disabled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create wallet top-up"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, payment)
+}
+
+func (wh *WalletHandlers) GetUserWalletTransactions(c *gin.Context, params api.GetUserWalletTransactionsParams) {
+	username, _, err := auth.GetPermissions(c)
+	if err != nil {
+		return
+	}
+
+	transactions, err := wh.dao.GetUserWalletTransactions(c.Request.Context(), username, params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get wallet transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
@@ -37,6 +37,26 @@ func (zh *ZoneHandlers) isZoneAdmin(c *gin.Context, zoneId int64, username strin
 	return false, nil
 }
 
+// isZoneAdminOrSuperuser tells whether the user is a superuser or an admin of
+// the zone. Unlike isZoneAdmin it never writes the response.
+func isZoneAdminOrSuperuser(c *gin.Context, zoneId int64, username string, role string) bool {
+	if role == "superuser" {
+		return true
+	}
+	zoneRole, err := dao.NewZoneDao().GetZoneUserRole(c.Request.Context(), zoneId, username)
+	return err == nil && zoneRole.Role == "admin"
+}
+
+// isZoneStaff tells whether the user is a superuser, an admin or a
+// controller of the zone. It never writes the response.
+func isZoneStaff(c *gin.Context, zoneId int64, username string, role string) bool {
+	if role == "superuser" {
+		return true
+	}
+	zoneRole, err := dao.NewZoneDao().GetZoneUserRole(c.Request.Context(), zoneId, username)
+	return err == nil && (zoneRole.Role == "admin" || zoneRole.Role == "controller")
+}
+
 func (zh *ZoneHandlers) isZoneController(c *gin.Context, zoneId int64, username string) (bool, error) {
 	ZoneUserRole, err := zh.dao.GetZoneUserRole(c.Request.Context(), zoneId, username)
 	if err != nil && !errors.Is(err, dao.ErrZoneUserRoleNotFound) {
@@ -59,6 +79,10 @@ func (zh *ZoneHandlers) GetZones(c *gin.Context, params api.GetZonesParams) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zones"})
 		return
 	}
+	if err := dao.NewOccupancyDao().FillZoneOccupancy(c.Request.Context(), zones); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone occupancy"})
+		return
+	}
 
 	c.JSON(http.StatusOK, zones)
 }
@@ -89,6 +113,10 @@ func (zh *ZoneHandlers) CreateZone(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "zone overlaps with existing zone"})
 			return
 		}
+		if errors.Is(err, dao.ErrZonePricingInvalid) || errors.Is(err, dao.ErrZoneCapacityInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create zone"})
 		return
 	}
@@ -116,8 +144,13 @@ func (zh *ZoneHandlers) GetZoneById(c *gin.Context, id int64) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone"})
 		return
 	}
+	zones := []api.ZoneResponse{*zone}
+	if err := dao.NewOccupancyDao().FillZoneOccupancy(c.Request.Context(), zones); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone occupancy"})
+		return
+	}
 
-	c.JSON(http.StatusOK, zone)
+	c.JSON(http.StatusOK, zones[0])
 }
 
 func (zh *ZoneHandlers) UpdateZoneById(c *gin.Context, id int64) {
@@ -146,6 +179,10 @@ func (zh *ZoneHandlers) UpdateZoneById(c *gin.Context, id int64) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "zone overlaps with existing zone"})
 			return
 		}
+		if errors.Is(err, dao.ErrZonePricingInvalid) || errors.Is(err, dao.ErrZoneCapacityInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update zone"})
 		return
 	}
@@ -186,8 +223,13 @@ func (zh *ZoneHandlers) GetZoneByLocation(c *gin.Context, params api.GetZoneByLo
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check zone location"})
 		return
 	}
+	zones := []api.ZoneResponse{*zone}
+	if err := dao.NewOccupancyDao().FillZoneOccupancy(c.Request.Context(), zones); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get zone occupancy"})
+		return
+	}
 
-	c.JSON(http.StatusOK, zone)
+	c.JSON(http.StatusOK, zones[0])
 }
 
 func (zh *ZoneHandlers) GetZoneUsers(c *gin.Context, id int64) {
@@ -0,0 +1,179 @@
+package idempotency
+
+import (
+	"OPP/backend/logger"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header carrying the client chosen key of a request
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set on responses replayed from the store
+const ReplayedHeader = "Idempotent-Replayed"
+
+// Longest key accepted
+const maxKeyLength = 255
+
+// How long a key and its response are kept, as a Go duration
+var IDEMPOTENCY_TTL = os.Getenv("IDEMPOTENCY_TTL")
+
+const defaultTTL = 24 * time.Hour
+
+var ErrKeyNotFound = errors.New("idempotency key not found")
+
+// Response is a response stored for a key
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Record is what a store holds for a key. Response is nil while the first
+// request with the key is still being handled.
+type Record struct {
+	RequestHash string
+	Response    *Response
+}
+
+// Store keeps idempotency keys, scoped per user
+type Store interface {
+	// Reserve claims a key for a request with the given hash. It returns nil
+	// when the key was free (or expired) and is now held by the caller, and
+	// the existing record otherwise.
+	Reserve(c context.Context, scope string, key string, requestHash string, ttl time.Duration) (*Record, error)
+	// Save stores the response of a reserved key
+	Save(c context.Context, scope string, key string, response Response) error
+	// Release frees a reserved key so that the request can be retried
+	Release(c context.Context, scope string, key string) error
+}
+
+// TTL returns the configured retention window of the keys
+func TTL() time.Duration {
+	if IDEMPOTENCY_TTL != "" {
+		if d, err := time.ParseDuration(IDEMPOTENCY_TTL); err == nil && d > 0 {
+			return d
+		}
+		fmt.Printf("Invalid IDEMPOTENCY_TTL %q, using %s\n", IDEMPOTENCY_TTL, defaultTTL)
+	}
+	return defaultTTL
+}
+
+// requestHash identifies a request by its method, path, query and body
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// recorder copies the response body while it is written
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Middleware makes mutating requests carrying an Idempotency-Key header
+// safe to retry. The first response for a key is stored for ttl and
+// replayed to later requests with the same key and the same content; a
+// different content gets 409. Keys are scoped per authenticated user, and
+// anonymous requests are not deduplicated since their clients cannot be
+// told apart. Server errors are not stored, so the request can be retried.
+func Middleware(store Store, ttl time.Duration) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		// The authentication func stores the username in the request context
+		scope, _ := c.Request.Context().Value("username").(string)
+		if key == "" || scope == "" || !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", Header, maxKeyLength)})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(c.Request, body)
+
+		ctx := c.Request.Context()
+		record, err := store.Reserve(ctx, scope, key, hash, ttl)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check idempotency key"})
+			return
+		}
+		if record != nil {
+			switch {
+			case record.RequestHash != hash:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "idempotency key already used for a different request"})
+			case record.Response == nil:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this idempotency key is in progress"})
+			default:
+				c.Header(ReplayedHeader, "true")
+				c.Data(record.Response.Status, record.Response.ContentType, record.Response.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		rec := &recorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		defer func() {
+			// Also frees the key when a handler panics
+			if p := recover(); p != nil {
+				store.Release(context.WithoutCancel(ctx), scope, key)
+				panic(p)
+			}
+		}()
+		c.Next()
+
+		// The client may be gone, the outcome must still be recorded
+		ctx = context.WithoutCancel(ctx)
+		if rec.Status() >= http.StatusInternalServerError {
+			store.Release(ctx, scope, key)
+			return
+		}
+		response := Response{
+			Status:      rec.Status(),
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		}
+		if err := store.Save(ctx, scope, key, response); err != nil {
+			logger.Error.Printf("failed to store idempotent response for key %q: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memStore is an in-memory Store
+type memStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func newMemStore() *memStore {
+	return &memStore{records: map[string]*Record{}}
+}
+
+func (s *memStore) Reserve(c context.Context, scope string, key string, requestHash string, ttl time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[scope+"\x00"+key]; ok {
+		copied := *record
+		return &copied, nil
+	}
+	s.records[scope+"\x00"+key] = &Record{RequestHash: requestHash}
+	return nil, nil
+}
+
+func (s *memStore) Save(c context.Context, scope string, key string, response Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[scope+"\x00"+key]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	record.Response = &response
+	return nil
+}
+
+func (s *memStore) Release(c context.Context, scope string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, scope+"\x00"+key)
+	return nil
+}
+
+// testRouter serves POST /things behind the middleware, as the user given
+// in the X-User header, answering status and counting the handled calls
+func testRouter(store Store, status *atomic.Int32, calls *atomic.Int32) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), "username", user))
+		}
+	})
+	r.Use(Middleware(store, time.Hour))
+	r.POST("/things", func(c *gin.Context) {
+		n := calls.Add(1)
+		c.JSON(int(status.Load()), gin.H{"call": n})
+	})
+	return r
+}
+
+func post(r *gin.Engine, user string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddlewareReplaysResponse(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusCreated)
+	r := testRouter(newMemStore(), &status, &calls)
+
+	first := post(r, "alice", "k1", `{"a":1}`)
+	second := post(r, "alice", "k1", `{"a":1}`)
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times, want 1", calls.Load())
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Fatalf("replayed %d %q, want %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get(ReplayedHeader) != "true" {
+		t.Fatalf("replayed response lacks %s", ReplayedHeader)
+	}
+
+	// Keys are scoped per user
+	if other := post(r, "bob", "k1", `{"a":1}`); other.Header().Get(ReplayedHeader) != "" {
+		t.Fatal("response of another user replayed")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("handler called %d times, want 2", calls.Load())
+	}
+}
+
+func TestMiddlewareRejectsDifferentRequest(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusOK)
+	r := testRouter(newMemStore(), &status, &calls)
+
+	post(r, "alice", "k1", `{"a":1}`)
+	if w := post(r, "alice", "k1", `{"a":2}`); w.Code != http.StatusConflict {
+		t.Fatalf("different body got %d, want 409", w.Code)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times, want 1", calls.Load())
+	}
+}
+
+func TestMiddlewareRejectsInProgress(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusOK)
+	store := newMemStore()
+	r := testRouter(store, &status, &calls)
+
+	// The first request holds the key without a response yet
+	req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(`{"a":1}`))
+	if _, err := store.Reserve(context.Background(), "alice", "k1", requestHash(req, []byte(`{"a":1}`)), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := post(r, "alice", "k1", `{"a":1}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "in progress") {
+		t.Fatalf("request in progress got %d %q, want 409 in progress", w.Code, w.Body.String())
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("handler called %d times, want 0", calls.Load())
+	}
+}
+
+func TestMiddlewareReleasesAfterServerError(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	r := testRouter(newMemStore(), &status, &calls)
+
+	if w := post(r, "alice", "k1", `{"a":1}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("first call got %d, want 500", w.Code)
+	}
+	status.Store(http.StatusCreated)
+	w := post(r, "alice", "k1", `{"a":1}`)
+	if w.Code != http.StatusCreated || w.Header().Get(ReplayedHeader) != "" {
+		t.Fatalf("retry got %d replayed %q, want a fresh 201", w.Code, w.Header().Get(ReplayedHeader))
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("handler called %d times, want 2", calls.Load())
+	}
+}
+
+func TestMiddlewareIgnoresAnonymousRequests(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusCreated)
+	store := newMemStore()
+	r := testRouter(store, &status, &calls)
+
+	post(r, "", "k1", `{"a":1}`)
+	if w := post(r, "", "k1", `{"a":2}`); w.Code != http.StatusCreated || w.Header().Get(ReplayedHeader) != "" {
+		t.Fatalf("anonymous request got %d replayed %q, want a fresh 201", w.Code, w.Header().Get(ReplayedHeader))
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("handler called %d times, want 2", calls.Load())
+	}
+	if len(store.records) != 0 {
+		t.Fatalf("%d anonymous keys stored, want 0", len(store.records))
+	}
+}
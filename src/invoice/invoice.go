@@ -0,0 +1,202 @@
+// Package invoice computes the VAT of invoices and renders them as PDF
+// documents and as UBL XML for accounting tools.
+package invoice
+
+import (
+	"OPP/backend/money"
+	"OPP/backend/pdf"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Party is the issuer or the buyer of an invoice
+type Party struct {
+	Name      string
+	Address   string
+	Country   string
+	VatNumber string
+}
+
+// Line is a line of an invoice. Amounts are in minor units, and negative
+// on credit notes.
+type Line struct {
+	Description string
+	// VatRate is in basis points, 2200 is 22%
+	VatRate int
+	Net     int64
+	Vat     int64
+	Total   int64
+}
+
+// Invoice is what the documents show
+type Invoice struct {
+	Number     string
+	CreditNote bool
+	// Credits is the number of the invoice a credit note credits
+	Credits     string
+	IssueTime   time.Time
+	PeriodStart *time.Time
+	PeriodEnd   *time.Time
+	Issuer      Party
+	// Buyer is nil on the invoices of users without a billing profile
+	Buyer    *Party
+	Currency string
+	Lines    []Line
+	Net      int64
+	Vat      int64
+	Total    int64
+}
+
+// SplitVat splits a VAT inclusive total in its net amount and VAT, at rate
+// basis points. The net amount is rounded half away from zero, so that net
+// and VAT always sum to the total.
+func SplitVat(total int64, rate int) (int64, int64) {
+	sign := int64(1)
+	if total < 0 {
+		sign, total = -1, -total
+	}
+	base := int64(10000 + rate)
+	net := (total*10000*2 + base) / (2 * base)
+	return sign * net, sign * (total - net)
+}
+
+// NewLine returns a line of a VAT inclusive total
+func NewLine(description string, total int64, rate int) Line {
+	net, vat := SplitVat(total, rate)
+	return Line{Description: description, VatRate: rate, Net: net, Vat: vat, Total: total}
+}
+
+// AddLine adds a line to the invoice and to its totals
+func (inv *Invoice) AddLine(line Line) {
+	inv.Lines = append(inv.Lines, line)
+	inv.Net += line.Net
+	inv.Vat += line.Vat
+	inv.Total += line.Total
+}
+
+// FormatRate formats a rate in basis points as a percentage, e.g. "22%"
+// or "5.5%"
+func FormatRate(rate int) string {
+	if rate%100 == 0 {
+		return fmt.Sprintf("%d%%", rate/100)
+	}
+	return strings.TrimRight(fmt.Sprintf("%d.%02d", rate/100, rate%100), "0") + "%"
+}
+
+func (inv Invoice) amount(minor int64) string {
+	return money.New(minor, inv.Currency).String()
+}
+
+func (inv Invoice) title() string {
+	if inv.CreditNote {
+		return "Credit note"
+	}
+	return "Invoice"
+}
+
+// Render renders the invoice as a PDF document. Lines that do not fit on
+// the first page continue on the following ones.
+func Render(inv Invoice) ([]byte, error) {
+	const margin = 50.0
+	const dateLayout = "02 Jan 2006"
+	brand := pdf.Color{R: 31, G: 78, B: 121}
+	grey := pdf.Color{R: 90, G: 90, B: 90}
+	right := pdf.A4Width - margin
+	bottom := pdf.A4Height - 80
+
+	doc := pdf.New(pdf.A4Width, pdf.A4Height)
+	doc.Title = inv.title() + " " + inv.Number
+	page := doc.AddPage()
+
+	// Header band with the issuer and the number
+	page.Rect(0, 0, pdf.A4Width, 90, brand)
+	page.Text(margin, 40, pdf.HelveticaBold, 20, pdf.White, inv.title())
+	page.Text(margin, 65, pdf.Helvetica, 12, pdf.White, inv.Issuer.Name)
+	page.TextRight(right, 40, pdf.HelveticaBold, 12, pdf.White, "No. "+inv.Number)
+	page.TextRight(right, 65, pdf.Helvetica, 11, pdf.White, inv.IssueTime.UTC().Format(dateLayout))
+
+	// Both parties side by side
+	party := func(x float64, label string, p Party) float64 {
+		y := 125.0
+		page.Text(x, y, pdf.Helvetica, 9, grey, label)
+		y += 18
+		page.Text(x, y, pdf.HelveticaBold, 11, pdf.Black, p.Name)
+		y = page.Paragraph(x, y+15, 220, pdf.Helvetica, 10, pdf.Black, p.Address)
+		page.Text(x, y, pdf.Helvetica, 10, pdf.Black, p.Country)
+		y += 13
+		if p.VatNumber != "" {
+			page.Text(x, y, pdf.Helvetica, 10, pdf.Black, "VAT "+p.VatNumber)
+			y += 13
+		}
+		return y
+	}
+	y := party(margin, "Issued by", inv.Issuer)
+	if inv.Buyer != nil {
+		y = max(y, party(pdf.A4Width/2, "Billed to", *inv.Buyer))
+	}
+
+	y += 15
+	if inv.PeriodStart != nil && inv.PeriodEnd != nil {
+		// Periods end at the start of the following day
+		period := fmt.Sprintf("Period %s - %s", inv.PeriodStart.UTC().Format(dateLayout), inv.PeriodEnd.UTC().Add(-time.Second).Format(dateLayout))
+		page.Text(margin, y, pdf.Helvetica, 10, pdf.Black, period)
+		y += 15
+	}
+	if inv.Credits != "" {
+		page.Text(margin, y, pdf.Helvetica, 10, pdf.Black, "Credits invoice "+inv.Credits)
+		y += 15
+	}
+
+	// Lines, one column per amount
+	columns := []float64{right - 200, right - 130, right - 65, right}
+	header := func(y float64) float64 {
+		page.Line(margin, y, right, y, 1, brand)
+		y += 16
+		page.Text(margin, y, pdf.HelveticaBold, 9, grey, "Description")
+		for i, label := range []string{"VAT rate", "Net", "VAT", "Total"} {
+			page.TextRight(columns[i], y, pdf.HelveticaBold, 9, grey, label)
+		}
+		return y + 18
+	}
+	y = header(y + 10)
+	for _, line := range inv.Lines {
+		wrapped := pdf.Wrap(pdf.Helvetica, 9, columns[0]-60-margin, line.Description)
+		if y+float64(len(wrapped))*12 > bottom {
+			page = doc.AddPage()
+			page.Text(margin, 50, pdf.Helvetica, 9, grey, fmt.Sprintf("%s %s, continued", inv.title(), inv.Number))
+			y = header(65)
+		}
+		for i, value := range []string{FormatRate(line.VatRate), inv.amount(line.Net), inv.amount(line.Vat), inv.amount(line.Total)} {
+			page.TextRight(columns[i], y, pdf.Helvetica, 9, pdf.Black, value)
+		}
+		for _, text := range wrapped {
+			page.Text(margin, y, pdf.Helvetica, 9, pdf.Black, text)
+			y += 12
+		}
+		y += 4
+	}
+	if y+90 > bottom {
+		page = doc.AddPage()
+		y = 50
+	}
+
+	// Totals
+	page.Line(margin, y, right, y, 1, brand)
+	y += 20
+	for _, total := range [][2]string{{"Net", inv.amount(inv.Net)}, {"VAT", inv.amount(inv.Vat)}} {
+		page.Text(columns[0], y, pdf.Helvetica, 10, grey, total[0])
+		page.TextRight(right, y, pdf.Helvetica, 10, pdf.Black, total[1])
+		y += 16
+	}
+	page.Text(columns[0], y+6, pdf.HelveticaBold, 12, pdf.Black, "Total")
+	page.TextRight(right, y+6, pdf.HelveticaBold, 16, brand, inv.amount(inv.Total))
+
+	footer := "Paid in full, prices include VAT."
+	if inv.CreditNote {
+		footer = "Refunded in full, amounts include VAT."
+	}
+	page.Text(margin, pdf.A4Height-50, pdf.Helvetica, 8, grey, footer)
+
+	return doc.Bytes()
+}
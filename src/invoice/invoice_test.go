@@ -0,0 +1,69 @@
+package invoice
+
+import "testing"
+
+func TestSplitVat(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int64
+		rate  int
+		net   int64
+		vat   int64
+	}{
+		{"exact", 1220, 2200, 1000, 220},
+		{"rounded net", 1000, 2200, 820, 180},
+		{"half rounded away from zero", 3, 2000, 3, 0},
+		{"fractional rate", 1055, 550, 1000, 55},
+		{"fractional rate rounded", 100, 550, 95, 5},
+		{"ten percent", 1000, 1000, 909, 91},
+		{"smallest amount", 1, 2200, 1, 0},
+		{"zero rate", 999, 0, 999, 0},
+		{"zero total", 0, 2200, 0, 0},
+		{"credit note", -1220, 2200, -1000, -220},
+		{"credit note rounded", -1000, 2200, -820, -180},
+		{"credit note half", -3, 2000, -3, 0},
+		{"credit note fractional rate", -100, 550, -95, -5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			net, vat := SplitVat(tt.total, tt.rate)
+			if net != tt.net || vat != tt.vat {
+				t.Fatalf("SplitVat(%d, %d) = %d, %d, want %d, %d", tt.total, tt.rate, net, vat, tt.net, tt.vat)
+			}
+		})
+	}
+}
+
+func TestSplitVatSumsToTotal(t *testing.T) {
+	for _, rate := range []int{0, 400, 550, 1000, 2100, 2200, 2500} {
+		for total := int64(-2000); total <= 2000; total++ {
+			net, vat := SplitVat(total, rate)
+			if net+vat != total {
+				t.Fatalf("SplitVat(%d, %d) = %d + %d", total, rate, net, vat)
+			}
+			// A credit note mirrors the invoice it credits
+			if creditNet, creditVat := SplitVat(-total, rate); creditNet != -net || creditVat != -vat {
+				t.Fatalf("SplitVat(%d, %d) = %d, %d, not the opposite of %d, %d", -total, rate, creditNet, creditVat, net, vat)
+			}
+		}
+	}
+}
+
+func TestInvoiceLines(t *testing.T) {
+	var inv Invoice
+	inv.AddLine(NewLine("ticket", 1000, 2200))
+	inv.AddLine(NewLine("refund", -500, 2200))
+	inv.AddLine(NewLine("book", 1055, 550))
+	if inv.Net != 820-410+1000 || inv.Vat != 180-90+55 || inv.Total != 1555 {
+		t.Fatalf("totals %d + %d = %d", inv.Net, inv.Vat, inv.Total)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := map[int]string{0: "0%", 2200: "22%", 550: "5.5%", 1050: "10.5%", 5: "0.05%"}
+	for rate, want := range tests {
+		if got := FormatRate(rate); got != want {
+			t.Errorf("FormatRate(%d) = %q, want %q", rate, got, want)
+		}
+	}
+}
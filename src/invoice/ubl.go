@@ -0,0 +1,216 @@
+package invoice
+
+import (
+	"OPP/backend/money"
+	"encoding/xml"
+	"fmt"
+	"sort"
+)
+
+// UBL 2.1 namespaces. Credit notes are CreditNote documents, whose amounts
+// are positive.
+const (
+	ublInvoiceNamespace    = "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+	ublCreditNoteNamespace = "urn:oasis:names:specification:ubl:schema:xsd:CreditNote-2"
+	ublCacNamespace        = "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+	ublCbcNamespace        = "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+)
+
+// UBL type codes of commercial invoices and credit notes
+const (
+	ublInvoiceTypeCode    = "380"
+	ublCreditNoteTypeCode = "381"
+)
+
+type ublAmount struct {
+	Currency string `xml:"currencyID,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// ublNamed is an element whose name depends on the document, e.g.
+// InvoiceTypeCode or CreditNoteTypeCode
+type ublNamed struct {
+	XMLName  xml.Name
+	UnitCode string `xml:"unitCode,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+type ublPeriod struct {
+	StartDate string `xml:"cbc:StartDate"`
+	EndDate   string `xml:"cbc:EndDate"`
+}
+
+type ublReference struct {
+	Id string `xml:"cbc:ID"`
+}
+
+type ublParty struct {
+	Name       string         `xml:"cac:Party>cac:PartyName>cbc:Name"`
+	StreetName string         `xml:"cac:Party>cac:PostalAddress>cbc:StreetName"`
+	Country    string         `xml:"cac:Party>cac:PostalAddress>cac:Country>cbc:IdentificationCode"`
+	TaxScheme  []ublTaxScheme `xml:"cac:Party>cac:PartyTaxScheme"`
+	LegalName  string         `xml:"cac:Party>cac:PartyLegalEntity>cbc:RegistrationName"`
+}
+
+type ublTaxScheme struct {
+	CompanyId string `xml:"cbc:CompanyID"`
+	TaxScheme string `xml:"cac:TaxScheme>cbc:ID"`
+}
+
+type ublTaxCategory struct {
+	Id        string `xml:"cbc:ID"`
+	Percent   string `xml:"cbc:Percent"`
+	TaxScheme string `xml:"cac:TaxScheme>cbc:ID"`
+}
+
+type ublTaxSubtotal struct {
+	TaxableAmount ublAmount      `xml:"cbc:TaxableAmount"`
+	TaxAmount     ublAmount      `xml:"cbc:TaxAmount"`
+	TaxCategory   ublTaxCategory `xml:"cac:TaxCategory"`
+}
+
+type ublLine struct {
+	XMLName             xml.Name
+	Id                  string         `xml:"cbc:ID"`
+	Quantity            ublNamed       `xml:""`
+	LineExtensionAmount ublAmount      `xml:"cbc:LineExtensionAmount"`
+	Description         string         `xml:"cac:Item>cbc:Description"`
+	Name                string         `xml:"cac:Item>cbc:Name"`
+	TaxCategory         ublTaxCategory `xml:"cac:Item>cac:ClassifiedTaxCategory"`
+	PriceAmount         ublAmount      `xml:"cac:Price>cbc:PriceAmount"`
+}
+
+type ublDocument struct {
+	XMLName              xml.Name
+	Namespace            string           `xml:"xmlns,attr"`
+	CacNamespace         string           `xml:"xmlns:cac,attr"`
+	CbcNamespace         string           `xml:"xmlns:cbc,attr"`
+	Id                   string           `xml:"cbc:ID"`
+	IssueDate            string           `xml:"cbc:IssueDate"`
+	TypeCode             ublNamed         `xml:""`
+	DocumentCurrencyCode string           `xml:"cbc:DocumentCurrencyCode"`
+	Period               *ublPeriod       `xml:"cac:InvoicePeriod"`
+	BillingReference     *ublReference    `xml:"cac:BillingReference>cac:InvoiceDocumentReference"`
+	Supplier             ublParty         `xml:"cac:AccountingSupplierParty"`
+	Customer             *ublParty        `xml:"cac:AccountingCustomerParty"`
+	TaxAmount            ublAmount        `xml:"cac:TaxTotal>cbc:TaxAmount"`
+	TaxSubtotals         []ublTaxSubtotal `xml:"cac:TaxTotal>cac:TaxSubtotal"`
+	LineExtensionAmount  ublAmount        `xml:"cac:LegalMonetaryTotal>cbc:LineExtensionAmount"`
+	TaxExclusiveAmount   ublAmount        `xml:"cac:LegalMonetaryTotal>cbc:TaxExclusiveAmount"`
+	TaxInclusiveAmount   ublAmount        `xml:"cac:LegalMonetaryTotal>cbc:TaxInclusiveAmount"`
+	PrepaidAmount        ublAmount        `xml:"cac:LegalMonetaryTotal>cbc:PrepaidAmount"`
+	PayableAmount        ublAmount        `xml:"cac:LegalMonetaryTotal>cbc:PayableAmount"`
+	Lines                []ublLine
+}
+
+// ublDecimal formats minor units as a decimal number of major units
+func ublDecimal(minor int64, currency string) string {
+	decimals := money.Decimals(currency)
+	if decimals == 0 {
+		return fmt.Sprintf("%d", minor)
+	}
+	scale := money.MinorPerMajor(currency)
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/scale, decimals, minor%scale)
+}
+
+// ublTaxCategoryOf returns the standard rated (S) or zero rated (Z) VAT
+// category of a rate
+func ublTaxCategoryOf(rate int) ublTaxCategory {
+	category := ublTaxCategory{Id: "S", Percent: fmt.Sprintf("%d.%02d", rate/100, rate%100), TaxScheme: "VAT"}
+	if rate == 0 {
+		category.Id = "Z"
+	}
+	return category
+}
+
+func ublPartyOf(p Party) ublParty {
+	party := ublParty{Name: p.Name, StreetName: p.Address, Country: p.Country, LegalName: p.Name}
+	if p.VatNumber != "" {
+		party.TaxScheme = []ublTaxScheme{{CompanyId: p.VatNumber, TaxScheme: "VAT"}}
+	}
+	return party
+}
+
+// MarshalUBL returns the invoice as a UBL 2.1 Invoice or CreditNote
+// document. The invoice is already paid, so nothing is left payable.
+func MarshalUBL(inv Invoice) ([]byte, error) {
+	root, namespace, typeCode, line, quantity := "Invoice", ublInvoiceNamespace, ublInvoiceTypeCode, "cac:InvoiceLine", "cbc:InvoicedQuantity"
+	sign := int64(1)
+	if inv.CreditNote {
+		root, namespace, typeCode, line, quantity = "CreditNote", ublCreditNoteNamespace, ublCreditNoteTypeCode, "cac:CreditNoteLine", "cbc:CreditedQuantity"
+		sign = -1
+	}
+	amount := func(minor int64) ublAmount {
+		return ublAmount{Currency: inv.Currency, Value: ublDecimal(sign*minor, inv.Currency)}
+	}
+
+	doc := ublDocument{
+		XMLName:              xml.Name{Local: root},
+		Namespace:            namespace,
+		CacNamespace:         ublCacNamespace,
+		CbcNamespace:         ublCbcNamespace,
+		Id:                   inv.Number,
+		IssueDate:            inv.IssueTime.UTC().Format("2006-01-02"),
+		TypeCode:             ublNamed{XMLName: xml.Name{Local: "cbc:" + root + "TypeCode"}, Value: typeCode},
+		DocumentCurrencyCode: inv.Currency,
+		Supplier:             ublPartyOf(inv.Issuer),
+		TaxAmount:            amount(inv.Vat),
+		LineExtensionAmount:  amount(inv.Net),
+		TaxExclusiveAmount:   amount(inv.Net),
+		TaxInclusiveAmount:   amount(inv.Total),
+		PrepaidAmount:        amount(inv.Total),
+		PayableAmount:        amount(0),
+	}
+	if inv.PeriodStart != nil && inv.PeriodEnd != nil {
+		doc.Period = &ublPeriod{StartDate: inv.PeriodStart.UTC().Format("2006-01-02"), EndDate: inv.PeriodEnd.UTC().AddDate(0, 0, -1).Format("2006-01-02")}
+	}
+	if inv.Credits != "" {
+		doc.BillingReference = &ublReference{Id: inv.Credits}
+	}
+	if inv.Buyer != nil {
+		customer := ublPartyOf(*inv.Buyer)
+		doc.Customer = &customer
+	}
+
+	// One VAT subtotal per rate
+	subtotals := map[int]*ublTaxSubtotal{}
+	nets, vats := map[int]int64{}, map[int]int64{}
+	for i, l := range inv.Lines {
+		if _, ok := subtotals[l.VatRate]; !ok {
+			subtotals[l.VatRate] = &ublTaxSubtotal{TaxCategory: ublTaxCategoryOf(l.VatRate)}
+		}
+		nets[l.VatRate] += l.Net
+		vats[l.VatRate] += l.Vat
+		doc.Lines = append(doc.Lines, ublLine{
+			XMLName:             xml.Name{Local: line},
+			Id:                  fmt.Sprintf("%d", i+1),
+			Quantity:            ublNamed{XMLName: xml.Name{Local: quantity}, UnitCode: "C62", Value: "1"},
+			LineExtensionAmount: amount(l.Net),
+			Description:         l.Description,
+			Name:                l.Description,
+			TaxCategory:         ublTaxCategoryOf(l.VatRate),
+			PriceAmount:         amount(l.Net),
+		})
+	}
+	rates := make([]int, 0, len(subtotals))
+	for rate := range subtotals {
+		rates = append(rates, rate)
+	}
+	sort.Ints(rates)
+	for _, rate := range rates {
+		subtotal := subtotals[rate]
+		subtotal.TaxableAmount = amount(nets[rate])
+		subtotal.TaxAmount = amount(vats[rate])
+		doc.TaxSubtotals = append(doc.TaxSubtotals, *subtotal)
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode invoice: %w", err)
+	}
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"OPP/backend/logger"
+	"context"
+	"time"
+)
+
+// Every runs fn every interval in a background goroutine until ctx is done.
+// Errors are logged and the job keeps running.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := fn(ctx); err != nil {
+					logger.Error.Printf("job %s failed: %v", name, err)
+				}
+			}
+		}
+	}()
+}
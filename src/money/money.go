@@ -0,0 +1,91 @@
+// Package money represents amounts as integer minor units (cents) of an
+// ISO 4217 currency, so that prices add up exactly and match the amounts
+// exchanged with the payment provider.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidCurrency  = errors.New("invalid currency code")
+	ErrInvalidRounding  = errors.New("invalid rounding rule")
+)
+
+// DefaultCurrency is the currency of zones created without one
+const DefaultCurrency = "EUR"
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Currencies whose minor unit is not the cent, by number of decimals
+var currencyDecimals = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// ValidateCurrency checks that code looks like an ISO 4217 code
+func ValidateCurrency(code string) error {
+	if !currencyCode.MatchString(code) {
+		return fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+	}
+	return nil
+}
+
+// Decimals returns the number of decimals of the currency minor unit
+func Decimals(currency string) int {
+	if d, ok := currencyDecimals[currency]; ok {
+		return d
+	}
+	return 2
+}
+
+// MinorPerMajor returns how many minor units make a major unit,
+// e.g. 100 cents in a euro
+func MinorPerMajor(currency string) int64 {
+	return int64(math.Pow10(Decimals(currency)))
+}
+
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+// String formats the amount in major units, e.g. "12.50 EUR"
+func (m Money) String() string {
+	decimals := Decimals(m.Currency)
+	if decimals == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	scale := MinorPerMajor(m.Currency)
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, decimals, amount%scale, m.Currency)
+}
@@ -0,0 +1,65 @@
+package money
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rounding modes
+const (
+	RoundHalfUp   = "half_up"
+	RoundHalfEven = "half_even"
+	RoundUp       = "up"
+	RoundDown     = "down"
+)
+
+// Rounding turns a computed, fractional amount of minor units into a
+// chargeable one. Increment is the smallest chargeable step in minor
+// units, e.g. 5 where 1 and 2 cent coins are not used.
+type Rounding struct {
+	Mode      string
+	Increment int64
+}
+
+// DefaultRounding rounds half up to the minor unit
+var DefaultRounding = Rounding{Mode: RoundHalfUp, Increment: 1}
+
+func (r Rounding) Validate() error {
+	switch r.Mode {
+	case RoundHalfUp, RoundHalfEven, RoundUp, RoundDown:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidRounding, r.Mode)
+	}
+	if r.Increment < 1 {
+		return fmt.Errorf("%w: increment must be at least 1", ErrInvalidRounding)
+	}
+	return nil
+}
+
+// Round rounds a fractional amount of minor units to a multiple of the
+// increment. Amounts are never negative in pricing, so up and down are
+// towards positive and negative infinity.
+func (r Rounding) Round(minor float64) int64 {
+	increment := r.Increment
+	if increment < 1 {
+		increment = 1
+	}
+	steps := minor / float64(increment)
+
+	// Drop the float noise of the pricing arithmetic, so that 250.00000001
+	// is not rounded up to the next step
+	steps = math.Round(steps*1e6) / 1e6
+
+	var rounded float64
+	switch r.Mode {
+	case RoundHalfEven:
+		rounded = math.RoundToEven(steps)
+	case RoundUp:
+		rounded = math.Ceil(steps)
+	case RoundDown:
+		rounded = math.Floor(steps)
+	default:
+		rounded = math.Round(steps)
+	}
+	return int64(rounded) * increment
+}
@@ -0,0 +1,298 @@
+// Package notice renders the printable notice of a fine, left on the
+// windscreen or sent by mail, as a PDF document.
+package notice
+
+import (
+	"OPP/backend/money"
+	"OPP/backend/pdf"
+	"OPP/backend/qrcode"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	_ "time/tzdata"
+)
+
+// Link encoded in the QR code of notices, {id} and {reference} are
+// replaced with those of the fine
+var FINE_PAYMENT_URL = os.Getenv("FINE_PAYMENT_URL")
+
+const defaultPaymentURL = "opp://fines/{id}/pay?reference={reference}"
+
+var ErrInvalidTemplate = errors.New("invalid notice template")
+
+// Template is the text and branding of the notices of a zone. Body and
+// Footer are text/template templates of Fields.
+type Template struct {
+	Title      string
+	Issuer     string
+	Body       string
+	Footer     string
+	Color      string
+	PaymentURL string
+	Timezone   string
+}
+
+// DefaultTemplate is used for the fields a zone leaves empty
+func DefaultTemplate() Template {
+	paymentURL := FINE_PAYMENT_URL
+	if paymentURL == "" {
+		paymentURL = defaultPaymentURL
+	}
+	return Template{
+		Title:  "Parking fine notice",
+		Issuer: "{{.ZoneName}}",
+		Body: "A parking fine was issued to the vehicle {{.Plate}} in {{.ZoneName}} on {{.Date}} for the offense {{.Offense}}. " +
+			"Pay {{.Amount}} with the payment reference {{.Reference}}, or scan the QR code to pay online.",
+		Footer:     "Payments made after this notice was printed are not shown. Keep this notice until the fine is paid.",
+		Color:      "#1F4E79",
+		PaymentURL: paymentURL,
+		Timezone:   "UTC",
+	}
+}
+
+// Merge returns t with its empty fields taken from defaults
+func (t Template) Merge(defaults Template) Template {
+	pick := func(value string, fallback string) string {
+		if strings.TrimSpace(value) == "" {
+			return fallback
+		}
+		return value
+	}
+	return Template{
+		Title:      pick(t.Title, defaults.Title),
+		Issuer:     pick(t.Issuer, defaults.Issuer),
+		Body:       pick(t.Body, defaults.Body),
+		Footer:     pick(t.Footer, defaults.Footer),
+		Color:      pick(t.Color, defaults.Color),
+		PaymentURL: pick(t.PaymentURL, defaults.PaymentURL),
+		Timezone:   pick(t.Timezone, defaults.Timezone),
+	}
+}
+
+// Stage is a period of the fine stages, with the amount due during it
+type Stage struct {
+	Name    string
+	From    time.Time
+	Percent int
+	Amount  money.Money
+	Current bool
+}
+
+// Fine is what a notice shows
+type Fine struct {
+	Id         int64
+	Plate      string
+	ZoneName   string
+	Offense    string
+	Status     string
+	Paid       bool
+	Date       time.Time
+	Amount     money.Money
+	BaseAmount money.Money
+	// Stages is empty when the amount no longer changes
+	Stages []Stage
+}
+
+// Fields are the values available to the Title, Issuer, Body and Footer
+// templates
+type Fields struct {
+	FineId     int64
+	Plate      string
+	ZoneName   string
+	Offense    string
+	Date       string
+	Amount     string
+	BaseAmount string
+	Reference  string
+	PaymentURL string
+}
+
+// Reference returns the payment reference of a fine: its number and two
+// check digits, computed as in IBANs
+func Reference(fineId int64) string {
+	return fmt.Sprintf("OPP-%08d-%02d", fineId, 98-(fineId%97)*100%97)
+}
+
+// paymentURL returns the payment link of a fine
+func (t Template) paymentURL(fineId int64) string {
+	return strings.NewReplacer("{id}", strconv.FormatInt(fineId, 10), "{reference}", Reference(fineId)).Replace(t.PaymentURL)
+}
+
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+func (t Template) color() pdf.Color {
+	var c pdf.Color
+	fmt.Sscanf(t.Color, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return c
+}
+
+func execute(name string, text string, fields Fields) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, fields); err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+	}
+	return out.String(), nil
+}
+
+// Validate checks a complete template by rendering a sample notice
+func (t Template) Validate() error {
+	if !colorPattern.MatchString(t.Color) {
+		return fmt.Errorf("%w: color must be #RRGGBB", ErrInvalidTemplate)
+	}
+	if _, err := time.LoadLocation(t.Timezone); err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidTemplate, t.Timezone)
+	}
+	// The largest id must still fit in the QR code
+	if _, err := qrcode.Encode(t.paymentURL(1<<53 - 1)); err != nil {
+		return fmt.Errorf("%w: payment url too long", ErrInvalidTemplate)
+	}
+	sample := Fine{
+		Id:         1,
+		Plate:      "AB123CD",
+		ZoneName:   "Sample zone",
+		Offense:    "expired",
+		Status:     "open",
+		Date:       time.Now(),
+		Amount:     money.New(5000, money.DefaultCurrency),
+		BaseAmount: money.New(5000, money.DefaultCurrency),
+	}
+	_, err := Render(sample, t)
+	return err
+}
+
+// Render renders the notice of a fine with a complete template
+func Render(fine Fine, t Template) ([]byte, error) {
+	location, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidTemplate, t.Timezone)
+	}
+	const dateLayout = "02 Jan 2006 15:04 MST"
+	fields := Fields{
+		FineId:     fine.Id,
+		Plate:      fine.Plate,
+		ZoneName:   fine.ZoneName,
+		Offense:    fine.Offense,
+		Date:       fine.Date.In(location).Format(dateLayout),
+		Amount:     fine.Amount.String(),
+		BaseAmount: fine.BaseAmount.String(),
+		Reference:  Reference(fine.Id),
+		PaymentURL: t.paymentURL(fine.Id),
+	}
+	texts := map[string]string{}
+	for _, part := range [][2]string{{"title", t.Title}, {"issuer", t.Issuer}, {"body", t.Body}, {"footer", t.Footer}} {
+		if texts[part[0]], err = execute(part[0], part[1], fields); err != nil {
+			return nil, err
+		}
+	}
+	code, err := qrcode.Encode(fields.PaymentURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payment link: %w", err)
+	}
+
+	const margin = 50.0
+	brand := t.color()
+	grey := pdf.Color{R: 90, G: 90, B: 90}
+	doc := pdf.New(pdf.A4Width, pdf.A4Height)
+	doc.Title = texts["title"]
+	page := doc.AddPage()
+	right := pdf.A4Width - margin
+
+	// Header band in the zone color
+	page.Rect(0, 0, pdf.A4Width, 90, brand)
+	page.Text(margin, 40, pdf.HelveticaBold, 20, pdf.White, texts["title"])
+	page.Text(margin, 65, pdf.Helvetica, 12, pdf.White, texts["issuer"])
+	page.TextRight(right, 40, pdf.HelveticaBold, 12, pdf.White, "No. "+strconv.FormatInt(fine.Id, 10))
+
+	// Fine details
+	y := 130.0
+	details := [][2]string{
+		{"Plate", fine.Plate},
+		{"Zone", fine.ZoneName},
+		{"Date", fields.Date},
+		{"Offense", fine.Offense},
+		{"Payment reference", fields.Reference},
+	}
+	for _, detail := range details {
+		page.Text(margin, y, pdf.Helvetica, 11, grey, detail[0])
+		page.Text(margin+120, y, pdf.HelveticaBold, 11, pdf.Black, detail[1])
+		y += 20
+	}
+
+	// QR code to the payment page, next to the details
+	const qrSize = 130.0
+	qrX, qrY := right-qrSize, 115.0
+	drawCode(page, code, qrX, qrY, qrSize)
+	caption := "Scan to pay"
+	page.Text(qrX+(qrSize-pdf.TextWidth(pdf.Helvetica, 9, caption))/2, qrY+qrSize+14, pdf.Helvetica, 9, grey, caption)
+
+	// Amount due, and how it changes with time
+	y = 290
+	page.Line(margin, y, right, y, 1, brand)
+	y += 30
+	label, amount := "Amount due", fine.Amount.String()
+	switch {
+	case fine.Paid:
+		label = "Paid"
+	case fine.Status == "cancelled":
+		label, amount = "Cancelled", "-"
+	case fine.Status == "appealed":
+		label = "Amount due, suspended while appealed"
+	}
+	page.Text(margin, y, pdf.Helvetica, 12, grey, label)
+	page.TextRight(right, y, pdf.HelveticaBold, 22, brand, amount)
+	y += 28
+	for _, stage := range fine.Stages {
+		line := fmt.Sprintf("%s, from %s", stage.Name, stage.From.In(location).Format("02 Jan 2006"))
+		if stage.Percent != 0 {
+			line += fmt.Sprintf(" (%+d%%)", stage.Percent)
+		}
+		font := pdf.Helvetica
+		if stage.Current {
+			font = pdf.HelveticaBold
+			line += ", current"
+		}
+		page.Text(margin, y, font, 11, pdf.Black, line)
+		page.TextRight(right, y, font, 11, pdf.Black, stage.Amount.String())
+		y += 18
+	}
+	y += 10
+	page.Line(margin, y, right, y, 1, brand)
+
+	// Zone text
+	page.Paragraph(margin, y+30, right-margin, pdf.Helvetica, 11, pdf.Black, texts["body"])
+	page.Paragraph(margin, pdf.A4Height-70, right-margin, pdf.Helvetica, 8, grey, texts["footer"])
+
+	return doc.Bytes()
+}
+
+// drawCode draws a QR code in a size by size square at x, y, with the
+// light margin the standard asks for
+func drawCode(page *pdf.Page, code *qrcode.Code, x float64, y float64, size float64) {
+	const quiet = 4
+	module := size / float64(code.Size+2*quiet)
+	page.Rect(x, y, size, size, pdf.White)
+	for row := 0; row < code.Size; row++ {
+		// One rectangle per run of dark modules
+		for col := 0; col < code.Size; {
+			if !code.Dark(col, row) {
+				col++
+				continue
+			}
+			start := col
+			for col < code.Size && code.Dark(col, row) {
+				col++
+			}
+			page.Rect(x+float64(quiet+start)*module, y+float64(quiet+row)*module, float64(col-start)*module, module, pdf.Black)
+		}
+	}
+}
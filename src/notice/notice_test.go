@@ -0,0 +1,164 @@
+package notice
+
+import (
+	"OPP/backend/money"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var streamPattern = regexp.MustCompile(`(?s)<< /Length (\d+) /Filter /FlateDecode >>\nstream\n(.*?)\nendstream\nendobj\n`)
+
+// pageContent checks the header, cross-reference table and trailer of a
+// PDF file and returns the inflated content of its pages
+func pageContent(t *testing.T, file []byte) string {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte("%PDF-1.")) {
+		t.Fatal("missing PDF header")
+	}
+	var xref int
+	end := bytes.LastIndex(file, []byte("startxref\n"))
+	if end < 0 {
+		t.Fatal("missing startxref")
+	}
+	if _, err := fmt.Sscanf(string(file[end:]), "startxref\n%d\n%%%%EOF\n", &xref); err != nil || xref >= end {
+		t.Fatalf("bad startxref: %v", err)
+	}
+	var count int
+	if _, err := fmt.Sscanf(string(file[xref:]), "xref\n0 %d\n", &count); err != nil {
+		t.Fatalf("no xref table at startxref %d: %v", xref, err)
+	}
+	lines := strings.Split(string(file[xref:end]), "\n")
+	if len(lines) != count+5 || lines[2] != "0000000000 65535 f " {
+		t.Fatalf("bad xref table %q", lines)
+	}
+	for n := 1; n < count; n++ {
+		offset, err := strconv.Atoi(strings.TrimSuffix(lines[2+n], " 00000 n "))
+		if err != nil || !bytes.HasPrefix(file[offset:], []byte(fmt.Sprintf("%d 0 obj\n", n))) {
+			t.Fatalf("xref entry %q of object %d does not point at it", lines[2+n], n)
+		}
+	}
+	if trailer := fmt.Sprintf("<< /Size %d /Root 1 0 R /Info 3 0 R >>", count); lines[count+2] != "trailer" || lines[count+3] != trailer {
+		t.Fatalf("bad trailer %q", lines[count+2:])
+	}
+
+	var content strings.Builder
+	for _, m := range streamPattern.FindAllSubmatch(file, -1) {
+		if length, _ := strconv.Atoi(string(m[1])); length != len(m[2]) {
+			t.Fatalf("/Length %d, stream of %d bytes", length, len(m[2]))
+		}
+		r, err := zlib.NewReader(bytes.NewReader(m[2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(&content, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if content.Len() == 0 {
+		t.Fatal("no page content")
+	}
+	return content.String()
+}
+
+// fixtureFine is an open fine of 50 EUR, due at 35 EUR when paid within
+// five days and at 60 EUR after thirty
+func fixtureFine() Fine {
+	issued := time.Date(2026, 3, 9, 14, 30, 0, 0, time.UTC)
+	return Fine{
+		Id:         1234,
+		Plate:      "AB123CD",
+		ZoneName:   "Harbour (north)",
+		Offense:    "expired",
+		Status:     "open",
+		Date:       issued,
+		Amount:     money.New(3500, "EUR"),
+		BaseAmount: money.New(5000, "EUR"),
+		Stages: []Stage{
+			{Name: "Early payment", From: issued, Percent: -30, Amount: money.New(3500, "EUR"), Current: true},
+			{Name: "Issued", From: issued.AddDate(0, 0, 5), Amount: money.New(5000, "EUR")},
+			{Name: "Late", From: issued.AddDate(0, 0, 30), Percent: 20, Amount: money.New(6000, "EUR")},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	file, err := Render(fixtureFine(), DefaultTemplate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := pageContent(t, file)
+
+	for _, want := range []string{
+		"(AB123CD) Tj",
+		`(Harbour \(north\)) Tj`,
+		"(Amount due) Tj",
+		"(35.00 EUR) Tj",
+		"(Early payment, from 09 Mar 2026 \\(-30%\\), current) Tj",
+		"(Issued, from 14 Mar 2026) Tj",
+		"(50.00 EUR) Tj",
+		"(Late, from 08 Apr 2026 \\(+20%\\)) Tj",
+		"(60.00 EUR) Tj",
+		"(" + Reference(1234) + ") Tj",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("notice lacks %q", want)
+		}
+	}
+	// The body repeats the reference, wrapped on its own line or not
+	if strings.Count(content, Reference(1234)) < 2 {
+		t.Error("body lacks the payment reference")
+	}
+}
+
+func TestRenderCancelled(t *testing.T) {
+	fine := fixtureFine()
+	fine.Status = "cancelled"
+	fine.Stages = nil
+	file, err := Render(fine, DefaultTemplate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := pageContent(t, file)
+	if !strings.Contains(content, "(Cancelled) Tj") || strings.Contains(content, "(Amount due) Tj") {
+		t.Fatal("cancelled notice still shows an amount due")
+	}
+}
+
+func TestReference(t *testing.T) {
+	if got := Reference(1); got != "OPP-00000001-95" {
+		t.Fatalf("Reference(1) = %q, want OPP-00000001-95", got)
+	}
+	for _, id := range []int64{1, 96, 97, 1234, 99999999} {
+		// As in IBANs, the number followed by the check digits is 1 modulo 97
+		ref := Reference(id)
+		n, _ := strconv.ParseInt(ref[4:12]+ref[13:], 10, 64)
+		if n%97 != 1 {
+			t.Errorf("Reference(%d) = %q, check digits do not verify", id, ref)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultTemplate().Validate(); err != nil {
+		t.Fatalf("default template: %v", err)
+	}
+	bad := []Template{
+		{Color: "blue"},
+		{Timezone: "Mars/Olympus"},
+		{Body: "{{.Unknown}}"},
+		{PaymentURL: "https://example.com/" + strings.Repeat("x", 500)},
+	}
+	for _, tmpl := range bad {
+		if err := tmpl.Merge(DefaultTemplate()).Validate(); !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("Validate(%+v) = %v, want ErrInvalidTemplate", tmpl, err)
+		}
+	}
+}
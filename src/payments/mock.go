@@ -0,0 +1,202 @@
+package payments
+
+import (
+	"OPP/backend/money"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// MockSignatureHeader carries the hex HMAC-SHA256 of the webhook payload
+const MockSignatureHeader = "X-Mock-Signature"
+
+type mockIntent struct {
+	amount   money.Money
+	refunded int64
+	status   string
+}
+
+// MockProvider is an in-process provider for development and tests.
+// Payments are confirmed as soon as Confirm is called, nothing leaves
+// the process and its state is lost on restart, so NewProvider only
+// hands it out in debug mode.
+type MockProvider struct {
+	mu      sync.Mutex
+	secret  []byte
+	intents map[string]*mockIntent
+	refunds map[string]string
+}
+
+// NewMockProvider creates a mock provider verifying webhooks with secret.
+// Without a secret a random one is used and webhooks can only be signed
+// with SignWebhook.
+func NewMockProvider(secret string) *MockProvider {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = []byte(randomHex(32))
+	}
+	return &MockProvider{
+		secret:  key,
+		intents: map[string]*mockIntent{},
+		refunds: map[string]string{},
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+func (p *MockProvider) CreateIntent(ctx context.Context, amount money.Money, description string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reference := "mock_pi_" + randomHex(12)
+	p.intents[reference] = &mockIntent{amount: amount, status: StatusPending}
+	return &Intent{
+		Reference:    reference,
+		ClientSecret: reference + "_secret_" + randomHex(12),
+		Status:       StatusPending,
+	}, nil
+}
+
+func (p *MockProvider) Confirm(ctx context.Context, reference string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[reference]
+	if !ok {
+		return "", ErrUnknownReference
+	}
+	if intent.status == StatusPending {
+		intent.status = StatusConfirmed
+	}
+	return intent.status, nil
+}
+
+func (p *MockProvider) Cancel(ctx context.Context, reference string) error {
+	return p.Fail(reference)
+}
+
+// Fail marks a pending payment as failed, to simulate a declined card
+func (p *MockProvider) Fail(reference string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[reference]
+	if !ok {
+		return ErrUnknownReference
+	}
+	if intent.status == StatusPending {
+		intent.status = StatusFailed
+	}
+	return nil
+}
+
+func (p *MockProvider) Refund(ctx context.Context, reference string, amount money.Money) (*Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[reference]
+	if !ok {
+		return nil, ErrUnknownReference
+	}
+	if intent.status != StatusConfirmed {
+		return nil, ErrNotConfirmed
+	}
+	if amount.Currency != intent.amount.Currency {
+		return nil, money.ErrCurrencyMismatch
+	}
+	if intent.refunded+amount.Amount > intent.amount.Amount {
+		return nil, ErrRefundTooLarge
+	}
+
+	intent.refunded += amount.Amount
+	refundReference := "mock_re_" + randomHex(12)
+	p.refunds[refundReference] = StatusConfirmed
+	return &Refund{Reference: refundReference, Status: StatusConfirmed}, nil
+}
+
+// FailRefund marks a refund as failed, to simulate a refund the bank of the
+// customer rejects after it was accepted
+func (p *MockProvider) FailRefund(reference string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.refunds[reference]; !ok {
+		return ErrUnknownReference
+	}
+	p.refunds[reference] = StatusFailed
+	return nil
+}
+
+func (p *MockProvider) RefundStatus(ctx context.Context, reference string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status, ok := p.refunds[reference]
+	if !ok {
+		return "", ErrUnknownReference
+	}
+	return status, nil
+}
+
+type mockEvent struct {
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+}
+
+// SignWebhook returns the signature header value of payload
+func (p *MockProvider) SignWebhook(payload []byte) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook accepts {"reference": "...", "status": "..."} payloads
+// signed with the webhook secret
+func (p *MockProvider) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	signature, err := hex.DecodeString(header.Get(MockSignatureHeader))
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	expected, _ := hex.DecodeString(p.SignWebhook(payload))
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event mockEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if event.Reference == "" {
+		return nil, fmt.Errorf("%w: missing reference", ErrInvalidEvent)
+	}
+	switch event.Status {
+	case StatusPending, StatusConfirmed, StatusFailed:
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidEvent, event.Status)
+	}
+
+	// Webhooks update the mock state too, so Confirm agrees with them
+	p.mu.Lock()
+	if intent, ok := p.intents[event.Reference]; ok && intent.status == StatusPending {
+		intent.status = event.Status
+	}
+	p.mu.Unlock()
+
+	return &Event{Reference: event.Reference, Status: event.Status}, nil
+}
@@ -0,0 +1,115 @@
+// Package payments abstracts the payment service provider (PSP) that moves
+// the money of tickets, fines and sessions.
+package payments
+
+import (
+	"OPP/backend/money"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Payment provider used by the backend, payments are disabled without one
+var PAYMENT_PROVIDER = os.Getenv("PAYMENT_PROVIDER")
+
+// The mock provider confirms every payment, it is only allowed in debug mode
+var DEBUG_MODE = os.Getenv("DEBUG_MODE")
+
+// Shared secret used to verify the provider webhooks
+var PAYMENT_WEBHOOK_SECRET = os.Getenv("PAYMENT_WEBHOOK_SECRET")
+
+// Payment statuses, as stored in the payments table
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusFailed    = "failed"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown payment provider")
+	ErrNoProvider       = errors.New("no payment provider configured")
+	ErrMockNotAllowed   = errors.New("the mock payment provider requires DEBUG_MODE=true")
+	ErrUnknownReference = errors.New("unknown payment reference")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidEvent     = errors.New("invalid webhook event")
+	ErrNotConfirmed     = errors.New("payment not confirmed")
+	ErrRefundTooLarge   = errors.New("refund exceeds the captured amount")
+)
+
+// Intent is a payment created with the provider, the client completes it
+// with ClientSecret
+type Intent struct {
+	Reference    string
+	ClientSecret string
+	Status       string
+}
+
+// Refund is a refund issued by the provider
+type Refund struct {
+	Reference string
+	Status    string
+}
+
+// Event is a payment status change notified by the provider
+type Event struct {
+	Reference string
+	Status    string
+}
+
+// Provider is a payment service provider
+type Provider interface {
+	// Name identifies the provider in the payments table
+	Name() string
+	// CreateIntent registers a payment of amount with the provider
+	CreateIntent(ctx context.Context, amount money.Money, description string) (*Intent, error)
+	// Confirm asks the provider for the outcome of a payment and returns
+	// its status
+	Confirm(ctx context.Context, reference string) (string, error)
+	// Cancel abandons a payment that was never completed
+	Cancel(ctx context.Context, reference string) error
+	// Refund gives back amount of a confirmed payment
+	Refund(ctx context.Context, reference string, amount money.Money) (*Refund, error)
+	// RefundStatus asks the provider for the outcome of a pending refund
+	RefundStatus(ctx context.Context, reference string) (string, error)
+	// VerifyWebhook authenticates a webhook request and decodes its event
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+var (
+	provider     Provider
+	providerOnce sync.Once
+	providerErr  error
+)
+
+// NewProvider creates the provider called name
+func NewProvider(name string) (Provider, error) {
+	switch name {
+	case "":
+		return nil, ErrNoProvider
+	case "mock":
+		if DEBUG_MODE != "true" {
+			return nil, ErrMockNotAllowed
+		}
+		return NewMockProvider(PAYMENT_WEBHOOK_SECRET), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+}
+
+// InitProvider creates the provider configured by PAYMENT_PROVIDER. It
+// returns ErrNoProvider when there is none, payments are then disabled.
+func InitProvider() error {
+	providerOnce.Do(func() {
+		provider, providerErr = NewProvider(PAYMENT_PROVIDER)
+	})
+	return providerErr
+}
+
+// GetProvider returns the configured provider, InitProvider must have
+// been called. It is nil when payments are disabled.
+func GetProvider() Provider {
+	return provider
+}
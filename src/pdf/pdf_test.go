@@ -0,0 +1,127 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	objectPattern = regexp.MustCompile(`(?s)^(\d+) 0 obj\n(.*?)\nendobj\n`)
+	streamPattern = regexp.MustCompile(`(?s)^<< /Length (\d+) /Filter /FlateDecode >>\nstream\n(.*)\nendstream$`)
+)
+
+// parse checks the structure of a PDF file: its header, that every xref
+// entry points at its object, the trailer and the startxref offset. It
+// returns the inflated content streams.
+func parse(t *testing.T, file []byte) []string {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte("%PDF-1.4\n")) {
+		t.Fatalf("bad header %q", file[:min(len(file), 16)])
+	}
+	if !bytes.HasSuffix(file, []byte("\n%%EOF\n")) {
+		t.Fatal("missing end of file marker")
+	}
+
+	i := bytes.LastIndex(file, []byte("startxref\n"))
+	if i < 0 {
+		t.Fatal("missing startxref")
+	}
+	xref, err := strconv.Atoi(strings.TrimSuffix(string(file[i+len("startxref\n"):]), "\n%%EOF\n"))
+	if err != nil || xref <= 0 || xref >= len(file) {
+		t.Fatalf("bad startxref: %v", err)
+	}
+	table := string(file[xref:i])
+	var count int
+	if _, err := fmt.Sscanf(table, "xref\n0 %d\n", &count); err != nil {
+		t.Fatalf("bad xref at %d: %v", xref, err)
+	}
+	table = table[strings.Index(table, "\n")+1:]
+	table = table[strings.Index(table, "\n")+1:]
+
+	// Entries are exactly 20 bytes, the first one is the free list head
+	if len(table) < 20*count || table[:20] != "0000000000 65535 f \n" {
+		t.Fatalf("bad xref entries %q", table)
+	}
+	var streams []string
+	for n := 1; n < count; n++ {
+		entry := table[20*n : 20*n+20]
+		var offset int
+		if _, err := fmt.Sscanf(entry, "%010d 00000 n \n", &offset); err != nil || len(entry) != 20 {
+			t.Fatalf("bad xref entry %q", entry)
+		}
+		m := objectPattern.FindSubmatch(file[offset:])
+		if m == nil || string(m[1]) != strconv.Itoa(n) {
+			t.Fatalf("xref entry %d points at %q", n, file[offset:min(len(file), offset+16)])
+		}
+		if s := streamPattern.FindSubmatch(m[2]); s != nil {
+			if length, _ := strconv.Atoi(string(s[1])); length != len(s[2]) {
+				t.Fatalf("object %d: /Length %d, stream of %d bytes", n, length, len(s[2]))
+			}
+			r, err := zlib.NewReader(bytes.NewReader(s[2]))
+			if err != nil {
+				t.Fatalf("object %d: %v", n, err)
+			}
+			content, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("object %d: %v", n, err)
+			}
+			streams = append(streams, string(content))
+		}
+	}
+
+	trailer := fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\n", count)
+	if !strings.HasSuffix(string(file[xref:i]), trailer) || len(table) != 20*count+len(trailer) {
+		t.Fatalf("bad trailer %q", table[min(len(table), 20*count):])
+	}
+	return streams
+}
+
+func TestDocumentStructure(t *testing.T) {
+	doc := New(A4Width, A4Height)
+	doc.Title = "Notice (draft)"
+	first := doc.AddPage()
+	first.Text(50, 100, HelveticaBold, 12, Black, "Total 12.50 €")
+	first.Rect(0, 0, A4Width, 90, Color{R: 31, G: 78, B: 121})
+	second := doc.AddPage()
+	second.Line(50, 100, 300, 100, 1, Black)
+	second.Text(50, 120, Helvetica, 10, Black, `a (b) \ c`)
+
+	file, err := doc.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := parse(t, file)
+	if len(streams) != 2 {
+		t.Fatalf("%d content streams, want 2", len(streams))
+	}
+	if !bytes.Contains(file, []byte("/Count 2")) || !bytes.Contains(file, []byte(`/Title (Notice \(draft\))`)) {
+		t.Fatal("missing page count or title")
+	}
+
+	// Text is drawn from the bottom left corner, in WinAnsi
+	if want := "BT 0 0 0 rg /F2 12 Tf 50 741.89 Td (Total 12.50 \x80) Tj ET\n"; !strings.Contains(streams[0], want) {
+		t.Fatalf("first page %q lacks %q", streams[0], want)
+	}
+	if want := "0.12 0.31 0.47 rg 0 751.89 595.28 90 re f\n"; !strings.Contains(streams[0], want) {
+		t.Fatalf("first page %q lacks %q", streams[0], want)
+	}
+	if want := `(a \(b\) \\ c) Tj`; !strings.Contains(streams[1], want) {
+		t.Fatalf("second page %q lacks %q", streams[1], want)
+	}
+}
+
+func TestEmptyDocument(t *testing.T) {
+	file, err := New(A4Width, A4Height).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streams := parse(t, file); len(streams) != 0 {
+		t.Fatalf("%d content streams, want 0", len(streams))
+	}
+}
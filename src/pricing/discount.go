@@ -0,0 +1,83 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Kinds of discount rules
+const (
+	DiscountPercent     = "percent"
+	DiscountAmount      = "amount"
+	DiscountFreeMinutes = "free_minutes"
+)
+
+var ErrInvalidDiscount = errors.New("invalid discount")
+
+// Rule is a discount rule: a percentage of the price, an amount in minor
+// units or a number of free minutes from the start of the stay
+type Rule struct {
+	Id    int64
+	Name  string
+	Kind  string
+	Value int64
+}
+
+// Validate checks the value of the rule against its kind
+func (r Rule) Validate() error {
+	switch r.Kind {
+	case DiscountPercent:
+		if r.Value <= 0 || r.Value > 100 {
+			return fmt.Errorf("%w: a percentage is between 1 and 100", ErrInvalidDiscount)
+		}
+	case DiscountAmount, DiscountFreeMinutes:
+		if r.Value <= 0 {
+			return fmt.Errorf("%w: the value must be positive", ErrInvalidDiscount)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidDiscount, r.Kind)
+	}
+	return nil
+}
+
+// ApplyRules applies discount rules to the breakdown. Free minutes come
+// first and add up, each is worth what it takes off the subtotal:
+// priceWithout prices the stay without its first `minutes` minutes. Then
+// percentages apply in turn to what is left, then fixed amounts. Rules of a
+// kind apply in the order given.
+func (b *Breakdown) ApplyRules(rules []Rule, priceWithout func(minutes int) (int64, error)) error {
+	free := 0
+	var taken int64
+	for _, rule := range rules {
+		if rule.Kind != DiscountFreeMinutes {
+			continue
+		}
+		free += int(rule.Value)
+		price, err := priceWithout(free)
+		if err != nil {
+			return err
+		}
+		// A daily maximum may make the shorter stay cost no less
+		amount := max(b.Subtotal-price-taken, 0)
+		taken += amount
+		b.ApplyDiscount(Discount{RuleId: rule.Id, Name: rule.Name, Amount: amount})
+	}
+
+	for _, rule := range rules {
+		if rule.Kind != DiscountPercent {
+			continue
+		}
+		amount := int64(math.Round(float64(b.Total) * float64(rule.Value) / 100))
+		b.ApplyDiscount(Discount{RuleId: rule.Id, Name: rule.Name, Amount: amount})
+	}
+
+	for _, rule := range rules {
+		if rule.Kind != DiscountAmount {
+			continue
+		}
+		b.ApplyDiscount(Discount{RuleId: rule.Id, Name: rule.Name, Amount: rule.Value})
+	}
+
+	return nil
+}
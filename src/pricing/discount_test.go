@@ -0,0 +1,105 @@
+package pricing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// twoHours prices a two hour stay at 10.00 an hour without its first
+// minutes, capped at dailyMax when positive
+func twoHours(dailyMax int64) func(minutes int) (int64, error) {
+	return func(minutes int) (int64, error) {
+		price := int64(max(120-minutes, 0)) * 1000 / 60
+		if dailyMax > 0 {
+			price = min(price, dailyMax)
+		}
+		return price, nil
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	free := func(id int64, minutes int64) Rule {
+		return Rule{Id: id, Name: "free", Kind: DiscountFreeMinutes, Value: minutes}
+	}
+	percent := func(id int64, value int64) Rule {
+		return Rule{Id: id, Name: "percent", Kind: DiscountPercent, Value: value}
+	}
+	amount := func(id int64, value int64) Rule {
+		return Rule{Id: id, Name: "amount", Kind: DiscountAmount, Value: value}
+	}
+
+	tests := []struct {
+		name      string
+		subtotal  int64
+		dailyMax  int64
+		rules     []Rule
+		discounts []int64
+		total     int64
+	}{
+		{"no rules", 2000, 0, nil, nil, 2000},
+		{"free minutes add up", 2000, 0, []Rule{free(1, 30), free(2, 30)}, []int64{500, 500}, 1000},
+		{"free minutes beyond the stay", 2000, 0, []Rule{free(1, 180)}, []int64{2000}, 0},
+		{"percentages apply in turn", 2000, 0, []Rule{percent(1, 10), percent(2, 50)}, []int64{200, 900}, 900},
+		{"percentage rounding", 1001, 0, []Rule{percent(1, 33)}, []int64{330}, 671},
+		{"amounts after percentages", 2000, 0, []Rule{amount(1, 300), percent(2, 10)}, []int64{200, 300}, 1500},
+		{"free minutes first", 2000, 0, []Rule{percent(1, 50), free(2, 60)}, []int64{1000, 500}, 500},
+		{"amount capped at the total", 2000, 0, []Rule{percent(1, 50), amount(2, 5000), amount(3, 100)}, []int64{1000, 1000}, 0},
+		// Under a daily maximum of 15.00 the first 30 free minutes save
+		// nothing, the next 30 save what the shorter stay costs less
+		{"free minutes under a daily maximum", 1500, 1500, []Rule{free(1, 30), free(2, 30)}, []int64{500}, 1000},
+		{"everything stacked", 2000, 0, []Rule{amount(1, 100), percent(2, 10), free(3, 60)}, []int64{1000, 100, 100}, 800},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Breakdown{Currency: "EUR", Subtotal: tt.subtotal, Total: tt.subtotal, Discounts: []Discount{}}
+			if err := b.ApplyRules(tt.rules, twoHours(tt.dailyMax)); err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			var discounts []int64
+			var sum int64
+			for _, d := range b.Discounts {
+				discounts = append(discounts, d.Amount)
+				sum += d.Amount
+			}
+			if !reflect.DeepEqual(discounts, tt.discounts) {
+				t.Fatalf("discounts %v, want %v", discounts, tt.discounts)
+			}
+			if b.Total != tt.total || b.Total != b.Subtotal-sum {
+				t.Fatalf("total %d, want %d", b.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestApplyRulesPricingError(t *testing.T) {
+	failure := errors.New("no tariff")
+	b := Breakdown{Subtotal: 2000, Total: 2000}
+	err := b.ApplyRules([]Rule{{Kind: DiscountFreeMinutes, Value: 30}}, func(int) (int64, error) {
+		return 0, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the pricing error, got %v", err)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule  Rule
+		valid bool
+	}{
+		{Rule{Kind: DiscountPercent, Value: 100}, true},
+		{Rule{Kind: DiscountPercent, Value: 101}, false},
+		{Rule{Kind: DiscountPercent, Value: 0}, false},
+		{Rule{Kind: DiscountAmount, Value: 1}, true},
+		{Rule{Kind: DiscountAmount, Value: -1}, false},
+		{Rule{Kind: DiscountFreeMinutes, Value: 0}, false},
+		{Rule{Kind: "bogus", Value: 1}, false},
+	}
+	for _, tt := range tests {
+		err := tt.rule.Validate()
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, ErrInvalidDiscount)) {
+			t.Errorf("%+v: got %v", tt.rule, err)
+		}
+	}
+}
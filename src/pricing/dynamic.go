@@ -0,0 +1,121 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BaseLevel is the price level charging the base prices of a zone. Levels
+// are in basis points of the base prices, 12000 charges 120% of them.
+const BaseLevel = 10000
+
+var ErrInvalidPolicy = errors.New("invalid dynamic pricing policy")
+
+// Policy moves the price level of a zone with its occupancy. Occupancies
+// are in basis points of the capacity, 8500 is 85% full. The level rises by
+// RaiseStep above HighOccupancy and falls by LowerStep below LowOccupancy,
+// within MinLevel and MaxLevel; in between it stays where it is.
+type Policy struct {
+	HighOccupancy int
+	RaiseStep     int
+	LowOccupancy  int
+	LowerStep     int
+	MinLevel      int
+	MaxLevel      int
+}
+
+// Validate checks the thresholds are ordered and the bounds contain the
+// base level
+func (p Policy) Validate() error {
+	if p.HighOccupancy <= 0 || p.HighOccupancy > BaseLevel {
+		return fmt.Errorf("%w: high_occupancy must be between 1 and %d", ErrInvalidPolicy, BaseLevel)
+	}
+	if p.LowOccupancy < 0 || p.LowOccupancy >= p.HighOccupancy {
+		return fmt.Errorf("%w: low_occupancy must be between 0 and high_occupancy", ErrInvalidPolicy)
+	}
+	if p.RaiseStep < 0 || p.LowerStep < 0 {
+		return fmt.Errorf("%w: steps must not be negative", ErrInvalidPolicy)
+	}
+	if p.MinLevel <= 0 || p.MinLevel > BaseLevel || p.MaxLevel < BaseLevel {
+		return fmt.Errorf("%w: levels must range from at most %d to at least %d", ErrInvalidPolicy, BaseLevel, BaseLevel)
+	}
+	return nil
+}
+
+// Next returns the level following `level` at an occupancy of `occupied`
+// spots out of `capacity`, which must be positive, and why it changed. A
+// level outside the bounds is brought back within them.
+func (p Policy) Next(level int, occupied int, capacity int) (int, string) {
+	occupancy := occupied * BaseLevel / capacity
+	next, reason := level, ""
+	switch {
+	case occupancy > p.HighOccupancy:
+		next = level + p.RaiseStep
+		reason = fmt.Sprintf("occupancy %s above %s", FormatLevel(occupancy), FormatLevel(p.HighOccupancy))
+	case occupancy < p.LowOccupancy:
+		next = level - p.LowerStep
+		reason = fmt.Sprintf("occupancy %s below %s", FormatLevel(occupancy), FormatLevel(p.LowOccupancy))
+	}
+	if next > p.MaxLevel {
+		next = p.MaxLevel
+		reason = joinReason(reason, "capped at "+FormatLevel(p.MaxLevel))
+	}
+	if next < p.MinLevel {
+		next = p.MinLevel
+		reason = joinReason(reason, "floored at "+FormatLevel(p.MinLevel))
+	}
+	return next, reason
+}
+
+func joinReason(reason string, more string) string {
+	if reason == "" {
+		return more
+	}
+	return reason + ", " + more
+}
+
+// FormatLevel formats basis points as a percentage, e.g. "85%" or "87.5%"
+func FormatLevel(bp int) string {
+	if bp%100 == 0 {
+		return fmt.Sprintf("%d%%", bp/100)
+	}
+	return strings.TrimRight(fmt.Sprintf("%d.%02d", bp/100, bp%100), "0") + "%"
+}
+
+// ScaleRate returns a rate at a price level, rounded half away from zero
+func ScaleRate(rate int64, level int) int64 {
+	if level == BaseLevel {
+		return rate
+	}
+	sign := int64(1)
+	if rate < 0 {
+		sign, rate = -1, -rate
+	}
+	return sign * ((rate*int64(level)*2 + BaseLevel) / (2 * BaseLevel))
+}
+
+// AtLevel returns the formula with its offset and hourly rate at a price
+// level. With an exponent other than 1 the variable part follows the level
+// raised to the exponent, as it follows the hourly rate.
+func (f Formula) AtLevel(level int) Formula {
+	f.Offset = ScaleRate(f.Offset, level)
+	f.Linear = ScaleRate(f.Linear, level)
+	return f
+}
+
+// AtLevel returns a copy of the tariff with its rates and daily maximum at
+// a price level
+func (t *Tariff) AtLevel(level int) *Tariff {
+	scaled := *t
+	scaled.Bands = make([]Band, len(t.Bands))
+	for i, b := range t.Bands {
+		b.RatePerHour = ScaleRate(b.RatePerHour, level)
+		scaled.Bands[i] = b
+	}
+	if t.DailyMax != nil {
+		dailyMax := ScaleRate(*t.DailyMax, level)
+		scaled.DailyMax = &dailyMax
+	}
+	return &scaled
+}
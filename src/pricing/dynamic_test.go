@@ -0,0 +1,114 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+)
+
+// stepPolicy raises the level by 10% above 85% occupancy and lowers it by
+// 10% below 30%, between 50% and 150% of the base prices
+var stepPolicy = Policy{
+	HighOccupancy: 8500,
+	RaiseStep:     1000,
+	LowOccupancy:  3000,
+	LowerStep:     1000,
+	MinLevel:      5000,
+	MaxLevel:      15000,
+}
+
+func TestPolicyNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    int
+		occupied int
+		capacity int
+		next     int
+		reason   string
+	}{
+		{"raised above the high threshold", 10000, 90, 100, 11000, "occupancy 90% above 85%"},
+		{"kept at the high threshold", 10000, 85, 100, 10000, ""},
+		{"occupancy rounded down to the threshold", 10000, 17, 20, 10000, ""},
+		{"fractional occupancy", 10000, 7, 8, 11000, "occupancy 87.5% above 85%"},
+		{"over capacity", 10000, 12, 10, 11000, "occupancy 120% above 85%"},
+		{"capped at the maximum", 14500, 90, 100, 15000, "occupancy 90% above 85%, capped at 150%"},
+		{"kept at the maximum", 15000, 100, 100, 15000, "occupancy 100% above 85%, capped at 150%"},
+		{"lowered below the low threshold", 10000, 10, 100, 9000, "occupancy 10% below 30%"},
+		{"kept at the low threshold", 10000, 30, 100, 10000, ""},
+		{"floored at the minimum", 5500, 10, 100, 5000, "occupancy 10% below 30%, floored at 50%"},
+		{"empty zone", 5000, 0, 100, 5000, "occupancy 0% below 30%, floored at 50%"},
+		{"kept between the thresholds", 12000, 60, 100, 12000, ""},
+		{"brought down within the bounds", 20000, 60, 100, 15000, "capped at 150%"},
+		{"brought up within the bounds", 3000, 60, 100, 5000, "floored at 50%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, reason := stepPolicy.Next(tt.level, tt.occupied, tt.capacity)
+			if next != tt.next || reason != tt.reason {
+				t.Fatalf("Next(%d, %d, %d) = %d, %q, want %d, %q", tt.level, tt.occupied, tt.capacity, next, reason, tt.next, tt.reason)
+			}
+		})
+	}
+}
+
+func TestScaleRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  int64
+		level int
+		want  int64
+	}{
+		{"base level", 123, BaseLevel, 123},
+		{"raised", 150, 12000, 180},
+		{"lowered", 200, 9000, 180},
+		{"half rounded up", 125, 9000, 113},
+		{"half at the minimum", 101, 5000, 51},
+		{"below half", 1, 14900, 1},
+		{"above half", 333, 15000, 500},
+		{"zero rate", 0, 15000, 0},
+		{"negative rate", -150, 12000, -180},
+		{"negative half rounded away from zero", -125, 9000, -113},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScaleRate(tt.rate, tt.level); got != tt.want {
+				t.Fatalf("ScaleRate(%d, %d) = %d, want %d", tt.rate, tt.level, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(p *Policy)
+		valid bool
+	}{
+		{"step policy", func(p *Policy) {}, true},
+		{"full zone threshold", func(p *Policy) { p.HighOccupancy = BaseLevel }, true},
+		{"no high threshold", func(p *Policy) { p.HighOccupancy = 0 }, false},
+		{"high threshold over capacity", func(p *Policy) { p.HighOccupancy = BaseLevel + 1 }, false},
+		{"thresholds not ordered", func(p *Policy) { p.LowOccupancy = p.HighOccupancy }, false},
+		{"negative step", func(p *Policy) { p.LowerStep = -1 }, false},
+		{"minimum above the base level", func(p *Policy) { p.MinLevel = BaseLevel + 1 }, false},
+		{"maximum below the base level", func(p *Policy) { p.MaxLevel = BaseLevel - 1 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := stepPolicy
+			tt.edit(&p)
+			err := p.Validate()
+			if (err == nil) != tt.valid || (err != nil && !errors.Is(err, ErrInvalidPolicy)) {
+				t.Fatalf("%+v: got %v", p, err)
+			}
+		})
+	}
+}
+
+func TestFormatLevel(t *testing.T) {
+	tests := map[int]string{0: "0%", 8500: "85%", 8750: "87.5%", 10001: "100.01%", 15000: "150%"}
+	for bp, want := range tests {
+		if got := FormatLevel(bp); got != want {
+			t.Errorf("FormatLevel(%d) = %q, want %q", bp, got, want)
+		}
+	}
+}
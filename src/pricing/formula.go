@@ -0,0 +1,88 @@
+package pricing
+
+import (
+	"OPP/backend/money"
+	"math"
+)
+
+// Formula is the legacy zone price formula, in major units:
+// price = offset + (linear * hours) ^ exponent
+// Offset and Linear are stored in minor units (per hour for Linear).
+type Formula struct {
+	Offset   int64
+	Linear   int64
+	Exponent float64
+	Currency string
+	Rounding money.Rounding
+}
+
+// Discount is a reduction applied to a price, in minor units. RuleId is the
+// discount rule it comes from, if any.
+type Discount struct {
+	RuleId int64
+	Name   string
+	Amount int64
+}
+
+// Breakdown explains how a price was computed, all amounts are in minor
+// units of Currency. A formula priced stay fills Offset, Linear and
+// Exponential, a tariff priced stay fills Tariff.
+type Breakdown struct {
+	Currency string
+	Offset   int64
+	Linear   int64
+	// Difference between the formula with its exponent and its linear part
+	Exponential int64
+	Tariff      *Quote
+	Subtotal    int64
+	Discounts   []Discount
+	Total       int64
+}
+
+// Price prices `minutes` minutes with the formula. The price is rounded
+// once, the exponential part absorbs the rounding of the linear part so
+// that the parts add up to the subtotal.
+func (f Formula) Price(minutes int) Breakdown {
+	scale := float64(money.MinorPerMajor(f.Currency))
+	hours := float64(minutes) / 60.0
+
+	// The exponent applies to major units, as the formula always did
+	linearMajor := float64(f.Linear) / scale * hours
+	variable := math.Pow(linearMajor, f.Exponent) * scale
+
+	subtotal := f.Rounding.Round(float64(f.Offset) + variable)
+	linear := f.Rounding.Round(linearMajor * scale)
+	return Breakdown{
+		Currency:    f.Currency,
+		Offset:      f.Offset,
+		Linear:      linear,
+		Exponential: subtotal - f.Offset - linear,
+		Discounts:   []Discount{},
+		Subtotal:    subtotal,
+		Total:       subtotal,
+	}
+}
+
+// TariffBreakdown wraps a tariff quote into a breakdown
+func TariffBreakdown(quote Quote, currency string) Breakdown {
+	return Breakdown{
+		Currency:  currency,
+		Tariff:    &quote,
+		Discounts: []Discount{},
+		Subtotal:  quote.Total,
+		Total:     quote.Total,
+	}
+}
+
+// ApplyDiscount subtracts a discount from the total, a discount never makes
+// the total negative and is recorded with the amount actually deducted
+func (b *Breakdown) ApplyDiscount(d Discount) {
+	if d.Amount > b.Total {
+		d.Amount = b.Total
+	}
+	if d.Amount <= 0 {
+		return
+	}
+	b.Total -= d.Amount
+	b.Discounts = append(b.Discounts, d)
+}
@@ -0,0 +1,238 @@
+package pricing
+
+import (
+	"OPP/backend/money"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Day values used in Band.Days, following time.Weekday (0 = Sunday),
+// plus Holiday for the dates of the zone holiday calendar
+const Holiday = 7
+
+const minutesPerDay = 24 * 60
+
+var (
+	ErrInvalidBand      = errors.New("invalid tariff band")
+	ErrOverlappingBands = errors.New("tariff bands overlap")
+)
+
+// Band is a rate applied on some days between two times of the day.
+// Times are minutes from midnight, EndMinute = 1440 means midnight.
+// Time not covered by any band is free. Rates are in minor units per hour.
+type Band struct {
+	Name        string
+	Days        []int
+	StartMinute int
+	EndMinute   int
+	RatePerHour int64
+}
+
+func (b Band) appliesTo(day int) bool {
+	for _, d := range b.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Tariff is the rate schedule of a zone
+type Tariff struct {
+	Location *time.Location
+	Bands    []Band
+	// Public holidays as YYYY-MM-DD dates, on those days only the
+	// bands including Holiday apply
+	Holidays map[string]bool
+	// Maximum charged per calendar day in minor units, nil for no cap
+	DailyMax *int64
+	// Rounding applied to the charge of each day
+	Rounding money.Rounding
+}
+
+// Segment is a part of the priced interval charged at a single rate.
+// Amount is in unrounded minor units.
+type Segment struct {
+	Band        string
+	Start       time.Time
+	End         time.Time
+	RatePerHour int64
+	Amount      float64
+}
+
+// Day is the charge of a calendar day, rounded and capped by the tariff
+// daily maximum
+type Day struct {
+	Date     string
+	Subtotal int64
+	Charged  int64
+	Capped   bool
+}
+
+type Quote struct {
+	Segments []Segment
+	Days     []Day
+	Total    int64
+}
+
+// Validate checks the bands are well formed and do not overlap on any day
+func (t *Tariff) Validate() error {
+	for _, b := range t.Bands {
+		if b.StartMinute < 0 || b.EndMinute > minutesPerDay || b.StartMinute >= b.EndMinute {
+			return fmt.Errorf("%w: %q must start before it ends within the day", ErrInvalidBand, b.Name)
+		}
+		if b.RatePerHour < 0 {
+			return fmt.Errorf("%w: %q has a negative rate", ErrInvalidBand, b.Name)
+		}
+		if len(b.Days) == 0 {
+			return fmt.Errorf("%w: %q applies to no day", ErrInvalidBand, b.Name)
+		}
+		for _, d := range b.Days {
+			if d < 0 || d > Holiday {
+				return fmt.Errorf("%w: %q has invalid day %d", ErrInvalidBand, b.Name, d)
+			}
+		}
+	}
+	if t.DailyMax != nil && *t.DailyMax < 0 {
+		return fmt.Errorf("%w: negative daily maximum", ErrInvalidBand)
+	}
+	if err := t.Rounding.Validate(); err != nil {
+		return err
+	}
+
+	for day := 0; day <= Holiday; day++ {
+		var bands []Band
+		for _, b := range t.Bands {
+			if b.appliesTo(day) {
+				bands = append(bands, b)
+			}
+		}
+		sort.Slice(bands, func(i, j int) bool { return bands[i].StartMinute < bands[j].StartMinute })
+		for i := 1; i < len(bands); i++ {
+			if bands[i].StartMinute < bands[i-1].EndMinute {
+				return fmt.Errorf("%w: %q and %q", ErrOverlappingBands, bands[i-1].Name, bands[i].Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *Tariff) location() *time.Location {
+	if t.Location == nil {
+		return time.UTC
+	}
+	return t.Location
+}
+
+// Price splits [start, end) across the tariff bands, day by day in the
+// tariff location, rounds the charge of each day and applies the daily
+// maximum
+func (t *Tariff) Price(start time.Time, end time.Time) Quote {
+	loc := t.location()
+	quote := Quote{Segments: []Segment{}, Days: []Day{}}
+
+	start = start.In(loc)
+	end = end.In(loc)
+	for dayStart := midnight(start); dayStart.Before(end); dayStart = dayStart.AddDate(0, 0, 1) {
+		nextDay := dayStart.AddDate(0, 0, 1)
+		from := latest(start, dayStart)
+		to := earliest(end, nextDay)
+
+		date := dayStart.Format(time.DateOnly)
+		dayKind := int(dayStart.Weekday())
+		if t.Holidays[date] {
+			dayKind = Holiday
+		}
+
+		day := Day{Date: date}
+		subtotal := 0.0
+		for _, b := range t.bandsFor(dayKind) {
+			bandStart := atMinute(dayStart, b.StartMinute)
+			bandEnd := atMinute(dayStart, b.EndMinute)
+			segStart := latest(from, bandStart)
+			segEnd := earliest(to, bandEnd)
+			if !segStart.Before(segEnd) {
+				continue
+			}
+			amount := float64(b.RatePerHour) * segEnd.Sub(segStart).Hours()
+			quote.Segments = append(quote.Segments, Segment{
+				Band:        b.Name,
+				Start:       segStart,
+				End:         segEnd,
+				RatePerHour: b.RatePerHour,
+				Amount:      amount,
+			})
+			subtotal += amount
+		}
+
+		day.Subtotal = t.Rounding.Round(subtotal)
+		day.Charged = day.Subtotal
+		if t.DailyMax != nil && day.Subtotal > *t.DailyMax {
+			day.Charged = *t.DailyMax
+			day.Capped = true
+		}
+		quote.Days = append(quote.Days, day)
+		quote.Total += day.Charged
+	}
+
+	return quote
+}
+
+func (t *Tariff) bandsFor(day int) []Band {
+	var bands []Band
+	for _, b := range t.Bands {
+		if b.appliesTo(day) {
+			bands = append(bands, b)
+		}
+	}
+	sort.Slice(bands, func(i, j int) bool { return bands[i].StartMinute < bands[j].StartMinute })
+	return bands
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// atMinute returns the wall clock time `minute` minutes after midnight,
+// so that bands follow local time across DST changes
+func atMinute(day time.Time, minute int) time.Time {
+	if minute >= minutesPerDay {
+		return day.AddDate(0, 0, 1)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, day.Location())
+}
+
+func latest(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// ParseClock parses a "HH:MM" time of the day into minutes from midnight,
+// "24:00" is accepted as the end of the day
+func ParseClock(clock string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(clock, "%d:%d", &h, &m); err != nil || len(clock) != 5 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return h*60 + m, nil
+}
+
+// FormatClock formats minutes from midnight as "HH:MM"
+func FormatClock(minute int) string {
+	return fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+}
@@ -0,0 +1,175 @@
+package pricing
+
+import (
+	"OPP/backend/money"
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+// weekTariff charges 2.00 an hour by day and 1.00 in the evening from
+// Monday to Saturday, 0.50 an hour on holidays and nothing on Sundays
+func weekTariff(t *testing.T) *Tariff {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Tariff{
+		Location: loc,
+		Bands: []Band{
+			{Name: "day", Days: []int{1, 2, 3, 4, 5, 6}, StartMinute: 8 * 60, EndMinute: 20 * 60, RatePerHour: 200},
+			{Name: "evening", Days: []int{1, 2, 3, 4, 5, 6}, StartMinute: 20 * 60, EndMinute: 24 * 60, RatePerHour: 100},
+			{Name: "holiday", Days: []int{Holiday}, StartMinute: 10 * 60, EndMinute: 18 * 60, RatePerHour: 50},
+		},
+		Holidays: map[string]bool{"2024-03-05": true},
+		Rounding: money.DefaultRounding,
+	}
+}
+
+// allDayTariff charges 0.60 an hour at any time
+func allDayTariff(t *testing.T) *Tariff {
+	t.Helper()
+	tariff := weekTariff(t)
+	tariff.Bands = []Band{{Name: "all", Days: []int{0, 1, 2, 3, 4, 5, 6, Holiday}, StartMinute: 0, EndMinute: 24 * 60, RatePerHour: 60}}
+	return tariff
+}
+
+func TestTariffPrice(t *testing.T) {
+	week := weekTariff(t)
+	loc := week.Location
+	at := func(month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, loc)
+	}
+
+	capped := weekTariff(t)
+	capped.DailyMax = int64Ptr(1500)
+	roundedUp := weekTariff(t)
+	roundedUp.Rounding = money.Rounding{Mode: money.RoundUp, Increment: 50}
+	earlyBand := allDayTariff(t)
+	earlyBand.Bands[0].StartMinute, earlyBand.Bands[0].EndMinute = 60, 4*60
+
+	tests := []struct {
+		name   string
+		tariff *Tariff
+		start  time.Time
+		end    time.Time
+		total  int64
+	}{
+		{"within a band", week, at(3, 4, 9, 0), at(3, 4, 11, 0), 400},
+		{"across bands", week, at(3, 4, 19, 0), at(3, 4, 21, 0), 300},
+		{"free time before the first band", week, at(3, 4, 6, 0), at(3, 4, 8, 30), 100},
+		{"sunday without bands", week, at(3, 10, 10, 0), at(3, 10, 12, 0), 0},
+		{"holiday bands only", week, at(3, 5, 9, 0), at(3, 5, 11, 0), 50},
+		{"over a holiday", week, at(3, 4, 18, 0), at(3, 6, 10, 0), 400 + 400 + 50*8 + 400},
+		{"daily maximum", capped, at(3, 4, 8, 0), at(3, 4, 22, 0), 1500},
+		{"daily maximum per day", capped, at(3, 6, 8, 0), at(3, 7, 20, 0), 1500 + 1500},
+		{"under the daily maximum", capped, at(3, 4, 9, 0), at(3, 4, 11, 0), 400},
+		{"rounded per day", roundedUp, at(3, 4, 9, 0), at(3, 4, 9, 10), 50},
+		{"short day at DST start", allDayTariff(t), at(3, 31, 0, 0), at(4, 1, 0, 0), 23 * 60},
+		{"long day at DST end", allDayTariff(t), at(10, 27, 0, 0), at(10, 28, 0, 0), 25 * 60},
+		{"band across the skipped hour", earlyBand, at(3, 31, 0, 0), at(3, 31, 12, 0), 2 * 60},
+		{"empty interval", week, at(3, 4, 9, 0), at(3, 4, 9, 0), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tariff.Validate(); err != nil {
+				t.Fatalf("invalid tariff: %v", err)
+			}
+			if total := tt.tariff.Price(tt.start, tt.end).Total; total != tt.total {
+				t.Fatalf("total %d, want %d", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestTariffPriceDays(t *testing.T) {
+	tariff := weekTariff(t)
+	tariff.DailyMax = int64Ptr(1500)
+	start := time.Date(2024, 3, 4, 8, 0, 0, 0, tariff.Location)
+
+	quote := tariff.Price(start, start.Add(26*time.Hour))
+	want := []Day{
+		{Date: "2024-03-04", Subtotal: 2800, Charged: 1500, Capped: true},
+		{Date: "2024-03-05", Subtotal: 0, Charged: 0},
+	}
+	if len(quote.Days) != len(want) {
+		t.Fatalf("%d days, want %d", len(quote.Days), len(want))
+	}
+	for i, day := range quote.Days {
+		if day != want[i] {
+			t.Fatalf("day %d is %+v, want %+v", i, day, want[i])
+		}
+	}
+	if len(quote.Segments) != 2 || quote.Segments[0].Band != "day" || quote.Segments[1].Band != "evening" {
+		t.Fatalf("unexpected segments %+v", quote.Segments)
+	}
+}
+
+func TestTariffValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		bands []Band
+		err   error
+	}{
+		{"adjacent bands", []Band{
+			{Name: "a", Days: []int{1}, StartMinute: 0, EndMinute: 600},
+			{Name: "b", Days: []int{1}, StartMinute: 600, EndMinute: 1440},
+		}, nil},
+		{"overlap on other days", []Band{
+			{Name: "a", Days: []int{1}, StartMinute: 0, EndMinute: 700},
+			{Name: "b", Days: []int{2}, StartMinute: 600, EndMinute: 1440},
+		}, nil},
+		{"overlapping bands", []Band{
+			{Name: "a", Days: []int{1, 2}, StartMinute: 0, EndMinute: 700},
+			{Name: "b", Days: []int{2}, StartMinute: 600, EndMinute: 1440},
+		}, ErrOverlappingBands},
+		{"ends before it starts", []Band{{Name: "a", Days: []int{1}, StartMinute: 600, EndMinute: 500}}, ErrInvalidBand},
+		{"ends after midnight", []Band{{Name: "a", Days: []int{1}, StartMinute: 600, EndMinute: 1441}}, ErrInvalidBand},
+		{"negative rate", []Band{{Name: "a", Days: []int{1}, StartMinute: 0, EndMinute: 60, RatePerHour: -1}}, ErrInvalidBand},
+		{"no day", []Band{{Name: "a", StartMinute: 0, EndMinute: 60}}, ErrInvalidBand},
+		{"invalid day", []Band{{Name: "a", Days: []int{Holiday + 1}, StartMinute: 0, EndMinute: 60}}, ErrInvalidBand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tariff := &Tariff{Bands: tt.bands, Rounding: money.DefaultRounding}
+			err := tariff.Validate()
+			if tt.err == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		clock  string
+		minute int
+		ok     bool
+	}{
+		{"00:00", 0, true},
+		{"08:30", 510, true},
+		{"24:00", 1440, true},
+		{"24:01", 0, false},
+		{"12:60", 0, false},
+		{"8:30", 0, false},
+		{"noon", 0, false},
+	}
+	for _, tt := range tests {
+		minute, err := ParseClock(tt.clock)
+		if (err == nil) != tt.ok || minute != tt.minute {
+			t.Errorf("ParseClock(%q) = %d, %v", tt.clock, minute, err)
+		}
+		if tt.ok && FormatClock(minute) != tt.clock {
+			t.Errorf("FormatClock(%d) = %q, want %q", minute, FormatClock(minute), tt.clock)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the worked example of the standard
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomon(data, 10); !bytes.Equal(got, want) {
+		t.Fatalf("reedSolomon = %v, want %v", got, want)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	formats := []int{
+		0b101010000010010, 0b101000100100101, 0b101111001111100, 0b101101101001011,
+		0b100010111111001, 0b100000011001110, 0b100111110010111, 0b100101010100000,
+	}
+	for mask, want := range formats {
+		if got := formatBits(mask); got != want {
+			t.Errorf("formatBits(%d) = %015b, want %015b", mask, got, want)
+		}
+	}
+	if got := versionBits(7); got != 0x07C94 {
+		t.Errorf("versionBits(7) = %#x, want 0x07c94", got)
+	}
+}
+
+func TestLayouts(t *testing.T) {
+	for v := 1; v <= maxVersion; v++ {
+		// Modules left for codewords once the function patterns are drawn
+		raw := (16*v+128)*v + 64
+		if v >= 2 {
+			n := v/7 + 2
+			raw -= (25*n-10)*n - 55
+			if len(alignments[v]) != n {
+				t.Errorf("version %d: %d alignment centers, want %d", v, len(alignments[v]), n)
+			}
+			if v >= 7 {
+				raw -= 36
+			}
+		}
+		l := layouts[v]
+		if total := l.dataCodewords() + (l.blocks1+l.blocks2)*l.ecPerBlock; total != raw/8 {
+			t.Errorf("version %d: %d codewords, want %d", v, total, raw/8)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, text := range []string{"OPP", "opp://fines/12345/pay?reference=OPP-00012345-42", strings.Repeat("x", 200), strings.Repeat("x", 412)} {
+		q, err := Encode(text)
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", len(text), err)
+		}
+		if (q.Size-17)%4 != 0 || q.Size < 21 {
+			t.Fatalf("Encode(%d bytes): bad size %d", len(text), q.Size)
+		}
+		// The corners of the three finders are dark, their separators light
+		for _, corner := range [][2]int{{0, 0}, {q.Size - 1, 0}, {0, q.Size - 1}} {
+			if !q.Dark(corner[0], corner[1]) {
+				t.Errorf("Encode(%d bytes): finder corner %v is light", len(text), corner)
+			}
+		}
+		if q.Dark(7, 7) || q.Dark(8, q.Size-8) == false {
+			t.Errorf("Encode(%d bytes): bad separator or dark module", len(text))
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("x", 413)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("Encode(413 bytes) = %v, want ErrTooLong", err)
+	}
+}
+
+// decode reads a code back the way a scanner would, independently of the
+// encoder: it checks the format and version information, unmasks and
+// collects the codewords, checks the error correction of every block and
+// returns the text, error correction level and mask
+func decode(t *testing.T, q *Code) (string, int, int) {
+	t.Helper()
+	size := q.Size
+	version := (size - 17) / 4
+	if size != 17+4*version || version < 1 || version > 40 {
+		t.Fatalf("bad size %d", size)
+	}
+	bit := func(x int, y int) int {
+		if q.Dark(x, y) {
+			return 1
+		}
+		return 0
+	}
+
+	// Both copies of the format information, which must be a valid BCH
+	// codeword of (level, mask)
+	var format1, format2 int
+	for i := 0; i <= 5; i++ {
+		format1 |= bit(8, i) << i
+	}
+	format1 |= bit(8, 7)<<6 | bit(8, 8)<<7 | bit(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		format1 |= bit(14-i, 8) << i
+	}
+	for i := 0; i < 8; i++ {
+		format2 |= bit(size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		format2 |= bit(8, size-15+i) << i
+	}
+	if format1 != format2 {
+		t.Fatalf("format copies differ: %015b, %015b", format1, format2)
+	}
+	if bit(8, size-8) != 1 {
+		t.Fatal("dark module is light")
+	}
+	level, mask := -1, -1
+	for data := 0; data < 32; data++ {
+		codeword := data << 10
+		for i := 14; i >= 10; i-- {
+			if codeword>>i&1 == 1 {
+				codeword ^= 0x537 << (i - 10)
+			}
+		}
+		if (data<<10|codeword)^0x5412 == format1 {
+			level, mask = data>>3, data&7
+		}
+	}
+	if level < 0 {
+		t.Fatalf("format %015b is not a valid codeword", format1)
+	}
+
+	// Both copies of the version information
+	if version >= 7 {
+		var version1, version2 int
+		for i := 0; i < 18; i++ {
+			version1 |= bit(size-11+i%3, i/3) << i
+			version2 |= bit(i/3, size-11+i%3) << i
+		}
+		codeword := version << 12
+		for i := 17; i >= 12; i-- {
+			if codeword>>i&1 == 1 {
+				codeword ^= 0x1F25 << (i - 12)
+			}
+		}
+		if want := version<<12 | codeword; version1 != want || version2 != want {
+			t.Fatalf("version information %018b, %018b, want %018b", version1, version2, want)
+		}
+	}
+
+	// Function modules, which hold no data
+	function := make([][]bool, size)
+	for y := range function {
+		function[y] = make([]bool, size)
+	}
+	fill := func(x0 int, y0 int, x1 int, y1 int) {
+		for y := max(y0, 0); y <= min(y1, size-1); y++ {
+			for x := max(x0, 0); x <= min(x1, size-1); x++ {
+				function[y][x] = true
+			}
+		}
+	}
+	fill(0, 6, size-1, 6)
+	fill(6, 0, 6, size-1)
+	fill(0, 0, 8, 8)
+	fill(size-8, 0, size-1, 8)
+	fill(0, size-8, 8, size-1)
+	if version >= 2 {
+		n := version/7 + 2
+		step := (version*4 + 4 + 2*n - 3) / (2*n - 2) * 2
+		centers := make([]int, n)
+		centers[0] = 6
+		for i, c := n-1, size-7; i >= 1; i, c = i-1, c-step {
+			centers[i] = c
+		}
+		for _, cy := range centers {
+			for _, cx := range centers {
+				// None where the finders are
+				if !(cx == 6 && cy == 6) && !(cx == 6 && cy == size-7) && !(cx == size-7 && cy == 6) {
+					fill(cx-2, cy-2, cx+2, cy+2)
+				}
+			}
+		}
+	}
+	if version >= 7 {
+		fill(size-11, 0, size-9, 5)
+		fill(0, size-11, 5, size-9)
+	}
+
+	// Codewords, two columns at a time from the bottom right corner
+	masks := []func(x, y int) bool{
+		func(x, y int) bool { return (x+y)%2 == 0 },
+		func(x, y int) bool { return y%2 == 0 },
+		func(x, y int) bool { return x%3 == 0 },
+		func(x, y int) bool { return (x+y)%3 == 0 },
+		func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+		func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+		func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+		func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+	}
+	var bits []int
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = size - 1 - vert
+			}
+			for x := right; x >= right-1; x-- {
+				if !function[y][x] {
+					b := bit(x, y)
+					if masks[mask](x, y) {
+						b ^= 1
+					}
+					bits = append(bits, b)
+				}
+			}
+		}
+	}
+	raw := make([]byte, len(bits)/8)
+	for i := range raw {
+		for _, b := range bits[8*i : 8*i+8] {
+			raw[i] = raw[i]<<1 | byte(b)
+		}
+	}
+
+	// Split the codewords in their blocks, from the tables of the standard
+	// for level M, and check the syndromes of each block are zero
+	if level != 0 {
+		t.Fatalf("level %d, only M (0) is supported", level)
+	}
+	ecPerBlock := []int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24}[version]
+	numBlocks := []int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10}[version]
+	short := numBlocks - len(raw)%numBlocks
+	shortData := len(raw)/numBlocks - ecPerBlock
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortData; i++ {
+		for j := range blocks {
+			if i < shortData || j >= short {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < ecPerBlock; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], raw[k])
+			k++
+		}
+	}
+	var exp [255]byte
+	for i, x := 0, 1; i < 255; i++ {
+		exp[i] = byte(x)
+		if x <<= 1; x >= 256 {
+			x ^= 0x11D
+		}
+	}
+	var logs [256]int
+	for i, x := range exp {
+		logs[x] = i
+	}
+	var data []byte
+	for j, block := range blocks {
+		for root := 0; root < ecPerBlock; root++ {
+			var s byte
+			for _, c := range block {
+				if s != 0 {
+					s = exp[(logs[s]+root)%255]
+				}
+				s ^= c
+			}
+			if s != 0 {
+				t.Fatalf("block %d: syndrome %d is %d", j, root, s)
+			}
+		}
+		data = append(data, block[:len(block)-ecPerBlock]...)
+	}
+
+	// Byte mode segment, terminator and padding
+	pos := 0
+	read := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(data[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return v
+	}
+	if m := read(4); m != 0b0100 {
+		t.Fatalf("mode %04b, want byte mode", m)
+	}
+	count := read(8)
+	if version >= 10 {
+		count = count<<8 | read(8)
+	}
+	text := make([]byte, count)
+	for i := range text {
+		text[i] = byte(read(8))
+	}
+	if terminator := min(4, 8*len(data)-pos); read(terminator) != 0 {
+		t.Fatal("bad terminator")
+	}
+	if pos%8 != 0 && read(8-pos%8) != 0 {
+		t.Fatal("bad bit padding")
+	}
+	for i, c := range data[pos/8:] {
+		if want := []byte{0xEC, 0x11}[i%2]; c != want {
+			t.Fatalf("pad codeword %d is %#x, want %#x", i, c, want)
+		}
+	}
+	return string(text), level, mask
+}
+
+func TestEncodeDecodes(t *testing.T) {
+	tests := []struct {
+		length  int
+		version int
+	}{
+		{1, 1}, {14, 1}, {15, 2}, {60, 4}, {107, 7}, {122, 7}, {180, 9}, {181, 10}, {287, 12}, {288, 13}, {412, 15},
+	}
+	for _, tt := range tests {
+		text := make([]byte, tt.length)
+		for i := range text {
+			text[i] = byte(i*37 + tt.length)
+		}
+		q, err := Encode(string(text))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", tt.length, err)
+		}
+		if version := (q.Size - 17) / 4; version != tt.version {
+			t.Errorf("Encode(%d bytes): version %d, want %d", tt.length, version, tt.version)
+		}
+		got, level, _ := decode(t, q)
+		if got != string(text) || level != levelM {
+			t.Fatalf("Encode(%d bytes) decodes to %d bytes at level %d", tt.length, len(got), level)
+		}
+	}
+}
+
+func TestEveryMaskDecodes(t *testing.T) {
+	text := "opp://fines/12345/pay?reference=OPP-00012345-42"
+	for _, version := range []int{4, 7, 10} {
+		codewords := addErrorCorrection(encodeData([]byte(text), version), layouts[version])
+		for mask := 0; mask < 8; mask++ {
+			q := newCode(version)
+			q.drawFunctionPatterns(version)
+			q.drawCodewords(codewords)
+			q.applyMask(mask)
+			q.drawFormat(mask)
+			got, _, gotMask := decode(t, q.Code)
+			if got != text || gotMask != mask {
+				t.Fatalf("version %d mask %d decodes to %q with mask %d", version, mask, got, gotMask)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package tickettoken
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Secret the signing keys are encrypted with in the database. Without it
+// they are stored in clear, which is only allowed in debug mode.
+var TICKET_KEY_SECRET = os.Getenv("TICKET_KEY_SECRET")
+
+var DEBUG_MODE = os.Getenv("DEBUG_MODE")
+
+var (
+	ErrKeySecret   = errors.New("ticket signing key cannot be decrypted")
+	ErrNoKeySecret = errors.New("TICKET_KEY_SECRET is required outside debug mode")
+)
+
+// Key is a signing key. Id is the kid of the tokens it signs.
+type Key struct {
+	Id      string
+	Private ed25519.PrivateKey
+}
+
+// Public returns the verification key of k
+func (k Key) Public() ed25519.PublicKey {
+	return k.Private.Public().(ed25519.PublicKey)
+}
+
+// GenerateKey creates a signing key, its id is derived from the public key
+func GenerateKey() (Key, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to generate ticket signing key: %w", err)
+	}
+	sum := sha256.Sum256(public)
+	return Key{Id: base64.RawURLEncoding.EncodeToString(sum[:6]), Private: private}, nil
+}
+
+// Formats of a stored private key
+const (
+	sealedClear = 0
+	sealedGCM   = 1
+)
+
+func secretCipher(secret string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal returns the private key of k for storage, encrypted with secret
+// unless it is empty, in debug mode only. The key id is authenticated with
+// it.
+func (k Key) Seal(secret string) ([]byte, error) {
+	seed := k.Private.Seed()
+	if secret == "" {
+		if DEBUG_MODE != "true" {
+			return nil, ErrNoKeySecret
+		}
+		return append([]byte{sealedClear}, seed...), nil
+	}
+	aead, err := secretCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal ticket signing key: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to seal ticket signing key: %w", err)
+	}
+	sealed := append([]byte{sealedGCM}, nonce...)
+	return aead.Seal(sealed, nonce, seed, []byte(k.Id)), nil
+}
+
+// OpenKey reverses Seal
+func OpenKey(id string, sealed []byte, secret string) (Key, error) {
+	if len(sealed) == 0 {
+		return Key{}, ErrKeySecret
+	}
+	var seed []byte
+	switch sealed[0] {
+	case sealedClear:
+		// Anyone reading the database could sign with a key stored in clear
+		if DEBUG_MODE != "true" {
+			return Key{}, fmt.Errorf("%w: the key is stored in clear", ErrNoKeySecret)
+		}
+		seed = sealed[1:]
+	case sealedGCM:
+		if secret == "" {
+			return Key{}, fmt.Errorf("%w: TICKET_KEY_SECRET is not set", ErrKeySecret)
+		}
+		aead, err := secretCipher(secret)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrKeySecret, err)
+		}
+		if len(sealed) < 1+aead.NonceSize() {
+			return Key{}, ErrKeySecret
+		}
+		nonce := sealed[1 : 1+aead.NonceSize()]
+		seed, err = aead.Open(nil, nonce, sealed[1+aead.NonceSize():], []byte(id))
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrKeySecret, err)
+		}
+	default:
+		return Key{}, ErrKeySecret
+	}
+	if len(seed) != ed25519.SeedSize {
+		return Key{}, ErrKeySecret
+	}
+	return Key{Id: id, Private: ed25519.NewKeyFromSeed(seed)}, nil
+}
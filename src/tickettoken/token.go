@@ -0,0 +1,122 @@
+// Package tickettoken signs tickets as compact JWS tokens (EdDSA over
+// Ed25519) that controller apps verify offline with the published keys.
+// Claim names are kept short so that the token fits in a QR code.
+package tickettoken
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const issuer = "opp"
+
+var (
+	ErrInvalidToken = errors.New("invalid ticket token")
+	ErrUnknownKey   = errors.New("unknown ticket signing key")
+)
+
+// Claims are the ticket fields a controller needs to check a car. Start
+// and End are Unix times. Entries standing for the plate of a permit have
+// the permit id and no ticket id.
+type Claims struct {
+	TicketId int64  `json:"tid"`
+	PermitId int64  `json:"pid,omitempty"`
+	Plate    string `json:"plt"`
+	ZoneId   int64  `json:"zid"`
+	Start    int64  `json:"st"`
+	End      int64  `json:"en"`
+	Paid     bool   `json:"pd"`
+	jwt.RegisteredClaims
+}
+
+// Ticket is what a token is issued for
+type Ticket struct {
+	Id     int64
+	Permit int64
+	Plate  string
+	Zone   int64
+	Start  time.Time
+	End    time.Time
+	Paid   bool
+}
+
+// Valid tells whether the ticket covers t: paid and between its start
+// and end
+func (c *Claims) Valid(t time.Time) bool {
+	return c.Paid && !t.Before(time.Unix(c.Start, 0)) && t.Before(time.Unix(c.End, 0))
+}
+
+// Sign issues the token of a ticket with key
+func Sign(key Key, ticket Ticket, now time.Time) (string, error) {
+	claims := Claims{
+		TicketId: ticket.Id,
+		PermitId: ticket.Permit,
+		Plate:    ticket.Plate,
+		ZoneId:   ticket.Zone,
+		Start:    ticket.Start.Unix(),
+		End:      ticket.End.Unix(),
+		Paid:     ticket.Paid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   issuer,
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	// The type header is left out, it is implied by the key set
+	delete(token.Header, "typ")
+	token.Header["kid"] = key.Id
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign ticket token: %w", err)
+	}
+	return signed, nil
+}
+
+// Verify checks the signature of a token with the key its header names
+// and returns its claims. It does not check that the ticket is valid now,
+// see Claims.Valid.
+func Verify(token string, keys map[string]ed25519.PublicKey) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithIssuer(issuer))
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			return nil, ErrUnknownKey
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &claims, nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 8037)
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	Kid string `json:"kid"`
+	X   string `json:"x"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// PublicJWK returns the public half of key as a JWK
+func PublicJWK(kid string, key ed25519.PublicKey) JWK {
+	return JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		Kid: kid,
+		X:   base64.RawURLEncoding.EncodeToString(key),
+		Alg: jwt.SigningMethodEdDSA.Alg(),
+		Use: "sig",
+	}
+}
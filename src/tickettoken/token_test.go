@@ -0,0 +1,118 @@
+package tickettoken
+
+import (
+	"OPP/backend/qrcode"
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	old, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]ed25519.PublicKey{old.Id: old.Public(), current.Id: current.Public()}
+
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	ticket := Ticket{Id: 9_007_199_254, Plate: "AB123CDEFG", Zone: 1_000_000, Start: start, End: start.Add(2 * time.Hour), Paid: true}
+	for _, key := range []Key{old, current} {
+		token, err := Sign(key, ticket, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := Verify(token, keys)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if claims.TicketId != ticket.Id || claims.Plate != ticket.Plate || claims.ZoneId != ticket.Zone || !claims.Paid {
+			t.Fatalf("Verify claims = %+v", claims)
+		}
+		if !claims.Valid(start.Add(time.Hour)) || claims.Valid(start.Add(-time.Minute)) || claims.Valid(start.Add(2*time.Hour)) {
+			t.Fatalf("Valid is wrong around %v", start)
+		}
+		// Controller apps scan it from the screen
+		if _, err := qrcode.Encode(token); err != nil {
+			t.Fatalf("token of %d bytes does not fit in a QR code: %v", len(token), err)
+		}
+	}
+
+	token, _ := Sign(current, ticket, start)
+	if _, err := Verify(token, map[string]ed25519.PublicKey{old.Id: old.Public()}); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Verify with a retired key set = %v, want ErrUnknownKey", err)
+	}
+	parts := strings.Split(token, ".")
+	forged, _ := Sign(Key{Id: current.Id, Private: old.Private}, ticket, start)
+	if _, err := Verify(forged, keys); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify forged = %v, want ErrInvalidToken", err)
+	}
+	unpaid, _ := Sign(current, Ticket{Id: ticket.Id, Plate: ticket.Plate, Zone: ticket.Zone, Start: ticket.Start, End: ticket.End}, start)
+	tampered := parts[0] + "." + strings.Split(unpaid, ".")[1] + "." + parts[2]
+	if _, err := Verify(tampered, keys); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify tampered = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugMode := DEBUG_MODE
+	t.Cleanup(func() { DEBUG_MODE = debugMode })
+
+	DEBUG_MODE = "true"
+	for _, secret := range []string{"", "s3cret"} {
+		sealed, err := key.Seal(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := OpenKey(key.Id, sealed, secret)
+		if err != nil {
+			t.Fatalf("OpenKey(secret %q): %v", secret, err)
+		}
+		if !opened.Private.Equal(key.Private) {
+			t.Fatalf("OpenKey(secret %q) returned another key", secret)
+		}
+	}
+
+	sealed, _ := key.Seal("s3cret")
+	if _, err := OpenKey(key.Id, sealed, "other"); !errors.Is(err, ErrKeySecret) {
+		t.Fatalf("OpenKey with the wrong secret = %v, want ErrKeySecret", err)
+	}
+	if _, err := OpenKey("other", sealed, "s3cret"); !errors.Is(err, ErrKeySecret) {
+		t.Fatalf("OpenKey with another id = %v, want ErrKeySecret", err)
+	}
+}
+
+func TestKeysInClearRequireDebugMode(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugMode := DEBUG_MODE
+	t.Cleanup(func() { DEBUG_MODE = debugMode })
+
+	DEBUG_MODE = "true"
+	inClear, err := key.Seal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	DEBUG_MODE = ""
+	if _, err := key.Seal(""); !errors.Is(err, ErrNoKeySecret) {
+		t.Fatalf("Seal without a secret = %v, want ErrNoKeySecret", err)
+	}
+	if _, err := OpenKey(key.Id, inClear, "s3cret"); !errors.Is(err, ErrNoKeySecret) {
+		t.Fatalf("OpenKey of a key in clear = %v, want ErrNoKeySecret", err)
+	}
+	if _, err := key.Seal("s3cret"); err != nil {
+		t.Fatalf("Seal with a secret: %v", err)
+	}
+}